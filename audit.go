@@ -0,0 +1,236 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SystemActor is recorded for mutations made without an actor in their context
+const SystemActor = "system"
+
+// Audited entity types
+const (
+	AuditUser            = "user"
+	AuditLoan            = "loan"
+	AuditPayment         = "payment"
+	AuditFee             = "fee"
+	AuditPostedPayment   = "posted_payment"
+	AuditChargeOff       = "charge_off"
+	AuditModification    = "loan_modification"
+	AuditRateIndex       = "rate_index"
+	AuditLoanParty       = "loan_party"
+	AuditCollectionCase  = "collection_case"
+	AuditContactAttempt  = "contact_attempt"
+	AuditPromise         = "promise_to_pay"
+	AuditRepaymentPlan   = "repayment_plan"
+	AuditSettlement      = "settlement"
+	AuditMessageTemplate = "message_template"
+	AuditAddress         = "user_address"
+	AuditNotification    = "notification"
+	AuditInAppMessage    = "in_app_message"
+)
+
+// Audited operations beyond create, update and delete
+const (
+	AuditCreate         = "create"
+	AuditUpdate         = "update"
+	AuditDelete         = "delete"
+	AuditRestore        = "restore"
+	AuditPostPayment    = "post_payment"
+	AuditReversePayment = "reverse_payment"
+	AuditAssessFee      = "assess_fee"
+	AuditWaiveFee       = "waive_fee"
+	AuditChargeOffLoan  = "charge_off"
+	AuditModifyLoan     = "modify"
+	AuditRateReset      = "rate_reset"
+	AuditImport         = "import"
+	AuditMerge          = "merge"
+	AuditCloseCase      = "close"
+	AuditSettle         = "settle"
+)
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	reasonKey
+	idempotencyKey
+)
+
+// WithActor returns a context whose mutations are audited as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFromContext returns the actor of a context, or SystemActor when it has none.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// WithReason returns a context whose mutations are audited with reason.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey, reason)
+}
+
+// ReasonFromContext returns the audit reason of a context, or "".
+func ReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey).(string)
+	return reason
+}
+
+// AuditEntry is one audited mutation.
+type AuditEntry struct {
+	ID         int64           // unique identifier for the entry
+	Actor      string          // who made the change
+	Operation  string          // what was done, e.g. "update"
+	EntityType string          // what kind of record changed, e.g. "loan"
+	EntityID   int64           // which record changed
+	Before     json.RawMessage // the record before the change (nil for creates)
+	After      json.RawMessage // the record after the change (nil for deletes)
+	Reason     string          // why, when the actor said
+	CreatedAt  time.Time       // when the change was made
+}
+
+// AuditFilter narrows GetAuditLog. Zero fields match everything.
+type AuditFilter struct {
+	EntityType string    // only this kind of record
+	EntityID   int64     // only this record, with EntityType
+	Actor      string    // only changes by this actor
+	From       time.Time // only changes made at or after
+	To         time.Time // only changes made before
+	Limit      int       // at most this many entries, newest first
+}
+
+// recordAudit stores an audit entry for a mutation using the actor and reason from ctx.
+// A reason in ctx wins over the one given, which operations take as an argument.
+func recordAudit(ctx context.Context, db execer, operation, entityType string, entityID int64, before, after any, reason string) error {
+	encode := func(v any) ([]byte, error) {
+		if v == nil {
+			return nil, nil
+		}
+		return json.Marshal(v)
+	}
+
+	beforeJSON, err := encode(before)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	afterJSON, err := encode(after)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	if r := ReasonFromContext(ctx); r != "" {
+		reason = r
+	}
+
+	query := `
+	INSERT INTO audit_log (actor, operation, entity_type, entity_id, before_data, after_data, reason)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err = db.Exec(query, ActorFromContext(ctx), operation, entityType, entityID, beforeJSON, afterJSON, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// inTx runs fn in a transaction and commits it when fn succeeds
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLog returns the audit entries matching the filter, newest first.
+func GetAuditLog(db execer, f AuditFilter) ([]AuditEntry, error) {
+	var conds []string
+	var args []any
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.EntityType != "" {
+		where("entity_type = $%d", f.EntityType)
+	}
+	if f.EntityID != 0 {
+		where("entity_id = $%d", f.EntityID)
+	}
+	if f.Actor != "" {
+		where("actor = $%d", f.Actor)
+	}
+	if !f.From.IsZero() {
+		where("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		where("created_at < $%d", f.To)
+	}
+
+	query := `
+	SELECT id, actor, operation, entity_type, entity_id, before_data, after_data, reason, created_at
+	FROM audit_log`
+	if len(conds) > 0 {
+		query += "\n\tWHERE " + strings.Join(conds, " AND ")
+	}
+	query += "\n\tORDER BY created_at DESC, id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf("\n\tLIMIT $%d", len(args))
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+
+	for rows.Next() {
+		var e AuditEntry
+		var before, after []byte
+
+		err := rows.Scan(&e.ID, &e.Actor, &e.Operation, &e.EntityType, &e.EntityID, &before, &after, &e.Reason, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit row: %w", err)
+		}
+
+		if before != nil {
+			e.Before = json.RawMessage(before)
+		}
+		if after != nil {
+			e.After = json.RawMessage(after)
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit rows: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,66 @@
+package delinquencytracker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestActorFromContext verifies mutations without an actor are attributed to the system.
+func TestActorFromContext(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, SystemActor, ActorFromContext(ctx))
+	require.Equal(t, "", ReasonFromContext(ctx))
+
+	ctx = WithReason(WithActor(ctx, "alice"), "customer called")
+	require.Equal(t, "alice", ActorFromContext(ctx))
+	require.Equal(t, "customer called", ReasonFromContext(ctx))
+}
+
+// TestAuditLog verifies mutations record who made them, why, and the record before and after.
+func TestAuditLog(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	ctx := WithActor(context.Background(), "alice")
+	user, err := InitializeUserWithLoanContext(ctx, db, "Audit User", "audit@example.com", "555-1919",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false, LoanOptions{})
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	// Act
+	require.NoError(t, UpdateUserContext(WithReason(ctx, "name change"), db, user.ID, "Renamed User", user.Email, user.Phone))
+	_, err = PostPaymentContext(WithActor(context.Background(), "bob"), db, ln.ID, 400, calendarDate(2024, time.February, 1))
+	require.NoError(t, err)
+
+	// Assert
+	entries, err := GetAuditLog(db, AuditFilter{EntityType: AuditUser, EntityID: user.ID})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	update := entries[0]
+	require.Equal(t, AuditUpdate, update.Operation)
+	require.Equal(t, "alice", update.Actor)
+	require.Equal(t, "name change", update.Reason)
+
+	var before, after User
+	require.NoError(t, json.Unmarshal(update.Before, &before))
+	require.NoError(t, json.Unmarshal(update.After, &after))
+	require.Equal(t, "Audit User", before.Name)
+	require.Equal(t, "Renamed User", after.Name)
+	require.Equal(t, AuditCreate, entries[1].Operation)
+	require.Nil(t, entries[1].Before)
+
+	byBob, err := GetAuditLog(db, AuditFilter{Actor: "bob"})
+	require.NoError(t, err)
+	require.Len(t, byBob, 1)
+	require.Equal(t, AuditPostPayment, byBob[0].Operation)
+	require.Equal(t, ln.ID, byBob[0].EntityID)
+
+	limited, err := GetAuditLog(db, AuditFilter{Actor: "alice", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+}
@@ -1,6 +1,7 @@
 package delinquencytracker
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"math"
@@ -81,41 +82,86 @@ func validateLoanParameters(totalAmount, interestRate float64, termMonths, dayDu
 	return nil
 }
 
-// createPaymentSchedule generates the complete Payment schedule for a Loan.
+// LoanOptions holds the optional settings of a Loan beyond its basic terms.
+// The zero value describes a level-payment fully amortizing Loan.
+type LoanOptions struct {
+	Schedule ScheduleConfig // structure of the Payment schedule
+	Rate     RateTerms      // adjustable rate terms, leave empty for a fixed rate
+
+	BusinessDayConvention BusinessDayConvention // how due dates on weekends and holidays are moved
+	HolidayCalendar       string                // registered calendar used by the convention ("US" for federal holidays)
+
+	Parties []LoanParty // co-borrowers and guarantors liable alongside the borrower, by UserID and Role
+}
+
+// generateSchedule builds the unsaved installments of a Loan from its options.
+func generateSchedule(opts LoanOptions, principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error) {
+	if err := opts.Rate.validate(termMonths, opts.Schedule); err != nil {
+		return nil, err
+	}
+	if err := validateBusinessDayRule(opts.BusinessDayConvention, opts.HolidayCalendar); err != nil {
+		return nil, err
+	}
+
+	gen, err := opts.Schedule.Generator()
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := gen.Generate(principal, annualRate, termMonths, dayDue, dateTaken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := adjustDueDates(payments, opts.BusinessDayConvention, opts.HolidayCalendar); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// createPaymentSchedule persists the generated installments of a Loan.
 // If autoPayPastDue is true, payments with due dates before now will be marked as paid.
 // The paidDate for auto-paid payments will be set to the dueDate (assumes on-time payment).
-func createPaymentSchedule(db *sql.DB, loanID int64, principal, annualRate float64,
-	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool) ([]Payment, error) {
-
-	monthlyPayment := calculateMonthlyPayment(principal, annualRate, termMonths)
-	payments := make([]Payment, 0, termMonths)
+func createPaymentSchedule(ctx context.Context, tx *sql.Tx, loanID int64, installments []Payment, autoPayPastDue bool) ([]Payment, error) {
+	payments := make([]Payment, 0, len(installments))
 	now := time.Now().UTC()
 
-	for i := 1; i <= termMonths; i++ {
-		dueDate := calculateDueDate(dateTaken, i, dayDue)
-
+	for _, inst := range installments {
 		// Determine if this payment should be marked as paid
 		var amountPaid float64
 		var paidDate time.Time
 
-		if autoPayPastDue && dueDate.Before(now) {
+		if autoPayPastDue && inst.DueDate.Before(now) {
 			// Payment is in the past - mark as paid with on-time payment
-			amountPaid = monthlyPayment
-			paidDate = dueDate
+			amountPaid = inst.AmountDue
+			paidDate = inst.DueDate
 		} else {
 			// Payment is in the future or we're not auto-paying - leave unpaid
 			amountPaid = 0
 			paidDate = time.Time{}
 		}
 
-		pmt, err := CreatePayment(db, loanID, int64(i), monthlyPayment, amountPaid, dueDate, paidDate)
+		pmt, err := createPayment(ctx, tx, loanID, inst.PaymentNumber, inst.AmountDue, amountPaid, inst.DueDate, paidDate)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create Payment %d: %w", i, err)
+			return nil, fmt.Errorf("failed to create Payment %d: %w", inst.PaymentNumber, err)
 		}
 
 		payments = append(payments, pmt)
 	}
 
+	// the ledger records the schedule and what was auto-paid on it
+	if err := syncLedgerSchedule(tx, loanID); err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		err := postLedgerEntries(tx, LedgerEntry{LoanID: loanID, Type: EntryPayment, DebitAccount: AccountCash,
+			CreditAccount: AccountDue, Amount: p.AmountPaid, PaymentNumber: p.PaymentNumber, EffectiveDate: p.PaidDate, Memo: "auto-pay"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return payments, nil
 }
 
@@ -124,29 +170,61 @@ func createPaymentSchedule(db *sql.DB, loanID int64, principal, annualRate float
 // If autoPayPastDue is true, payments with due dates before today will be automatically marked as paid.
 func InitializeUserWithLoan(db *sql.DB, name, email, phone string, totalAmount, interestRate float64,
 	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool) (User, error) {
+	return InitializeUserWithLoanOptions(db, name, email, phone, totalAmount, interestRate,
+		termMonths, dayDue, dateTaken, autoPayPastDue, LoanOptions{})
+}
+
+// InitializeUserWithLoanOptions is InitializeUserWithLoan for loans that need a
+// non-default schedule structure or other options.
+func InitializeUserWithLoanOptions(db *sql.DB, name, email, phone string, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (User, error) {
+	return InitializeUserWithLoanContext(context.Background(), db, name, email, phone, totalAmount, interestRate,
+		termMonths, dayDue, dateTaken, autoPayPastDue, opts)
+}
+
+// InitializeUserWithLoanContext is InitializeUserWithLoanOptions, audited as the actor in ctx.
+// With an idempotency key in ctx a replayed request returns the User first created for it.
+func InitializeUserWithLoanContext(ctx context.Context, db *sql.DB, name, email, phone string, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (User, error) {
 
 	// Ensure dateTaken is in UTC for consistency
 	dateTaken = dateTaken.UTC()
 
+	request := []any{name, email, phone, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue, opts}
+	return idempotent(ctx, db, IdempotentOrigination, request, func(tx *sql.Tx) (User, error) {
+		return initializeUserWithLoan(ctx, tx, name, email, phone, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue, opts)
+	})
+}
+
+// initializeUserWithLoan creates the User, the Loan and its schedule inside the caller's transaction
+func initializeUserWithLoan(ctx context.Context, tx *sql.Tx, name, email, phone string, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (User, error) {
+
 	// Validate input parameters
 	if err := validateLoanParameters(totalAmount, interestRate, termMonths, dayDue, dateTaken); err != nil {
 		return User{}, fmt.Errorf("invalid loan parameters: %w", err)
 	}
 
+	// Build the schedule up front so a bad structure is rejected before anything is written
+	installments, err := generateSchedule(opts, totalAmount, interestRate, termMonths, dayDue, dateTaken)
+	if err != nil {
+		return User{}, fmt.Errorf("invalid loan schedule: %w", err)
+	}
+
 	// Step 1: Create the User
-	usr, err := CreateUser(db, name, email, phone)
+	usr, err := createUser(ctx, tx, name, email, phone, UserProfile{})
 	if err != nil {
 		return User{}, fmt.Errorf("failed to create User: %w", err)
 	}
 
 	// Step 2: Create the Loan
-	ln, err := CreateLoan(db, usr.ID, totalAmount, interestRate, termMonths, dayDue, "active", dateTaken)
+	ln, err := originateLoan(ctx, tx, usr.ID, totalAmount, interestRate, termMonths, dayDue, "active", dateTaken, opts)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to create Loan for User %d: %w", usr.ID, err)
 	}
 
 	// Step 3: Create all Payment records
-	payments, err := createPaymentSchedule(db, ln.ID, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue)
+	payments, err := createPaymentSchedule(ctx, tx, ln.ID, installments, autoPayPastDue)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to create payment schedule for Loan %d: %w", ln.ID, err)
 	}
@@ -179,29 +257,62 @@ func InitializeUserWithLoanNowAutoPay(db *sql.DB, name, email, phone string,
 // If autoPayPastDue is true, payments with due dates before today will be automatically marked as paid.
 func AddLoanToExistingUser(db *sql.DB, userID int64, totalAmount, interestRate float64,
 	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool) (Loan, error) {
+	return AddLoanToExistingUserOptions(db, userID, totalAmount, interestRate,
+		termMonths, dayDue, dateTaken, autoPayPastDue, LoanOptions{})
+}
+
+// AddLoanToExistingUserOptions is AddLoanToExistingUser for loans that need a
+// non-default schedule structure, co-borrowers or guarantors, or other options.
+func AddLoanToExistingUserOptions(db *sql.DB, userID int64, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (Loan, error) {
+	return AddLoanToExistingUserContext(context.Background(), db, userID, totalAmount, interestRate,
+		termMonths, dayDue, dateTaken, autoPayPastDue, opts)
+}
+
+// AddLoanToExistingUserContext is AddLoanToExistingUserOptions, audited as the actor in ctx.
+// With an idempotency key in ctx a replayed request returns the Loan first created for it.
+func AddLoanToExistingUserContext(ctx context.Context, db *sql.DB, userID int64, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (Loan, error) {
 
 	// Ensure dateTaken is in UTC for consistency
 	dateTaken = dateTaken.UTC()
 
+	request := []any{userID, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue, opts}
+	return idempotent(ctx, db, IdempotentAddLoan, request, func(tx *sql.Tx) (Loan, error) {
+		return addLoanToExistingUser(ctx, tx, userID, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue, opts)
+	})
+}
+
+// addLoanToExistingUser creates the Loan and its schedule for a User inside the caller's transaction
+func addLoanToExistingUser(ctx context.Context, tx *sql.Tx, userID int64, totalAmount, interestRate float64,
+	termMonths, dayDue int, dateTaken time.Time, autoPayPastDue bool, opts LoanOptions) (Loan, error) {
+
 	// Validate input parameters
 	if err := validateLoanParameters(totalAmount, interestRate, termMonths, dayDue, dateTaken); err != nil {
 		return Loan{}, fmt.Errorf("invalid loan parameters: %w", err)
 	}
 
+	// Build the schedule up front so a bad structure is rejected before anything is written
+	installments, err := generateSchedule(opts, totalAmount, interestRate, termMonths, dayDue, dateTaken)
+	if err != nil {
+		return Loan{}, fmt.Errorf("invalid loan schedule: %w", err)
+	}
+
 	// Step 1: Verify User exists
-	_, err := GetUserByID(db, userID)
+	usr, err := GetUserByID(tx, userID)
 	if err != nil {
 		return Loan{}, fmt.Errorf("User %d not found: %w", userID, err)
 	}
+	userID = usr.ID
 
 	// Step 2: Create the Loan
-	ln, err := CreateLoan(db, userID, totalAmount, interestRate, termMonths, dayDue, "active", dateTaken)
+	ln, err := originateLoan(ctx, tx, userID, totalAmount, interestRate, termMonths, dayDue, "active", dateTaken, opts)
 	if err != nil {
 		return Loan{}, fmt.Errorf("failed to create Loan for User %d: %w", userID, err)
 	}
 
 	// Step 3: Create all Payment records
-	payments, err := createPaymentSchedule(db, ln.ID, totalAmount, interestRate, termMonths, dayDue, dateTaken, autoPayPastDue)
+	payments, err := createPaymentSchedule(ctx, tx, ln.ID, installments, autoPayPastDue)
 	if err != nil {
 		return Loan{}, fmt.Errorf("failed to create payment schedule for Loan %d: %w", ln.ID, err)
 	}
@@ -230,15 +341,17 @@ func AddLoanToExistingUserNowAutoPay(db *sql.DB, userID int64, totalAmount, inte
 }
 
 // GetFullUserByID retrieves a User with all their loans and payments.
-func GetFullUserByID(db *sql.DB, userID int64) (User, error) {
+// Pass IncludeDeleted to read a soft-deleted User or their deleted loans.
+func GetFullUserByID(db *sql.DB, userID int64, opts ...ReadOption) (User, error) {
 	// Step 1: Get the basic User information
-	usr, err := GetUserByID(db, userID)
+	usr, err := GetUserByID(db, userID, opts...)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to get User: %w", err)
 	}
+	userID = usr.ID
 
 	// Step 2: Get all loans for this User
-	loans, err := GetLoansByUserID(db, userID)
+	loans, err := GetLoansByUserID(db, userID, opts...)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to get loans for User %d: %w", userID, err)
 	}
@@ -250,18 +363,29 @@ func GetFullUserByID(db *sql.DB, userID int64) (User, error) {
 			return User{}, fmt.Errorf("failed to get payments for Loan %d: %w", loans[i].ID, err)
 		}
 		loans[i].Payments = payments
+
+		mods, err := GetLoanModifications(db, loans[i].ID)
+		if err != nil {
+			return User{}, fmt.Errorf("failed to get modifications for Loan %d: %w", loans[i].ID, err)
+		}
+		loans[i].Modifications = mods
 	}
 
-	// Step 4: Attach all loans to the User
+	// Step 4: Attach all loans and the mailing address to the User
 	usr.Loans = loans
 
+	usr.Address, err = GetUserAddress(db, userID)
+	if err != nil {
+		return User{}, err
+	}
+
 	return usr, nil
 }
 
-// GetFullLoanByID retrieves a Loan with all its Payment information.
-func GetFullLoanByID(db *sql.DB, loanID int64) (Loan, error) {
+// GetFullLoanByID retrieves a Loan with all its Payment information and modification history.
+func GetFullLoanByID(db *sql.DB, loanID int64, opts ...ReadOption) (Loan, error) {
 	// Step 1: Get the basic Loan information
-	ln, err := GetLoanByLoanID(db, loanID)
+	ln, err := GetLoanByLoanID(db, loanID, opts...)
 	if err != nil {
 		return Loan{}, fmt.Errorf("failed to get Loan: %w", err)
 	}
@@ -272,8 +396,15 @@ func GetFullLoanByID(db *sql.DB, loanID int64) (Loan, error) {
 		return Loan{}, fmt.Errorf("failed to get payments for Loan %d: %w", loanID, err)
 	}
 
-	// Step 3: Attach payments to the Loan
+	// Step 3: Get the modification history, forbearance windows affect delinquency
+	mods, err := GetLoanModifications(db, loanID)
+	if err != nil {
+		return Loan{}, fmt.Errorf("failed to get modifications for Loan %d: %w", loanID, err)
+	}
+
+	// Step 4: Attach payments and modifications to the Loan
 	ln.Payments = payments
+	ln.Modifications = mods
 
 	return ln, nil
 }
@@ -184,7 +184,7 @@ func TestInitializeUserWithLoanUnpaid(t *testing.T) {
 	require.NotEqual(t, int64(0), user.ID, "User should have valid ID")
 	require.Equal(t, name, user.Name, "User name should match")
 	require.Equal(t, email, user.Email, "User email should match")
-	require.Equal(t, phone, user.Phone, "User phone should match")
+	require.Equal(t, "+15551234", user.Phone, "User phone should be stored in E.164 form")
 
 	// Check loan was created
 	require.Len(t, user.Loans, 1, "User should have exactly 1 loan")
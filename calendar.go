@@ -0,0 +1,277 @@
+package delinquencytracker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BusinessDayConvention says how a due date that falls on a weekend or holiday is moved.
+type BusinessDayConvention string
+
+const (
+	Unadjusted        BusinessDayConvention = "unadjusted"         // keep the date as is
+	Following         BusinessDayConvention = "following"          // move to the next business day
+	ModifiedFollowing BusinessDayConvention = "modified_following" // next business day, unless that is in the next month
+	Preceding         BusinessDayConvention = "preceding"          // move to the previous business day
+)
+
+// HolidayCalendar knows which days the bank is closed.
+// Weekends are never business days, holidays come from a file or from rules.
+type HolidayCalendar struct {
+	Name string
+
+	dates map[time.Time]string                // fixed holidays, as loaded from a file
+	rules func(year int) map[time.Time]string // holidays generated per year
+
+	mu    sync.Mutex
+	cache map[int]map[time.Time]string // generated holidays by year
+}
+
+// the calendars loans can reference by name
+var (
+	calendarsMu sync.RWMutex
+	calendars   = map[string]*HolidayCalendar{
+		"US": USFederalCalendar(),
+	}
+)
+
+// RegisterHolidayCalendar makes a calendar available to loans under its name,
+// replacing any calendar already registered with that name.
+func RegisterHolidayCalendar(cal *HolidayCalendar) {
+	calendarsMu.Lock()
+	defer calendarsMu.Unlock()
+
+	calendars[cal.Name] = cal
+}
+
+// LookupHolidayCalendar returns the registered calendar with the given name.
+// The built-in "US" federal calendar is always registered.
+func LookupHolidayCalendar(name string) (*HolidayCalendar, error) {
+	calendarsMu.RLock()
+	defer calendarsMu.RUnlock()
+
+	cal, ok := calendars[name]
+	if !ok {
+		return nil, fmt.Errorf("holiday calendar %q is not registered", name)
+	}
+
+	return cal, nil
+}
+
+// holidayCalendarFor resolves the calendar a Loan references.
+// An empty name means weekends are the only non-business days.
+func holidayCalendarFor(name string) (*HolidayCalendar, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	return LookupHolidayCalendar(name)
+}
+
+// LoadHolidayCalendar reads a calendar from a file with one "YYYY-MM-DD[,description]" per line.
+// Blank lines and lines starting with # are ignored.
+func LoadHolidayCalendar(name, path string) (*HolidayCalendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open holiday calendar: %w", err)
+	}
+	defer f.Close()
+
+	cal := &HolidayCalendar{Name: name, dates: map[time.Time]string{}}
+	scanner := bufio.NewScanner(f)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		dateText, description, _ := strings.Cut(text, ",")
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(dateText))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, dateText)
+		}
+
+		cal.dates[date] = strings.TrimSpace(description)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read holiday calendar: %w", err)
+	}
+
+	return cal, nil
+}
+
+// USFederalCalendar returns the calendar of US federal holidays, with the
+// observed date used when a holiday falls on a weekend.
+func USFederalCalendar() *HolidayCalendar {
+	return &HolidayCalendar{Name: "US", rules: usFederalHolidays}
+}
+
+func usFederalHolidays(year int) map[time.Time]string {
+	holidays := map[time.Time]string{}
+
+	add := func(d time.Time, name string) {
+		holidays[d] = name
+	}
+	observed := func(d time.Time, name string) {
+		switch d.Weekday() {
+		case time.Saturday:
+			d = d.AddDate(0, 0, -1)
+		case time.Sunday:
+			d = d.AddDate(0, 0, 1)
+		}
+		add(d, name)
+	}
+
+	observed(calendarDate(year, time.January, 1), "New Year's Day")
+	add(nthWeekday(year, time.January, time.Monday, 3), "Martin Luther King Jr. Day")
+	add(nthWeekday(year, time.February, time.Monday, 3), "Washington's Birthday")
+	add(lastWeekday(year, time.May, time.Monday), "Memorial Day")
+	if year >= 2021 {
+		observed(calendarDate(year, time.June, 19), "Juneteenth")
+	}
+	observed(calendarDate(year, time.July, 4), "Independence Day")
+	add(nthWeekday(year, time.September, time.Monday, 1), "Labor Day")
+	add(nthWeekday(year, time.October, time.Monday, 2), "Columbus Day")
+	observed(calendarDate(year, time.November, 11), "Veterans Day")
+	add(nthWeekday(year, time.November, time.Thursday, 4), "Thanksgiving Day")
+	observed(calendarDate(year, time.December, 25), "Christmas Day")
+
+	return holidays
+}
+
+func calendarDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns the n-th given weekday of a month
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	d := calendarDate(year, month, 1)
+	offset := (int(weekday) - int(d.Weekday()) + 7) % 7
+	return d.AddDate(0, 0, offset+7*(n-1))
+}
+
+// lastWeekday returns the last given weekday of a month
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	d := calendarDate(year, month+1, 0)
+	offset := (int(d.Weekday()) - int(weekday) + 7) % 7
+	return d.AddDate(0, 0, -offset)
+}
+
+// holidaysFor returns the generated holidays that fall in a year, computing them once
+func (c *HolidayCalendar) holidaysFor(year int) map[time.Time]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cache == nil {
+		c.cache = map[int]map[time.Time]string{}
+	}
+	if h, ok := c.cache[year]; ok {
+		return h
+	}
+
+	h := map[time.Time]string{}
+	// a holiday early in next year can be observed at the end of this one
+	for _, y := range []int{year, year + 1} {
+		for d, name := range c.rules(y) {
+			if d.Year() == year {
+				h[d] = name
+			}
+		}
+	}
+	c.cache[year] = h
+
+	return h
+}
+
+// Holiday returns the description of the holiday on d, if there is one.
+func (c *HolidayCalendar) Holiday(d time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	day := calendarDate(d.Year(), d.Month(), d.Day())
+	if name, ok := c.dates[day]; ok {
+		return name, true
+	}
+	if c.rules != nil {
+		name, ok := c.holidaysFor(day.Year())[day]
+		return name, ok
+	}
+
+	return "", false
+}
+
+// IsBusinessDay reports whether d is neither a weekend nor a holiday.
+// A nil calendar only treats weekends as closed.
+func (c *HolidayCalendar) IsBusinessDay(d time.Time) bool {
+	if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+		return false
+	}
+
+	_, holiday := c.Holiday(d)
+	return !holiday
+}
+
+// Adjust moves d to a business day according to the convention.
+func (c *HolidayCalendar) Adjust(d time.Time, conv BusinessDayConvention) time.Time {
+	switch conv {
+	case Following:
+		return c.roll(d, 1)
+	case Preceding:
+		return c.roll(d, -1)
+	case ModifiedFollowing:
+		next := c.roll(d, 1)
+		if next.Month() != d.Month() {
+			return c.roll(d, -1)
+		}
+		return next
+	default:
+		return d
+	}
+}
+
+// roll steps one day at a time in the given direction until it lands on a business day
+func (c *HolidayCalendar) roll(d time.Time, step int) time.Time {
+	for !c.IsBusinessDay(d) {
+		d = d.AddDate(0, 0, step)
+	}
+	return d
+}
+
+// validateBusinessDayRule checks a Loan's convention and that its calendar is registered.
+func validateBusinessDayRule(conv BusinessDayConvention, calendar string) error {
+	switch conv {
+	case "", Unadjusted, Following, ModifiedFollowing, Preceding:
+	default:
+		return fmt.Errorf("unknown business day convention %q", conv)
+	}
+
+	_, err := holidayCalendarFor(calendar)
+	return err
+}
+
+// adjustDueDates moves the due dates of a schedule to business days.
+func adjustDueDates(payments []Payment, conv BusinessDayConvention, calendar string) error {
+	if conv == "" || conv == Unadjusted {
+		return nil
+	}
+
+	cal, err := holidayCalendarFor(calendar)
+	if err != nil {
+		return err
+	}
+
+	for i := range payments {
+		payments[i].DueDate = cal.Adjust(payments[i].DueDate, conv)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package delinquencytracker
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestUSFederalCalendar verifies fixed, floating and observed federal holidays.
+func TestUSFederalCalendar(t *testing.T) {
+	cal := USFederalCalendar()
+
+	holidays := []time.Time{
+		calendarDate(2025, time.January, 1),   // New Year's Day
+		calendarDate(2025, time.January, 20),  // third Monday
+		calendarDate(2025, time.May, 26),      // last Monday
+		calendarDate(2025, time.June, 19),     // Juneteenth
+		calendarDate(2025, time.November, 27), // fourth Thursday
+		calendarDate(2022, time.December, 26), // Christmas on a Sunday, observed Monday
+		calendarDate(2021, time.December, 31), // New Year's 2022 on a Saturday, observed Friday
+		calendarDate(2020, time.July, 3),      // Independence Day on a Saturday, observed Friday
+		calendarDate(2023, time.September, 4), // Labor Day
+		calendarDate(2024, time.October, 14),  // Columbus Day
+		calendarDate(2024, time.December, 25), // Christmas
+		calendarDate(2024, time.February, 19), // Washington's Birthday
+		calendarDate(2023, time.November, 10), // Veterans Day on a Saturday, observed Friday
+	}
+	for _, d := range holidays {
+		require.False(t, cal.IsBusinessDay(d), "%s should be a holiday", d.Format("2006-01-02"))
+	}
+
+	require.True(t, cal.IsBusinessDay(calendarDate(2025, time.June, 18)))
+	require.True(t, cal.IsBusinessDay(calendarDate(2020, time.June, 19)), "Juneteenth is only a holiday from 2021")
+	require.False(t, cal.IsBusinessDay(calendarDate(2025, time.June, 21)), "Saturdays are never business days")
+}
+
+// TestHolidayCalendarAdjust verifies each business day convention.
+func TestHolidayCalendarAdjust(t *testing.T) {
+	cal := USFederalCalendar()
+
+	tests := []struct {
+		name     string
+		date     time.Time
+		conv     BusinessDayConvention
+		expected time.Time
+	}{
+		{"Business day is kept", calendarDate(2024, time.December, 24), Following, calendarDate(2024, time.December, 24)},
+		{"Unadjusted keeps holiday", calendarDate(2024, time.December, 25), Unadjusted, calendarDate(2024, time.December, 25)},
+		{"Following skips Christmas", calendarDate(2024, time.December, 25), Following, calendarDate(2024, time.December, 26)},
+		{"Preceding goes back", calendarDate(2024, time.December, 25), Preceding, calendarDate(2024, time.December, 24)},
+		{"Following skips weekend and Labor Day", calendarDate(2024, time.September, 1), Following, calendarDate(2024, time.September, 3)},
+		{"Modified following stays in month", calendarDate(2025, time.May, 31), ModifiedFollowing, calendarDate(2025, time.May, 30)},
+		{"Modified following moves forward within month", calendarDate(2025, time.March, 15), ModifiedFollowing, calendarDate(2025, time.March, 17)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, cal.Adjust(tt.date, tt.conv))
+		})
+	}
+}
+
+// TestLoadHolidayCalendar verifies file calendars and their registration.
+func TestLoadHolidayCalendar(t *testing.T) {
+	cal, err := LoadHolidayCalendar("TEST", filepath.Join("testdata", "holidays.txt"))
+	require.NoError(t, err)
+
+	name, ok := cal.Holiday(calendarDate(2025, time.December, 24))
+	require.True(t, ok)
+	require.Equal(t, "Christmas Eve", name)
+	require.False(t, cal.IsBusinessDay(calendarDate(2025, time.December, 26)))
+	require.True(t, cal.IsBusinessDay(calendarDate(2025, time.December, 25)), "only the listed days are holidays")
+
+	RegisterHolidayCalendar(cal)
+	found, err := LookupHolidayCalendar("TEST")
+	require.NoError(t, err)
+	require.Same(t, cal, found)
+
+	_, err = LookupHolidayCalendar("nowhere")
+	require.Error(t, err)
+}
+
+// TestGenerateScheduleAdjustsDueDates verifies schedules move due dates off closed days.
+func TestGenerateScheduleAdjustsDueDates(t *testing.T) {
+	dateTaken := calendarDate(2024, time.July, 25)
+	opts := LoanOptions{BusinessDayConvention: Following, HolidayCalendar: "US"}
+
+	payments, err := generateSchedule(opts, 6000, 0.05, 6, 1, dateTaken)
+	require.NoError(t, err)
+
+	// 2024-09-01 is a Sunday followed by Labor Day
+	require.Equal(t, calendarDate(2024, time.August, 1), payments[0].DueDate)
+	require.Equal(t, calendarDate(2024, time.September, 3), payments[1].DueDate)
+	require.Equal(t, calendarDate(2025, time.January, 2), payments[5].DueDate, "New Year's Day is skipped")
+
+	_, err = generateSchedule(LoanOptions{BusinessDayConvention: "nearest"}, 6000, 0.05, 6, 1, dateTaken)
+	require.Error(t, err)
+	_, err = generateSchedule(LoanOptions{BusinessDayConvention: Following, HolidayCalendar: "nowhere"}, 6000, 0.05, 6, 1, dateTaken)
+	require.Error(t, err)
+}
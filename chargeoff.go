@@ -0,0 +1,389 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultChargeOffDays is the days past due at which RunChargeOffs charges off a Loan when no threshold is given
+const DefaultChargeOffDays = 120
+
+// Report periods
+const (
+	PeriodMonth   = "month"
+	PeriodQuarter = "quarter"
+	PeriodYear    = "year"
+)
+
+// ChargeOff records what was written off when a Loan was charged off.
+type ChargeOff struct {
+	ID            int64     // unique identifier for the charge-off
+	LoanID        int64     // which loan was charged off
+	ChargeOffDate time.Time // the day the Loan was charged off
+	Principal     float64   // principal written off
+	Interest      float64   // interest written off
+	Fees          float64   // fees written off
+	DaysPastDue   int       // days past due on the charge-off date
+	Approver      string    // who approved the charge-off
+	Reason        string    // why the Loan was charged off
+	CreatedAt     time.Time // when was this record created
+}
+
+// Total returns everything written off.
+func (c ChargeOff) Total() float64 {
+	return c.Principal + c.Interest + c.Fees
+}
+
+// LossPeriod is one period of the loss report.
+type LossPeriod struct {
+	Period     time.Time // first day of the period
+	ChargeOffs int       // how many loans were charged off in the period
+	GrossLoss  float64   // total written off in the period
+	Recoveries float64   // total recovered in the period, on any charged-off Loan
+	NetLoss    float64   // GrossLoss less Recoveries
+}
+
+// splitOutstanding splits what is still owed on a Loan into principal and interest as of a day.
+// Each installment is split into interest on the scheduled balance and principal, payments
+// cover interest first. Principal not yet due is the scheduled balance after the last due installment,
+// less whatever was already paid ahead on installments not due yet.
+func splitOutstanding(ln Loan, history []RateChange, asOf time.Time) (principal, interest float64) {
+	asOf = startOfDay(asOf)
+	balance := ln.TotalAmount
+	prepaid := 0.0
+
+	for _, p := range ln.Payments {
+		if startOfDay(p.DueDate).After(asOf) {
+			prepaid += p.AmountPaid
+			continue
+		}
+
+		rate := rateForPayment(history, p.PaymentNumber)
+		due := balance * rate / 12
+		balance = balance + due - p.AmountDue
+
+		unpaid := p.AmountDue - p.AmountPaid
+		if unpaid <= paymentTolerance {
+			continue
+		}
+		unpaidInterest := min(unpaid, max(due-p.AmountPaid, 0))
+		interest += unpaidInterest
+		principal += unpaid - unpaidInterest
+	}
+
+	return max(principal+max(balance, 0)-prepaid, 0), interest
+}
+
+// ChargeOffLoan writes off what is still owed on an active or defaulted Loan and moves it to charged_off,
+// approved by approver. Payments received afterwards are tracked as recoveries.
+func ChargeOffLoan(db *sql.DB, loanID int64, chargeOffDate time.Time, approver, reason string) (ChargeOff, error) {
+	return ChargeOffLoanContext(WithActor(context.Background(), approver), db, loanID, chargeOffDate, reason)
+}
+
+// ChargeOffLoanContext is ChargeOffLoan with the actor in ctx as the approver.
+func ChargeOffLoanContext(ctx context.Context, db *sql.DB, loanID int64, chargeOffDate time.Time, reason string) (ChargeOff, error) {
+	approver := ActorFromContext(ctx)
+	if approver == SystemActor {
+		return ChargeOff{}, fmt.Errorf("charge-off requires an approver, set the actor")
+	}
+	if chargeOffDate.IsZero() {
+		return ChargeOff{}, fmt.Errorf("chargeOffDate cannot be zero time")
+	}
+
+	var co ChargeOff
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		// the Loan stays locked until the write-off is recorded, so no payment slips in between
+		ln, err := lockLoan(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if ln.Status != LoanStatusActive && ln.Status != LoanStatusDefaulted {
+			return fmt.Errorf("Loan %d is %s and cannot be charged off", loanID, ln.Status)
+		}
+		if ln, err = withLedgerSchedule(tx, ln); err != nil {
+			return err
+		}
+
+		history, err := GetRateHistory(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if len(history) == 0 {
+			history = []RateChange{{LoanID: loanID, FromPayment: 1, Rate: ln.InterestRate}}
+		}
+
+		d, err := EvaluateDelinquency(ln, chargeOffDate)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", loanID, err)
+		}
+
+		fees, err := GetFeesByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		co = ChargeOff{
+			LoanID:        loanID,
+			ChargeOffDate: chargeOffDate.UTC(),
+			Fees:          outstandingFees(fees),
+			DaysPastDue:   d.DaysPastDue,
+			Approver:      approver,
+			Reason:        reason,
+		}
+		co.Principal, co.Interest = splitOutstanding(ln, history, chargeOffDate)
+
+		query := `
+		INSERT INTO charge_offs (loan_id, charge_off_date, principal, interest, fees, days_past_due, approver, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+		`
+
+		err = tx.QueryRow(query, co.LoanID, co.ChargeOffDate, co.Principal, co.Interest, co.Fees,
+			co.DaysPastDue, co.Approver, co.Reason).Scan(&co.ID, &co.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record charge-off: %w", err)
+		}
+		co.CreatedAt = co.CreatedAt.UTC()
+
+		if _, err := tx.Exec(`UPDATE loans SET status = $1 WHERE id = $2`, LoanStatusChargedOff, loanID); err != nil {
+			return fmt.Errorf("failed to charge off Loan %d: %w", loanID, err)
+		}
+
+		if err := postWriteOff(tx, co); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, AuditChargeOffLoan, AuditLoan, loanID, ln, co, reason)
+	})
+	if err != nil {
+		return ChargeOff{}, err
+	}
+
+	return co, nil
+}
+
+// postWriteOff cancels the installments due after the charge-off in the ledger and writes off
+// everything the ledger shows as owed on the charge-off date
+func postWriteOff(tx execer, co ChargeOff) error {
+	return closeOutLedger(tx, co.LoanID, co.ChargeOffDate, "charge-off", EntryWriteOff, AccountChargeOffLoss, co.Reason)
+}
+
+// closeOutLedger cancels the installments due after a day and moves everything the ledger
+// shows as owed on that day to a loss account with entries of entryType
+func closeOutLedger(tx execer, loanID int64, day time.Time, cancelMemo, entryType, lossAccount, memo string) error {
+	if err := cancelInstallmentsAfter(tx, loanID, day, cancelMemo); err != nil {
+		return err
+	}
+
+	b, err := GetLoanBalances(tx, loanID, day)
+	if err != nil {
+		return err
+	}
+
+	var entries []LedgerEntry
+	for _, account := range []string{AccountPrincipal, AccountDue, AccountFees} {
+		entries = append(entries, LedgerEntry{LoanID: loanID, Type: entryType, DebitAccount: lossAccount,
+			CreditAccount: account, Amount: b.Accounts[account], EffectiveDate: day, Memo: memo})
+	}
+
+	return postLedgerEntries(tx, entries...)
+}
+
+// RunChargeOffs charges off every active or defaulted Loan at least thresholdDays past due as of a day.
+// Loans on an active repayment plan are left alone. A thresholdDays of 0 uses DefaultChargeOffDays.
+func RunChargeOffs(db *sql.DB, asOf time.Time, thresholdDays int, approver string) ([]ChargeOff, error) {
+	return RunChargeOffsContext(WithActor(context.Background(), approver), db, asOf, thresholdDays)
+}
+
+// RunChargeOffsContext is RunChargeOffs with the actor in ctx as the approver.
+func RunChargeOffsContext(ctx context.Context, db *sql.DB, asOf time.Time, thresholdDays int) ([]ChargeOff, error) {
+	if thresholdDays <= 0 {
+		thresholdDays = DefaultChargeOffDays
+	}
+
+	rows, err := db.Query(`SELECT id FROM loans WHERE status IN ($1, $2) AND deleted_at IS NULL ORDER BY id`, LoanStatusActive, LoanStatusDefaulted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loans: %w", err)
+	}
+	defer rows.Close()
+
+	var loanIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan Loan row: %w", err)
+		}
+		loanIDs = append(loanIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan rows: %w", err)
+	}
+
+	var chargeOffs []ChargeOff
+	for _, id := range loanIDs {
+		onPlan, err := hasActivePlan(db, id)
+		if err != nil {
+			return chargeOffs, err
+		}
+		if onPlan {
+			continue
+		}
+
+		d, err := GetLoanDelinquency(db, id, asOf)
+		if err != nil {
+			return chargeOffs, err
+		}
+		if d.DaysPastDue < thresholdDays {
+			continue
+		}
+
+		reason := fmt.Sprintf("%d days past due", d.DaysPastDue)
+		co, err := ChargeOffLoanContext(ctx, db, id, asOf, reason)
+		if err != nil {
+			return chargeOffs, err
+		}
+		chargeOffs = append(chargeOffs, co)
+	}
+
+	return chargeOffs, nil
+}
+
+// GetChargeOffByLoanID returns the charge-off of a Loan.
+func GetChargeOffByLoanID(db execer, loanID int64) (ChargeOff, error) {
+	query := `
+	SELECT id, loan_id, charge_off_date, principal, interest, fees, days_past_due, approver, reason, created_at
+	FROM charge_offs
+	WHERE loan_id = $1
+	`
+
+	var co ChargeOff
+
+	err := db.QueryRow(query, loanID).Scan(
+		&co.ID,
+		&co.LoanID,
+		&co.ChargeOffDate,
+		&co.Principal,
+		&co.Interest,
+		&co.Fees,
+		&co.DaysPastDue,
+		&co.Approver,
+		&co.Reason,
+		&co.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return ChargeOff{}, fmt.Errorf("Loan %d has not been charged off", loanID)
+	}
+	if err != nil {
+		return ChargeOff{}, fmt.Errorf("failed to get charge-off: %w", err)
+	}
+
+	co.ChargeOffDate = co.ChargeOffDate.UTC()
+	co.CreatedAt = co.CreatedAt.UTC()
+
+	return co, nil
+}
+
+// GetRecoveriesByLoanID returns the money received on a Loan after it was charged off, oldest first.
+// Recoveries that were returned are left out.
+func GetRecoveriesByLoanID(db execer, loanID int64) ([]PostedPayment, error) {
+	posted, err := GetPostedPaymentsByLoanID(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	var recoveries []PostedPayment
+	for _, pp := range posted {
+		if pp.Kind == PostingRecovery && !pp.Reversed {
+			recoveries = append(recoveries, pp)
+		}
+	}
+
+	return recoveries, nil
+}
+
+// ChargeOffReport totals gross charge-offs, recoveries and net loss per period between two days.
+// period is "month", "quarter" or "year". Periods with neither charge-offs nor recoveries are left out.
+func ChargeOffReport(db *sql.DB, from, to time.Time, period string) ([]LossPeriod, error) {
+	switch period {
+	case PeriodMonth, PeriodQuarter, PeriodYear:
+	default:
+		return nil, fmt.Errorf("invalid report period %q", period)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("report end %s is before start %s", to.Format("2006-01-02"), from.Format("2006-01-02"))
+	}
+
+	byPeriod := map[time.Time]*LossPeriod{}
+	get := func(p time.Time) *LossPeriod {
+		p = p.UTC()
+		if _, ok := byPeriod[p]; !ok {
+			byPeriod[p] = &LossPeriod{Period: p}
+		}
+		return byPeriod[p]
+	}
+
+	// Step 1: gross charge-offs
+	rows, err := db.Query(`
+	SELECT date_trunc($1, charge_off_date AT TIME ZONE 'UTC'), COUNT(*), SUM(principal + interest + fees)
+	FROM charge_offs
+	WHERE charge_off_date >= $2 AND charge_off_date < $3
+	GROUP BY 1
+	`, period, startOfDay(from), startOfDay(to).AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query charge-offs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p time.Time
+		var count int
+		var gross float64
+		if err := rows.Scan(&p, &count, &gross); err != nil {
+			return nil, fmt.Errorf("failed to scan charge-off row: %w", err)
+		}
+		lp := get(p)
+		lp.ChargeOffs = count
+		lp.GrossLoss = gross
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating charge-off rows: %w", err)
+	}
+
+	// Step 2: recoveries
+	rows, err = db.Query(`
+	SELECT date_trunc($1, received_date AT TIME ZONE 'UTC'), SUM(amount)
+	FROM posted_payments
+	WHERE kind = $2 AND NOT reversed AND received_date >= $3 AND received_date < $4
+	GROUP BY 1
+	`, period, PostingRecovery, startOfDay(from), startOfDay(to).AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recoveries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p time.Time
+		var recovered float64
+		if err := rows.Scan(&p, &recovered); err != nil {
+			return nil, fmt.Errorf("failed to scan recovery row: %w", err)
+		}
+		get(p).Recoveries = recovered
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recovery rows: %w", err)
+	}
+
+	// Step 3: net loss, oldest period first
+	report := make([]LossPeriod, 0, len(byPeriod))
+	for _, lp := range byPeriod {
+		lp.NetLoss = lp.GrossLoss - lp.Recoveries
+		report = append(report, *lp)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Period.Before(report[j].Period) })
+
+	return report, nil
+}
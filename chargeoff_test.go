@@ -0,0 +1,134 @@
+package delinquencytracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSplitOutstanding verifies what is owed is split into principal and interest.
+func TestSplitOutstanding(t *testing.T) {
+	payments, err := LevelSchedule{}.Generate(12000, 0.12, 12, 1, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+	history := []RateChange{{FromPayment: 1, Rate: 0.12}}
+	ln := Loan{TotalAmount: 12000, Payments: payments}
+
+	// nothing due yet, the whole principal is outstanding
+	principal, interest := splitOutstanding(ln, history, calendarDate(2024, time.January, 15))
+	require.InDelta(t, 12000, principal, 0.01)
+	require.Equal(t, 0.0, interest)
+
+	// first installment missed: $120 interest and the rest is principal
+	principal, interest = splitOutstanding(ln, history, calendarDate(2024, time.February, 15))
+	require.InDelta(t, 120, interest, 0.01)
+	require.InDelta(t, 12000, principal, 0.01)
+
+	// a partial payment covers interest first
+	ln.Payments[0].AmountPaid = 100
+	principal, interest = splitOutstanding(ln, history, calendarDate(2024, time.February, 15))
+	require.InDelta(t, 20, interest, 0.01)
+	require.InDelta(t, 12000, principal, 0.01)
+
+	// money paid ahead on an installment not due yet already reduced the principal
+	ln.Payments[2].AmountPaid = 500
+	principal, interest = splitOutstanding(ln, history, calendarDate(2024, time.February, 15))
+	require.InDelta(t, 20, interest, 0.01)
+	require.InDelta(t, 11500, principal, 0.01)
+}
+
+// TestChargeOffLoan verifies the write-off is recorded and later money becomes a recovery.
+func TestChargeOffLoan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan that was never paid
+	user, err := InitializeUserWithLoan(db, "Lost User", "lost@example.com", "555-1313",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	_, err = AssessFee(db, ln.ID, FeeLate, 25, calendarDate(2024, time.February, 16))
+	require.NoError(t, err)
+
+	// Act
+	cos, err := RunChargeOffs(db, calendarDate(2024, time.June, 15), 0, "collections")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, cos, 1)
+	co := cos[0]
+	require.Equal(t, ln.ID, co.LoanID)
+	require.Equal(t, 135, co.DaysPastDue)
+	require.Equal(t, "collections", co.Approver)
+	require.InDelta(t, 25, co.Fees, 0.001)
+	require.Greater(t, co.Interest, 0.0)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusChargedOff, full.Status)
+
+	_, err = ChargeOffLoan(db, ln.ID, calendarDate(2024, time.July, 1), "collections", "again")
+	require.Error(t, err, "a charged-off Loan cannot be charged off again")
+	_, err = ChargeOffLoanContext(context.Background(), db, ln.ID, calendarDate(2024, time.July, 1), "no actor")
+	require.ErrorContains(t, err, "requires an approver")
+	active := LoanStatusActive
+	_, err = PatchLoan(db, ln.ID, LoanPatch{Status: &active})
+	require.ErrorContains(t, err, "cannot be reopened")
+
+	// money received now is a recovery and leaves the schedule alone
+	pp, err := PostPayment(db, ln.ID, 300, calendarDate(2024, time.July, 10))
+	require.NoError(t, err)
+	require.Equal(t, PostingRecovery, pp.Kind)
+	require.Equal(t, co.ID, pp.ChargeOffID)
+	require.Empty(t, pp.Allocations)
+
+	full, err = GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0.0, full.Payments[0].AmountPaid)
+
+	recoveries, err := GetRecoveriesByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, recoveries, 1)
+
+	report, err := ChargeOffReport(db, calendarDate(2024, time.January, 1), calendarDate(2024, time.December, 31), PeriodQuarter)
+	require.NoError(t, err)
+	require.Len(t, report, 2)
+	require.Equal(t, calendarDate(2024, time.April, 1), report[0].Period)
+	require.InDelta(t, co.Total(), report[0].NetLoss, 0.01)
+	require.Equal(t, calendarDate(2024, time.July, 1), report[1].Period)
+	require.InDelta(t, -300, report[1].NetLoss, 0.01)
+}
+
+// TestPostPayment verifies the waterfall pays due installments, then fees, then future installments.
+func TestPostPayment(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Paying User", "paying@example.com", "555-1414",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	fee, err := AssessFee(db, ln.ID, FeeLate, 25, calendarDate(2024, time.February, 16))
+	require.NoError(t, err)
+
+	// Act, $400 installment due and a $25 fee, with $100 over
+	pp, err := PostPayment(db, ln.ID, 525, calendarDate(2024, time.February, 20))
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, PostingPayment, pp.Kind)
+	require.Len(t, pp.Allocations, 3)
+	require.InDelta(t, 400, pp.Allocations[0].Amount, 0.001)
+	require.Equal(t, fee.ID, pp.Allocations[1].FeeID)
+	require.InDelta(t, 100, pp.Allocations[2].Amount, 0.001)
+
+	// the rest pays the Loan off, with $50 left unapplied
+	pp, err = PostPayment(db, ln.ID, 750, calendarDate(2024, time.March, 1))
+	require.NoError(t, err)
+	require.InDelta(t, 50, pp.UnappliedAmount, 0.001)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusPaidOff, full.Status)
+}
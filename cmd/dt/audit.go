@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runAudit lists audit entries, newest first
+func runAudit(_ context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	entity := fs.String("entity", "", "only entries for this entity type, e.g. user or loan")
+	id := fs.Int64("id", 0, "only entries for this entity id")
+	actor := fs.String("actor", "", "only entries made by this actor")
+	from := fs.String("from", "", "only entries made on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only entries made before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 50, "at most this many entries, 0 for all")
+	asJSON := fs.Bool("json", false, "print entries as JSON with their before and after records")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f := dt.AuditFilter{EntityType: *entity, EntityID: *id, Actor: *actor, Limit: *limit}
+	var err error
+	if f.From, err = parseDate(*from); err != nil {
+		return err
+	}
+	if f.To, err = parseDate(*to); err != nil {
+		return err
+	}
+
+	entries, err := dt.GetAuditLog(db, f)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tOPERATION\tENTITY\tREASON")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s %d\t%s\n",
+			e.CreatedAt.Format(time.RFC3339), e.Actor, e.Operation, e.EntityType, e.EntityID, e.Reason)
+	}
+	return w.Flush()
+}
+
+// parseDate reads a YYYY-MM-DD flag, leaving an empty one as the zero time
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runCollections opens and closes collection cases and works the queues
+func runCollections(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt collections run|queue|show|assign|log|promise|promises|promise-report [flags]")
+	}
+
+	switch args[0] {
+	case "run":
+		return runCollectionsRun(ctx, db, args[1:])
+	case "queue":
+		return runCollectionsQueue(db, args[1:])
+	case "show":
+		return runCollectionsShow(db, args[1:])
+	case "assign":
+		return runCollectionsAssign(ctx, db, args[1:])
+	case "log":
+		return runCollectionsLog(ctx, db, args[1:])
+	case "promise":
+		return runCollectionsPromise(ctx, db, args[1:])
+	case "promises":
+		return runCollectionsPromises(db, args[1:])
+	case "promise-report":
+		return runCollectionsPromiseReport(db, args[1:])
+	default:
+		return fmt.Errorf("unknown collections command %q, want run, queue, show, assign, log, promise, promises or promise-report", args[0])
+	}
+}
+
+func runCollectionsRun(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections run", flag.ContinueOnError)
+	asOf := fs.String("as-of", "", "day to evaluate delinquency for (YYYY-MM-DD, default today)")
+	threshold := fs.Int("threshold", dt.DefaultCollectionsDays, "days past due at which a case is opened")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	run, err := dt.RunCollectionsContext(ctx, db, day, *threshold)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("settled %d promises\n", len(run.Promises))
+	fmt.Printf("ended %d repayment plans\n", len(run.Plans))
+	fmt.Printf("ended %d settlements\n", len(run.Settlements))
+	fmt.Printf("opened %d, rerouted %d, closed %d cases\n", len(run.Opened), len(run.Rerouted), len(run.Closed))
+	return nil
+}
+
+func runCollectionsQueue(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections queue", flag.ContinueOnError)
+	var f dt.WorkQueueFilter
+	fs.StringVar(&f.Queue, "queue", "", "only cases in this queue")
+	fs.StringVar(&f.AssignedTo, "assignee", "", "only cases assigned to this collector")
+	fs.BoolVar(&f.Unassigned, "unassigned", false, "only cases nobody is working")
+	fs.IntVar(&f.Limit, "limit", 50, "at most this many cases, 0 for all")
+	fs.BoolVar(&f.IncludePromised, "include-promised", false, "also cases held off by a promise to pay")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cases, err := dt.GetWorkQueue(db, f)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CASE\tLOAN\tQUEUE\tDPD\tPAST DUE\tBALANCE\tASSIGNEE")
+	for _, c := range cases {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%.2f\t%.2f\t%s\n", c.ID, c.LoanID, c.Queue, c.DaysPastDue, c.PastDueAmount, c.Balance, c.AssignedTo)
+	}
+	return w.Flush()
+}
+
+func runCollectionsShow(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections show", flag.ContinueOnError)
+	id := fs.Int64("case", 0, "the collection case to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := dt.GetCollectionCase(db, *id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Case\t%d (%s)\n", c.ID, c.Status)
+	fmt.Fprintf(w, "Loan\t%d\n", c.LoanID)
+	fmt.Fprintf(w, "Queue\t%s\n", c.Queue)
+	fmt.Fprintf(w, "Days past due\t%d\n", c.DaysPastDue)
+	fmt.Fprintf(w, "Past due\t%.2f of %.2f\n", c.PastDueAmount, c.Balance)
+	fmt.Fprintf(w, "Assigned to\t%s\n", c.AssignedTo)
+	fmt.Fprintf(w, "Opened\t%s\n", formatDate(c.OpenedAt))
+	if c.Status == dt.CaseClosed {
+		fmt.Fprintf(w, "Closed\t%s (%s)\n", formatDate(c.ClosedAt), c.CloseReason)
+	}
+	for _, a := range c.Contacts {
+		fmt.Fprintf(w, "%s\t%s by %s to User %d: %s %s\n",
+			a.ContactedAt.Format(time.RFC3339), a.Channel, a.Collector, a.UserID, a.Outcome, a.Notes)
+	}
+	return w.Flush()
+}
+
+func runCollectionsAssign(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections assign", flag.ContinueOnError)
+	id := fs.Int64("case", 0, "the collection case to assign")
+	to := fs.String("to", "", "the collector to work it, empty to unassign")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := dt.AssignCaseContext(ctx, db, *id, *to)
+	if err != nil {
+		return err
+	}
+	if c.AssignedTo == "" {
+		fmt.Printf("case %d is unassigned\n", c.ID)
+		return nil
+	}
+	fmt.Printf("case %d is assigned to %s\n", c.ID, c.AssignedTo)
+	return nil
+}
+
+func runCollectionsLog(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections log", flag.ContinueOnError)
+	var a dt.ContactAttempt
+	fs.Int64Var(&a.CaseID, "case", 0, "the collection case")
+	fs.Int64Var(&a.UserID, "user", 0, "the party contacted (default the primary borrower)")
+	fs.StringVar(&a.Channel, "channel", dt.ChannelPhone, "email, sms, phone or mail")
+	fs.StringVar(&a.Outcome, "outcome", "", "outcome code, e.g. no_answer, left_message or promise_to_pay")
+	fs.StringVar(&a.Notes, "notes", "", "what was said")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := dt.LogContactAttemptContext(ctx, db, a)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("logged contact attempt %d on case %d\n", a.ID, a.CaseID)
+	return nil
+}
+
+func runCollectionsPromise(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections promise", flag.ContinueOnError)
+	var p dt.PromiseToPay
+	fs.Int64Var(&p.LoanID, "loan", 0, "the Loan the money is promised for")
+	fs.Int64Var(&p.UserID, "user", 0, "the party who promised (default the primary borrower)")
+	fs.Float64Var(&p.Amount, "amount", 0, "how much was promised")
+	date := fs.String("date", "", "the day it is to be paid by (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if p.PromisedDate, err = parseDate(*date); err != nil {
+		return err
+	}
+
+	p, err = dt.RecordPromiseContext(ctx, db, p)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("recorded promise %d of %.2f on Loan %d by %s\n", p.ID, p.Amount, p.LoanID, formatDate(p.PromisedDate))
+	return nil
+}
+
+func runCollectionsPromises(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections promises", flag.ContinueOnError)
+	loanID := fs.Int64("loan", 0, "the Loan whose promises to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	promises, err := dt.GetPromisesByLoanID(db, *loanID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROMISE\tUSER\tCOLLECTOR\tAMOUNT\tMADE\tBY\tSTATUS\tPAID")
+	for _, p := range promises {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%.2f\t%s\t%s\t%s\t%.2f\n",
+			p.ID, p.UserID, p.Collector, p.Amount, formatDate(p.MadeOn), formatDate(p.PromisedDate), p.Status, p.AmountPaid)
+	}
+	return w.Flush()
+}
+
+func runCollectionsPromiseReport(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("collections promise-report", flag.ContinueOnError)
+	from := fs.String("from", "", "first promised day to report on (YYYY-MM-DD)")
+	to := fs.String("to", "", "last promised day to report on (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	start, err := parseDate(*from)
+	if err != nil {
+		return err
+	}
+	end, err := parseDate(*to)
+	if err != nil {
+		return err
+	}
+	if end.IsZero() {
+		end = time.Now().UTC()
+	}
+
+	rates, err := dt.PromiseKeptRates(db, start, end)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTOR\tPROMISES\tKEPT\tPARTIAL\tBROKEN\tPENDING\tPROMISED\tPAID\tKEPT RATE")
+	for _, r := range rates {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.2f\t%.2f\t%.0f%%\n",
+			r.Collector, r.Promises, r.Kept, r.PartiallyKept, r.Broken, r.Pending, r.AmountPromised, r.AmountPaid, r.KeptRate*100)
+	}
+	return w.Flush()
+}
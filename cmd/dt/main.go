@@ -1,5 +1,110 @@
+// Command dt browses and maintains the delinquency tracker database and serves its HTTP API.
+//
+// It connects with $DT_DATABASE_URL, or to the local loan_tracker database when that is unset,
+// and acts as $DT_ACTOR, or $USER, in the audit log. Phone numbers without a country code
+// are read as $DT_PHONE_REGION numbers, US by default.
 package main
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+const defaultDatabaseURL = "host=localhost port=5432 user=postgres password=amir dbname=loan_tracker sslmode=disable"
+
+// command is one dt subcommand, run with the arguments after its name
+type command struct {
+	summary string
+	run     func(ctx context.Context, db *sql.DB, args []string) error
+}
+
+var commands = map[string]command{
+	"audit":              {"browse the audit log by entity or actor", runAudit},
+	"collections":        {"open collection cases and work the queues", runCollections},
+	"normalize-contacts": {"rewrite stored emails and phones in normalized form", runNormalizeContacts},
+	"notify":             {"send borrowers due reminders, missed-payment notices and payoff confirmations", runNotify},
+	"plan":               {"set up and follow arrears repayment plans", runPlan},
+	"search":             {"find borrowers by part of their name, email or phone", runSearch},
+	"serve":              {"serve the HTTP API", runServe},
+	"settlement":         {"offer, approve and follow settlements for less than owed", runSettlement},
+	"strategy":           {"check collection strategies and run them over the portfolio", runStrategy},
+	"template":           {"write, preview and activate localized notice templates", runTemplate},
+	"user":               {"show, edit, find duplicate or merge borrowers", runUser},
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "dt: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if region := os.Getenv("DT_PHONE_REGION"); region != "" {
+		dt.DefaultPhoneRegion = region
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dt: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	ctx := dt.WithActor(context.Background(), actor())
+	if err := cmd.run(ctx, db, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "dt %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: dt <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, commands[name].summary)
+	}
+}
+
+// openDatabase connects to the database and makes sure its schema is current
+func openDatabase() (*sql.DB, error) {
+	url := os.Getenv("DT_DATABASE_URL")
+	if url == "" {
+		url = defaultDatabaseURL
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := dt.ApplySchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
 
+// actor names whoever is running dt in the audit log
+func actor() string {
+	if a := os.Getenv("DT_ACTOR"); a != "" {
+		return a
+	}
+	return os.Getenv("USER")
 }
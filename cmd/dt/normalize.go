@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runNormalizeContacts rewrites stored emails and phones in normalized form and reports what needs a human
+func runNormalizeContacts(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("normalize-contacts", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	region := fs.String("region", dt.DefaultPhoneRegion, "region of phone numbers stored without a country code")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dt.DefaultPhoneRegion = *region
+
+	report, err := dt.NormalizeStoredContacts(ctx, db, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("checked %d users, %s %d\n", report.Checked, verb, report.Updated)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(report.Invalid) > 0 {
+		fmt.Fprintln(w, "\nUSER\tFIELD\tVALUE\tPROBLEM")
+		for _, issue := range report.Invalid {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", issue.UserID, issue.Field, issue.Value, issue.Error)
+		}
+	}
+	if len(report.Collisions) > 0 {
+		fmt.Fprintln(w, "\nFIELD\tVALUE\tUSERS")
+		for _, c := range report.Collisions {
+			fmt.Fprintf(w, "%s\t%s\t%v\n", c.Field, c.Value, c.UserIDs)
+		}
+	}
+	return w.Flush()
+}
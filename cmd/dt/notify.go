@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runNotify sends borrower notices and shows what was sent
+func runNotify(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt notify run|log|inbox [flags]")
+	}
+
+	switch args[0] {
+	case "run":
+		return runNotifyRun(ctx, db, args[1:])
+	case "log":
+		return runNotifyLog(db, args[1:])
+	case "inbox":
+		return runNotifyInbox(db, args[1:])
+	default:
+		return fmt.Errorf("unknown notify command %q, want run, log or inbox", args[0])
+	}
+}
+
+func runNotifyRun(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("notify run", flag.ContinueOnError)
+	asOf := fs.String("as-of", "", "day to send notices for (YYYY-MM-DD, default today)")
+	smtpAddr := fs.String("smtp", "", "host:port of the SMTP server to email through (no email when empty)")
+	from := fs.String("from", "", "the address email is sent from")
+	smsFile := fs.String("sms-file", "", "append texts to this file instead of sending them (no SMS when empty)")
+	inApp := fs.Bool("in-app", true, "put notices in the borrower's in-app inbox")
+	svc := dt.NotificationService{DB: db}
+	fs.IntVar(&svc.ReminderDays, "reminder-days", dt.DefaultReminderDays, "days before an installment falls due to remind")
+	fs.IntVar(&svc.PayoffLookbackDays, "payoff-lookback", dt.DefaultPayoffLookbackDays, "days back to confirm payoffs")
+	fs.DurationVar(&svc.ClaimTimeout, "claim-timeout", dt.DefaultClaimTimeout, "how long a notice a run claimed but never sent stays claimed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	if *smtpAddr != "" {
+		if *from == "" {
+			return errors.New("-from is required to send email")
+		}
+		svc.Notifiers = append(svc.Notifiers, dt.SMTPNotifier{Addr: *smtpAddr, From: *from})
+	}
+	if *smsFile != "" {
+		svc.Notifiers = append(svc.Notifiers, dt.SMSNotifier{Provider: &dt.FileSMSProvider{Path: *smsFile}})
+	}
+	if *inApp {
+		svc.Notifiers = append(svc.Notifiers, dt.InAppNotifier{DB: db})
+	}
+	if svc.Templates, err = dt.LoadTemplates(db); err != nil {
+		return err
+	}
+
+	run, err := svc.Run(ctx, day)
+	if err != nil {
+		return err
+	}
+	for _, nt := range run.Failed {
+		fmt.Printf("notification %d to User %d failed: %s\n", nt.ID, nt.UserID, nt.Error)
+	}
+	fmt.Printf("sent %d, failed %d, skipped %d notices\n", len(run.Sent), len(run.Failed), len(run.Skipped))
+	return nil
+}
+
+func runNotifyLog(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("notify log", flag.ContinueOnError)
+	userID := fs.Int64("user", 0, "the User whose notices to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	notifications, err := dt.GetNotificationsByUserID(db, *userID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLOAN\tKIND\t#\tCHANNEL\tTO\tSTATUS\tATTEMPTS\tSENT\tERROR")
+	for _, nt := range notifications {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			nt.ID, nt.LoanID, nt.Kind, nt.PaymentNumber, nt.Channel, nt.Recipient, nt.Status, nt.Attempts, formatDate(nt.SentAt), nt.Error)
+	}
+	return w.Flush()
+}
+
+func runNotifyInbox(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("notify inbox", flag.ContinueOnError)
+	userID := fs.Int64("user", 0, "the User whose in-app inbox to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	messages, err := dt.GetInAppMessages(db, *userID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECEIVED\tKIND\tSUBJECT")
+	for _, m := range messages {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.CreatedAt.Format(time.RFC3339), m.Kind, m.Subject)
+	}
+	return w.Flush()
+}
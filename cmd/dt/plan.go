@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runPlan sets up and follows arrears repayment plans
+func runPlan(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt plan create|show|cancel|evaluate [flags]")
+	}
+
+	switch args[0] {
+	case "create":
+		return runPlanCreate(ctx, db, args[1:])
+	case "show":
+		return runPlanShow(db, args[1:])
+	case "cancel":
+		return runPlanCancel(ctx, db, args[1:])
+	case "evaluate":
+		return runPlanEvaluate(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown plan command %q, want create, show, cancel or evaluate", args[0])
+	}
+}
+
+func runPlanCreate(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("plan create", flag.ContinueOnError)
+	loanID := fs.Int64("loan", 0, "the Loan in arrears")
+	installments := fs.Int("installments", 3, "how many regular installments to spread the arrears over")
+	start := fs.String("start", "", "the day the plan starts (YYYY-MM-DD, default today)")
+	approver := fs.String("approver", "", "who agreed the plan with the borrower")
+	reason := fs.String("reason", "", "why the plan was offered")
+	var rules dt.PlanBreakRules
+	fs.IntVar(&rules.GraceDays, "grace", dt.DefaultPlanGraceDays, "days past due on the plan before it breaks")
+	fs.IntVar(&rules.MaxMissed, "max-missed", dt.DefaultPlanMaxMissed, "missed payments before it breaks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*start)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	plan, err := dt.CreateRepaymentPlanContext(ctx, db, *loanID, *installments, day, rules, *approver, *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created repayment plan %d for %.2f of arrears on Loan %d\n", plan.ID, plan.Arrears, plan.LoanID)
+	return printPlanInstallments(plan.Installments)
+}
+
+func runPlanShow(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("plan show", flag.ContinueOnError)
+	id := fs.Int64("plan", 0, "the repayment plan to show")
+	asOf := fs.String("as-of", "", "day to evaluate the plan for (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	plan, err := dt.GetRepaymentPlan(db, *id)
+	if err != nil {
+		return err
+	}
+	d, err := dt.EvaluateRepaymentPlan(db, plan.ID, day)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Plan\t%d (%s)\n", plan.ID, plan.Status)
+	fmt.Fprintf(w, "Loan\t%d\n", plan.LoanID)
+	fmt.Fprintf(w, "Arrears\t%.2f, %.2f still to pay\n", plan.Arrears, d.Remaining)
+	fmt.Fprintf(w, "Started\t%s, approved by %s\n", formatDate(plan.StartDate), plan.Approver)
+	fmt.Fprintf(w, "Breaks\tafter %d days past due or %d missed installments\n", plan.Rules.GraceDays, plan.Rules.MaxMissed)
+	fmt.Fprintf(w, "Past due\t%.2f, %d days\n", d.PastDueAmount, d.DaysPastDue)
+	if plan.Status != dt.PlanActive {
+		fmt.Fprintf(w, "Ended\t%s (%s)\n", formatDate(plan.EndedAt), plan.EndReason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	return printPlanInstallments(d.Installments)
+}
+
+// printPlanInstallments prints the installments of a plan as a table
+func printPlanInstallments(installments []dt.PlanInstallment) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tDUE\tAMOUNT\tPAID\tDAYS PAST DUE")
+	for _, inst := range installments {
+		fmt.Fprintf(w, "%d\t%s\t%.2f\t%.2f\t%d\n", inst.Number, formatDate(inst.DueDate), inst.AmountDue, inst.AmountPaid, inst.PastDueDays)
+	}
+	return w.Flush()
+}
+
+func runPlanCancel(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("plan cancel", flag.ContinueOnError)
+	id := fs.Int64("plan", 0, "the repayment plan to call off")
+	reason := fs.String("reason", "", "why it is called off")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	plan, err := dt.CancelRepaymentPlanContext(ctx, db, *id, *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("cancelled repayment plan %d, Loan %d is back in collections\n", plan.ID, plan.LoanID)
+	return nil
+}
+
+func runPlanEvaluate(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("plan evaluate", flag.ContinueOnError)
+	asOf := fs.String("as-of", "", "day to evaluate the plans for (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	ended, err := dt.EvaluateRepaymentPlansContext(ctx, db, day)
+	if err != nil {
+		return err
+	}
+	for _, plan := range ended {
+		fmt.Printf("plan %d on Loan %d %s: %s\n", plan.ID, plan.LoanID, plan.Status, plan.EndReason)
+	}
+	fmt.Printf("%d plans ended\n", len(ended))
+	return nil
+}
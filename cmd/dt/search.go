@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runSearch looks borrowers up by part of their name, email or phone
+func runSearch(_ context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	limit := fs.Int("limit", dt.DefaultSearchLimit, "results per page")
+	offset := fs.Int("offset", 0, "results to skip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: dt search [-limit N] [-offset N] name, email fragment or last digits of a phone")
+	}
+
+	page, err := dt.SearchUsers(db, dt.UserSearch{Query: strings.Join(fs.Args(), " "), Limit: *limit, Offset: *offset})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tID\tNAME\tEMAIL\tPHONE\tLOANS\tPAST DUE\tDPD")
+	for _, r := range page.Results {
+		fmt.Fprintf(w, "%.2f\t%d\t%s\t%s\t%s\t%d/%d\t%.2f\t%d\n", r.Rank, r.User.ID, r.User.Name, r.User.Email, r.User.Phone,
+			r.Summary.ActiveLoans, r.Summary.Loans, r.Summary.PastDueAmount, r.Summary.MaxDaysPastDue)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if shown := page.Offset + len(page.Results); shown < page.Total {
+		fmt.Printf("\n%d-%d of %d, next page with -offset %d\n", page.Offset+1, shown, page.Total, shown)
+	}
+	return nil
+}
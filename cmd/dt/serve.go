@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runServe serves the HTTP API until it fails
+func runServe(_ context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           dt.NewHandler(db),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("serving on http://%s\n", *addr)
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runSettlement offers, approves and follows settlements of delinquent and charged-off loans
+func runSettlement(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt settlement offer|approve|reject|show|evaluate [flags]")
+	}
+
+	switch args[0] {
+	case "offer":
+		return runSettlementOffer(ctx, db, args[1:])
+	case "approve":
+		return runSettlementApprove(ctx, db, args[1:])
+	case "reject":
+		return runSettlementReject(ctx, db, args[1:])
+	case "show":
+		return runSettlementShow(db, args[1:])
+	case "evaluate":
+		return runSettlementEvaluate(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown settlement command %q, want offer, approve, reject, show or evaluate", args[0])
+	}
+}
+
+func runSettlementOffer(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("settlement offer", flag.ContinueOnError)
+	var offer dt.SettlementOffer
+	fs.Int64Var(&offer.LoanID, "loan", 0, "the Loan to settle")
+	fs.Float64Var(&offer.Amount, "amount", 0, "what the borrower pays to settle")
+	fs.IntVar(&offer.Installments, "installments", 1, "1 for a lump sum, more for monthly installments")
+	first := fs.String("first-due", "", "when the lump sum or first installment is due (YYYY-MM-DD, default the expiry)")
+	offered := fs.String("offered", "", "the day of the offer (YYYY-MM-DD, default today)")
+	expires := fs.String("expires", "", "the offer lapses unless approved by this day (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if offer.FirstDueDate, err = parseDate(*first); err != nil {
+		return err
+	}
+	if offer.OfferedOn, err = parseDate(*offered); err != nil {
+		return err
+	}
+	if offer.ExpiresOn, err = parseDate(*expires); err != nil {
+		return err
+	}
+
+	s, err := dt.OfferSettlementContext(ctx, db, offer)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("offered settlement %d of %.2f against %.2f owed on Loan %d, expires %s\n",
+		s.ID, s.Amount, s.Balance, s.LoanID, formatDate(s.ExpiresOn))
+	return printSettlementInstallments(s.Installments)
+}
+
+func runSettlementApprove(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("settlement approve", flag.ContinueOnError)
+	id := fs.Int64("settlement", 0, "the settlement to approve, as DT_ACTOR, who cannot be whoever offered it")
+	on := fs.String("on", "", "the day of the approval (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*on)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	s, err := dt.ApproveSettlementContext(ctx, db, *id, day)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("settlement %d on Loan %d approved by %s\n", s.ID, s.LoanID, s.Approver)
+	return nil
+}
+
+func runSettlementReject(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("settlement reject", flag.ContinueOnError)
+	id := fs.Int64("settlement", 0, "the settlement to turn down, as DT_ACTOR")
+	reason := fs.String("reason", "", "why it is turned down")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := dt.RejectSettlementContext(ctx, db, *id, *reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("settlement %d on Loan %d rejected by %s\n", s.ID, s.LoanID, s.Approver)
+	return nil
+}
+
+func runSettlementShow(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("settlement show", flag.ContinueOnError)
+	id := fs.Int64("settlement", 0, "the settlement to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := dt.GetSettlement(db, *id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Settlement\t%d (%s, %s)\n", s.ID, s.Kind, s.Status)
+	fmt.Fprintf(w, "Loan\t%d\n", s.LoanID)
+	fmt.Fprintf(w, "Amount\t%.2f of %.2f owed, %.2f paid\n", s.Amount, s.Balance, s.Paid)
+	fmt.Fprintf(w, "Offered\t%s by %s, expires %s\n", formatDate(s.OfferedOn), s.OfferedBy, formatDate(s.ExpiresOn))
+	if s.Approver != "" {
+		fmt.Fprintf(w, "Decided\t%s by %s\n", formatDate(s.DecidedAt), s.Approver)
+	}
+	if s.Status == dt.SettlementSatisfied {
+		fmt.Fprintf(w, "Settled\t%s\n", formatDate(s.SettledOn))
+		fmt.Fprintf(w, "Forgiven\t%.2f (principal %.2f, interest %.2f, fees %.2f)\n",
+			s.Forgiven.Total(), s.Forgiven.Principal, s.Forgiven.Interest, s.Forgiven.Fees)
+	}
+	if s.EndReason != "" {
+		fmt.Fprintf(w, "Reason\t%s\n", s.EndReason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	return printSettlementInstallments(s.Installments)
+}
+
+// printSettlementInstallments prints what is due under a settlement as a table
+func printSettlementInstallments(installments []dt.SettlementInstallment) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tDUE\tAMOUNT")
+	for _, inst := range installments {
+		fmt.Fprintf(w, "%d\t%s\t%.2f\n", inst.Number, formatDate(inst.DueDate), inst.Amount)
+	}
+	return w.Flush()
+}
+
+func runSettlementEvaluate(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("settlement evaluate", flag.ContinueOnError)
+	asOf := fs.String("as-of", "", "day to evaluate the settlements for (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	changed, err := dt.EvaluateSettlementsContext(ctx, db, day)
+	if err != nil {
+		return err
+	}
+	for _, s := range changed {
+		if s.Status == dt.SettlementSatisfied {
+			fmt.Printf("settlement %d satisfied, Loan %d settled with %.2f forgiven\n", s.ID, s.LoanID, s.Forgiven.Total())
+			continue
+		}
+		fmt.Printf("settlement %d on Loan %d %s: %s\n", s.ID, s.LoanID, s.Status, s.EndReason)
+	}
+	fmt.Printf("%d settlements changed\n", len(changed))
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runStrategy checks collection strategies and runs them over the portfolio
+func runStrategy(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt strategy check|run|actions [flags]")
+	}
+
+	switch args[0] {
+	case "check":
+		return runStrategyCheck(args[1:])
+	case "run":
+		return runStrategyRun(ctx, db, args[1:])
+	case "actions":
+		return runStrategyActions(db, args[1:])
+	default:
+		return fmt.Errorf("unknown strategy command %q, want check, run or actions", args[0])
+	}
+}
+
+func runStrategyCheck(args []string) error {
+	fs := flag.NewFlagSet("strategy check", flag.ContinueOnError)
+	file := fs.String("file", "", "the strategy, a .yaml, .yml or .json file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := dt.LoadStrategy(*file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("strategy %q has %d valid rules\n", s.Name, len(s.Rules))
+	return nil
+}
+
+func runStrategyRun(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("strategy run", flag.ContinueOnError)
+	file := fs.String("file", "", "the strategy, a .yaml, .yml or .json file")
+	asOf := fs.String("as-of", "", "day to evaluate the portfolio for (YYYY-MM-DD, default today)")
+	dryRun := fs.Bool("dry-run", false, "only show which rules would fire")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := dt.LoadStrategy(*file)
+	if err != nil {
+		return err
+	}
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	results, err := dt.RunStrategyContext(ctx, db, s, day, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOAN\tDPD\tBALANCE\tQUEUE\tRULE\tACTION")
+	for _, r := range results {
+		for _, a := range r.Actions {
+			action := a.Action
+			if a.Queue != "" {
+				action += " " + a.Queue
+			}
+			fmt.Fprintf(w, "%d\t%d\t%.2f\t%s\t%s\t%s\n", r.Facts.LoanID, r.Facts.DaysPastDue, r.Facts.Balance, r.Facts.Queue, a.Rule, action)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("dry run: rules would fire on %d loans\n", len(results))
+		return nil
+	}
+	fmt.Printf("rules fired on %d loans\n", len(results))
+	return nil
+}
+
+func runStrategyActions(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("strategy actions", flag.ContinueOnError)
+	loanID := fs.Int64("loan", 0, "the Loan whose logged actions to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	actions, err := dt.GetStrategyActions(db, *loanID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tSTRATEGY\tRULE\tACTION\tQUEUE\tTEMPLATE")
+	for _, a := range actions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", formatDate(a.AsOf), a.Strategy, a.Rule, a.Action, a.Queue, a.Template)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runTemplate writes, previews and activates the templates notices are written from
+func runTemplate(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt template list|create|preview|activate [flags]")
+	}
+
+	switch args[0] {
+	case "list":
+		return runTemplateList(db, args[1:])
+	case "create":
+		return runTemplateCreate(ctx, db, args[1:])
+	case "preview":
+		return runTemplatePreview(db, args[1:])
+	case "activate":
+		return runTemplateActivate(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown template command %q, want list, create, preview or activate", args[0])
+	}
+}
+
+func runTemplateList(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("template list", flag.ContinueOnError)
+	name := fs.String("name", "", "only templates for this notice, e.g. missed_payment")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	templates, err := dt.GetTemplates(db, *name)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNOTICE\tCHANNEL\tLOCALE\tSTATUS\tACTIVATED\tSUBJECT")
+	for _, t := range templates {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Channel, t.Locale, t.Status, formatDate(t.ActivatedAt), t.Subject)
+	}
+	return w.Flush()
+}
+
+func runTemplateCreate(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("template create", flag.ContinueOnError)
+	var t dt.MessageTemplate
+	fs.StringVar(&t.Name, "name", "", "the notice: upcoming_due, missed_payment or payoff")
+	fs.StringVar(&t.Channel, "channel", dt.ChannelEmail, "email, sms or in_app")
+	fs.StringVar(&t.Locale, "locale", dt.DefaultLocale, "the language it is written in, e.g. en or es-MX")
+	fs.StringVar(&t.Subject, "subject", "", "the subject line template")
+	bodyFile := fs.String("body", "", "file holding the plain text body template")
+	htmlFile := fs.String("html", "", "file holding the HTML body template, email only")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *bodyFile == "" {
+		return errors.New("-body is required")
+	}
+	body, err := os.ReadFile(*bodyFile)
+	if err != nil {
+		return err
+	}
+	t.Body = string(body)
+	if *htmlFile != "" {
+		html, err := os.ReadFile(*htmlFile)
+		if err != nil {
+			return err
+		}
+		t.HTML = string(html)
+	}
+
+	t, err = dt.CreateTemplateContext(ctx, db, t)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created draft template %d for %s notices by %s in %s\n", t.ID, t.Name, t.Channel, t.Locale)
+	return nil
+}
+
+func runTemplatePreview(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("template preview", flag.ContinueOnError)
+	id := fs.Int64("template", 0, "the template to preview")
+	loanID := fs.Int64("loan", 0, "the Loan to render it for")
+	asOf := fs.String("as-of", "", "day of the notice (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	day, err := parseDate(*asOf)
+	if err != nil {
+		return err
+	}
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	msg, err := dt.PreviewTemplate(db, *id, *loanID, day)
+	if err != nil {
+		return err
+	}
+	if msg.Subject != "" {
+		fmt.Printf("Subject: %s\n\n", msg.Subject)
+	}
+	fmt.Println(msg.Body)
+	if msg.HTML != "" {
+		fmt.Printf("\n%s\n", msg.HTML)
+	}
+	return nil
+}
+
+func runTemplateActivate(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("template activate", flag.ContinueOnError)
+	id := fs.Int64("template", 0, "the draft template to put in use")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := dt.ActivateTemplateContext(ctx, db, *id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("template %d is now used for %s notices by %s in %s\n", t.ID, t.Name, t.Channel, t.Locale)
+	return nil
+}
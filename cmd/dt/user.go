@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	dt "github.com/amirlevant/delinquencytracker"
+)
+
+// runUser shows and edits borrower profiles and mailing addresses
+func runUser(ctx context.Context, db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: dt user show|profile|address|duplicates|merge [flags]")
+	}
+
+	switch args[0] {
+	case "show":
+		return runUserShow(db, args[1:])
+	case "profile":
+		return runUserProfile(ctx, db, args[1:])
+	case "address":
+		return runUserAddress(ctx, db, args[1:])
+	case "duplicates":
+		return runUserDuplicates(db, args[1:])
+	case "merge":
+		return runUserMerge(ctx, db, args[1:])
+	default:
+		return fmt.Errorf("unknown user command %q, want show, profile, address, duplicates or merge", args[0])
+	}
+}
+
+func runUserShow(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("user show", flag.ContinueOnError)
+	id := fs.Int64("id", 0, "the User to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	usr, err := dt.GetFullUserByID(db, *id)
+	if err != nil {
+		return err
+	}
+	history, err := dt.GetUserAddressHistory(db, usr.ID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%d\n", usr.ID)
+	fmt.Fprintf(w, "Name\t%s\n", usr.Name)
+	fmt.Fprintf(w, "Email\t%s\n", usr.Email)
+	fmt.Fprintf(w, "Phone\t%s\n", usr.Phone)
+	fmt.Fprintf(w, "Date of birth\t%s\n", formatDate(usr.Profile.DateOfBirth))
+	fmt.Fprintf(w, "Time zone\t%s\n", usr.Profile.TimeZone)
+	fmt.Fprintf(w, "Language\t%s\n", usr.Profile.Language)
+	fmt.Fprintf(w, "Contact by\t%s\n", usr.Profile.Contact.Channel)
+	fmt.Fprintf(w, "Opted out of\t%s\n", strings.Join(usr.Profile.Contact.OptedOut, ", "))
+	fmt.Fprintf(w, "Loans\t%d\n", len(usr.Loans))
+	for _, a := range history {
+		fmt.Fprintf(w, "Address %s to %s\t%s\n", formatDate(a.ValidFrom), formatDate(a.ValidTo), formatAddress(a))
+	}
+	return w.Flush()
+}
+
+func runUserProfile(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("user profile", flag.ContinueOnError)
+	id := fs.Int64("id", 0, "the User to change")
+	dob := fs.String("dob", "", "date of birth (YYYY-MM-DD)")
+	tz := fs.String("tz", "", "IANA time zone, e.g. America/Chicago")
+	lang := fs.String("lang", "", "preferred language, e.g. en or es-MX")
+	channel := fs.String("channel", "", "preferred contact channel: email, sms, phone or mail")
+	optOut := fs.String("opt-out", "", "comma-separated channels not to use, \"none\" to clear")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// only the flags given on the command line are changed
+	var patch dt.UserPatch
+	var parseErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dob":
+			d, err := parseDate(*dob)
+			parseErr = errors.Join(parseErr, err)
+			patch.DateOfBirth = &d
+		case "tz":
+			patch.TimeZone = tz
+		case "lang":
+			patch.Language = lang
+		}
+	})
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if *channel != "" || *optOut != "" {
+		usr, err := dt.GetUserByID(db, *id)
+		if err != nil {
+			return err
+		}
+		contact := usr.Profile.Contact
+		if *channel != "" {
+			contact.Channel = *channel
+		}
+		switch *optOut {
+		case "":
+		case "none":
+			contact.OptedOut = nil
+		default:
+			contact.OptedOut = strings.Split(*optOut, ",")
+		}
+		patch.Contact = &contact
+	}
+
+	usr, err := dt.PatchUserContext(ctx, db, *id, patch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("updated User %d\n", usr.ID)
+	return nil
+}
+
+func runUserAddress(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("user address", flag.ContinueOnError)
+	id := fs.Int64("id", 0, "the User who moved")
+	var a dt.Address
+	fs.StringVar(&a.Line1, "line1", "", "street address")
+	fs.StringVar(&a.Line2, "line2", "", "apartment, suite, etc.")
+	fs.StringVar(&a.City, "city", "", "city")
+	fs.StringVar(&a.Region, "region", "", "state, province or region")
+	fs.StringVar(&a.PostalCode, "postal-code", "", "ZIP or postal code")
+	fs.StringVar(&a.Country, "country", "US", "ISO 3166-1 alpha-2 country code")
+	from := fs.String("from", "", "date the User moved in (YYYY-MM-DD, default today)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	validFrom, err := parseDate(*from)
+	if err != nil {
+		return err
+	}
+	if validFrom.IsZero() {
+		validFrom = time.Now().UTC()
+	}
+
+	a, err = dt.SetUserAddressContext(ctx, db, *id, a, validFrom)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("User %d now receives mail at %s\n", *id, formatAddress(a))
+	return nil
+}
+
+func runUserDuplicates(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("user duplicates", flag.ContinueOnError)
+	minScore := fs.Float64("min-score", 0.5, "only pairs scoring at least this, from 0 to 1")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	candidates, err := dt.FindDuplicateUsers(db, *minScore)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCORE\tUSER\tOTHER\tREASONS")
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%.2f\t%d\t%d\t%s\n", c.Score, c.UserID, c.OtherID, strings.Join(c.Reasons, ", "))
+	}
+	return w.Flush()
+}
+
+func runUserMerge(ctx context.Context, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("user merge", flag.ContinueOnError)
+	from := fs.Int64("from", 0, "the duplicate User to fold away")
+	into := fs.Int64("into", 0, "the User to keep")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	usr, err := dt.MergeUsersContext(ctx, db, *from, *into)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("merged User %d into User %d, who now has %d loans\n", *from, usr.ID, len(usr.Loans))
+	return nil
+}
+
+// formatDate prints a date, or "-" when it is unknown
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02")
+}
+
+// formatAddress prints an address on one line
+func formatAddress(a dt.Address) string {
+	parts := []string{a.Line1, a.Line2, a.City, strings.TrimSpace(a.Region + " " + a.PostalCode), a.Country}
+	var kept []string
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
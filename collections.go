@@ -0,0 +1,644 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultCollectionsDays is the days past due at which RunCollections opens a case when no threshold is given
+const DefaultCollectionsDays = 5
+
+// Collection case statuses
+const (
+	CaseOpen   = "open"
+	CaseClosed = "closed"
+)
+
+// Why a collection case was closed
+const (
+	CloseCured      = "cured"       // nothing is past due any more
+	ClosePaidOff    = "paid_off"    // the Loan was paid off
+	CloseChargedOff = "charged_off" // the Loan was charged off and moves to recovery
+	CloseDeleted    = "deleted"     // the Loan was deleted
+	CloseSettled    = "settled"     // the Loan was settled for less than was owed
+
+	CloseRepaymentPlan = "repayment_plan" // the borrower agreed a repayment plan
+)
+
+// Outcomes of a contact attempt
+const (
+	OutcomeNoAnswer     = "no_answer"
+	OutcomeLeftMessage  = "left_message"
+	OutcomeWrongNumber  = "wrong_number"
+	OutcomeCallback     = "callback_requested"
+	OutcomePromise      = "promise_to_pay"
+	OutcomePaymentMade  = "payment_made"
+	OutcomeRefused      = "refused_to_pay"
+	OutcomeDispute      = "dispute"
+	OutcomeHardship     = "hardship"
+	OutcomeUndelivered  = "undelivered"
+	OutcomeDoNotContact = "do_not_contact"
+)
+
+var contactOutcomes = map[string]bool{
+	OutcomeNoAnswer: true, OutcomeLeftMessage: true, OutcomeWrongNumber: true, OutcomeCallback: true,
+	OutcomePromise: true, OutcomePaymentMade: true, OutcomeRefused: true, OutcomeDispute: true,
+	OutcomeHardship: true, OutcomeUndelivered: true, OutcomeDoNotContact: true,
+}
+
+// CollectionQueue routes cases to a queue by how far behind the Loan is and how much is owed.
+// A zero MaxDaysPastDue or MinBalance leaves that side open.
+type CollectionQueue struct {
+	Name           string
+	MinDaysPastDue int     // at least this many days past due
+	MaxDaysPastDue int     // at most this many days past due
+	MinBalance     float64 // at least this much owed in total
+}
+
+// CollectionQueues are tried in order and a case goes to the first that matches.
+// Replace them at start-up to route differently; the last should match every case.
+var CollectionQueues = []CollectionQueue{
+	{Name: "high_balance", MinDaysPastDue: 30, MinBalance: 25000},
+	{Name: "early", MaxDaysPastDue: 29},
+	{Name: "mid", MinDaysPastDue: 30, MaxDaysPastDue: 59},
+	{Name: "late", MinDaysPastDue: 60, MaxDaysPastDue: 89},
+	{Name: "recovery"},
+}
+
+// matches reports whether a Loan this far behind and owing this much belongs in the queue
+func (q CollectionQueue) matches(daysPastDue int, balance float64) bool {
+	if daysPastDue < q.MinDaysPastDue {
+		return false
+	}
+	if q.MaxDaysPastDue > 0 && daysPastDue > q.MaxDaysPastDue {
+		return false
+	}
+	return balance >= q.MinBalance
+}
+
+// routeCase returns the queue a case belongs in
+func routeCase(daysPastDue int, balance float64) (string, error) {
+	for _, q := range CollectionQueues {
+		if q.matches(daysPastDue, balance) {
+			return q.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no collection queue takes a Loan %d days past due owing %.2f", daysPastDue, balance)
+}
+
+// CollectionCase is the collections work on one delinquent Loan
+type CollectionCase struct {
+	ID            int64     // unique identifier for the case
+	LoanID        int64     // the delinquent Loan
+	Queue         string    // the queue the case is worked from
+	Status        string    // "open" or "closed"
+	DaysPastDue   int       // days past due when RunCollections last looked
+	PastDueAmount float64   // amount past due when RunCollections last looked
+	Balance       float64   // everything owed when RunCollections last looked
+	AssignedTo    string    // the collector working the case ("" while unassigned)
+	AssignedAt    time.Time // when it was assigned
+	OpenedAt      time.Time // when the case was opened
+	ClosedAt      time.Time // when it was closed (zero while open)
+	CloseReason   string    // why it was closed, e.g. "cured"
+
+	Contacts []ContactAttempt // every attempt to reach the borrowers (filled by GetCollectionCase)
+}
+
+// ContactAttempt is one attempt to reach a party to a Loan about its collection case
+type ContactAttempt struct {
+	ID          int64     // unique identifier for the attempt
+	CaseID      int64     // the collection case
+	UserID      int64     // the borrower, co-borrower or guarantor contacted
+	Collector   string    // who made the attempt
+	Channel     string    // "email", "sms", "phone" or "mail"
+	Outcome     string    // outcome code, e.g. "no_answer"
+	Notes       string    // what was said
+	ContactedAt time.Time // when the attempt was made
+	CreatedAt   time.Time // when it was logged
+}
+
+// CollectionsRun is what RunCollections did
+type CollectionsRun struct {
+	Opened      []CollectionCase // cases opened for loans that crossed the threshold
+	Rerouted    []CollectionCase // open cases moved to another queue
+	Closed      []CollectionCase // cases closed because their Loan cured or left collections
+	Promises    []PromiseToPay   // promises settled as kept, partially kept or broken
+	Plans       []RepaymentPlan  // repayment plans that completed, broke or were cancelled
+	Settlements []Settlement     // settlements that were satisfied, expired or broke
+}
+
+// RunCollections opens a case for every active or defaulted Loan at least thresholdDays past due as of a day,
+// routes open cases to the queue matching their days past due and balance, and closes the cases of loans
+// that cured or are no longer collectable. A case moved to another queue is unassigned.
+// Pending promises, active repayment plans and open settlements are evaluated first. While a Loan has an active promise
+// no case is opened for it and its case stays in its queue; while it is on a repayment plan it has no case.
+// A thresholdDays of 0 uses DefaultCollectionsDays.
+func RunCollections(db *sql.DB, asOf time.Time, thresholdDays int) (CollectionsRun, error) {
+	return RunCollectionsContext(context.Background(), db, asOf, thresholdDays)
+}
+
+// RunCollectionsContext is RunCollections, audited as the actor in ctx.
+func RunCollectionsContext(ctx context.Context, db *sql.DB, asOf time.Time, thresholdDays int) (CollectionsRun, error) {
+	if thresholdDays <= 0 {
+		thresholdDays = DefaultCollectionsDays
+	}
+
+	var run CollectionsRun
+
+	promises, err := EvaluatePromisesContext(ctx, db, asOf)
+	if err != nil {
+		return run, err
+	}
+	run.Promises = promises
+
+	plans, err := EvaluateRepaymentPlansContext(ctx, db, asOf)
+	if err != nil {
+		return run, err
+	}
+	run.Plans = plans
+
+	settlements, err := EvaluateSettlementsContext(ctx, db, asOf)
+	if err != nil {
+		return run, err
+	}
+	run.Settlements = settlements
+
+	// loans that might need a case, and loans that have one
+	rows, err := db.Query(`
+	SELECT id FROM loans WHERE status IN ($1, $2) AND deleted_at IS NULL
+	UNION
+	SELECT loan_id FROM collection_cases WHERE status = $3
+	ORDER BY 1
+	`, LoanStatusActive, LoanStatusDefaulted, CaseOpen)
+	if err != nil {
+		return run, fmt.Errorf("failed to query loans: %w", err)
+	}
+
+	var loanIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return run, fmt.Errorf("failed to scan Loan row: %w", err)
+		}
+		loanIDs = append(loanIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return run, fmt.Errorf("error iterating Loan rows: %w", err)
+	}
+
+	for _, id := range loanIDs {
+		err := inTx(db, func(tx *sql.Tx) error {
+			return collectLoan(ctx, tx, db, id, asOf, thresholdDays, &run)
+		})
+		if err != nil {
+			return run, err
+		}
+	}
+
+	return run, nil
+}
+
+// collectLoan opens, reroutes or closes the collection case of one Loan
+func collectLoan(ctx context.Context, tx *sql.Tx, db *sql.DB, loanID int64, asOf time.Time, thresholdDays int, run *CollectionsRun) error {
+	ln, err := GetLoanByLoanID(tx, loanID, IncludeDeleted)
+	if err != nil {
+		return err
+	}
+
+	open, err := getOpenCase(tx, loanID)
+	if err != nil {
+		return err
+	}
+
+	closeReason := ""
+	switch {
+	case !ln.DeletedAt.IsZero():
+		closeReason = CloseDeleted
+	case ln.Status == LoanStatusPaidOff:
+		closeReason = ClosePaidOff
+	case ln.Status == LoanStatusChargedOff:
+		closeReason = CloseChargedOff
+	case ln.Status == LoanStatusSettled:
+		closeReason = CloseSettled
+	}
+	if closeReason == "" {
+		onPlan, err := hasActivePlan(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if onPlan {
+			closeReason = CloseRepaymentPlan
+		}
+	}
+	if closeReason != "" {
+		if open.ID == 0 {
+			return nil
+		}
+		closed, err := closeCase(ctx, tx, open, closeReason)
+		if err != nil {
+			return err
+		}
+		run.Closed = append(run.Closed, closed)
+		return nil
+	}
+
+	d, err := GetLoanDelinquency(db, loanID, asOf)
+	if err != nil {
+		return err
+	}
+
+	promised, err := hasActivePromise(tx, loanID, asOf)
+	if err != nil {
+		return err
+	}
+
+	if open.ID == 0 {
+		if d.DaysPastDue < thresholdDays || promised {
+			return nil
+		}
+	} else if !d.IsDelinquent() {
+		closed, err := closeCase(ctx, tx, open, CloseCured)
+		if err != nil {
+			return err
+		}
+		run.Closed = append(run.Closed, closed)
+		return nil
+	}
+
+	balances, err := GetLoanBalances(tx, loanID, asOf)
+	if err != nil {
+		return err
+	}
+	queue, err := routeCase(d.DaysPastDue, balances.Total())
+	if err != nil {
+		return err
+	}
+	if promised && open.ID != 0 {
+		queue = open.Queue
+	}
+
+	if open.ID == 0 {
+		c, err := openCase(ctx, tx, loanID, queue, d, balances.Total())
+		if err != nil {
+			return err
+		}
+		run.Opened = append(run.Opened, c)
+		return nil
+	}
+
+	after := open
+	after.DaysPastDue, after.PastDueAmount, after.Balance = d.DaysPastDue, d.PastDueAmount, balances.Total()
+	if queue != open.Queue {
+		after.Queue, after.AssignedTo, after.AssignedAt = queue, "", time.Time{}
+	}
+
+	_, err = tx.Exec(`
+	UPDATE collection_cases
+	SET queue = $1, days_past_due = $2, past_due_amount = $3, balance = $4, assigned_to = $5, assigned_at = $6
+	WHERE id = $7
+	`, after.Queue, after.DaysPastDue, after.PastDueAmount, after.Balance, after.AssignedTo, nullDate(after.AssignedAt), open.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update collection case %d: %w", open.ID, err)
+	}
+
+	if queue != open.Queue {
+		reason := fmt.Sprintf("rerouted from %s to %s", open.Queue, queue)
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditCollectionCase, open.ID, open, after, reason); err != nil {
+			return err
+		}
+		run.Rerouted = append(run.Rerouted, after)
+	}
+
+	return nil
+}
+
+func openCase(ctx context.Context, tx *sql.Tx, loanID int64, queue string, d Delinquency, balance float64) (CollectionCase, error) {
+	c := CollectionCase{LoanID: loanID, Queue: queue, Status: CaseOpen, DaysPastDue: d.DaysPastDue, PastDueAmount: d.PastDueAmount, Balance: balance}
+
+	err := tx.QueryRow(`
+	INSERT INTO collection_cases (loan_id, queue, days_past_due, past_due_amount, balance)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, opened_at
+	`, loanID, queue, d.DaysPastDue, d.PastDueAmount, balance).Scan(&c.ID, &c.OpenedAt)
+	if err != nil {
+		return CollectionCase{}, fmt.Errorf("failed to open collection case for Loan %d: %w", loanID, err)
+	}
+	c.OpenedAt = c.OpenedAt.UTC()
+
+	reason := fmt.Sprintf("%d days past due", d.DaysPastDue)
+	if err := recordAudit(ctx, tx, AuditCreate, AuditCollectionCase, c.ID, nil, c, reason); err != nil {
+		return CollectionCase{}, err
+	}
+
+	return c, nil
+}
+
+func closeCase(ctx context.Context, tx *sql.Tx, c CollectionCase, reason string) (CollectionCase, error) {
+	after := c
+	after.Status, after.CloseReason = CaseClosed, reason
+
+	err := tx.QueryRow(`UPDATE collection_cases SET status = $1, close_reason = $2, closed_at = NOW() WHERE id = $3 RETURNING closed_at`,
+		CaseClosed, reason, c.ID).Scan(&after.ClosedAt)
+	if err != nil {
+		return CollectionCase{}, fmt.Errorf("failed to close collection case %d: %w", c.ID, err)
+	}
+	after.ClosedAt = after.ClosedAt.UTC()
+
+	if err := recordAudit(ctx, tx, AuditCloseCase, AuditCollectionCase, c.ID, c, after, reason); err != nil {
+		return CollectionCase{}, err
+	}
+
+	return after, nil
+}
+
+const caseColumns = `id, loan_id, queue, status, days_past_due, past_due_amount, balance,
+	assigned_to, assigned_at, opened_at, closed_at, close_reason`
+
+// scanCase reads a row selected with caseColumns into a CollectionCase
+func scanCase(row rowScanner) (CollectionCase, error) {
+	var c CollectionCase
+	var assignedAt, closedAt sql.NullTime
+
+	err := row.Scan(&c.ID, &c.LoanID, &c.Queue, &c.Status, &c.DaysPastDue, &c.PastDueAmount, &c.Balance,
+		&c.AssignedTo, &assignedAt, &c.OpenedAt, &closedAt, &c.CloseReason)
+	if err != nil {
+		return CollectionCase{}, err
+	}
+
+	c.OpenedAt = c.OpenedAt.UTC()
+	if assignedAt.Valid {
+		c.AssignedAt = assignedAt.Time.UTC()
+	}
+	if closedAt.Valid {
+		c.ClosedAt = closedAt.Time.UTC()
+	}
+
+	return c, nil
+}
+
+// getOpenCase returns the open case of a Loan, locked for the rest of the transaction, or a zero case when it has none
+func getOpenCase(tx *sql.Tx, loanID int64) (CollectionCase, error) {
+	c, err := scanCase(tx.QueryRow(`SELECT `+caseColumns+` FROM collection_cases WHERE loan_id = $1 AND status = $2 FOR UPDATE`, loanID, CaseOpen))
+	if err == sql.ErrNoRows {
+		return CollectionCase{}, nil
+	}
+	if err != nil {
+		return CollectionCase{}, fmt.Errorf("failed to get collection case of Loan %d: %w", loanID, err)
+	}
+	return c, nil
+}
+
+// GetCollectionCase returns a collection case with its contact attempts, oldest first.
+func GetCollectionCase(db execer, caseID int64) (CollectionCase, error) {
+	c, err := scanCase(db.QueryRow(`SELECT `+caseColumns+` FROM collection_cases WHERE id = $1`, caseID))
+	if err == sql.ErrNoRows {
+		return CollectionCase{}, fmt.Errorf("collection case with ID %d not found", caseID)
+	}
+	if err != nil {
+		return CollectionCase{}, fmt.Errorf("failed to get collection case: %w", err)
+	}
+
+	c.Contacts, err = GetContactAttempts(db, caseID)
+	if err != nil {
+		return CollectionCase{}, err
+	}
+
+	return c, nil
+}
+
+// WorkQueueFilter narrows GetWorkQueue. Zero fields match everything.
+type WorkQueueFilter struct {
+	Queue      string // only cases in this queue
+	AssignedTo string // only cases assigned to this collector
+	Unassigned bool   // only cases nobody is working
+	Limit      int    // at most this many cases
+	Offset     int    // cases to skip, for later pages
+
+	IncludePromised bool      // also cases whose Loan has an active promise to pay
+	AsOf            time.Time // the day promises are active on (default today)
+}
+
+// GetWorkQueue returns open collection cases, the furthest behind and then the largest balance first.
+// Cases whose Loan has an active promise to pay are left out unless f.IncludePromised is set.
+func GetWorkQueue(db execer, f WorkQueueFilter) ([]CollectionCase, error) {
+	query := `SELECT ` + caseColumns + ` FROM collection_cases WHERE status = $1`
+	args := []any{CaseOpen}
+
+	if f.Queue != "" {
+		args = append(args, f.Queue)
+		query += fmt.Sprintf(" AND queue = $%d", len(args))
+	}
+	if f.AssignedTo != "" {
+		args = append(args, f.AssignedTo)
+		query += fmt.Sprintf(" AND assigned_to = $%d", len(args))
+	}
+	if f.Unassigned {
+		query += " AND assigned_to = ''"
+	}
+	if !f.IncludePromised {
+		asOf := f.AsOf
+		if asOf.IsZero() {
+			asOf = time.Now()
+		}
+		args = append(args, PromisePending, startOfDay(asOf))
+		query += fmt.Sprintf(` AND NOT EXISTS (
+		SELECT 1 FROM promises_to_pay p
+		WHERE p.loan_id = collection_cases.loan_id AND p.status = $%d AND p.promised_date >= $%d)`, len(args)-1, len(args))
+	}
+	query += " ORDER BY days_past_due DESC, balance DESC, id"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query work queue: %w", err)
+	}
+	defer rows.Close()
+
+	cases := []CollectionCase{}
+
+	for rows.Next() {
+		c, err := scanCase(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan collection case row: %w", err)
+		}
+		cases = append(cases, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating collection case rows: %w", err)
+	}
+
+	return cases, nil
+}
+
+// AssignCase gives an open collection case to a collector, or unassigns it when collector is "".
+func AssignCase(db *sql.DB, caseID int64, collector string) (CollectionCase, error) {
+	return AssignCaseContext(context.Background(), db, caseID, collector)
+}
+
+// AssignCaseContext is AssignCase, audited as the actor in ctx
+func AssignCaseContext(ctx context.Context, db *sql.DB, caseID int64, collector string) (CollectionCase, error) {
+	var after CollectionCase
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		before, err := scanCase(tx.QueryRow(`SELECT `+caseColumns+` FROM collection_cases WHERE id = $1 FOR UPDATE`, caseID))
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("collection case with ID %d not found", caseID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get collection case: %w", err)
+		}
+		if before.Status != CaseOpen {
+			return fmt.Errorf("collection case %d is closed", caseID)
+		}
+
+		var assignedAt sql.NullTime
+		err = tx.QueryRow(`
+		UPDATE collection_cases
+		SET assigned_to = $1, assigned_at = CASE WHEN $1 = '' THEN NULL ELSE NOW() END
+		WHERE id = $2
+		RETURNING assigned_at
+		`, collector, caseID).Scan(&assignedAt)
+		if err != nil {
+			return fmt.Errorf("failed to assign collection case %d: %w", caseID, err)
+		}
+
+		after = before
+		after.AssignedTo, after.AssignedAt = collector, time.Time{}
+		if assignedAt.Valid {
+			after.AssignedAt = assignedAt.Time.UTC()
+		}
+
+		return recordAudit(ctx, tx, AuditUpdate, AuditCollectionCase, caseID, before, after, "")
+	})
+	if err != nil {
+		return CollectionCase{}, err
+	}
+
+	return after, nil
+}
+
+// LogContactAttempt records an attempt to reach a party to the Loan of an open collection case.
+// The User must be a party to the Loan and must not have opted out of the channel.
+func LogContactAttempt(db *sql.DB, attempt ContactAttempt) (ContactAttempt, error) {
+	return LogContactAttemptContext(context.Background(), db, attempt)
+}
+
+// LogContactAttemptContext is LogContactAttempt, audited as the actor in ctx.
+// The collector defaults to the actor.
+func LogContactAttemptContext(ctx context.Context, db *sql.DB, attempt ContactAttempt) (ContactAttempt, error) {
+	if !contactChannels[attempt.Channel] {
+		return ContactAttempt{}, fmt.Errorf("unknown contact channel %q", attempt.Channel)
+	}
+	if !contactOutcomes[attempt.Outcome] {
+		return ContactAttempt{}, fmt.Errorf("unknown contact outcome %q", attempt.Outcome)
+	}
+	if attempt.ContactedAt.IsZero() {
+		attempt.ContactedAt = time.Now()
+	}
+	attempt.ContactedAt = attempt.ContactedAt.UTC()
+	if attempt.Collector == "" {
+		attempt.Collector = ActorFromContext(ctx)
+	}
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		c, err := GetCollectionCase(tx, attempt.CaseID)
+		if err != nil {
+			return err
+		}
+		if c.Status != CaseOpen {
+			return fmt.Errorf("collection case %d is closed", c.ID)
+		}
+
+		parties, err := GetLoanParties(tx, c.LoanID)
+		if err != nil {
+			return err
+		}
+		if attempt.UserID == 0 {
+			attempt.UserID = parties[0].UserID
+		}
+		if !isParty(parties, attempt.UserID) {
+			return fmt.Errorf("User %d is not a party to Loan %d", attempt.UserID, c.LoanID)
+		}
+
+		usr, err := GetUserByID(tx, attempt.UserID)
+		if err != nil {
+			return err
+		}
+		if !usr.Profile.Contact.Allows(attempt.Channel) {
+			return fmt.Errorf("User %d opted out of %s contact", usr.ID, attempt.Channel)
+		}
+
+		err = tx.QueryRow(`
+		INSERT INTO collection_contacts (case_id, user_id, collector, channel, outcome, notes, contacted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`, attempt.CaseID, attempt.UserID, attempt.Collector, attempt.Channel, attempt.Outcome, attempt.Notes, attempt.ContactedAt).
+			Scan(&attempt.ID, &attempt.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to log contact attempt on collection case %d: %w", attempt.CaseID, err)
+		}
+		attempt.CreatedAt = attempt.CreatedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditCreate, AuditContactAttempt, attempt.ID, nil, attempt, "")
+	})
+	if err != nil {
+		return ContactAttempt{}, err
+	}
+
+	return attempt, nil
+}
+
+// isParty reports whether a User is among the parties to a Loan
+func isParty(parties []LoanParty, userID int64) bool {
+	for _, p := range parties {
+		if p.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetContactAttempts returns the contact attempts logged on a collection case, oldest first.
+func GetContactAttempts(db execer, caseID int64) ([]ContactAttempt, error) {
+	rows, err := db.Query(`
+	SELECT id, case_id, user_id, collector, channel, outcome, notes, contacted_at, created_at
+	FROM collection_contacts
+	WHERE case_id = $1
+	ORDER BY contacted_at, id
+	`, caseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contact attempts of collection case %d: %w", caseID, err)
+	}
+	defer rows.Close()
+
+	var attempts []ContactAttempt
+
+	for rows.Next() {
+		var a ContactAttempt
+		err := rows.Scan(&a.ID, &a.CaseID, &a.UserID, &a.Collector, &a.Channel, &a.Outcome, &a.Notes, &a.ContactedAt, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan contact attempt row: %w", err)
+		}
+		a.ContactedAt = a.ContactedAt.UTC()
+		a.CreatedAt = a.CreatedAt.UTC()
+		attempts = append(attempts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating contact attempt rows: %w", err)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,161 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRouteCase verifies cases go to the first queue that matches their days past due and balance.
+func TestRouteCase(t *testing.T) {
+	tests := []struct {
+		daysPastDue int
+		balance     float64
+		want        string
+	}{
+		{5, 1000, "early"},
+		{29, 100000, "early"},
+		{30, 1000, "mid"},
+		{30, 25000, "high_balance"},
+		{75, 5000, "late"},
+		{90, 5000, "recovery"},
+		{400, 50000, "high_balance"},
+	}
+	for _, tt := range tests {
+		got, err := routeCase(tt.daysPastDue, tt.balance)
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got, "%d days past due owing %.2f", tt.daysPastDue, tt.balance)
+	}
+}
+
+// TestRunCollections verifies a case is opened when a Loan crosses the threshold, rerouted as it
+// falls further behind and closed once it is cured.
+func TestRunCollections(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan whose first installment was missed
+	user, err := InitializeUserWithLoan(db, "Behind User", "behind@example.com", "555-0601",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	// Act, not yet past the threshold
+	run, err := RunCollections(db, calendarDate(2024, time.February, 3), 0)
+	require.NoError(t, err)
+	require.Empty(t, run.Opened)
+
+	// Act, past it
+	run, err = RunCollections(db, calendarDate(2024, time.February, 15), 0)
+	require.NoError(t, err)
+
+	// Assert
+	require.Len(t, run.Opened, 1)
+	c := run.Opened[0]
+	require.Equal(t, ln.ID, c.LoanID)
+	require.Equal(t, "early", c.Queue)
+	require.Equal(t, CaseOpen, c.Status)
+	require.Equal(t, 14, c.DaysPastDue)
+
+	_, err = AssignCase(db, c.ID, "alice")
+	require.NoError(t, err)
+
+	// a second run leaves the open case alone
+	run, err = RunCollections(db, calendarDate(2024, time.February, 15), 0)
+	require.NoError(t, err)
+	require.Empty(t, run.Opened)
+	require.Empty(t, run.Rerouted)
+
+	// Act, further behind the case moves queue and is unassigned
+	run, err = RunCollections(db, calendarDate(2024, time.April, 1), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Rerouted, 1)
+	moved, err := GetCollectionCase(db, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, "late", moved.Queue)
+	require.Empty(t, moved.AssignedTo)
+
+	// Act, catching up cures the Loan and closes the case
+	_, err = PostPayment(db, ln.ID, moved.PastDueAmount+100, calendarDate(2024, time.April, 1))
+	require.NoError(t, err)
+	run, err = RunCollections(db, calendarDate(2024, time.April, 1), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Closed, 1)
+
+	closed, err := GetCollectionCase(db, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, CaseClosed, closed.Status)
+	require.Equal(t, CloseCured, closed.CloseReason)
+	require.False(t, closed.ClosedAt.IsZero())
+
+	queue, err := GetWorkQueue(db, WorkQueueFilter{})
+	require.NoError(t, err)
+	require.Empty(t, queue)
+}
+
+// TestWorkCollectionCase verifies the work queue, assignment and the contact log of a case.
+func TestWorkCollectionCase(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, an overdue Loan with a guarantor who will not take calls
+	user, err := InitializeUserWithLoan(db, "Worked User", "worked@example.com", "555-0611",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	guarantor, err := CreateUserWithProfile(db, "Quiet Guarantor", "quiet@example.com", "555-0612",
+		UserProfile{Contact: ContactPreferences{Channel: ChannelEmail, OptedOut: []string{ChannelPhone}}})
+	require.NoError(t, err)
+	_, err = AddLoanParty(db, ln.ID, guarantor.ID, PartyGuarantor)
+	require.NoError(t, err)
+	stranger, err := CreateUser(db, "Stranger", "stranger@example.com", "555-0613")
+	require.NoError(t, err)
+
+	run, err := RunCollections(db, calendarDate(2024, time.February, 20), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Opened, 1)
+	caseID := run.Opened[0].ID
+
+	// Act
+	unassigned, err := GetWorkQueue(db, WorkQueueFilter{Queue: "early", Unassigned: true})
+	require.NoError(t, err)
+	assigned, err := AssignCase(db, caseID, "bob")
+	require.NoError(t, err)
+	mine, err := GetWorkQueue(db, WorkQueueFilter{AssignedTo: "bob"})
+	require.NoError(t, err)
+
+	// Assert
+	require.Len(t, unassigned, 1)
+	require.Equal(t, caseID, unassigned[0].ID)
+	require.Equal(t, "bob", assigned.AssignedTo)
+	require.False(t, assigned.AssignedAt.IsZero())
+	require.Len(t, mine, 1)
+
+	// Act, log attempts to reach the borrower and the guarantor
+	first, err := LogContactAttempt(db, ContactAttempt{CaseID: caseID, Collector: "bob",
+		Channel: ChannelPhone, Outcome: OutcomeNoAnswer})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, first.UserID, "the primary borrower is contacted by default")
+
+	_, err = LogContactAttempt(db, ContactAttempt{CaseID: caseID, UserID: guarantor.ID, Collector: "bob",
+		Channel: ChannelPhone, Outcome: OutcomeNoAnswer})
+	require.Error(t, err, "the guarantor opted out of phone calls")
+
+	_, err = LogContactAttempt(db, ContactAttempt{CaseID: caseID, UserID: guarantor.ID, Collector: "bob",
+		Channel: ChannelEmail, Outcome: OutcomeLeftMessage, Notes: "sent a reminder"})
+	require.NoError(t, err)
+
+	_, err = LogContactAttempt(db, ContactAttempt{CaseID: caseID, UserID: stranger.ID, Collector: "bob",
+		Channel: ChannelEmail, Outcome: OutcomeLeftMessage})
+	require.Error(t, err, "only parties to the Loan are contacted")
+
+	_, err = LogContactAttempt(db, ContactAttempt{CaseID: caseID, Channel: ChannelEmail, Outcome: "shrugged"})
+	require.Error(t, err)
+
+	// Assert
+	c, err := GetCollectionCase(db, caseID)
+	require.NoError(t, err)
+	require.Len(t, c.Contacts, 2)
+	require.Equal(t, "sent a reminder", c.Contacts[1].Notes)
+}
@@ -1,66 +1,293 @@
 package delinquencytracker
 
 import (
+	"context"
 	"database/sql"
+	_ "embed"
+	"errors"
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+//go:embed schema.sql
+var schemaSQL string
+
+// execer is satisfied by both *sql.DB and *sql.Tx so helpers can run inside a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ApplySchema creates any missing tables and columns.
+// It is safe to run against a database that is already up to date.
+func ApplySchema(db *sql.DB) error {
+	_, err := db.Exec(schemaSQL)
+	if err != nil {
+		return fmt.Errorf("failed to apply schema: %w", err)
+	}
+
+	return nil
+}
+
+// ReadOption widens what the read functions return
+type ReadOption int
+
+const (
+	// IncludeDeleted makes reads return soft-deleted users and loans too
+	IncludeDeleted ReadOption = iota + 1
+	// IncludeAsParty makes GetLoansByUserID also return loans the User is a co-borrower or guarantor of
+	IncludeAsParty
+)
+
+// includes reports whether the options contain opt
+func includes(opts []ReadOption, opt ReadOption) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// includesDeleted reports whether the options ask for soft-deleted rows
+func includesDeleted(opts []ReadOption) bool {
+	return includes(opts, IncludeDeleted)
+}
+
+// notDeleted is the SQL condition that hides soft-deleted rows unless the options include them
+func notDeleted(opts []ReadOption) string {
+	if includesDeleted(opts) {
+		return "TRUE"
+	}
+	return "deleted_at IS NULL"
+}
+
+// DeletePolicy decides what deleting a User does with the loans they still owe on
+type DeletePolicy int
+
+const (
+	// DeleteRestrict refuses to delete a User with an active or defaulted Loan
+	DeleteRestrict DeletePolicy = iota
+	// DeleteCascade deletes the User's active and defaulted loans along with them
+	DeleteCascade
+)
+
+// ErrUserHasActiveLoans is returned when deleting a User who still owes on a Loan without DeleteCascade
+var ErrUserHasActiveLoans = errors.New("User has active loans")
+
+// ConflictError is returned by an update made against a version of a record that has since changed.
+// Read the record again and retry on top of the current version.
+type ConflictError struct {
+	Entity   string // kind of record, e.g. "Loan"
+	ID       int64  // which record
+	Expected int64  // the version the update was made against
+	Actual   int64  // the version the record has now
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %d was changed by someone else: expected version %d, found %d", e.Entity, e.ID, e.Expected, e.Actual)
+}
+
+// lockVersion locks a row of table for the rest of the transaction and checks it is still at the expected version
+func lockVersion(tx *sql.Tx, table, entity string, id, expected int64) error {
+	var actual int64
+
+	err := tx.QueryRow(`SELECT version FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&actual)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s with ID %d not found", entity, id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock %s %d: %w", entity, id, err)
+	}
+	if actual != expected {
+		return &ConflictError{Entity: entity, ID: id, Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
+
+// lockLoan locks a Loan for the rest of the transaction and loads it with its payments and modifications
+func lockLoan(tx *sql.Tx, loanID int64, opts ...ReadOption) (Loan, error) {
+	ln, err := scanLoan(tx.QueryRow(`SELECT `+loanColumns+` FROM loans WHERE id = $1 AND `+notDeleted(opts)+` FOR UPDATE`, loanID))
+	if err == sql.ErrNoRows {
+		return Loan{}, fmt.Errorf("Loan with ID %d not found", loanID)
+	}
+	if err != nil {
+		return Loan{}, fmt.Errorf("failed to lock Loan %d: %w", loanID, err)
+	}
+
+	if ln.Payments, err = GetPaymentsByLoanID(tx, loanID); err != nil {
+		return Loan{}, fmt.Errorf("failed to get payments for Loan %d: %w", loanID, err)
+	}
+	if ln.Modifications, err = GetLoanModifications(tx, loanID); err != nil {
+		return Loan{}, fmt.Errorf("failed to get modifications for Loan %d: %w", loanID, err)
+	}
+
+	return ln, nil
+}
+
 // we pass db connection and the User information
 // we return the new User's ID and any error
+// the email is stored lowercased and the phone in E.164 form, see NormalizeEmail and NormalizePhone
 func CreateUser(db *sql.DB, name, email, phone string) (User, error) {
+	return CreateUserContext(context.Background(), db, name, email, phone)
+}
+
+// CreateUserContext is CreateUser, audited as the actor in ctx
+func CreateUserContext(ctx context.Context, db *sql.DB, name, email, phone string) (User, error) {
+	return CreateUserWithProfileContext(ctx, db, name, email, phone, UserProfile{})
+}
+
+// CreateUserWithProfile is CreateUser for a User whose profile is already known.
+func CreateUserWithProfile(db *sql.DB, name, email, phone string, profile UserProfile) (User, error) {
+	return CreateUserWithProfileContext(context.Background(), db, name, email, phone, profile)
+}
+
+// CreateUserWithProfileContext is CreateUserWithProfile, audited as the actor in ctx
+func CreateUserWithProfileContext(ctx context.Context, db *sql.DB, name, email, phone string, profile UserProfile) (User, error) {
+	var usr User
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		var err error
+		usr, err = createUser(ctx, tx, name, email, phone, profile)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return usr, nil
+}
+
+// createUser validates, inserts and audits a User inside the caller's transaction
+func createUser(ctx context.Context, tx *sql.Tx, name, email, phone string, profile UserProfile) (User, error) {
+	email, phone, err := normalizeContact(email, phone)
+	if err != nil {
+		return User{}, err
+	}
+	if err := profile.Validate(); err != nil {
+		return User{}, fmt.Errorf("invalid profile: %w", err)
+	}
+	profile = profile.normalized()
+
 	query := `
-	INSERT INTO users (name, email, phone)
-	VALUES ($1, $2, $3)
+	INSERT INTO users (name, email, phone, date_of_birth, time_zone, language, contact_channel, contact_opt_outs)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	RETURNING id, created_at
 	`
 
 	var userID int64
 	var createdAt time.Time
 
-	err := db.QueryRow(query, name, email, phone).Scan(&userID, &createdAt)
+	err = tx.QueryRow(query, name, email, phone, nullDate(profile.DateOfBirth), profile.TimeZone, profile.Language,
+		profile.Contact.Channel, profile.Contact.optedOutArray()).Scan(&userID, &createdAt)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to create User: %w", err)
 	}
 
-	usr := User{userID, name, email, phone, createdAt, nil}
+	usr := User{ID: userID, Name: name, Email: email, Phone: phone, CreatedAt: createdAt.UTC(), Profile: profile}
+
+	if err := recordAudit(ctx, tx, AuditCreate, AuditUser, userID, nil, usr, ""); err != nil {
+		return User{}, err
+	}
 
 	return usr, nil
 }
 
+// UpdateUser overwrites every field of a User. Use PatchUser to change only some of them.
 func UpdateUser(db *sql.DB, userID int64, name, email, phone string) error {
+	return UpdateUserContext(context.Background(), db, userID, name, email, phone)
+}
+
+// UpdateUserContext is UpdateUser, audited as the actor in ctx
+func UpdateUserContext(ctx context.Context, db *sql.DB, userID int64, name, email, phone string) error {
+	email, phone, err := normalizeContact(email, phone)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, phone = $3
 		WHERE id = $4
 		`
 
-	_, err := db.Exec(query, name, email, phone, userID)
-	if err != nil {
-		return fmt.Errorf("failed to update User: %w", err)
-	}
+	return inTx(db, func(tx *sql.Tx) error {
+		before, err := GetUserByID(tx, userID)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		_, err = tx.Exec(query, name, email, phone, before.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update User: %w", err)
+		}
+
+		after := before
+		after.Name, after.Email, after.Phone = name, email, phone
+
+		return recordAudit(ctx, tx, AuditUpdate, AuditUser, before.ID, before, after, "")
+	})
 }
 
-func GetUserByID(db *sql.DB, userID int64) (User, error) {
-	query := `
-	SELECT id, name, email, phone, created_at
-	FROM users
-	WHERE id = $1
-	`
+const userColumns = `id, name, email, phone, created_at, deleted_at, deleted_by,
+	date_of_birth, time_zone, language, contact_channel, contact_opt_outs, merged_into`
 
-	usr := User{}
+// scanUser reads a row selected with userColumns into a User
+func scanUser(row rowScanner) (User, error) {
+	var usr User
+	var deletedAt, dateOfBirth sql.NullTime
+	var mergedInto sql.NullInt64
 
-	err := db.QueryRow(query, userID).Scan(
+	err := row.Scan(
 		&usr.ID,
 		&usr.Name,
 		&usr.Email,
 		&usr.Phone,
 		&usr.CreatedAt,
+		&deletedAt,
+		&usr.DeletedBy,
+		&dateOfBirth,
+		&usr.Profile.TimeZone,
+		&usr.Profile.Language,
+		&usr.Profile.Contact.Channel,
+		pq.Array(&usr.Profile.Contact.OptedOut),
+		&mergedInto,
 	)
+	if err != nil {
+		return User{}, err
+	}
+
+	if dateOfBirth.Valid {
+		usr.Profile.DateOfBirth = dateOfBirth.Time
+	}
+	usr.Profile = usr.Profile.normalized()
+	usr.MergedInto = mergedInto.Int64
+
+	usr.CreatedAt = usr.CreatedAt.UTC()
+	if deletedAt.Valid {
+		usr.DeletedAt = deletedAt.Time.UTC()
+	}
+
+	return usr, nil
+}
+
+// GetUserByID returns a User. The ID of a User merged into another resolves to the one it was merged into,
+// unless IncludeDeleted asks for the merged User itself.
+func GetUserByID(db execer, userID int64, opts ...ReadOption) (User, error) {
+	query := `
+	SELECT ` + userColumns + `
+	FROM users
+	WHERE id = (SELECT COALESCE(merged_into, id) FROM users WHERE id = $1) AND deleted_at IS NULL
+	`
+	if includesDeleted(opts) {
+		query = `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	}
+
+	usr, err := scanUser(db.QueryRow(query, userID))
 
 	if err == sql.ErrNoRows {
 		return User{}, fmt.Errorf("User with ID %d not found", userID)
@@ -72,22 +299,19 @@ func GetUserByID(db *sql.DB, userID int64) (User, error) {
 	return usr, nil
 }
 
-func GetUserByEmail(db *sql.DB, email string) (User, error) {
+// GetUserByEmail finds a User by email, ignoring case and surrounding spaces
+func GetUserByEmail(db *sql.DB, email string, opts ...ReadOption) (User, error) {
+	if normalized, err := NormalizeEmail(email); err == nil {
+		email = normalized
+	}
+
 	query := `
-	SELECT id, name, email, phone, created_at
+	SELECT ` + userColumns + `
 	FROM users
-	WHERE email = $1
+	WHERE email = $1 AND ` + notDeleted(opts) + `
 	`
 
-	usr := User{}
-
-	err := db.QueryRow(query, email).Scan(
-		&usr.ID,
-		&usr.Name,
-		&usr.Email,
-		&usr.Phone,
-		&usr.CreatedAt,
-	)
+	usr, err := scanUser(db.QueryRow(query, email))
 
 	if err == sql.ErrNoRows {
 		return User{}, fmt.Errorf("User with Email %s not found", email)
@@ -99,22 +323,20 @@ func GetUserByEmail(db *sql.DB, email string) (User, error) {
 	return usr, nil
 }
 
-func GetUserByPhone(db *sql.DB, phone string) (User, error) {
+// GetUserByPhone finds a User by phone number in any format NormalizePhone accepts,
+// so "555-0101", "(555) 0101" and "+15550101" find the same User
+func GetUserByPhone(db *sql.DB, phone string, opts ...ReadOption) (User, error) {
+	if normalized, err := NormalizePhone(phone); err == nil {
+		phone = normalized
+	}
+
 	query := `
-	SELECT id, name, email, phone, created_at
+	SELECT ` + userColumns + `
 	FROM users
-	WHERE phone = $1
+	WHERE phone = $1 AND ` + notDeleted(opts) + `
 	`
 
-	usr := User{}
-
-	err := db.QueryRow(query, phone).Scan(
-		&usr.ID,
-		&usr.Name,
-		&usr.Email,
-		&usr.Phone,
-		&usr.CreatedAt,
-	)
+	usr, err := scanUser(db.QueryRow(query, phone))
 
 	if err == sql.ErrNoRows {
 		return User{}, fmt.Errorf("User with phone %s not found", phone)
@@ -126,11 +348,12 @@ func GetUserByPhone(db *sql.DB, phone string) (User, error) {
 	return usr, nil
 }
 
-func GetAllUsers(db *sql.DB) ([]User, error) {
+func GetAllUsers(db *sql.DB, opts ...ReadOption) ([]User, error) {
 	query :=
 		`
-	SELECT id, name, email, phone, created_at
+	SELECT ` + userColumns + `
 	FROM users
+	WHERE ` + notDeleted(opts) + `
 	ORDER BY name
 	`
 	rows, err := db.Query(query)
@@ -142,13 +365,7 @@ func GetAllUsers(db *sql.DB) ([]User, error) {
 	var users []User
 
 	for rows.Next() {
-		var usr User
-		err := rows.Scan(
-			&usr.ID,
-			&usr.Name,
-			&usr.Email,
-			&usr.Phone,
-			&usr.CreatedAt)
+		usr, err := scanUser(rows)
 
 		// if nil then scan was correct
 		if err != nil {
@@ -163,8 +380,8 @@ func GetAllUsers(db *sql.DB) ([]User, error) {
 	return users, nil
 }
 
-func CountUsers(db *sql.DB) (int64, error) {
-	query := `SELECT COUNT(*) FROM users`
+func CountUsers(db *sql.DB, opts ...ReadOption) (int64, error) {
+	query := `SELECT COUNT(*) FROM users WHERE ` + notDeleted(opts)
 
 	var count int64
 
@@ -176,75 +393,287 @@ func CountUsers(db *sql.DB) (int64, error) {
 	return count, nil
 }
 
+// DeleteUser soft-deletes a User and their loans, refusing while they owe on an active or defaulted Loan.
+// The rows and their payment history are kept and can be brought back with RestoreUser.
 func DeleteUser(db *sql.DB, userID int64) error {
-	query :=
-		`
-	DELETE FROM users
-	WHERE id = $1
-	`
-	_, err := db.Exec(query, userID)
+	return DeleteUserContext(context.Background(), db, userID)
+}
+
+// DeleteUserContext is DeleteUser, audited as the actor in ctx
+func DeleteUserContext(ctx context.Context, db *sql.DB, userID int64) error {
+	return DeleteUserWithPolicy(ctx, db, userID, DeleteRestrict)
+}
+
+// DeleteUserWithPolicy soft-deletes a User and their loans, with policy deciding
+// whether loans that are still active or defaulted block the delete.
+func DeleteUserWithPolicy(ctx context.Context, db *sql.DB, userID int64, policy DeletePolicy) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		before, err := GetUserByID(tx, userID)
+		if err != nil {
+			return err
+		}
+		userID = before.ID
+
+		var active int64
+		err = tx.QueryRow(`SELECT COUNT(*) FROM loans WHERE user_id = $1 AND deleted_at IS NULL AND status IN ($2, $3)`,
+			userID, LoanStatusActive, LoanStatusDefaulted).Scan(&active)
+		if err != nil {
+			return fmt.Errorf("failed to count active loans of User %d: %w", userID, err)
+		}
+		if active > 0 && policy != DeleteCascade {
+			return fmt.Errorf("cannot delete User %d with %d active loans: %w", userID, active, ErrUserHasActiveLoans)
+		}
+
+		actor := ActorFromContext(ctx)
+		after := before
+		err = tx.QueryRow(`UPDATE users SET deleted_at = clock_timestamp(), deleted_by = $1 WHERE id = $2 RETURNING deleted_at`,
+			actor, userID).Scan(&after.DeletedAt)
+		if err != nil {
+			return fmt.Errorf("failed to delete User %w", err)
+		}
+		after.DeletedAt = after.DeletedAt.UTC()
+		after.DeletedBy = actor
+
+		// the loans go with the User, stamped alike so RestoreUser brings back exactly these
+		loans, err := GetLoansByUserID(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, ln := range loans {
+			if err := softDeleteLoan(ctx, tx, ln, after.DeletedAt); err != nil {
+				return err
+			}
+		}
+
+		return recordAudit(ctx, tx, AuditDelete, AuditUser, userID, before, after, "")
+	})
+}
+
+// RestoreUser brings back a soft-deleted User with the loans that were deleted along with them.
+func RestoreUser(db *sql.DB, userID int64) error {
+	return RestoreUserContext(context.Background(), db, userID)
+}
+
+// RestoreUserContext is RestoreUser, audited as the actor in ctx
+func RestoreUserContext(ctx context.Context, db *sql.DB, userID int64) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		before, err := GetUserByID(tx, userID, IncludeDeleted)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt.IsZero() {
+			return fmt.Errorf("User %d is not deleted", userID)
+		}
+		if before.MergedInto != 0 {
+			return fmt.Errorf("User %d was merged into User %d and cannot be restored", userID, before.MergedInto)
+		}
+
+		if _, err := tx.Exec(`UPDATE users SET deleted_at = NULL, deleted_by = '' WHERE id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to restore User %d: %w", userID, err)
+		}
+
+		loans, err := GetLoansByUserID(tx, userID, IncludeDeleted)
+		if err != nil {
+			return err
+		}
+		for _, ln := range loans {
+			if !ln.DeletedAt.Equal(before.DeletedAt) {
+				continue
+			}
+			if err := restoreLoan(ctx, tx, ln); err != nil {
+				return err
+			}
+		}
+
+		after := before
+		after.DeletedAt, after.DeletedBy = time.Time{}, ""
+
+		return recordAudit(ctx, tx, AuditRestore, AuditUser, userID, before, after, "")
+	})
+}
 
+func CreateLoan(db *sql.DB, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time) (Loan, error) {
+	return CreateLoanWithOptions(db, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, LoanOptions{})
+}
+
+// CreateLoanWithOptions creates a Loan and persists the schedule structure, rate terms and due date rules from opts
+func CreateLoanWithOptions(db *sql.DB, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time, opts LoanOptions) (Loan, error) {
+	return CreateLoanContext(context.Background(), db, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, opts)
+}
+
+// CreateLoanContext is CreateLoanWithOptions, audited as the actor in ctx
+func CreateLoanContext(ctx context.Context, db *sql.DB, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time, opts LoanOptions) (Loan, error) {
+	var ln Loan
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		var err error
+		ln, err = originateLoan(ctx, tx, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, opts)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete User %w", err)
+		return Loan{}, err
 	}
 
-	return nil
+	return ln, nil
+}
 
+// originateLoan creates and audits a Loan with its parties inside the caller's transaction
+func originateLoan(ctx context.Context, tx *sql.Tx, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time, opts LoanOptions) (Loan, error) {
+	for _, p := range opts.Parties {
+		if err := validatePartyRole(p.Role); err != nil {
+			return Loan{}, err
+		}
+	}
+
+	ln, err := createLoan(tx, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, opts)
+	if err != nil {
+		return Loan{}, err
+	}
+	if err := recordAudit(ctx, tx, AuditCreate, AuditLoan, ln.ID, nil, ln, ""); err != nil {
+		return Loan{}, err
+	}
+
+	for _, p := range opts.Parties {
+		if _, err := addLoanParty(ctx, tx, ln, p); err != nil {
+			return Loan{}, err
+		}
+	}
+
+	return ln, nil
 }
 
-func CreateLoan(db *sql.DB, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time) (Loan, error) {
+// createLoan inserts a Loan with its opening rate and disbursement
+func createLoan(db execer, userID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time, opts LoanOptions) (Loan, error) {
 	query := `
-        INSERT INTO loans (user_id, total_amount, interest_rate, term_months, day_due, status, date_taken)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
-        RETURNING id, created_at
+        INSERT INTO loans (user_id, total_amount, interest_rate, term_months, day_due, status, date_taken,
+            schedule_type, interest_only_months, amortization_months, step_rate, step_months, step_count,
+            rate_index, rate_margin, first_reset_months, reset_months,
+            periodic_cap, periodic_floor, lifetime_cap, lifetime_floor,
+            business_day_convention, holiday_calendar)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+        RETURNING id, created_at, version
     `
-	var loanID int64
+	var loanID, version int64
 	var createdAt time.Time
 
-	err := db.QueryRow(query, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken).Scan(&loanID, &createdAt)
+	sched := opts.Schedule.normalized()
+	rt := opts.Rate
+	conv := opts.BusinessDayConvention
+	if conv == "" {
+		conv = Unadjusted
+	}
+
+	err := db.QueryRow(query, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken,
+		sched.Type, sched.InterestOnlyMonths, sched.AmortizationMonths, sched.StepRate, sched.StepMonths, sched.Steps,
+		rt.IndexName, rt.Margin, rt.FirstResetMonths, rt.ResetMonths,
+		rt.PeriodicCap, rt.PeriodicFloor, rt.LifetimeCap, rt.LifetimeFloor,
+		conv, opts.HolidayCalendar,
+	).Scan(&loanID, &createdAt, &version)
+	if err != nil {
+		return Loan{}, fmt.Errorf("failed to create Loan: %w", err)
+	}
+
+	// the origination rate opens the rate history so the schedule can always be reproduced
+	_, err = createRateChange(db, loanID, 1, dateTaken, 0, 0, interestRate)
 	if err != nil {
 		return Loan{}, fmt.Errorf("failed to create Loan: %w", err)
 	}
 
-	ln := Loan{loanID, userID, totalAmount, interestRate, termMonths, dayDue, status, dateTaken.UTC(), createdAt.UTC(), nil}
+	ln := Loan{
+		ID:           loanID,
+		UserID:       userID,
+		TotalAmount:  totalAmount,
+		InterestRate: interestRate,
+		TermMonths:   termMonths,
+		DayDue:       dayDue,
+		Status:       status,
+		DateTaken:    dateTaken.UTC(),
+		CreatedAt:    createdAt.UTC(),
+		Version:      version,
+		Schedule:     sched,
+		Rate:         rt,
+
+		BusinessDayConvention: conv,
+		HolidayCalendar:       opts.HolidayCalendar,
+	}
+
+	if err := postDisbursement(db, ln); err != nil {
+		return Loan{}, fmt.Errorf("failed to create Loan: %w", err)
+	}
+
 	return ln, nil
 }
 
-func UpdateLoan(db *sql.DB, loanID int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time) error {
+// UpdateLoan overwrites the terms of a Loan read at the given version.
+// It fails with a *ConflictError when the Loan has changed since, instead of overwriting that change.
+// Use PatchLoan to change only some of its terms.
+func UpdateLoan(db *sql.DB, loanID, version int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time) error {
+	return UpdateLoanContext(context.Background(), db, loanID, version, totalAmount, interestRate, termMonths, dayDue, status, dateTaken)
+}
+
+// UpdateLoanContext is UpdateLoan, audited as the actor in ctx
+func UpdateLoanContext(ctx context.Context, db *sql.DB, loanID, version int64, totalAmount, interestRate float64, termMonths, dayDue int, status string, dateTaken time.Time) error {
 	query := `
 		UPDATE loans
 		SET total_amount = $1, interest_rate = $2, term_months = $3, day_due = $4, status = $5, date_taken = $6
 		WHERE id = $7
 	`
 
-	result, err := db.Exec(query, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, loanID)
-	if err != nil {
-		return fmt.Errorf("failed to update Loan: %w", err)
-	}
+	return inTx(db, func(tx *sql.Tx) error {
+		if err := lockVersion(tx, "loans", "Loan", loanID, version); err != nil {
+			return err
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
+		before, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if status != before.Status && (status == LoanStatusChargedOff || status == LoanStatusSettled) {
+			return fmt.Errorf("Loan %d cannot be updated to %s, charge it off or settle it instead", loanID, status)
+		}
+		if status != before.Status && before.closedOut() {
+			return fmt.Errorf("Loan %d is %s and cannot be reopened", loanID, before.Status)
+		}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("Loan with ID %d not found", loanID)
-	}
+		_, err = tx.Exec(query, totalAmount, interestRate, termMonths, dayDue, status, dateTaken, loanID)
+		if err != nil {
+			return fmt.Errorf("failed to update Loan: %w", err)
+		}
 
-	return nil
+		after, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditLoan, loanID, before, after, ""); err != nil {
+			return err
+		}
+
+		if after.TotalAmount == before.TotalAmount && after.DateTaken.Equal(before.DateTaken) {
+			return nil
+		}
+		return syncLedger(tx, loanID)
+	})
 }
 
-// Get a singular Loan based on it's ID
-func GetLoanByLoanID(db *sql.DB, loanID int64) (Loan, error) {
-	query := `
-	SELECT id, user_id, total_amount, interest_rate, term_months, day_due, status, date_taken, created_at
-	FROM loans
-	WHERE id = $1
-	`
+// loanColumns is the column list every Loan query selects, in the order scanLoan expects
+const loanColumns = `id, user_id, total_amount, interest_rate, term_months, day_due, status, date_taken, created_at,
+	schedule_type, interest_only_months, amortization_months, step_rate, step_months, step_count,
+	rate_index, rate_margin, first_reset_months, reset_months,
+	periodic_cap, periodic_floor, lifetime_cap, lifetime_floor,
+	business_day_convention, holiday_calendar, deleted_at, deleted_by, version`
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLoan reads a row selected with loanColumns into a Loan
+func scanLoan(row rowScanner) (Loan, error) {
 	var l Loan
+	var deletedAt sql.NullTime
 
-	err := db.QueryRow(query, loanID).Scan(
+	err := row.Scan(
 		&l.ID,
 		&l.UserID,
 		&l.TotalAmount,
@@ -254,7 +683,48 @@ func GetLoanByLoanID(db *sql.DB, loanID int64) (Loan, error) {
 		&l.Status,
 		&l.DateTaken,
 		&l.CreatedAt,
+		&l.Schedule.Type,
+		&l.Schedule.InterestOnlyMonths,
+		&l.Schedule.AmortizationMonths,
+		&l.Schedule.StepRate,
+		&l.Schedule.StepMonths,
+		&l.Schedule.Steps,
+		&l.Rate.IndexName,
+		&l.Rate.Margin,
+		&l.Rate.FirstResetMonths,
+		&l.Rate.ResetMonths,
+		&l.Rate.PeriodicCap,
+		&l.Rate.PeriodicFloor,
+		&l.Rate.LifetimeCap,
+		&l.Rate.LifetimeFloor,
+		&l.BusinessDayConvention,
+		&l.HolidayCalendar,
+		&deletedAt,
+		&l.DeletedBy,
+		&l.Version,
 	)
+	if err != nil {
+		return Loan{}, err
+	}
+
+	l.DateTaken = l.DateTaken.UTC()
+	l.CreatedAt = l.CreatedAt.UTC()
+	if deletedAt.Valid {
+		l.DeletedAt = deletedAt.Time.UTC()
+	}
+
+	return l, nil
+}
+
+// Get a singular Loan based on it's ID
+func GetLoanByLoanID(db execer, loanID int64, opts ...ReadOption) (Loan, error) {
+	query := `
+	SELECT ` + loanColumns + `
+	FROM loans
+	WHERE id = $1 AND ` + notDeleted(opts) + `
+	`
+
+	l, err := scanLoan(db.QueryRow(query, loanID))
 
 	if err == sql.ErrNoRows {
 		return Loan{}, fmt.Errorf("Loan with ID %d not found", loanID)
@@ -263,19 +733,22 @@ func GetLoanByLoanID(db *sql.DB, loanID int64) (Loan, error) {
 		return Loan{}, fmt.Errorf("failed to get Loan: %w", err)
 	}
 
-	l.DateTaken = l.DateTaken.UTC()
-	l.CreatedAt = l.CreatedAt.UTC()
-
 	return l, nil
 }
 
 // Get all loans associated to a User
-func GetLoansByUserID(db *sql.DB, userID int64) ([]Loan, error) {
+// Pass IncludeAsParty to also get the loans they co-borrowed or guaranteed.
+func GetLoansByUserID(db execer, userID int64, opts ...ReadOption) ([]Loan, error) {
+	owner := `user_id = $1`
+	if includes(opts, IncludeAsParty) {
+		owner = `(user_id = $1 OR id IN (SELECT loan_id FROM loan_parties WHERE user_id = $1))`
+	}
+
 	query :=
 		`
-	SELECT id, user_id, total_amount, interest_rate, term_months, day_due, status, date_taken, created_at
+	SELECT ` + loanColumns + `
 	FROM loans 
-	WHERE user_id = $1
+	WHERE ` + owner + ` AND ` + notDeleted(opts) + `
 	ORDER BY id 
 	`
 
@@ -289,26 +762,11 @@ func GetLoansByUserID(db *sql.DB, userID int64) ([]Loan, error) {
 	var loans []Loan
 
 	for rows.Next() {
-		var l Loan
-
-		err := rows.Scan(
-			&l.ID,
-			&l.UserID,
-			&l.TotalAmount,
-			&l.InterestRate,
-			&l.TermMonths,
-			&l.DayDue,
-			&l.Status,
-			&l.DateTaken,
-			&l.CreatedAt,
-		)
-
+		l, err := scanLoan(rows)
 		if err != nil {
 			return []Loan{}, fmt.Errorf("failed to scan Loan row: %w", err)
 		}
 
-		l.DateTaken = l.DateTaken.UTC()
-		l.CreatedAt = l.CreatedAt.UTC()
 		loans = append(loans, l) // we add l to loans
 	}
 
@@ -322,11 +780,12 @@ func GetLoansByUserID(db *sql.DB, userID int64) ([]Loan, error) {
 }
 
 // Gets all the loans in the database
-func GetAllLoans(db *sql.DB) ([]Loan, error) {
+func GetAllLoans(db *sql.DB, opts ...ReadOption) ([]Loan, error) {
 	query :=
 		`
-	SELECT id, user_id, total_amount, interest_rate, term_months, day_due, status, date_taken, created_at
+	SELECT ` + loanColumns + `
 	FROM loans 
+	WHERE ` + notDeleted(opts) + `
 	ORDER BY id 
 	`
 
@@ -339,25 +798,10 @@ func GetAllLoans(db *sql.DB) ([]Loan, error) {
 	loans := []Loan{}
 
 	for rows.Next() {
-		var ln Loan
-
-		err := rows.Scan(
-			&ln.ID,
-			&ln.UserID,
-			&ln.TotalAmount,
-			&ln.InterestRate,
-			&ln.TermMonths,
-			&ln.DayDue,
-			&ln.Status,
-			&ln.DateTaken,
-			&ln.CreatedAt,
-		)
-
+		ln, err := scanLoan(rows)
 		if err != nil {
 			return nil, err
 		}
-		ln.DateTaken = ln.DateTaken.UTC()
-		ln.CreatedAt = ln.CreatedAt.UTC()
 
 		loans = append(loans, ln)
 	}
@@ -369,11 +813,11 @@ func GetAllLoans(db *sql.DB) ([]Loan, error) {
 }
 
 // GetLoansByStatus retrieves all loans with a specific status
-func GetLoansByStatus(db *sql.DB, status string) ([]Loan, error) {
+func GetLoansByStatus(db *sql.DB, status string, opts ...ReadOption) ([]Loan, error) {
 	query := `
-	SELECT id, user_id, total_amount, interest_rate, term_months, day_due, status, date_taken, created_at 
+	SELECT ` + loanColumns + `
 	FROM loans
-	where status = $1
+	where status = $1 AND ` + notDeleted(opts) + `
 	ORDER BY id
 	`
 	rows, err := db.Query(query, status)
@@ -385,25 +829,10 @@ func GetLoansByStatus(db *sql.DB, status string) ([]Loan, error) {
 	loans := []Loan{}
 
 	for rows.Next() {
-		var ln Loan
-
-		err := rows.Scan(
-			&ln.ID,
-			&ln.UserID,
-			&ln.TotalAmount,
-			&ln.InterestRate,
-			&ln.TermMonths,
-			&ln.DayDue,
-			&ln.Status,
-			&ln.DateTaken,
-			&ln.CreatedAt,
-		)
-
+		ln, err := scanLoan(rows)
 		if err != nil {
 			return nil, err
 		}
-		ln.DateTaken = ln.DateTaken.UTC()
-		ln.CreatedAt = ln.CreatedAt.UTC()
 
 		loans = append(loans, ln)
 	}
@@ -414,11 +843,11 @@ func GetLoansByStatus(db *sql.DB, status string) ([]Loan, error) {
 }
 
 // CountLoansByStatus returns the count of loans with a specific status
-func CountLoansByStatus(db *sql.DB, status string) (int64, error) {
+func CountLoansByStatus(db *sql.DB, status string, opts ...ReadOption) (int64, error) {
 	query := `
 	SELECT COUNT(*) 
 	FROM loans 
-	where status = $1`
+	where status = $1 AND ` + notDeleted(opts)
 
 	var count int64
 
@@ -430,71 +859,180 @@ func CountLoansByStatus(db *sql.DB, status string) (int64, error) {
 	return count, nil
 }
 
+// DeleteLoan soft-deletes a Loan. Its payments, ledger and history are kept and RestoreLoan brings it back.
 func DeleteLoan(db *sql.DB, LoanID int64) error {
-	query :=
-		`
-	DELETE FROM loans 
-	where id = $1
-	`
+	return DeleteLoanContext(context.Background(), db, LoanID)
+}
+
+// DeleteLoanContext is DeleteLoan, audited as the actor in ctx
+func DeleteLoanContext(ctx context.Context, db *sql.DB, loanID int64) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		before, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		var now time.Time
+		if err := tx.QueryRow(`SELECT clock_timestamp()`).Scan(&now); err != nil {
+			return fmt.Errorf("failed to delete Loan %w", err)
+		}
 
-	_, err := db.Exec(query, LoanID)
+		return softDeleteLoan(ctx, tx, before, now)
+	})
+}
+
+// softDeleteLoan marks a Loan deleted at the given moment by the actor in ctx
+func softDeleteLoan(ctx context.Context, tx *sql.Tx, ln Loan, deletedAt time.Time) error {
+	actor := ActorFromContext(ctx)
 
+	_, err := tx.Exec(`UPDATE loans SET deleted_at = $1, deleted_by = $2 WHERE id = $3`, deletedAt, actor, ln.ID)
 	if err != nil {
 		return fmt.Errorf("failed to delete Loan %w", err)
 	}
 
-	return nil
+	after := ln
+	after.DeletedAt, after.DeletedBy = deletedAt.UTC(), actor
+
+	return recordAudit(ctx, tx, AuditDelete, AuditLoan, ln.ID, ln, after, "")
+}
+
+// RestoreLoan brings back a soft-deleted Loan. The User it belongs to must not be deleted.
+func RestoreLoan(db *sql.DB, loanID int64) error {
+	return RestoreLoanContext(context.Background(), db, loanID)
+}
+
+// RestoreLoanContext is RestoreLoan, audited as the actor in ctx
+func RestoreLoanContext(ctx context.Context, db *sql.DB, loanID int64) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		ln, err := GetLoanByLoanID(tx, loanID, IncludeDeleted)
+		if err != nil {
+			return err
+		}
+		if ln.DeletedAt.IsZero() {
+			return fmt.Errorf("Loan %d is not deleted", loanID)
+		}
+		if _, err := GetUserByID(tx, ln.UserID); err != nil {
+			return fmt.Errorf("cannot restore Loan %d: %w", loanID, err)
+		}
+
+		return restoreLoan(ctx, tx, ln)
+	})
+}
+
+// restoreLoan clears the deletion mark of a Loan
+func restoreLoan(ctx context.Context, tx *sql.Tx, ln Loan) error {
+	if _, err := tx.Exec(`UPDATE loans SET deleted_at = NULL, deleted_by = '' WHERE id = $1`, ln.ID); err != nil {
+		return fmt.Errorf("failed to restore Loan %d: %w", ln.ID, err)
+	}
+
+	after := ln
+	after.DeletedAt, after.DeletedBy = time.Time{}, ""
+
+	return recordAudit(ctx, tx, AuditRestore, AuditLoan, ln.ID, ln, after, "")
 }
 
 func CreatePayment(db *sql.DB, LoanID, payment_number int64, AmountDue, AmountPaid float64, DueDate, PaidDate time.Time) (Payment, error) {
+	return CreatePaymentContext(context.Background(), db, LoanID, payment_number, AmountDue, AmountPaid, DueDate, PaidDate)
+}
+
+// CreatePaymentContext is CreatePayment, audited as the actor in ctx.
+// The installment and what was paid on it are posted to the Loan's ledger.
+func CreatePaymentContext(ctx context.Context, db *sql.DB, LoanID, payment_number int64, AmountDue, AmountPaid float64, DueDate, PaidDate time.Time) (Payment, error) {
+	var pyment Payment
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		var err error
+		pyment, err = createPayment(ctx, tx, LoanID, payment_number, AmountDue, AmountPaid, DueDate, PaidDate)
+		if err != nil {
+			return err
+		}
+		return syncLedger(tx, LoanID)
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return pyment, nil
+}
+
+// createPayment inserts and audits a Payment, leaving the ledger to the caller
+func createPayment(ctx context.Context, tx execer, LoanID, payment_number int64, AmountDue, AmountPaid float64, DueDate, PaidDate time.Time) (Payment, error) {
 	query :=
 		`
 	INSERT INTO payments (loan_id, payment_number, amount_due, amount_paid, due_date, paid_date)
 	VALUES ($1, $2, $3, $4, $5, $6)
-	returning id, created_at
+	returning id, created_at, version
 	`
 
-	var paymentID int64
+	var paymentID, version int64
 	var createdAt time.Time
 
-	err := db.QueryRow(query, LoanID, payment_number, AmountDue, AmountPaid, DueDate, PaidDate).Scan(&paymentID, &createdAt)
+	err := tx.QueryRow(query, LoanID, payment_number, AmountDue, AmountPaid, DueDate, PaidDate).Scan(&paymentID, &createdAt, &version)
 	if err != nil {
 		return Payment{}, fmt.Errorf("failed to create Payment: %w", err)
 	}
 
-	pyment := Payment{paymentID, LoanID, payment_number, AmountDue, AmountPaid, DueDate.UTC(), PaidDate.UTC(), createdAt.UTC()}
+	pyment := Payment{paymentID, LoanID, payment_number, AmountDue, AmountPaid, DueDate.UTC(), PaidDate.UTC(), createdAt.UTC(), version}
+
+	if err := recordAudit(ctx, tx, AuditCreate, AuditPayment, paymentID, nil, pyment, ""); err != nil {
+		return Payment{}, err
+	}
+
 	return pyment, nil
 }
 
-func UpdatePayment(db *sql.DB, UserID, LoanID, payment_number int64, AmountDue, AmountPaid float64, DueDate, PaidDate time.Time) error {
+// UpdatePayment overwrites a Payment read at the given version.
+// It fails with a *ConflictError when the Payment has changed since, instead of overwriting that change.
+// Use PatchPayment to change only some of its fields.
+func UpdatePayment(db *sql.DB, paymentID, version, loanID, paymentNumber int64, amountDue, amountPaid float64, dueDate, paidDate time.Time) error {
+	return UpdatePaymentContext(context.Background(), db, paymentID, version, loanID, paymentNumber, amountDue, amountPaid, dueDate, paidDate)
+}
+
+// UpdatePaymentContext is UpdatePayment, audited as the actor in ctx
+func UpdatePaymentContext(ctx context.Context, db *sql.DB, paymentID, version, loanID, paymentNumber int64, amountDue, amountPaid float64, dueDate, paidDate time.Time) error {
 	query :=
 		`
 	UPDATE payments
 	SET loan_id = $1, payment_number = $2, amount_due = $3, amount_paid = $4, due_date = $5, paid_date = $6
 	WHERE id = $7
+	RETURNING version
 	`
 
-	result, err := db.Exec(query, LoanID, payment_number, AmountDue, AmountPaid, DueDate, PaidDate, UserID)
-	if err != nil {
-		return fmt.Errorf("failed to update Payment: %w", err)
-	}
+	return inTx(db, func(tx *sql.Tx) error {
+		if err := lockVersion(tx, "payments", "Payment", paymentID, version); err != nil {
+			return err
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
+		before, err := GetPaymentByID(tx, paymentID)
+		if err != nil {
+			return err
+		}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("Payment with ID %d not found", UserID)
-	}
+		var newVersion int64
+		err = tx.QueryRow(query, loanID, paymentNumber, amountDue, amountPaid, dueDate, paidDate, paymentID).Scan(&newVersion)
+		if err != nil {
+			return fmt.Errorf("failed to update Payment: %w", err)
+		}
 
-	return nil
+		after := Payment{paymentID, loanID, paymentNumber, amountDue, amountPaid, dueDate.UTC(), paidDate.UTC(), before.CreatedAt, newVersion}
 
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditPayment, paymentID, before, after, ""); err != nil {
+			return err
+		}
+
+		// a Payment moved to another Loan changes both ledgers
+		if before.LoanID != loanID {
+			if err := syncLedger(tx, before.LoanID); err != nil {
+				return err
+			}
+		}
+		return syncLedger(tx, loanID)
+	})
 }
 
-func GetPaymentByID(db *sql.DB, paymentID int64) (Payment, error) {
+func GetPaymentByID(db execer, paymentID int64) (Payment, error) {
 	query := `
-        SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at
+        SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at, version
         FROM payments
         WHERE id = $1
     `
@@ -509,6 +1047,7 @@ func GetPaymentByID(db *sql.DB, paymentID int64) (Payment, error) {
 		&p.DueDate,
 		&p.PaidDate,
 		&p.CreatedAt,
+		&p.Version,
 	)
 	p.DueDate = p.DueDate.UTC()
 	p.PaidDate = p.PaidDate.UTC()
@@ -522,9 +1061,9 @@ func GetPaymentByID(db *sql.DB, paymentID int64) (Payment, error) {
 }
 
 // Gets all the payments associated with a singular Loan
-func GetPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
+func GetPaymentsByLoanID(db execer, loanID int64) ([]Payment, error) {
 	query := `
-	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at
+	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at, version
 	FROM payments
 	WHERE loan_id = $1
 	ORDER BY payment_number
@@ -550,6 +1089,7 @@ func GetPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
 			&p.DueDate,
 			&p.PaidDate,
 			&p.CreatedAt,
+			&p.Version,
 		)
 
 		if err != nil {
@@ -575,7 +1115,7 @@ func GetPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
 func GetAllPayments(db *sql.DB) ([]Payment, error) {
 	query :=
 		`
-	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at
+	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at, version
 	FROM payments
 	ORDER BY id
 	`
@@ -600,6 +1140,7 @@ func GetAllPayments(db *sql.DB) ([]Payment, error) {
 			&p.DueDate,
 			&p.PaidDate,
 			&p.CreatedAt,
+			&p.Version,
 		)
 
 		if err != nil {
@@ -623,7 +1164,7 @@ func GetAllPayments(db *sql.DB) ([]Payment, error) {
 // GetUnpaidPaymentsByLoanID retrieves all unpaid payments for a Loan
 func GetUnpaidPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
 	query := `
-	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at
+	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at, version
 	FROM payments
 	WHERE loan_id = $1 
 	AND (paid_date IS NULL OR amount_paid < amount_due)
@@ -650,6 +1191,7 @@ func GetUnpaidPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
 			&p.DueDate,
 			&p.PaidDate,
 			&p.CreatedAt,
+			&p.Version,
 		)
 
 		if err != nil {
@@ -673,16 +1215,32 @@ func GetUnpaidPaymentsByLoanID(db *sql.DB, loanID int64) ([]Payment, error) {
 
 // Deletes a singular Payment based on a given ID
 func DeletePayment(db *sql.DB, paymentID int64) error {
+	return DeletePaymentContext(context.Background(), db, paymentID)
+}
+
+// DeletePaymentContext is DeletePayment, audited as the actor in ctx
+func DeletePaymentContext(ctx context.Context, db *sql.DB, paymentID int64) error {
 	query :=
 		`
 	DELETE FROM payments
 	WHERE id = $1
 	`
-	_, err := db.Exec(query, paymentID)
 
-	if err != nil {
-		return fmt.Errorf("failed to delete Payment %w", err)
-	}
+	return inTx(db, func(tx *sql.Tx) error {
+		before, err := GetPaymentByID(tx, paymentID)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		_, err = tx.Exec(query, paymentID)
+		if err != nil {
+			return fmt.Errorf("failed to delete Payment %w", err)
+		}
+
+		if err := recordAudit(ctx, tx, AuditDelete, AuditPayment, paymentID, before, nil, ""); err != nil {
+			return err
+		}
+
+		return syncLedger(tx, before.LoanID)
+	})
 }
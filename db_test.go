@@ -1,7 +1,9 @@
 package delinquencytracker
 
 import (
+	"context"
 	"database/sql"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,14 +19,42 @@ func setupTestDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("failed to connect to test database: %v", err)
 	}
+	if err := ApplySchema(db); err != nil {
+		t.Fatalf("failed to prepare test database: %v", err)
+	}
 	return db
 }
 
 // cleanup
 func teardownTestDB(db *sql.DB) {
+	db.Exec("TRUNCATE ledger_entries")
+	db.Exec("DELETE FROM payment_reversals")
+	db.Exec("DELETE FROM payment_allocations")
+	db.Exec("DELETE FROM posted_payments")
+	db.Exec("DELETE FROM charge_offs")
+	db.Exec("DELETE FROM loan_fees")
+	db.Exec("DELETE FROM loan_modifications")
+	db.Exec("DELETE FROM in_app_messages")
+	db.Exec("DELETE FROM notifications")
+	db.Exec("DELETE FROM strategy_actions")
+	db.Exec("DELETE FROM settlement_installments")
+	db.Exec("DELETE FROM settlements")
+	db.Exec("DELETE FROM repayment_plan_installments")
+	db.Exec("DELETE FROM repayment_plans")
+	db.Exec("DELETE FROM promises_to_pay")
+	db.Exec("DELETE FROM collection_contacts")
+	db.Exec("DELETE FROM collection_cases")
+	db.Exec("DELETE FROM loan_parties")
 	db.Exec("DELETE FROM payments")
+	db.Exec("DELETE FROM loan_rate_history")
 	db.Exec("DELETE FROM loans")
+	db.Exec("DELETE FROM user_addresses")
 	db.Exec("DELETE FROM users")
+	db.Exec("DELETE FROM rate_index_values")
+	db.Exec("DELETE FROM row_history")
+	db.Exec("TRUNCATE audit_log")
+	db.Exec("DELETE FROM idempotency_keys")
+	db.Exec("DELETE FROM message_templates")
 	db.Close()
 }
 
@@ -108,8 +138,8 @@ func TestGetUserByPhone(t *testing.T) {
 		t.Fatalf("Failed to create test User: %v", err)
 	}
 
-	//Act: Get the User by ID
-	usr, err = GetUserByPhone(db, usr.Phone)
+	//Act: Get the User by the same phone written another way
+	usr, err = GetUserByPhone(db, "(555) 4444")
 
 	//Assert: Check results
 	if err != nil {
@@ -121,8 +151,8 @@ func TestGetUserByPhone(t *testing.T) {
 	if usr.Email != "test@test.com" {
 		t.Fatalf("Expected email 'test@test.com', got '%s'", usr.Email)
 	}
-	if usr.Phone != "555-4444" {
-		t.Fatalf("Expected phone '555-4444', got '%s'", usr.Phone)
+	if usr.Phone != "+15554444" {
+		t.Fatalf("Expected phone '+15554444', got '%s'", usr.Phone)
 	}
 }
 
@@ -211,7 +241,7 @@ func TestUpdateUser(t *testing.T) {
 	}
 
 	// esnuring the updated User is as we expected
-	if updatedUsr.Name != "New Name" || updatedUsr.Email != "new@test.com" || updatedUsr.Phone != "555-9999" {
+	if updatedUsr.Name != "New Name" || updatedUsr.Email != "new@test.com" || updatedUsr.Phone != "+15559999" {
 		t.Fatalf("Updated User is not as Expected")
 	}
 }
@@ -225,19 +255,19 @@ func TestGetAllUsers(t *testing.T) {
 
 	// Creating multiple test users
 	// Arrange
-	user1, err := CreateUser(db, "Amir M", "amir@example.com", "111")
+	user1, err := CreateUser(db, "Amir M", "amir@example.com", "555-0111")
 
 	if err != nil {
 		t.Fatalf("Failed to create user1: %v", err)
 	}
 
-	user2, err := CreateUser(db, "Ori J", "ori@example.com", "333")
+	user2, err := CreateUser(db, "Ori J", "ori@example.com", "555-0333")
 
 	if err != nil {
 		t.Fatalf("Failed to create user2: %v", err)
 	}
 
-	user3, err := CreateUser(db, "Seb I", "seb@example.com", "222")
+	user3, err := CreateUser(db, "Seb I", "seb@example.com", "555-0222")
 
 	if err != nil {
 		t.Fatalf("Failed to create user3: %v", err)
@@ -265,7 +295,7 @@ func TestDeleteUser(t *testing.T) {
 
 	// Arrange, creating the User
 
-	usr, err := CreateUser(db, "Deleted Usr", "deleted@example.com", "555")
+	usr, err := CreateUser(db, "Deleted Usr", "deleted@example.com", "555-0555")
 
 	if err != nil {
 		t.Fatal("err is not nil in CreateUser %w", err)
@@ -357,7 +387,7 @@ func TestUpdateLoan(t *testing.T) {
 	// Act
 	// Updating the Loan with new values
 	newDateTaken := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -30) // 30 days ago
-	err = UpdateLoan(db, ln.ID, 15000.00, 0.08, 48, 20, "refinanced", newDateTaken)
+	err = UpdateLoan(db, ln.ID, ln.Version, 15000.00, 0.08, 48, 20, "refinanced", newDateTaken)
 
 	// Assert
 	// Update should succeed
@@ -385,6 +415,9 @@ func TestUpdateLoan(t *testing.T) {
 	if updatedLoan.InterestRate != 0.08 {
 		t.Errorf("Expected InterestRate 0.08, got %f", updatedLoan.InterestRate)
 	}
+	if updatedLoan.Version != ln.Version+1 {
+		t.Errorf("Expected Version %d, got %d", ln.Version+1, updatedLoan.Version)
+	}
 	if updatedLoan.TermMonths != 48 {
 		t.Errorf("Expected TermMonths 48, got %d", updatedLoan.TermMonths)
 	}
@@ -803,7 +836,7 @@ func TestCreatePayment(t *testing.T) {
 		t.Fatalf("Create Payment failed %v:", err)
 	}
 
-	var expectedPyment = Payment{pyment.ID, ln.ID, 1, 1000, 900, dueDate, paidDate, pyment.CreatedAt}
+	var expectedPyment = Payment{pyment.ID, ln.ID, 1, 1000, 900, dueDate, paidDate, pyment.CreatedAt, 1}
 
 	require.Equal(t, expectedPyment, pyment)
 
@@ -841,7 +874,7 @@ func TestUpdatePayment(t *testing.T) {
 	newDueDate := dateTaken.Add(45 * 24 * time.Hour)  // 45 days after Loan was taken
 	newPaidDate := newDueDate.Add(3 * 24 * time.Hour) // paid 3 days late
 
-	err = UpdatePayment(db, pyment.ID, ln.ID, 2, 1200.00, 1200.00, newDueDate, newPaidDate)
+	err = UpdatePayment(db, pyment.ID, pyment.Version, ln.ID, 2, 1200.00, 1200.00, newDueDate, newPaidDate)
 
 	// Assert
 	// Update should succeed
@@ -1286,3 +1319,145 @@ func TestDeletePayment(t *testing.T) {
 
 	require.Empty(t, checkPayments)
 }
+
+// TestDeleteUserWithActiveLoans verifies a User who still owes is only deleted when the cascade is asked for.
+func TestDeleteUserWithActiveLoans(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Owing User", "owing@example.com", "555-2020",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ctx := WithActor(context.Background(), "alice")
+
+	// Act, the default policy refuses
+	err = DeleteUserContext(ctx, db, user.ID)
+	require.ErrorIs(t, err, ErrUserHasActiveLoans)
+	_, err = GetUserByID(db, user.ID)
+	require.NoError(t, err)
+
+	// Act, the cascade deletes the User and their loans but keeps the payments
+	require.NoError(t, DeleteUserWithPolicy(ctx, db, user.ID, DeleteCascade))
+
+	// Assert
+	_, err = GetUserByID(db, user.ID)
+	require.Error(t, err)
+	deleted, err := GetFullUserByID(db, user.ID, IncludeDeleted)
+	require.NoError(t, err)
+	require.Equal(t, "alice", deleted.DeletedBy)
+	require.Len(t, deleted.Loans, 1)
+	require.Equal(t, deleted.DeletedAt, deleted.Loans[0].DeletedAt)
+	require.Len(t, deleted.Loans[0].Payments, 3)
+
+	count, err := CountLoansByStatus(db, LoanStatusActive)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+}
+
+// TestRestoreUser verifies a restore brings back the User with the loans deleted along with them, and only those.
+func TestRestoreUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, one Loan deleted on its own and one deleted with the User
+	usr, err := CreateUser(db, "Restored User", "restored@example.com", "555-2121")
+	require.NoError(t, err)
+	first, err := CreateLoan(db, usr.ID, 5000, 0.05, 12, 1, LoanStatusPaidOff, calendarDate(2023, time.January, 1))
+	require.NoError(t, err)
+	second, err := CreateLoan(db, usr.ID, 2000, 0.05, 12, 1, LoanStatusPaidOff, calendarDate(2023, time.June, 1))
+	require.NoError(t, err)
+	require.NoError(t, DeleteLoan(db, first.ID))
+	require.NoError(t, DeleteUser(db, usr.ID))
+
+	// a Loan cannot come back while its User is deleted
+	require.Error(t, RestoreLoan(db, second.ID))
+
+	// Act
+	require.NoError(t, RestoreUser(db, usr.ID))
+
+	// Assert
+	restored, err := GetFullUserByID(db, usr.ID)
+	require.NoError(t, err)
+	require.True(t, restored.DeletedAt.IsZero())
+	require.Len(t, restored.Loans, 1)
+	require.Equal(t, second.ID, restored.Loans[0].ID)
+
+	require.NoError(t, RestoreLoan(db, first.ID))
+	loans, err := GetLoansByUserID(db, usr.ID)
+	require.NoError(t, err)
+	require.Len(t, loans, 2)
+	require.Error(t, RestoreLoan(db, first.ID), "the Loan is no longer deleted")
+}
+
+// TestUpdateLoanConcurrent verifies two writers editing the same Loan version cannot both succeed.
+func TestUpdateLoanConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, two collectors read the same Loan
+	usr, err := CreateUser(db, "Concurrent User", "concurrent@example.com", "555-2222")
+	require.NoError(t, err)
+	ln, err := CreateLoan(db, usr.ID, 10000, 0.05, 36, 15, LoanStatusActive, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+
+	// Act, both write on top of what they read at the same time
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, status := range []string{LoanStatusDefaulted, LoanStatusPaidOff} {
+		wg.Add(1)
+		go func(i int, status string) {
+			defer wg.Done()
+			errs[i] = UpdateLoan(db, ln.ID, ln.Version, ln.TotalAmount, ln.InterestRate, ln.TermMonths, ln.DayDue, status, ln.DateTaken)
+		}(i, status)
+	}
+	wg.Wait()
+
+	// Assert, one wins and the other is told about the conflict
+	var conflicts int
+	var winner string
+	for i, err := range errs {
+		if err == nil {
+			winner = []string{LoanStatusDefaulted, LoanStatusPaidOff}[i]
+			continue
+		}
+		var conflict *ConflictError
+		require.ErrorAs(t, err, &conflict)
+		require.Equal(t, ln.Version, conflict.Expected)
+		require.Equal(t, ln.Version+1, conflict.Actual)
+		conflicts++
+	}
+	require.Equal(t, 1, conflicts)
+
+	got, err := GetLoanByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, winner, got.Status)
+	require.Equal(t, ln.Version+1, got.Version)
+}
+
+// TestUpdatePaymentConflict verifies a Payment changed by someone else since it was read is not overwritten.
+func TestUpdatePaymentConflict(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Stale User", "stale@example.com", "555-2323",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	p := user.Loans[0].Payments[0]
+
+	// Act, a payment posted after the read changes the installment
+	_, err = PostPayment(db, p.LoanID, 100, calendarDate(2024, time.February, 1))
+	require.NoError(t, err)
+	err = UpdatePayment(db, p.ID, p.Version, p.LoanID, p.PaymentNumber, p.AmountDue, 0, p.DueDate, p.PaidDate)
+
+	// Assert
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, "Payment", conflict.Entity)
+
+	fresh, err := GetPaymentByID(db, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 100.0, fresh.AmountPaid)
+	require.NoError(t, UpdatePayment(db, p.ID, fresh.Version, p.LoanID, p.PaymentNumber, p.AmountDue, 0, p.DueDate, p.PaidDate))
+}
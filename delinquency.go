@@ -0,0 +1,133 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Delinquency buckets by days past due
+const (
+	BucketCurrent = "current"
+	Bucket1To29   = "1-29"
+	Bucket30To59  = "30-59"
+	Bucket60To89  = "60-89"
+	Bucket90Plus  = "90+"
+)
+
+// amounts below this are treated as paid, to absorb float rounding
+const paymentTolerance = 0.005
+
+// Delinquency is the delinquency state of a Loan on a given day.
+type Delinquency struct {
+	LoanID         int64     // which loan was evaluated
+	AsOf           time.Time // the day the Loan was evaluated for
+	DaysPastDue    int       // days since the oldest unpaid installment fell due, less forbearance days (0 when current)
+	PastDueAmount  float64   // total still owed on installments that are past due
+	MissedPayments int       // how many installments are past due
+	OldestDueDate  time.Time // business-day adjusted due date of the oldest past due installment
+	Bucket         string    // aging bucket derived from DaysPastDue
+	InForbearance  bool      // whether AsOf falls in a forbearance window
+}
+
+// IsDelinquent reports whether anything is past due.
+func (d Delinquency) IsDelinquent() bool {
+	return d.DaysPastDue > 0
+}
+
+// delinquencyBucket maps days past due to an aging bucket
+func delinquencyBucket(dpd int) string {
+	switch {
+	case dpd <= 0:
+		return BucketCurrent
+	case dpd < 30:
+		return Bucket1To29
+	case dpd < 60:
+		return Bucket30To59
+	case dpd < 90:
+		return Bucket60To89
+	default:
+		return Bucket90Plus
+	}
+}
+
+// startOfDay truncates t to midnight UTC
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// paidAsOf returns how much of an installment had been paid by the given day.
+// Payments recorded after asOf are ignored so past days can be evaluated.
+func paidAsOf(p Payment, asOf time.Time) float64 {
+	if p.PaidDate.IsZero() || startOfDay(p.PaidDate).After(asOf) {
+		return 0
+	}
+	return p.AmountPaid
+}
+
+// EvaluateDelinquency computes the delinquency of a Loan with its payments and modifications loaded.
+// An installment is past due once its due date, moved to a business day with the
+// Loan's convention and calendar, is before asOf and it is not fully paid.
+// Days inside a forbearance window do not count towards days past due.
+func EvaluateDelinquency(ln Loan, asOf time.Time) (Delinquency, error) {
+	asOf = startOfDay(asOf)
+
+	cal, err := holidayCalendarFor(ln.HolidayCalendar)
+	if err != nil {
+		return Delinquency{}, err
+	}
+
+	d := Delinquency{LoanID: ln.ID, AsOf: asOf}
+
+	for _, m := range ln.Modifications {
+		if m.inForbearance(asOf) {
+			d.InForbearance = true
+		}
+	}
+
+	for _, p := range ln.Payments {
+		dueDate := cal.Adjust(startOfDay(p.DueDate), ln.BusinessDayConvention)
+		if !dueDate.Before(asOf) {
+			continue
+		}
+
+		owed := p.AmountDue - paidAsOf(p, asOf)
+		if owed <= paymentTolerance {
+			continue
+		}
+
+		aged := int(asOf.Sub(dueDate).Hours()/24) - forbearanceDays(ln.Modifications, dueDate, asOf)
+		if aged <= 0 {
+			continue
+		}
+
+		d.PastDueAmount += owed
+		d.MissedPayments++
+		if aged > d.DaysPastDue {
+			d.DaysPastDue = aged
+		}
+		if d.OldestDueDate.IsZero() || dueDate.Before(d.OldestDueDate) {
+			d.OldestDueDate = dueDate
+		}
+	}
+
+	d.Bucket = delinquencyBucket(d.DaysPastDue)
+
+	return d, nil
+}
+
+// GetLoanDelinquency loads a Loan with its payments and evaluates its delinquency as of a day.
+func GetLoanDelinquency(db *sql.DB, loanID int64, asOf time.Time) (Delinquency, error) {
+	ln, err := LoanFromLedger(db, loanID)
+	if err != nil {
+		return Delinquency{}, err
+	}
+
+	d, err := EvaluateDelinquency(ln, asOf)
+	if err != nil {
+		return Delinquency{}, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", loanID, err)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,87 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// delinquentTestLoan builds a Loan with three $500 installments due on the 1st
+func delinquentTestLoan() Loan {
+	return Loan{
+		ID: 7,
+		Payments: []Payment{
+			{PaymentNumber: 1, AmountDue: 500, AmountPaid: 500, DueDate: calendarDate(2024, time.August, 1), PaidDate: calendarDate(2024, time.August, 1)},
+			{PaymentNumber: 2, AmountDue: 500, DueDate: calendarDate(2024, time.September, 1)},
+			{PaymentNumber: 3, AmountDue: 500, DueDate: calendarDate(2024, time.October, 1)},
+		},
+	}
+}
+
+// TestEvaluateDelinquency verifies days past due, amount and bucket.
+func TestEvaluateDelinquency(t *testing.T) {
+	ln := delinquentTestLoan()
+
+	tests := []struct {
+		name   string
+		asOf   time.Time
+		dpd    int
+		amount float64
+		bucket string
+		missed int
+	}{
+		{"Before anything is due", calendarDate(2024, time.August, 15), 0, 0, BucketCurrent, 0},
+		{"On the due date", calendarDate(2024, time.September, 1), 0, 0, BucketCurrent, 0},
+		{"Day after due date", calendarDate(2024, time.September, 2), 1, 500, Bucket1To29, 1},
+		{"Two installments missed", calendarDate(2024, time.October, 15), 44, 1000, Bucket30To59, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := EvaluateDelinquency(ln, tt.asOf)
+			require.NoError(t, err)
+			require.Equal(t, tt.dpd, d.DaysPastDue)
+			require.InDelta(t, tt.amount, d.PastDueAmount, 0.001)
+			require.Equal(t, tt.bucket, d.Bucket)
+			require.Equal(t, tt.missed, d.MissedPayments)
+		})
+	}
+}
+
+// TestEvaluateDelinquencyIgnoresLaterPayments verifies a past day is evaluated with the payments known then.
+func TestEvaluateDelinquencyIgnoresLaterPayments(t *testing.T) {
+	ln := delinquentTestLoan()
+	ln.Payments[1].AmountPaid = 500
+	ln.Payments[1].PaidDate = calendarDate(2024, time.September, 20)
+
+	before, err := EvaluateDelinquency(ln, calendarDate(2024, time.September, 10))
+	require.NoError(t, err)
+	require.Equal(t, 9, before.DaysPastDue)
+
+	after, err := EvaluateDelinquency(ln, calendarDate(2024, time.September, 25))
+	require.NoError(t, err)
+	require.False(t, after.IsDelinquent())
+}
+
+// TestEvaluateDelinquencyBusinessDays verifies the clock starts on the adjusted due date.
+func TestEvaluateDelinquencyBusinessDays(t *testing.T) {
+	ln := delinquentTestLoan()
+
+	// 2024-09-01 is a Sunday and 2024-09-02 is Labor Day
+	unadjusted, err := EvaluateDelinquency(ln, calendarDate(2024, time.September, 3))
+	require.NoError(t, err)
+	require.Equal(t, 2, unadjusted.DaysPastDue)
+
+	ln.BusinessDayConvention = Following
+	ln.HolidayCalendar = "US"
+
+	onAdjustedDueDate, err := EvaluateDelinquency(ln, calendarDate(2024, time.September, 3))
+	require.NoError(t, err)
+	require.Equal(t, 0, onAdjustedDueDate.DaysPastDue, "the bank was closed until the 3rd")
+
+	dayAfter, err := EvaluateDelinquency(ln, calendarDate(2024, time.September, 4))
+	require.NoError(t, err)
+	require.Equal(t, 1, dayAfter.DaysPastDue)
+	require.Equal(t, calendarDate(2024, time.September, 3), dayAfter.OldestDueDate)
+}
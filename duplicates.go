@@ -0,0 +1,409 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// How much each signal adds to the score of a candidate pair. A pair's score is capped at 1.
+const (
+	scoreSamePhone   = 0.4
+	scoreSimilarName = 0.3 // scaled by how similar the names are
+	scoreSameBirth   = 0.2
+	scoreSameMailbox = 0.15
+	scoreSameAddress = 0.15
+
+	// names less alike than this do not count towards the score
+	minNameSimilarity = 0.75
+)
+
+// DuplicateCandidate is a pair of users who may be the same borrower
+type DuplicateCandidate struct {
+	UserID  int64    // the older of the two users
+	OtherID int64    // the newer one
+	Score   float64  // from 0 to 1, higher is more likely the same borrower
+	Reasons []string // the signals the score is made of, e.g. "same phone"
+}
+
+// FindDuplicateUsers scores pairs of users that share a phone, date of birth, email mailbox, address or
+// name word, and returns those scoring at least minScore, most likely duplicates first.
+// Deleted and merged users are left out.
+func FindDuplicateUsers(db *sql.DB, minScore float64) ([]DuplicateCandidate, error) {
+	users, err := GetAllUsers(db)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := currentAddresses(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Address = addresses[users[i].ID]
+	}
+
+	// only users sharing at least one blocking key are compared, rather than every pair
+	blocks := map[string][]int{}
+	for i, usr := range users {
+		for _, key := range blockingKeys(usr) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+
+	seen := map[[2]int]bool{}
+	var candidates []DuplicateCandidate
+
+	for _, block := range blocks {
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				pair := [2]int{block[x], block[y]}
+				if seen[pair] {
+					continue
+				}
+				seen[pair] = true
+
+				c := scoreDuplicate(users[pair[0]], users[pair[1]])
+				if c.Score >= minScore {
+					candidates = append(candidates, c)
+				}
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if candidates[i].UserID != candidates[j].UserID {
+			return candidates[i].UserID < candidates[j].UserID
+		}
+		return candidates[i].OtherID < candidates[j].OtherID
+	})
+
+	return candidates, nil
+}
+
+// currentAddresses returns the current mailing address of every User who has one
+func currentAddresses(db execer) (map[int64]Address, error) {
+	rows, err := db.Query(`SELECT ` + addressColumns + ` FROM user_addresses WHERE valid_to IS NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query addresses: %w", err)
+	}
+	defer rows.Close()
+
+	addresses := map[int64]Address{}
+
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan address row: %w", err)
+		}
+		addresses[a.UserID] = a
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating address rows: %w", err)
+	}
+
+	return addresses, nil
+}
+
+// blockingKeys are the values two users must share one of to be compared at all
+func blockingKeys(usr User) []string {
+	keys := []string{"phone:" + usr.Phone, "mailbox:" + mailbox(usr.Email)}
+	if !usr.Profile.DateOfBirth.IsZero() {
+		keys = append(keys, "dob:"+usr.Profile.DateOfBirth.Format("2006-01-02"))
+	}
+	if addr := addressKey(usr.Address); addr != "" {
+		keys = append(keys, "address:"+addr)
+	}
+	for _, word := range strings.Fields(normalizedName(usr.Name)) {
+		if len(word) >= 3 {
+			keys = append(keys, "name:"+word)
+		}
+	}
+	return keys
+}
+
+// scoreDuplicate adds up the signals that a and b are the same borrower
+func scoreDuplicate(a, b User) DuplicateCandidate {
+	if a.ID > b.ID {
+		a, b = b, a
+	}
+	c := DuplicateCandidate{UserID: a.ID, OtherID: b.ID}
+
+	if a.Phone == b.Phone {
+		c.Score += scoreSamePhone
+		c.Reasons = append(c.Reasons, "same phone")
+	}
+	if sim := nameSimilarity(a.Name, b.Name); sim >= minNameSimilarity {
+		c.Score += scoreSimilarName * sim
+		c.Reasons = append(c.Reasons, fmt.Sprintf("similar name (%.2f)", sim))
+	}
+	if !a.Profile.DateOfBirth.IsZero() && a.Profile.DateOfBirth.Equal(b.Profile.DateOfBirth) {
+		c.Score += scoreSameBirth
+		c.Reasons = append(c.Reasons, "same date of birth")
+	}
+	if mailbox(a.Email) == mailbox(b.Email) {
+		c.Score += scoreSameMailbox
+		c.Reasons = append(c.Reasons, "same email mailbox")
+	}
+	if addr := addressKey(a.Address); addr != "" && addr == addressKey(b.Address) {
+		c.Score += scoreSameAddress
+		c.Reasons = append(c.Reasons, "same address")
+	}
+
+	c.Score = min(c.Score, 1)
+	return c
+}
+
+// normalizedName lowercases a name, drops punctuation and sorts its words, so "Doe, Jane" reads as "Jane Doe"
+func normalizedName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// nameSimilarity is 1 minus the edit distance of the normalized names over the longer one's length
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizedName(a)), []rune(normalizedName(b))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein counts the single-rune insertions, deletions and substitutions that turn a into b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// mailbox is the part of an email before the @, without dots or a +tag, so
+// "jane.doe+loans@example.com" and "janedoe@example.org" share one
+func mailbox(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+	local, _, _ = strings.Cut(local, "+")
+	return strings.ReplaceAll(local, ".", "")
+}
+
+// addressKey identifies a mailing address regardless of case and spacing, or is "" when there is none
+func addressKey(a Address) string {
+	if a.ID == 0 {
+		return ""
+	}
+	return strings.Join(strings.Fields(strings.ToLower(a.Line1+" "+a.Line2+" "+a.PostalCode+" "+a.Country)), " ")
+}
+
+// MergeUsers folds a duplicate User into the one that is kept: every Loan of fromID moves to intoID along
+// with their roles on other loans, addresses, promises, contact attempts and notifications, and fromID is soft-deleted with a redirect so GetUserByID(fromID) returns the kept User from then on.
+// The kept User's own details and profile are left as they are. It returns the kept User with their loans.
+func MergeUsers(db *sql.DB, fromID, intoID int64) (User, error) {
+	return MergeUsersContext(context.Background(), db, fromID, intoID)
+}
+
+// MergeUsersContext is MergeUsers, audited as the actor in ctx
+func MergeUsersContext(ctx context.Context, db *sql.DB, fromID, intoID int64) (User, error) {
+	err := inTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SELECT id FROM users WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, fromID, intoID); err != nil {
+			return fmt.Errorf("failed to lock users %d and %d: %w", fromID, intoID, err)
+		}
+
+		from, err := GetUserByID(tx, fromID)
+		if err != nil {
+			return err
+		}
+		if from.ID != fromID {
+			return fmt.Errorf("User %d was already merged into User %d", fromID, from.ID)
+		}
+		into, err := GetUserByID(tx, intoID)
+		if err != nil {
+			return err
+		}
+		if into.ID == from.ID {
+			return fmt.Errorf("cannot merge User %d into itself", fromID)
+		}
+		intoID = into.ID
+
+		reason := fmt.Sprintf("merged User %d into User %d", fromID, intoID)
+
+		loans, err := GetLoansByUserID(tx, fromID, IncludeDeleted)
+		if err != nil {
+			return err
+		}
+		for _, before := range loans {
+			if _, err := tx.Exec(`UPDATE loans SET user_id = $1 WHERE id = $2`, intoID, before.ID); err != nil {
+				return fmt.Errorf("failed to move Loan %d to User %d: %w", before.ID, intoID, err)
+			}
+			after, err := GetLoanByLoanID(tx, before.ID, IncludeDeleted)
+			if err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, tx, AuditUpdate, AuditLoan, before.ID, before, after, reason); err != nil {
+				return err
+			}
+		}
+
+		if err := mergeLoanParties(ctx, tx, fromID, intoID, reason); err != nil {
+			return err
+		}
+		if err := mergeUserRecords(ctx, tx, fromID, intoID, reason); err != nil {
+			return err
+		}
+
+		// users merged into this one earlier now lead straight to the kept User
+		if _, err := tx.Exec(`UPDATE users SET merged_into = $1 WHERE merged_into = $2`, intoID, fromID); err != nil {
+			return fmt.Errorf("failed to redirect users merged into User %d: %w", fromID, err)
+		}
+
+		actor := ActorFromContext(ctx)
+		after := from
+		err = tx.QueryRow(`
+		UPDATE users SET merged_into = $1, deleted_at = clock_timestamp(), deleted_by = $2
+		WHERE id = $3
+		RETURNING deleted_at
+		`, intoID, actor, fromID).Scan(&after.DeletedAt)
+		if err != nil {
+			return fmt.Errorf("failed to merge User %d: %w", fromID, err)
+		}
+		after.DeletedAt, after.DeletedBy, after.MergedInto = after.DeletedAt.UTC(), actor, intoID
+
+		return recordAudit(ctx, tx, AuditMerge, AuditUser, fromID, from, after, reason)
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return GetFullUserByID(db, intoID)
+}
+
+// mergeUserRecords hands the addresses, promises, contact attempts, notifications and in-app messages
+// of fromID to intoID, auditing every row it changes.
+// The kept User's current address stays current; the duplicate's joins their address history, closed now.
+// A notice both were logged for is kept once, preferring the one that was sent, so it is not sent again.
+func mergeUserRecords(ctx context.Context, tx *sql.Tx, fromID, intoID int64, reason string) error {
+	current, err := GetUserAddress(tx, intoID)
+	if err != nil {
+		return err
+	}
+	closeCurrent := ""
+	if current.ID != 0 {
+		closeCurrent = ", valid_to = COALESCE(valid_to, NOW())"
+	}
+	if err := moveUserRows(ctx, tx, "user_addresses", AuditAddress, closeCurrent, fromID, intoID, reason); err != nil {
+		return err
+	}
+
+	sameNotice := `d.loan_id = k.loan_id AND d.kind = k.kind AND d.payment_number = k.payment_number`
+	err = auditRows(ctx, tx, AuditDelete, AuditNotification, reason, `
+	DELETE FROM notifications k USING notifications d
+	WHERE k.user_id = $1 AND d.user_id = $2 AND `+sameNotice+` AND d.status = $3 AND k.status <> $3
+	RETURNING k.id, row_to_json(k), NULL::json
+	`, intoID, fromID, DeliverySent)
+	if err != nil {
+		return err
+	}
+	err = auditRows(ctx, tx, AuditDelete, AuditNotification, reason, `
+	DELETE FROM notifications d USING notifications k
+	WHERE k.user_id = $1 AND d.user_id = $2 AND `+sameNotice+`
+	RETURNING d.id, row_to_json(d), NULL::json
+	`, intoID, fromID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range []struct{ table, entity string }{
+		{"promises_to_pay", AuditPromise},
+		{"collection_contacts", AuditContactAttempt},
+		{"notifications", AuditNotification},
+		{"in_app_messages", AuditInAppMessage},
+	} {
+		if err := moveUserRows(ctx, tx, t.table, t.entity, "", fromID, intoID, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveUserRows points the rows of table that belong to fromID at intoID, auditing each as entity.
+// set adds SET clauses applied along with the move.
+func moveUserRows(ctx context.Context, tx *sql.Tx, table, entity, set string, fromID, intoID int64, reason string) error {
+	query := `
+	UPDATE ` + table + ` t SET user_id = $1` + set + `
+	FROM (SELECT id, row_to_json(r) AS before FROM ` + table + ` r WHERE user_id = $2) moved
+	WHERE t.id = moved.id
+	RETURNING t.id, moved.before, row_to_json(t)
+	`
+	if err := auditRows(ctx, tx, AuditUpdate, entity, reason, query, intoID, fromID); err != nil {
+		return fmt.Errorf("failed to move %s of User %d to User %d: %w", table, fromID, intoID, err)
+	}
+	return nil
+}
+
+// auditRows runs a statement returning the ID of every row it changes with the row before and after
+// as JSON (NULL when there is none), and audits each change as operation on entity
+func auditRows(ctx context.Context, tx *sql.Tx, operation, entity, reason, query string, args ...any) error {
+	type change struct {
+		id            int64
+		before, after []byte
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.id, &c.before, &c.after); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan changed %s row: %w", entity, err)
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating changed %s rows: %w", entity, err)
+	}
+
+	// the rows come back as JSON already, a missing side is audited as nothing
+	record := func(data []byte) any {
+		if data == nil {
+			return nil
+		}
+		return json.RawMessage(data)
+	}
+	for _, c := range changes {
+		if err := recordAudit(ctx, tx, operation, entity, c.id, record(c.before), record(c.after), reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
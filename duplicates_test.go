@@ -0,0 +1,144 @@
+package delinquencytracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestNameSimilarity verifies word order, case and punctuation do not count as differences.
+func TestNameSimilarity(t *testing.T) {
+	require.Equal(t, 1.0, nameSimilarity("Jane Doe", "DOE, Jane"))
+	require.InDelta(t, 0.875, nameSimilarity("Jane Doe", "Jane Dow"), 0.001)
+	require.Less(t, nameSimilarity("Jane Doe", "Bob Smith"), minNameSimilarity)
+	require.Equal(t, "janedoe", mailbox("jane.doe+loans@example.com"))
+}
+
+// TestFindDuplicateUsers verifies the same borrower under two emails is found and a stranger is not.
+func TestFindDuplicateUsers(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	dob := calendarDate(1985, time.March, 3)
+	jane, err := CreateUserWithProfile(db, "Jane Doe", "jane.doe@example.com", "555-0101", UserProfile{DateOfBirth: dob})
+	require.NoError(t, err)
+	twin, err := CreateUserWithProfile(db, "Doe, Jane", "janedoe@work.example", "(555) 0101", UserProfile{DateOfBirth: dob})
+	require.NoError(t, err)
+	_, err = CreateUser(db, "Bob Smith", "bob@example.com", "555-0202")
+	require.NoError(t, err)
+
+	// Act
+	candidates, err := FindDuplicateUsers(db, 0.5)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	require.Equal(t, jane.ID, candidates[0].UserID)
+	require.Equal(t, twin.ID, candidates[0].OtherID)
+	require.Equal(t, 1.0, candidates[0].Score)
+	require.Contains(t, candidates[0].Reasons, "same phone")
+	require.Contains(t, candidates[0].Reasons, "same date of birth")
+}
+
+// TestMergeUsers verifies loans move to the kept User and the duplicate's ID still resolves.
+func TestMergeUsers(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	kept, err := InitializeUserWithLoanNow(db, "Kept User", "kept@example.com", "555-0303", 1200, 5, 12, 1)
+	require.NoError(t, err)
+	dup, err := InitializeUserWithLoanNow(db, "Kept User", "kept.user@example.com", "555-0303", 600, 5, 6, 1)
+	require.NoError(t, err)
+	earlier, err := CreateUser(db, "Kept U", "kept.u@example.com", "555-0303")
+	require.NoError(t, err)
+	_, err = MergeUsers(db, earlier.ID, dup.ID)
+	require.NoError(t, err)
+
+	// Act
+	merged, err := MergeUsers(db, dup.ID, kept.ID)
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, kept.ID, merged.ID)
+	require.Len(t, merged.Loans, 2)
+
+	for _, id := range []int64{dup.ID, earlier.ID} {
+		resolved, err := GetUserByID(db, id)
+		require.NoError(t, err)
+		require.Equal(t, kept.ID, resolved.ID, "User %d redirects to the kept User", id)
+
+		raw, err := GetUserByID(db, id, IncludeDeleted)
+		require.NoError(t, err)
+		require.Equal(t, kept.ID, raw.MergedInto)
+		require.False(t, raw.DeletedAt.IsZero())
+	}
+
+	_, err = MergeUsers(db, dup.ID, kept.ID)
+	require.Error(t, err, "a User can be merged only once")
+	require.Error(t, RestoreUser(db, dup.ID), "a merged User cannot be restored")
+
+	entries, err := GetAuditLog(db, AuditFilter{EntityType: AuditUser, EntityID: dup.ID})
+	require.NoError(t, err)
+	require.Equal(t, AuditMerge, entries[0].Operation)
+}
+
+// TestMergeUsersMovesRecords verifies the duplicate's addresses and notices follow them to the kept User
+// and a notice both were sent is not sent again.
+func TestMergeUsersMovesRecords(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, the duplicate co-borrows the kept User's Loan and both were told it was missed
+	kept, err := InitializeUserWithLoan(db, "Kept User", "kept@example.com", "555-0404",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	dup, err := CreateUser(db, "Kept User", "kept.user@example.com", "555-0404")
+	require.NoError(t, err)
+	_, err = AddLoanParty(db, kept.Loans[0].ID, dup.ID, PartyCoBorrower)
+	require.NoError(t, err)
+
+	home := Address{Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+	_, err = SetUserAddress(db, kept.ID, home, calendarDate(2023, time.January, 1))
+	require.NoError(t, err)
+	other, err := SetUserAddress(db, dup.ID, Address{Line1: "9 Elm St", City: "Shelbyville", PostalCode: "54321", Country: "US"},
+		calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+
+	svc := NotificationService{DB: db, Notifiers: []Notifier{&recordingNotifier{channel: ChannelEmail}}}
+	run, err := svc.Run(context.Background(), calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 2)
+
+	// Act
+	_, err = MergeUsers(db, dup.ID, kept.ID)
+
+	// Assert
+	require.NoError(t, err)
+
+	current, err := GetUserAddress(db, kept.ID)
+	require.NoError(t, err)
+	require.Equal(t, home.Line1, current.Line1, "the kept User's address stays current")
+	history, err := GetUserAddressHistory(db, kept.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	log, err := GetNotificationsByUserID(db, kept.ID)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	log, err = GetNotificationsByUserID(db, dup.ID)
+	require.NoError(t, err)
+	require.Empty(t, log)
+
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Empty(t, run.Sent)
+
+	entries, err := GetAuditLog(db, AuditFilter{EntityType: AuditAddress, EntityID: other.ID})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, AuditUpdate, entries[0].Operation)
+}
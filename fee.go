@@ -0,0 +1,206 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Fee types
+const (
+	FeeLate = "late" // assessed when an installment is paid late
+	FeeNSF  = "nsf"  // assessed when a payment is returned unpaid
+)
+
+// Fee is a charge assessed on a Loan on top of its installments.
+type Fee struct {
+	ID           int64     // unique identifier for the fee
+	LoanID       int64     // which loan the fee was assessed on
+	Type         string    // what the fee is for ("late", "nsf")
+	Amount       float64   // how much was assessed
+	AmountPaid   float64   // how much of it has been paid
+	AmountWaived float64   // how much of it has been waived
+	AssessedDate time.Time // when the fee was assessed
+	CreatedAt    time.Time // when was this record created
+}
+
+// Outstanding returns how much of the fee is still owed.
+func (f Fee) Outstanding() float64 {
+	return f.Amount - f.AmountPaid - f.AmountWaived
+}
+
+// AssessFee charges a fee on a Loan.
+func AssessFee(db *sql.DB, loanID int64, feeType string, amount float64, assessedDate time.Time) (Fee, error) {
+	return AssessFeeContext(context.Background(), db, loanID, feeType, amount, assessedDate)
+}
+
+// AssessFeeContext is AssessFee, audited as the actor in ctx.
+func AssessFeeContext(ctx context.Context, db *sql.DB, loanID int64, feeType string, amount float64, assessedDate time.Time) (Fee, error) {
+	var f Fee
+	err := inTx(db, func(tx *sql.Tx) error {
+		var err error
+		f, err = assessFee(ctx, tx, loanID, feeType, amount, assessedDate)
+		return err
+	})
+	if err != nil {
+		return Fee{}, err
+	}
+	return f, nil
+}
+
+// assessFee charges a fee, posts it to the ledger and audits it inside the caller's transaction
+func assessFee(ctx context.Context, tx *sql.Tx, loanID int64, feeType string, amount float64, assessedDate time.Time) (Fee, error) {
+	if amount <= 0 {
+		return Fee{}, fmt.Errorf("fee amount must be positive, got %.2f", amount)
+	}
+	if feeType == "" {
+		return Fee{}, fmt.Errorf("fee type cannot be empty")
+	}
+
+	query := `
+	INSERT INTO loan_fees (loan_id, type, amount, assessed_date)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	f := Fee{LoanID: loanID, Type: feeType, Amount: amount, AssessedDate: assessedDate.UTC()}
+
+	err := tx.QueryRow(query, loanID, feeType, amount, assessedDate).Scan(&f.ID, &f.CreatedAt)
+	if err != nil {
+		return Fee{}, fmt.Errorf("failed to assess Fee: %w", err)
+	}
+	f.CreatedAt = f.CreatedAt.UTC()
+
+	err = postLedgerEntries(tx, LedgerEntry{LoanID: loanID, Type: EntryFee, DebitAccount: AccountFees,
+		CreditAccount: AccountFeeIncome, Amount: amount, FeeID: f.ID, EffectiveDate: assessedDate})
+	if err != nil {
+		return Fee{}, err
+	}
+
+	if err := recordAudit(ctx, tx, AuditAssessFee, AuditLoan, loanID, nil, f, ""); err != nil {
+		return Fee{}, err
+	}
+
+	return f, nil
+}
+
+// WaiveFee forgives up to amount of what is still owed on a fee.
+func WaiveFee(db *sql.DB, feeID int64, amount float64, waivedDate time.Time, reason string) (Fee, error) {
+	return WaiveFeeContext(context.Background(), db, feeID, amount, waivedDate, reason)
+}
+
+// WaiveFeeContext is WaiveFee, audited as the actor in ctx.
+func WaiveFeeContext(ctx context.Context, db *sql.DB, feeID int64, amount float64, waivedDate time.Time, reason string) (Fee, error) {
+	if amount <= 0 {
+		return Fee{}, fmt.Errorf("waived amount must be positive, got %.2f", amount)
+	}
+
+	var f Fee
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		var err error
+		f, err = scanFee(tx.QueryRow(`SELECT `+feeColumns+` FROM loan_fees WHERE id = $1 FOR UPDATE`, feeID))
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("Fee with ID %d not found", feeID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get Fee: %w", err)
+		}
+
+		amount = min(amount, f.Outstanding())
+		if amount <= paymentTolerance {
+			return fmt.Errorf("Fee %d has nothing left to waive", feeID)
+		}
+
+		if _, err := tx.Exec(`UPDATE loan_fees SET amount_waived = amount_waived + $1 WHERE id = $2`, amount, feeID); err != nil {
+			return fmt.Errorf("failed to waive Fee %d: %w", feeID, err)
+		}
+		before := f
+		f.AmountWaived += amount
+
+		err = postLedgerEntries(tx, LedgerEntry{LoanID: f.LoanID, Type: EntryWaiver, DebitAccount: AccountFeeIncome,
+			CreditAccount: AccountFees, Amount: amount, FeeID: feeID, EffectiveDate: waivedDate, Memo: reason})
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, AuditWaiveFee, AuditLoan, f.LoanID, before, f, reason)
+	})
+	if err != nil {
+		return Fee{}, err
+	}
+
+	return f, nil
+}
+
+// feeColumns is the column list every Fee query selects
+const feeColumns = `id, loan_id, type, amount, amount_paid, amount_waived, assessed_date, created_at`
+
+// scanFee reads a row selected with feeColumns
+func scanFee(row rowScanner) (Fee, error) {
+	var f Fee
+
+	err := row.Scan(
+		&f.ID,
+		&f.LoanID,
+		&f.Type,
+		&f.Amount,
+		&f.AmountPaid,
+		&f.AmountWaived,
+		&f.AssessedDate,
+		&f.CreatedAt,
+	)
+	if err != nil {
+		return Fee{}, err
+	}
+
+	f.AssessedDate = f.AssessedDate.UTC()
+	f.CreatedAt = f.CreatedAt.UTC()
+
+	return f, nil
+}
+
+// GetFeesByLoanID returns every fee assessed on a Loan, oldest first.
+func GetFeesByLoanID(db execer, loanID int64) ([]Fee, error) {
+	query := `
+	SELECT ` + feeColumns + `
+	FROM loan_fees
+	WHERE loan_id = $1
+	ORDER BY assessed_date, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fees for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var fees []Fee
+
+	for rows.Next() {
+		f, err := scanFee(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Fee row: %w", err)
+		}
+
+		fees = append(fees, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Fee rows: %w", err)
+	}
+
+	return fees, nil
+}
+
+// outstandingFees sums what is still owed on a Loan's fees
+func outstandingFees(fees []Fee) float64 {
+	var total float64
+	for _, f := range fees {
+		if owed := f.Outstanding(); owed > paymentTolerance {
+			total += owed
+		}
+	}
+	return total
+}
@@ -0,0 +1,229 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Every insert, update and delete of users, loans and payments is copied into row_history by a
+// trigger (see schema.sql), so any of those rows can be read back as it was recorded at a past moment.
+// Columns added after a snapshot was taken are filled from the row as it is now, unless historyDefaults
+// says what they were before they existed.
+// Soft-deleted rows are left out like deleted ones unless the read includes them.
+
+// historyDefaults holds, per table, the values of columns whose current value must not leak into older snapshots
+var historyDefaults = map[string]string{
+	"users":    `{"deleted_at": null, "deleted_by": "", "merged_into": null}`,
+	"loans":    `{"deleted_at": null, "deleted_by": "", "version": 1}`,
+	"payments": `{"version": 1}`,
+}
+
+// historyAsOf selects the latest snapshot of each row of a table recorded by ts, as rows of that table.
+// Rows whose latest snapshot is a delete are left out. filter narrows the row ids looked at.
+func historyAsOf(table, filter string) string {
+	defaults, ok := historyDefaults[table]
+	if !ok {
+		defaults = `{}`
+	}
+
+	return `
+	SELECT * FROM (
+		SELECT DISTINCT ON (h.row_id) h.operation AS history_operation, (jsonb_populate_record(cur, '` + defaults + `'::jsonb || h.row_data)).*
+		FROM row_history h
+		LEFT JOIN ` + table + ` cur ON cur.id = h.row_id
+		WHERE h.table_name = '` + table + `' AND h.recorded_at <= $1 AND h.row_id IN (` + filter + `)
+		ORDER BY h.row_id, h.recorded_at DESC, h.id DESC
+	) snapshot
+	WHERE history_operation <> 'DELETE'
+	`
+}
+
+// GetUserAsOf returns a User as it was recorded at ts, without loans.
+func GetUserAsOf(db *sql.DB, userID int64, ts time.Time, opts ...ReadOption) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM (` + historyAsOf("users", "$2") + `) users WHERE ` + notDeleted(opts)
+
+	usr, err := scanUser(db.QueryRow(query, ts, userID))
+	if err == sql.ErrNoRows {
+		return User{}, fmt.Errorf("User with ID %d did not exist at %s", userID, ts.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get User as of %s: %w", ts.UTC().Format(time.RFC3339), err)
+	}
+
+	return usr, nil
+}
+
+// GetLoanAsOf returns a Loan as it was recorded at ts, without payments.
+func GetLoanAsOf(db *sql.DB, loanID int64, ts time.Time, opts ...ReadOption) (Loan, error) {
+	query := `SELECT ` + loanColumns + ` FROM (` + historyAsOf("loans", "$2") + `) loans WHERE ` + notDeleted(opts)
+
+	ln, err := scanLoan(db.QueryRow(query, ts, loanID))
+	if err == sql.ErrNoRows {
+		return Loan{}, fmt.Errorf("Loan with ID %d did not exist at %s", loanID, ts.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return Loan{}, fmt.Errorf("failed to get Loan as of %s: %w", ts.UTC().Format(time.RFC3339), err)
+	}
+
+	return ln, nil
+}
+
+// getLoansAsOf returns the loans recorded at ts whose ids the filter selects, ordered by id
+func getLoansAsOf(db *sql.DB, ts time.Time, opts []ReadOption, filter string, args ...any) ([]Loan, error) {
+	query := `SELECT ` + loanColumns + ` FROM (` + historyAsOf("loans", filter) + `) loans WHERE ` + notDeleted(opts) + ` ORDER BY id`
+
+	rows, err := db.Query(query, append([]any{ts}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loans as of %s: %w", ts.UTC().Format(time.RFC3339), err)
+	}
+	defer rows.Close()
+
+	var loans []Loan
+
+	for rows.Next() {
+		ln, err := scanLoan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Loan row: %w", err)
+		}
+		loans = append(loans, ln)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan rows: %w", err)
+	}
+
+	return loans, nil
+}
+
+// GetPaymentsAsOf returns a Loan's payments as they were recorded at ts, ordered by payment number.
+func GetPaymentsAsOf(db *sql.DB, loanID int64, ts time.Time) ([]Payment, error) {
+	// any payment that ever belonged to the Loan, its snapshot at ts decides whether it still did
+	filter := `SELECT row_id FROM row_history WHERE table_name = 'payments' AND (row_data->>'loan_id')::bigint = $2`
+	query := `
+	SELECT id, loan_id, payment_number, amount_due, amount_paid, due_date, paid_date, created_at, version
+	FROM (` + historyAsOf("payments", filter) + `) payments
+	WHERE loan_id = $2
+	ORDER BY payment_number
+	`
+
+	rows, err := db.Query(query, ts, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query payments for Loan %d as of %s: %w", loanID, ts.UTC().Format(time.RFC3339), err)
+	}
+	defer rows.Close()
+
+	var payments []Payment
+
+	for rows.Next() {
+		var p Payment
+
+		err := rows.Scan(
+			&p.ID,
+			&p.LoanID,
+			&p.PaymentNumber,
+			&p.AmountDue,
+			&p.AmountPaid,
+			&p.DueDate,
+			&p.PaidDate,
+			&p.CreatedAt,
+			&p.Version,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Payment row: %w", err)
+		}
+
+		p.DueDate = p.DueDate.UTC()
+		p.PaidDate = p.PaidDate.UTC()
+		p.CreatedAt = p.CreatedAt.UTC()
+
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Payment rows: %w", err)
+	}
+
+	return payments, nil
+}
+
+// GetFullLoanAsOf returns a Loan with its payments and modifications exactly as they were recorded at ts.
+// Pass the result to EvaluateDelinquency with the same ts to get the delinquency known at that moment.
+func GetFullLoanAsOf(db *sql.DB, loanID int64, ts time.Time, opts ...ReadOption) (Loan, error) {
+	ln, err := GetLoanAsOf(db, loanID, ts, opts...)
+	if err != nil {
+		return Loan{}, err
+	}
+
+	return attachHistoryAsOf(db, ln, ts)
+}
+
+// attachHistoryAsOf loads the payments and modifications of a Loan recorded at ts
+func attachHistoryAsOf(db *sql.DB, ln Loan, ts time.Time) (Loan, error) {
+	payments, err := GetPaymentsAsOf(db, ln.ID, ts)
+	if err != nil {
+		return Loan{}, err
+	}
+
+	// modifications are never changed once recorded
+	mods, err := GetLoanModifications(db, ln.ID)
+	if err != nil {
+		return Loan{}, fmt.Errorf("failed to get modifications for Loan %d: %w", ln.ID, err)
+	}
+	var recorded []LoanModification
+	for _, m := range mods {
+		if !m.CreatedAt.After(ts) {
+			recorded = append(recorded, m)
+		}
+	}
+
+	ln.Payments = payments
+	ln.Modifications = recorded
+
+	return ln, nil
+}
+
+// GetFullUserAsOf returns a User with the loans, payments and modifications they had as recorded at ts.
+func GetFullUserAsOf(db *sql.DB, userID int64, ts time.Time, opts ...ReadOption) (User, error) {
+	usr, err := GetUserAsOf(db, userID, ts, opts...)
+	if err != nil {
+		return User{}, err
+	}
+
+	filter := `SELECT row_id FROM row_history WHERE table_name = 'loans' AND (row_data->>'user_id')::bigint = $2`
+	loans, err := getLoansAsOf(db, ts, opts, filter, userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, ln := range loans {
+		if ln.UserID != userID {
+			continue
+		}
+		full, err := attachHistoryAsOf(db, ln, ts)
+		if err != nil {
+			return User{}, err
+		}
+		usr.Loans = append(usr.Loans, full)
+	}
+
+	return usr, nil
+}
+
+// GetPortfolioAsOf returns every Loan with its payments and modifications as recorded at ts.
+func GetPortfolioAsOf(db *sql.DB, ts time.Time, opts ...ReadOption) ([]Loan, error) {
+	filter := `SELECT row_id FROM row_history WHERE table_name = 'loans'`
+	loans, err := getLoansAsOf(db, ts, opts, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ln := range loans {
+		loans[i], err = attachHistoryAsOf(db, ln, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return loans, nil
+}
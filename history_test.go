@@ -0,0 +1,70 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dbNow reads the database clock, which stamps the row history
+func dbNow(t *testing.T, db *sql.DB) time.Time {
+	var now time.Time
+	require.NoError(t, db.QueryRow(`SELECT clock_timestamp()`).Scan(&now))
+	return now
+}
+
+// TestGetFullUserAsOf verifies overwritten rows can be read back as they were.
+func TestGetFullUserAsOf(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Before Name", "asof@example.com", "555-1717",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	p := ln.Payments[0]
+	before := dbNow(t, db)
+
+	// Act, overwrite the User and the first Payment in place
+	require.NoError(t, UpdateUser(db, user.ID, "After Name", user.Email, user.Phone))
+	require.NoError(t, UpdatePayment(db, p.ID, p.Version, ln.ID, p.PaymentNumber, p.AmountDue, p.AmountDue, p.DueDate, calendarDate(2024, time.February, 1)))
+
+	// Assert
+	then, err := GetFullUserAsOf(db, user.ID, before)
+	require.NoError(t, err)
+	require.Equal(t, "Before Name", then.Name)
+	require.Len(t, then.Loans, 1)
+	require.Equal(t, 0.0, then.Loans[0].Payments[0].AmountPaid)
+
+	d, err := EvaluateDelinquency(then.Loans[0], calendarDate(2024, time.March, 31))
+	require.NoError(t, err)
+	require.Equal(t, 2, d.MissedPayments, "nothing was recorded as paid back then")
+
+	now, err := GetFullLoanAsOf(db, ln.ID, dbNow(t, db))
+	require.NoError(t, err)
+	require.Equal(t, p.AmountDue, now.Payments[0].AmountPaid)
+}
+
+// TestGetLoanAsOfDeleted verifies a deleted Loan can still be read as it was before the delete.
+func TestGetLoanAsOfDeleted(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	usr, err := CreateUser(db, "Deleted Loan User", "deleted@example.com", "555-1818")
+	require.NoError(t, err)
+	ln, err := CreateLoan(db, usr.ID, 5000, 0.05, 12, 1, LoanStatusActive, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+	before := dbNow(t, db)
+
+	require.NoError(t, DeleteLoan(db, ln.ID))
+
+	then, err := GetLoanAsOf(db, ln.ID, before)
+	require.NoError(t, err)
+	require.Equal(t, 5000.0, then.TotalAmount)
+
+	_, err = GetLoanAsOf(db, ln.ID, dbNow(t, db))
+	require.Error(t, err)
+}
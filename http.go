@@ -0,0 +1,350 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewHandler returns the HTTP API over db. Responses are JSON; errors are {"error": "..."}.
+// Changes are audited as the actor in the X-Actor header, with the reason in X-Reason.
+//
+//	GET   /users/search?q=...&limit=...&offset=...          search users, see SearchUsers
+//	GET   /users/{id}/profile                               a User's profile
+//	PATCH /users/{id}/profile              ProfilePatch     change some profile fields, see PatchUser
+//	GET   /users/{id}/addresses                             a User's address history, see GetUserAddressHistory
+//	POST  /users/{id}/addresses            Address          set a User's mailing address, see SetUserAddress
+//	GET   /collections/queue?queue=...&assigned_to=...      open collection cases, see GetWorkQueue
+//	GET   /collections/cases/{id}                           a collection case and its contact attempts
+//	POST  /collections/cases/{id}/assign   {"Collector"}    assign a case, see AssignCase
+//	POST  /collections/cases/{id}/contacts ContactAttempt   log a contact attempt, see LogContactAttempt
+//	POST  /collections/promises            PromiseToPay     record a promise to pay, see RecordPromise
+//	GET   /collections/promises/report?from=...&to=...      promise kept rates per collector, see PromiseKeptRates
+func NewHandler(db *sql.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/search", func(w http.ResponseWriter, r *http.Request) {
+		handleSearchUsers(db, w, r)
+	})
+	mux.HandleFunc("GET /users/{id}/profile", func(w http.ResponseWriter, r *http.Request) {
+		handleGetProfile(db, w, r)
+	})
+	mux.HandleFunc("PATCH /users/{id}/profile", func(w http.ResponseWriter, r *http.Request) {
+		handlePatchProfile(db, w, r)
+	})
+	mux.HandleFunc("GET /users/{id}/addresses", func(w http.ResponseWriter, r *http.Request) {
+		handleGetAddresses(db, w, r)
+	})
+	mux.HandleFunc("POST /users/{id}/addresses", func(w http.ResponseWriter, r *http.Request) {
+		handleSetAddress(db, w, r)
+	})
+	mux.HandleFunc("GET /collections/queue", func(w http.ResponseWriter, r *http.Request) {
+		handleWorkQueue(db, w, r)
+	})
+	mux.HandleFunc("GET /collections/cases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetCase(db, w, r)
+	})
+	mux.HandleFunc("POST /collections/cases/{id}/assign", func(w http.ResponseWriter, r *http.Request) {
+		handleAssignCase(db, w, r)
+	})
+	mux.HandleFunc("POST /collections/cases/{id}/contacts", func(w http.ResponseWriter, r *http.Request) {
+		handleLogContact(db, w, r)
+	})
+	mux.HandleFunc("POST /collections/promises", func(w http.ResponseWriter, r *http.Request) {
+		handleRecordPromise(db, w, r)
+	})
+	mux.HandleFunc("GET /collections/promises/report", func(w http.ResponseWriter, r *http.Request) {
+		handlePromiseReport(db, w, r)
+	})
+	return mux
+}
+
+// requestContext carries the actor and reason of a request into the audit log
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if actor := r.Header.Get("X-Actor"); actor != "" {
+		ctx = WithActor(ctx, actor)
+	}
+	if reason := r.Header.Get("X-Reason"); reason != "" {
+		ctx = WithReason(ctx, reason)
+	}
+	return ctx
+}
+
+func handleSearchUsers(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s := UserSearch{Query: strings.TrimSpace(q.Get("q"))}
+
+	var err error
+	if s.Limit, err = intParam(q.Get("limit")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit: %w", err))
+		return
+	}
+	if s.Offset, err = intParam(q.Get("offset")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("offset: %w", err))
+		return
+	}
+	if s.Query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("q is required"))
+		return
+	}
+	if s.Limit < 0 || s.Limit > MaxSearchLimit || s.Offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d and offset cannot be negative", MaxSearchLimit))
+		return
+	}
+
+	page, err := SearchUsers(db, s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// ProfilePatch is the body of PATCH /users/{id}/profile. Absent fields are left as they are.
+type ProfilePatch struct {
+	DateOfBirth *time.Time
+	TimeZone    *string
+	Language    *string
+	Contact     *ContactPreferences
+}
+
+func handleGetProfile(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	usr, err := GetUserByID(db, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usr.Profile)
+}
+
+func handlePatchProfile(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	var body ProfilePatch
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	patch := UserPatch{DateOfBirth: body.DateOfBirth, TimeZone: body.TimeZone, Language: body.Language, Contact: body.Contact}
+	usr, err := PatchUserContext(requestContext(r), db, id, patch)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, usr.Profile)
+}
+
+func handleGetAddresses(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	// a merged User's ID leads to the kept User's addresses
+	usr, err := GetUserByID(db, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	addresses, err := GetUserAddressHistory(db, usr.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, addresses)
+}
+
+func handleSetAddress(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	var addr Address
+	if !readJSON(w, r, &addr) {
+		return
+	}
+	validFrom := addr.ValidFrom
+	if validFrom.IsZero() {
+		validFrom = time.Now().UTC()
+	}
+
+	addr, err := SetUserAddressContext(requestContext(r), db, id, addr, validFrom)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, addr)
+}
+
+func handleWorkQueue(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := WorkQueueFilter{Queue: q.Get("queue"), AssignedTo: q.Get("assigned_to"), Unassigned: q.Get("unassigned") == "true"}
+
+	var err error
+	if f.Limit, err = intParam(q.Get("limit")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("limit: %w", err))
+		return
+	}
+	if f.Offset, err = intParam(q.Get("offset")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("offset: %w", err))
+		return
+	}
+
+	cases, err := GetWorkQueue(db, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cases)
+}
+
+func handleGetCase(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := GetCollectionCase(db, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func handleAssignCase(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	var body struct{ Collector string }
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	c, err := AssignCaseContext(requestContext(r), db, id, body.Collector)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func handleLogContact(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r)
+	if !ok {
+		return
+	}
+	var attempt ContactAttempt
+	if !readJSON(w, r, &attempt) {
+		return
+	}
+	attempt.CaseID = id
+
+	attempt, err := LogContactAttemptContext(requestContext(r), db, attempt)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, attempt)
+}
+
+func handleRecordPromise(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var promise PromiseToPay
+	if !readJSON(w, r, &promise) {
+		return
+	}
+
+	promise, err := RecordPromiseContext(requestContext(r), db, promise)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, promise)
+}
+
+func handlePromiseReport(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := time.Parse("2006-01-02", q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("from must be a YYYY-MM-DD day"))
+		return
+	}
+	to, err := time.Parse("2006-01-02", q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("to must be a YYYY-MM-DD day"))
+		return
+	}
+
+	rates, err := PromiseKeptRates(db, from, to)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+// idParam reads the {id} path parameter, answering 400 when it is not a number
+func idParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("id %q is not a number", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// readJSON decodes the request body into v, answering 400 when it cannot
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// statusFor is the status of a failed change: 409 when it conflicts with another change, 400 otherwise
+func statusFor(err error) int {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) || errors.Is(err, ErrIdempotencyConflict) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// intParam reads an optional integer query parameter, 0 when it is absent
+func intParam(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
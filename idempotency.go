@@ -0,0 +1,127 @@
+package delinquencytracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Operations that accept an idempotency key
+const (
+	IdempotentOrigination = "originate"
+	IdempotentAddLoan     = "add_loan"
+	IdempotentPostPayment = "post_payment"
+)
+
+// ErrIdempotencyConflict is returned when an idempotency key is replayed with a different request
+var ErrIdempotencyConflict = errors.New("idempotency key was already used for a different request")
+
+// WithIdempotencyKey returns a context whose origination or payment posting happens at most once per key.
+// A replay with the same key and the same request returns the result of the first call.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey, key)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key of a context, or "".
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey).(string)
+	return key
+}
+
+// requestHash fingerprints an operation and its parameters so replays can be told from reuse of a key
+func requestHash(operation string, request any) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s request: %w", operation, err)
+	}
+
+	sum := sha256.Sum256(append([]byte(operation+"\x00"), body...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotent runs op in a transaction unless the idempotency key in ctx was already used, in which case it
+// returns the stored result. op runs in the transaction that claims the key, so what it writes and the stored
+// result commit together, and a concurrent replay waits for the first call and then gets its result.
+// Without a key in ctx op simply runs in a transaction of its own.
+func idempotent[T any](ctx context.Context, db *sql.DB, operation string, request any, op func(tx *sql.Tx) (T, error)) (T, error) {
+	var result T
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		key := IdempotencyKeyFromContext(ctx)
+		if key == "" {
+			var err error
+			result, err = op(tx)
+			return err
+		}
+
+		hash, err := requestHash(operation, request)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`
+		INSERT INTO idempotency_keys (key, operation, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING
+		`, key, operation, hash)
+		if err != nil {
+			return fmt.Errorf("failed to claim idempotency key %q: %w", key, err)
+		}
+		claimed, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to claim idempotency key %q: %w", key, err)
+		}
+
+		if claimed == 0 {
+			result, err = replay[T](tx, key, operation, hash)
+			return err
+		}
+
+		if result, err = op(tx); err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s result: %w", operation, err)
+		}
+		if _, err := tx.Exec(`UPDATE idempotency_keys SET result = $1 WHERE key = $2`, body, key); err != nil {
+			return fmt.Errorf("failed to store result for idempotency key %q: %w", key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return result, nil
+}
+
+// replay returns the result stored for a key, refusing it when the key was used for another request
+func replay[T any](tx *sql.Tx, key, operation, hash string) (T, error) {
+	var zero T
+	var storedOperation, storedHash string
+	var body []byte
+
+	err := tx.QueryRow(`SELECT operation, request_hash, result FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&storedOperation, &storedHash, &body)
+	if err != nil {
+		return zero, fmt.Errorf("failed to read idempotency key %q: %w", key, err)
+	}
+
+	if storedOperation != operation || storedHash != hash {
+		return zero, fmt.Errorf("key %q: %w", key, ErrIdempotencyConflict)
+	}
+
+	var result T
+	if err := json.Unmarshal(body, &result); err != nil {
+		return zero, fmt.Errorf("failed to decode result for idempotency key %q: %w", key, err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package delinquencytracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRequestHash verifies a request hashes the same every time and differently from any other.
+func TestRequestHash(t *testing.T) {
+	day := calendarDate(2024, time.February, 1)
+
+	a, err := requestHash(IdempotentPostPayment, []any{int64(1), 100.0, day})
+	require.NoError(t, err)
+	b, err := requestHash(IdempotentPostPayment, []any{int64(1), 100.0, day})
+	require.NoError(t, err)
+	require.Equal(t, a, b)
+
+	c, err := requestHash(IdempotentPostPayment, []any{int64(1), 100.01, day})
+	require.NoError(t, err)
+	require.NotEqual(t, a, c)
+
+	d, err := requestHash(IdempotentAddLoan, []any{int64(1), 100.0, day})
+	require.NoError(t, err)
+	require.NotEqual(t, a, d)
+}
+
+// TestInitializeUserWithLoanIdempotent verifies a double-submitted origination creates one User and Loan.
+func TestInitializeUserWithLoanIdempotent(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	ctx := WithIdempotencyKey(context.Background(), "origination-1")
+	originate := func(amount float64) (User, error) {
+		return InitializeUserWithLoanContext(ctx, db, "Twice User", "twice@example.com", "555-2424",
+			amount, 0.05, 12, 1, calendarDate(2024, time.January, 1), false, LoanOptions{})
+	}
+
+	// Act
+	first, err := originate(1200)
+	require.NoError(t, err)
+	replayed, err := originate(1200)
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, first, replayed)
+	count, err := CountUsers(db)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// the same key cannot be reused for a different request
+	_, err = originate(5000)
+	require.ErrorIs(t, err, ErrIdempotencyConflict)
+}
+
+// TestInitializeUserWithLoanAtomic verifies a failed origination leaves nothing behind and frees its key.
+func TestInitializeUserWithLoanAtomic(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a guarantor that does not exist fails the origination after the User is written
+	ctx := WithIdempotencyKey(context.Background(), "origination-2")
+	opts := LoanOptions{Parties: []LoanParty{{UserID: 999999, Role: PartyGuarantor}}}
+
+	// Act
+	_, err := InitializeUserWithLoanContext(ctx, db, "Half User", "half@example.com", "555-2626",
+		1200, 0.05, 12, 1, calendarDate(2024, time.January, 1), false, opts)
+
+	// Assert
+	require.Error(t, err)
+	count, err := CountUsers(db)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+
+	// the key was not spent on the failed attempt
+	_, err = InitializeUserWithLoanContext(ctx, db, "Half User", "half@example.com", "555-2626",
+		1200, 0.05, 12, 1, calendarDate(2024, time.January, 1), false, LoanOptions{})
+	require.NoError(t, err)
+}
+
+// TestPostPaymentIdempotent verifies a retried payment webhook posts the money once.
+func TestPostPaymentIdempotent(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Retry User", "retry@example.com", "555-2525",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	ctx := WithIdempotencyKey(context.Background(), "webhook-42")
+
+	// Act, the processor delivers the same webhook three times at once
+	results := make(chan PostedPayment, 3)
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			pp, err := PostPaymentContext(ctx, db, ln.ID, 400, calendarDate(2024, time.February, 1))
+			errs <- err
+			results <- pp
+		}()
+	}
+
+	// Assert
+	var ids []int64
+	for range 3 {
+		require.NoError(t, <-errs)
+		ids = append(ids, (<-results).ID)
+	}
+	require.Equal(t, ids[0], ids[1])
+	require.Equal(t, ids[0], ids[2])
+
+	posted, err := GetPostedPaymentsByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, posted, 1)
+
+	_, err = PostPaymentContext(ctx, db, ln.ID, 500, calendarDate(2024, time.February, 1))
+	require.ErrorIs(t, err, ErrIdempotencyConflict)
+}
@@ -0,0 +1,666 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Ledger entry types
+const (
+	EntryDisbursement   = "disbursement"    // principal lent to the borrower
+	EntryDue            = "due"             // an installment scheduled to fall due
+	EntryScheduleChange = "schedule_change" // an installment's amount or due date changed
+	EntryCancel         = "cancel"          // an installment removed from the schedule
+	EntryPayment        = "payment"         // money received and applied
+	EntryFee            = "fee"             // a fee assessed
+	EntryWaiver         = "waiver"          // a fee waived
+	EntryReversal       = "reversal"        // a returned payment taken back off the Loan
+	EntryWriteOff       = "write_off"       // what was owed when the Loan was charged off
+	EntryRecovery       = "recovery"        // money received after charge-off
+	EntrySettlement     = "settlement"      // what was forgiven when the Loan was settled
+	EntryAdjustment     = "adjustment"      // a principal lent or paid amount corrected by hand
+)
+
+// Ledger accounts. Receivable accounts hold debit balances, the others credit balances.
+const (
+	AccountCash               = "cash"                // money lent out and received
+	AccountPrincipal          = "principal"           // receivable: principal not yet due
+	AccountDue                = "due"                 // receivable: installments fallen due and not paid
+	AccountFees               = "fees"                // receivable: fees assessed and not paid
+	AccountUnapplied          = "unapplied"           // money received that nothing was owed for
+	AccountInterestIncome     = "interest_income"     // interest earned as installments fall due
+	AccountFeeIncome          = "fee_income"          // fees earned, less waivers
+	AccountChargeOffLoss      = "charge_off_loss"     // amounts written off
+	AccountRecoveryIncome     = "recovery_income"     // money recovered after charge-off
+	AccountSettlementForgiven = "settlement_forgiven" // amounts forgiven by settlements
+)
+
+// amounts below this are not worth a ledger entry
+const ledgerEpsilon = 0.000001
+
+// LedgerEntry is one double-entry line of a Loan's ledger: Amount moves from the credit account to the debit account.
+// Entries are never changed or deleted, corrections are new entries.
+type LedgerEntry struct {
+	ID              int64     // unique identifier for the entry
+	LoanID          int64     // which loan the entry belongs to
+	Type            string    // what happened, e.g. "payment"
+	DebitAccount    string    // account the amount is debited to
+	CreditAccount   string    // account the amount is credited to
+	Amount          float64   // always positive
+	PaymentNumber   int64     // installment the entry concerns (0 for none)
+	FeeID           int64     // fee the entry concerns (0 for none)
+	PostedPaymentID int64     // posted payment the entry came from (0 for none)
+	EffectiveDate   time.Time // when the event took effect
+	PostedDate      time.Time // when the entry was recorded
+	Memo            string    // free text
+}
+
+// LoanBalances is what a Loan's ledger shows on a given day.
+type LoanBalances struct {
+	AsOf      time.Time          // the day the balances are for
+	Principal float64            // principal not yet due
+	Due       float64            // installments fallen due and not paid
+	Fees      float64            // fees assessed and not paid
+	Unapplied float64            // money received that nothing was owed for
+	Accounts  map[string]float64 // every account's balance, debits positive
+}
+
+// Total returns everything the borrower owes.
+func (b LoanBalances) Total() float64 {
+	return b.Principal + b.Due + b.Fees
+}
+
+// LedgerDiscrepancy is a difference between the payments and fees tables and the ledger.
+type LedgerDiscrepancy struct {
+	PaymentNumber int64  // installment that differs (0 for fees)
+	Field         string // what differs, e.g. "amount_paid"
+	Recorded      string // value in the payments or fees table
+	Ledger        string // value derived from the ledger
+}
+
+// postLedgerEntries appends entries to the ledger.
+// A negative amount is posted with the accounts swapped and zero amounts are skipped.
+func postLedgerEntries(db execer, entries ...LedgerEntry) error {
+	query := `
+	INSERT INTO ledger_entries (loan_id, type, debit_account, credit_account, amount,
+		payment_number, fee_id, posted_payment_id, effective_date, memo)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	for _, e := range entries {
+		if e.Amount < 0 {
+			e.DebitAccount, e.CreditAccount, e.Amount = e.CreditAccount, e.DebitAccount, -e.Amount
+		}
+		if e.Amount < ledgerEpsilon {
+			continue
+		}
+
+		var feeID, postedPaymentID sql.NullInt64
+		if e.FeeID != 0 {
+			feeID = sql.NullInt64{Int64: e.FeeID, Valid: true}
+		}
+		if e.PostedPaymentID != 0 {
+			postedPaymentID = sql.NullInt64{Int64: e.PostedPaymentID, Valid: true}
+		}
+
+		_, err := db.Exec(query, e.LoanID, e.Type, e.DebitAccount, e.CreditAccount, e.Amount,
+			e.PaymentNumber, feeID, postedPaymentID, e.EffectiveDate, e.Memo)
+		if err != nil {
+			return fmt.Errorf("failed to post %s entry for Loan %d: %w", e.Type, e.LoanID, err)
+		}
+	}
+
+	return nil
+}
+
+// GetLedgerEntries returns a Loan's ledger in the order it was posted.
+func GetLedgerEntries(db execer, loanID int64) ([]LedgerEntry, error) {
+	query := `
+	SELECT id, loan_id, type, debit_account, credit_account, amount, payment_number,
+		COALESCE(fee_id, 0), COALESCE(posted_payment_id, 0), effective_date, posted_date, memo
+	FROM ledger_entries
+	WHERE loan_id = $1
+	ORDER BY id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+
+	for rows.Next() {
+		var e LedgerEntry
+
+		err := rows.Scan(
+			&e.ID,
+			&e.LoanID,
+			&e.Type,
+			&e.DebitAccount,
+			&e.CreditAccount,
+			&e.Amount,
+			&e.PaymentNumber,
+			&e.FeeID,
+			&e.PostedPaymentID,
+			&e.EffectiveDate,
+			&e.PostedDate,
+			&e.Memo,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ledger row: %w", err)
+		}
+
+		e.EffectiveDate = e.EffectiveDate.UTC()
+		e.PostedDate = e.PostedDate.UTC()
+
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ledger rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// BalancesFromLedger sums the entries in effect on a day into account balances.
+func BalancesFromLedger(entries []LedgerEntry, asOf time.Time) LoanBalances {
+	day := startOfDay(asOf)
+	b := LoanBalances{AsOf: day, Accounts: map[string]float64{}}
+
+	for _, e := range entries {
+		if startOfDay(e.EffectiveDate).After(day) {
+			continue
+		}
+		b.Accounts[e.DebitAccount] += e.Amount
+		b.Accounts[e.CreditAccount] -= e.Amount
+	}
+
+	b.Principal = b.Accounts[AccountPrincipal]
+	b.Due = b.Accounts[AccountDue]
+	b.Fees = b.Accounts[AccountFees]
+	b.Unapplied = -b.Accounts[AccountUnapplied]
+
+	return b
+}
+
+// GetLoanBalances returns a Loan's balances on a day according to its ledger.
+func GetLoanBalances(db execer, loanID int64, asOf time.Time) (LoanBalances, error) {
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return LoanBalances{}, err
+	}
+	return BalancesFromLedger(entries, asOf), nil
+}
+
+// isScheduleEntry reports whether an entry sets what an installment owes
+func isScheduleEntry(e LedgerEntry) bool {
+	return e.Type == EntryDue || e.Type == EntryScheduleChange || e.Type == EntryCancel
+}
+
+// signed returns the entry amount as seen from an account: positive for debits, negative for credits
+func (e LedgerEntry) signed(account string) float64 {
+	switch account {
+	case e.DebitAccount:
+		return e.Amount
+	case e.CreditAccount:
+		return -e.Amount
+	}
+	return 0
+}
+
+// scheduledInstallment is what the ledger says one installment owes
+type scheduledInstallment struct {
+	amountDue float64   // net debited to the due account
+	principal float64   // net credited to the principal account
+	dueDate   time.Time // effective date of the latest schedule entry
+	cancelled bool      // whether the latest schedule entry removed it
+}
+
+// ledgerInstallments collects the schedule entries of each installment
+func ledgerInstallments(entries []LedgerEntry) map[int64]*scheduledInstallment {
+	installments := map[int64]*scheduledInstallment{}
+
+	for _, e := range entries {
+		if !isScheduleEntry(e) || e.PaymentNumber == 0 {
+			continue
+		}
+		inst, ok := installments[e.PaymentNumber]
+		if !ok {
+			inst = &scheduledInstallment{}
+			installments[e.PaymentNumber] = inst
+		}
+		inst.amountDue += e.signed(AccountDue)
+		inst.principal -= e.signed(AccountPrincipal)
+		inst.dueDate = e.EffectiveDate
+		inst.cancelled = e.Type == EntryCancel
+	}
+
+	return installments
+}
+
+// ScheduleFromLedger derives a Loan's installments from its ledger.
+// Installments removed from the schedule, e.g. by a charge-off, are left out.
+// A paid date is the latest payment applied to the installment that was not reversed.
+func ScheduleFromLedger(entries []LedgerEntry) []Payment {
+	installments := ledgerInstallments(entries)
+
+	reversed := map[int64]bool{}
+	for _, e := range entries {
+		if e.Type == EntryReversal && e.PostedPaymentID != 0 {
+			reversed[e.PostedPaymentID] = true
+		}
+	}
+
+	paid := map[int64]float64{}
+	paidDate := map[int64]time.Time{}
+	for _, e := range entries {
+		if e.PaymentNumber == 0 || (e.Type != EntryPayment && e.Type != EntryReversal && e.Type != EntryAdjustment) {
+			continue
+		}
+		paid[e.PaymentNumber] -= e.signed(AccountDue)
+		if e.Type != EntryReversal && e.signed(AccountDue) < 0 && !reversed[e.PostedPaymentID] && e.EffectiveDate.After(paidDate[e.PaymentNumber]) {
+			paidDate[e.PaymentNumber] = e.EffectiveDate
+		}
+	}
+
+	var payments []Payment
+	for n, inst := range installments {
+		if inst.cancelled {
+			continue
+		}
+		p := Payment{
+			PaymentNumber: n,
+			AmountDue:     inst.amountDue,
+			AmountPaid:    paid[n],
+			DueDate:       inst.dueDate,
+		}
+		if p.AmountPaid > 0 {
+			p.PaidDate = paidDate[n]
+		}
+		payments = append(payments, p)
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].PaymentNumber < payments[j].PaymentNumber })
+
+	return payments
+}
+
+// LoanFromLedger loads a Loan with its schedule and payments derived from the ledger
+// instead of the payments table, ready for EvaluateDelinquency.
+// A Loan created before the ledger existed and not backfilled yet keeps its payments table schedule.
+func LoanFromLedger(db *sql.DB, loanID int64) (Loan, error) {
+	ln, err := GetFullLoanByID(db, loanID)
+	if err != nil {
+		return Loan{}, err
+	}
+	return withLedgerSchedule(db, ln)
+}
+
+// withLedgerSchedule replaces the payments table schedule of a loaded Loan with the one derived from its ledger
+func withLedgerSchedule(db execer, ln Loan) (Loan, error) {
+	loanID := ln.ID
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return Loan{}, err
+	}
+	if len(entries) == 0 {
+		return ln, nil
+	}
+
+	// the rows the installments are stored in keep their identity
+	rows := map[int64]Payment{}
+	for _, p := range ln.Payments {
+		rows[p.PaymentNumber] = p
+	}
+
+	ln.Payments = ScheduleFromLedger(entries)
+	for i, p := range ln.Payments {
+		row := rows[p.PaymentNumber]
+		ln.Payments[i].ID, ln.Payments[i].CreatedAt, ln.Payments[i].Version = row.ID, row.CreatedAt, row.Version
+		ln.Payments[i].LoanID = loanID
+	}
+
+	return ln, nil
+}
+
+// installmentSplit is the target principal and interest of one installment
+type installmentSplit struct {
+	payment   Payment
+	principal float64
+}
+
+// splitInstallments splits each installment into the principal it repays and the interest it charges.
+// The last installment repays whatever principal is left so the principal account closes at zero.
+func splitInstallments(principal float64, payments []Payment, history []RateChange) []installmentSplit {
+	splits := make([]installmentSplit, len(payments))
+	balance := principal
+
+	for i, p := range payments {
+		rate := rateForPayment(history, p.PaymentNumber)
+		repaid := p.AmountDue - balance*rate/12
+		if i == len(payments)-1 {
+			repaid = balance
+		}
+		balance -= repaid
+		splits[i] = installmentSplit{payment: p, principal: repaid}
+	}
+
+	return splits
+}
+
+// scheduleEntries returns the entries that move an installment from nothing owed to amountDue on dueDate
+func scheduleEntries(loanID int64, entryType string, n int64, amountDue, principal float64, dueDate time.Time) []LedgerEntry {
+	return []LedgerEntry{
+		{LoanID: loanID, Type: entryType, DebitAccount: AccountDue, CreditAccount: AccountPrincipal,
+			Amount: principal, PaymentNumber: n, EffectiveDate: dueDate},
+		{LoanID: loanID, Type: entryType, DebitAccount: AccountDue, CreditAccount: AccountInterestIncome,
+			Amount: amountDue - principal, PaymentNumber: n, EffectiveDate: dueDate},
+	}
+}
+
+// syncLedgerSchedule brings the ledger's installments in line with the payments table.
+// New installments are posted as due, changed ones are reversed at their old due date and
+// posted again, and installments no longer in the table are cancelled.
+func syncLedgerSchedule(db execer, loanID int64) error {
+	ln, err := GetLoanByLoanID(db, loanID)
+	if err != nil {
+		return err
+	}
+	payments, err := GetPaymentsByLoanID(db, loanID)
+	if err != nil {
+		return err
+	}
+	history, err := GetRateHistory(db, loanID)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		history = []RateChange{{LoanID: loanID, FromPayment: 1, Rate: ln.InterestRate}}
+	}
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return err
+	}
+
+	posted := ledgerInstallments(entries)
+	var changes []LedgerEntry
+
+	for _, s := range splitInstallments(ln.TotalAmount, payments, history) {
+		p := s.payment
+		inst, ok := posted[p.PaymentNumber]
+		if !ok || inst.cancelled {
+			changes = append(changes, scheduleEntries(loanID, EntryDue, p.PaymentNumber, p.AmountDue, s.principal, p.DueDate)...)
+			continue
+		}
+		delete(posted, p.PaymentNumber)
+
+		if math.Abs(inst.amountDue-p.AmountDue) < paymentTolerance &&
+			math.Abs(inst.principal-s.principal) < paymentTolerance &&
+			inst.dueDate.Equal(p.DueDate) {
+			continue
+		}
+
+		// take the old installment back at its old due date, then post it as it stands now
+		changes = append(changes, scheduleEntries(loanID, EntryScheduleChange, p.PaymentNumber, -inst.amountDue, -inst.principal, inst.dueDate)...)
+		changes = append(changes, scheduleEntries(loanID, EntryScheduleChange, p.PaymentNumber, p.AmountDue, s.principal, p.DueDate)...)
+	}
+
+	for n, inst := range posted {
+		if !inst.cancelled {
+			changes = append(changes, scheduleEntries(loanID, EntryCancel, n, -inst.amountDue, -inst.principal, inst.dueDate)...)
+		}
+	}
+
+	return postLedgerEntries(db, changes...)
+}
+
+// syncLedger brings a Loan's ledger in line with its row and its payments table after they were changed
+// directly: the principal lent, the schedule, and what was paid on each installment. Differences in the
+// principal lent and the amounts paid are posted as adjustments. The ledger of a charged-off or settled Loan is closed.
+func syncLedger(db execer, loanID int64) error {
+	ln, err := GetLoanByLoanID(db, loanID)
+	if err != nil {
+		return err
+	}
+	if ln.closedOut() {
+		return fmt.Errorf("Loan %d is %s and its ledger is closed", loanID, ln.Status)
+	}
+
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return err
+	}
+
+	var lent float64
+	for _, e := range entries {
+		if e.Type == EntryDisbursement || (e.Type == EntryAdjustment && e.PaymentNumber == 0) {
+			lent += e.signed(AccountPrincipal)
+		}
+	}
+	err = postLedgerEntries(db, LedgerEntry{LoanID: loanID, Type: EntryAdjustment, DebitAccount: AccountPrincipal,
+		CreditAccount: AccountCash, Amount: ln.TotalAmount - lent, EffectiveDate: ln.DateTaken})
+	if err != nil {
+		return err
+	}
+
+	if err := syncLedgerSchedule(db, loanID); err != nil {
+		return err
+	}
+
+	payments, err := GetPaymentsByLoanID(db, loanID)
+	if err != nil {
+		return err
+	}
+	posted := map[int64]float64{}
+	for _, p := range ScheduleFromLedger(entries) {
+		posted[p.PaymentNumber] = p.AmountPaid
+	}
+
+	today := startOfDay(time.Now())
+	var changes []LedgerEntry
+	for _, p := range payments {
+		day := p.PaidDate
+		if day.IsZero() {
+			day = today
+		}
+		changes = append(changes, LedgerEntry{LoanID: loanID, Type: EntryAdjustment, DebitAccount: AccountCash,
+			CreditAccount: AccountDue, Amount: p.AmountPaid - posted[p.PaymentNumber], PaymentNumber: p.PaymentNumber, EffectiveDate: day})
+		delete(posted, p.PaymentNumber)
+	}
+	for n, paid := range posted {
+		changes = append(changes, LedgerEntry{LoanID: loanID, Type: EntryAdjustment, DebitAccount: AccountCash,
+			CreditAccount: AccountDue, Amount: -paid, PaymentNumber: n, EffectiveDate: today})
+	}
+
+	return postLedgerEntries(db, changes...)
+}
+
+// cancelInstallmentsAfter removes the installments due after a day from the ledger
+func cancelInstallmentsAfter(db execer, loanID int64, day time.Time, memo string) error {
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return err
+	}
+
+	var changes []LedgerEntry
+	for n, inst := range ledgerInstallments(entries) {
+		if inst.cancelled || !startOfDay(inst.dueDate).After(startOfDay(day)) {
+			continue
+		}
+		for _, e := range scheduleEntries(loanID, EntryCancel, n, -inst.amountDue, -inst.principal, inst.dueDate) {
+			e.Memo = memo
+			changes = append(changes, e)
+		}
+	}
+
+	return postLedgerEntries(db, changes...)
+}
+
+// reverseLedgerEntries posts the mirror image of a posted payment's entries
+func reverseLedgerEntries(db execer, postedPaymentID int64, effectiveDate time.Time, memo string) error {
+	rows, err := db.Query(`
+	SELECT loan_id, debit_account, credit_account, amount, payment_number, COALESCE(fee_id, 0)
+	FROM ledger_entries
+	WHERE posted_payment_id = $1 AND type IN ($2, $3)
+	ORDER BY id
+	`, postedPaymentID, EntryPayment, EntryRecovery)
+	if err != nil {
+		return fmt.Errorf("failed to query ledger for posted payment %d: %w", postedPaymentID, err)
+	}
+	defer rows.Close()
+
+	var reversals []LedgerEntry
+	for rows.Next() {
+		e := LedgerEntry{Type: EntryReversal, PostedPaymentID: postedPaymentID, EffectiveDate: effectiveDate, Memo: memo}
+		if err := rows.Scan(&e.LoanID, &e.CreditAccount, &e.DebitAccount, &e.Amount, &e.PaymentNumber, &e.FeeID); err != nil {
+			return fmt.Errorf("failed to scan ledger row: %w", err)
+		}
+		reversals = append(reversals, e)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating ledger rows: %w", err)
+	}
+
+	return postLedgerEntries(db, reversals...)
+}
+
+// BackfillLedger opens the ledger of a Loan created before the ledger existed.
+// It posts the disbursement, the schedule, what has been paid on each installment as one payment
+// on its paid date, and the fees. Loans that already have ledger entries are left alone.
+func BackfillLedger(db *sql.DB, loanID int64) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_entries WHERE loan_id = $1`, loanID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check ledger of Loan %d: %w", loanID, err)
+		}
+		if count > 0 {
+			return nil
+		}
+
+		ln, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if err := postDisbursement(tx, ln); err != nil {
+			return err
+		}
+		if err := syncLedgerSchedule(tx, loanID); err != nil {
+			return err
+		}
+
+		payments, err := GetPaymentsByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+		for _, p := range payments {
+			err := postLedgerEntries(tx, LedgerEntry{LoanID: loanID, Type: EntryPayment, DebitAccount: AccountCash, CreditAccount: AccountDue,
+				Amount: p.AmountPaid, PaymentNumber: p.PaymentNumber, EffectiveDate: p.PaidDate, Memo: "backfill"})
+			if err != nil {
+				return err
+			}
+		}
+
+		fees, err := GetFeesByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+		for _, f := range fees {
+			err := postLedgerEntries(tx,
+				LedgerEntry{LoanID: loanID, Type: EntryFee, DebitAccount: AccountFees, CreditAccount: AccountFeeIncome,
+					Amount: f.Amount, FeeID: f.ID, EffectiveDate: f.AssessedDate, Memo: "backfill"},
+				LedgerEntry{LoanID: loanID, Type: EntryPayment, DebitAccount: AccountCash, CreditAccount: AccountFees,
+					Amount: f.AmountPaid, FeeID: f.ID, EffectiveDate: f.AssessedDate, Memo: "backfill"},
+				LedgerEntry{LoanID: loanID, Type: EntryWaiver, DebitAccount: AccountFeeIncome, CreditAccount: AccountFees,
+					Amount: f.AmountWaived, FeeID: f.ID, EffectiveDate: f.AssessedDate, Memo: "backfill"},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// postDisbursement records the principal lent on the day the Loan was taken
+func postDisbursement(db execer, ln Loan) error {
+	return postLedgerEntries(db, LedgerEntry{LoanID: ln.ID, Type: EntryDisbursement, DebitAccount: AccountPrincipal,
+		CreditAccount: AccountCash, Amount: ln.TotalAmount, EffectiveDate: ln.DateTaken})
+}
+
+// ReconcileLedger compares the payments and fees tables of a Loan with its ledger.
+// Installments of a charged-off or settled Loan due after it was closed out are not compared, the ledger cancels them.
+func ReconcileLedger(db *sql.DB, loanID int64) ([]LedgerDiscrepancy, error) {
+	ln, err := GetFullLoanByID(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := GetLedgerEntries(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := GetFeesByLoanID(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	var cutoff time.Time
+	switch ln.Status {
+	case LoanStatusChargedOff:
+		co, err := GetChargeOffByLoanID(db, loanID)
+		if err != nil {
+			return nil, err
+		}
+		cutoff = startOfDay(co.ChargeOffDate)
+	case LoanStatusSettled:
+		if cutoff, err = closedOutOn(db, loanID); err != nil {
+			return nil, err
+		}
+	}
+
+	derived := map[int64]Payment{}
+	for _, p := range ScheduleFromLedger(entries) {
+		derived[p.PaymentNumber] = p
+	}
+
+	var diffs []LedgerDiscrepancy
+	money := func(n int64, field string, recorded, ledger float64) {
+		if math.Abs(recorded-ledger) >= paymentTolerance {
+			diffs = append(diffs, LedgerDiscrepancy{n, field, fmt.Sprintf("%.2f", recorded), fmt.Sprintf("%.2f", ledger)})
+		}
+	}
+
+	for _, p := range ln.Payments {
+		if !cutoff.IsZero() && startOfDay(p.DueDate).After(cutoff) {
+			continue
+		}
+		d, ok := derived[p.PaymentNumber]
+		if !ok {
+			diffs = append(diffs, LedgerDiscrepancy{p.PaymentNumber, "installment", "present", "missing"})
+			continue
+		}
+		delete(derived, p.PaymentNumber)
+
+		money(p.PaymentNumber, "amount_due", p.AmountDue, d.AmountDue)
+		money(p.PaymentNumber, "amount_paid", p.AmountPaid, d.AmountPaid)
+		if !startOfDay(p.DueDate).Equal(startOfDay(d.DueDate)) {
+			diffs = append(diffs, LedgerDiscrepancy{p.PaymentNumber, "due_date", p.DueDate.Format("2006-01-02"), d.DueDate.Format("2006-01-02")})
+		}
+	}
+	for n := range derived {
+		diffs = append(diffs, LedgerDiscrepancy{n, "installment", "missing", "present"})
+	}
+
+	if ln.Status != LoanStatusChargedOff && ln.Status != LoanStatusSettled {
+		money(0, "fees_outstanding", outstandingFees(fees), BalancesFromLedger(entries, time.Now()).Fees)
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].PaymentNumber < diffs[j].PaymentNumber })
+
+	return diffs, nil
+}
@@ -0,0 +1,161 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ledgerTestEntries builds the ledger of a $1,000 Loan with two installments and one payment
+func ledgerTestEntries() []LedgerEntry {
+	jan, feb, mar := calendarDate(2024, time.January, 1), calendarDate(2024, time.February, 1), calendarDate(2024, time.March, 1)
+
+	entries := []LedgerEntry{{Type: EntryDisbursement, DebitAccount: AccountPrincipal, CreditAccount: AccountCash, Amount: 1000, EffectiveDate: jan}}
+	entries = append(entries, scheduleEntries(0, EntryDue, 1, 510, 500, feb)...)
+	entries = append(entries, scheduleEntries(0, EntryDue, 2, 505, 500, mar)...)
+	entries = append(entries, LedgerEntry{Type: EntryPayment, DebitAccount: AccountCash, CreditAccount: AccountDue,
+		Amount: 510, PaymentNumber: 1, PostedPaymentID: 9, EffectiveDate: calendarDate(2024, time.February, 3)})
+
+	return entries
+}
+
+// TestBalancesFromLedger verifies balances only count entries in effect on the day.
+func TestBalancesFromLedger(t *testing.T) {
+	entries := ledgerTestEntries()
+
+	b := BalancesFromLedger(entries, calendarDate(2024, time.February, 2))
+	require.InDelta(t, 500, b.Principal, 0.001)
+	require.InDelta(t, 510, b.Due, 0.001)
+	require.InDelta(t, -10, b.Accounts[AccountInterestIncome], 0.001)
+
+	b = BalancesFromLedger(entries, calendarDate(2024, time.March, 1))
+	require.InDelta(t, 0, b.Principal, 0.001)
+	require.InDelta(t, 505, b.Due, 0.001)
+	require.InDelta(t, 505, b.Total(), 0.001)
+}
+
+// TestScheduleFromLedger verifies installments, payments and reversals are derived from the entries.
+func TestScheduleFromLedger(t *testing.T) {
+	entries := ledgerTestEntries()
+
+	payments := ScheduleFromLedger(entries)
+	require.Len(t, payments, 2)
+	require.Equal(t, 510.0, payments[0].AmountDue)
+	require.Equal(t, 510.0, payments[0].AmountPaid)
+	require.Equal(t, calendarDate(2024, time.February, 3), payments[0].PaidDate)
+	require.Equal(t, calendarDate(2024, time.March, 1), payments[1].DueDate)
+
+	// the payment comes back
+	entries = append(entries, LedgerEntry{Type: EntryReversal, DebitAccount: AccountDue, CreditAccount: AccountCash,
+		Amount: 510, PaymentNumber: 1, PostedPaymentID: 9, EffectiveDate: calendarDate(2024, time.February, 8)})
+	payments = ScheduleFromLedger(entries)
+	require.Equal(t, 0.0, payments[0].AmountPaid)
+	require.True(t, payments[0].PaidDate.IsZero())
+
+	// the second installment is cancelled
+	entries = append(entries, scheduleEntries(0, EntryCancel, 2, -505, -500, calendarDate(2024, time.March, 1))...)
+	payments = ScheduleFromLedger(entries)
+	require.Len(t, payments, 1)
+	require.InDelta(t, 500, BalancesFromLedger(entries, calendarDate(2024, time.March, 1)).Principal, 0.001)
+}
+
+// TestSplitInstallments verifies the principal repaid by a level schedule adds up to the amount lent.
+func TestSplitInstallments(t *testing.T) {
+	payments, err := LevelSchedule{}.Generate(12000, 0.06, 12, 1, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+
+	splits := splitInstallments(12000, payments, []RateChange{{FromPayment: 1, Rate: 0.06}})
+
+	var repaid float64
+	for _, s := range splits {
+		repaid += s.principal
+	}
+	require.InDelta(t, 12000, repaid, 0.000001)
+	require.InDelta(t, payments[0].AmountDue-60, splits[0].principal, 0.001, "first month's interest is $60")
+}
+
+// TestLedgerLifecycle verifies the ledger follows a Loan through payments, fees, a return and a charge-off.
+func TestLedgerLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Ledger User", "ledger@example.com", "555-1616",
+		1200, 0.12, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	// Act
+	pp, err := PostPayment(db, ln.ID, ln.Payments[0].AmountDue, calendarDate(2024, time.February, 1))
+	require.NoError(t, err)
+	_, err = ReversePayment(db, pp.ID, calendarDate(2024, time.February, 5), ReturnInsufficientFunds, 25)
+	require.NoError(t, err)
+	fees, err := GetFeesByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	_, err = WaiveFee(db, fees[0].ID, 10, calendarDate(2024, time.February, 6), "first return")
+	require.NoError(t, err)
+
+	// Assert, the tables and the ledger agree
+	diffs, err := ReconcileLedger(db, ln.ID)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	b, err := GetLoanBalances(db, ln.ID, calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.InDelta(t, ln.Payments[0].AmountDue, b.Due, 0.01)
+	require.InDelta(t, 15, b.Fees, 0.001)
+
+	fromLedger, err := LoanFromLedger(db, ln.ID)
+	require.NoError(t, err)
+	d, err := EvaluateDelinquency(fromLedger, calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Equal(t, 9, d.DaysPastDue)
+
+	// a charge-off leaves nothing owing in the ledger
+	co, err := ChargeOffLoan(db, ln.ID, calendarDate(2024, time.March, 15), "collections", "fraud")
+	require.NoError(t, err)
+	b, err = GetLoanBalances(db, ln.ID, calendarDate(2024, time.December, 31))
+	require.NoError(t, err)
+	require.InDelta(t, 0, b.Total(), 0.01)
+	require.InDelta(t, co.Total(), b.Accounts[AccountChargeOffLoss], 0.01)
+}
+
+// TestDirectChangesReachLedger verifies payments and loans changed directly are adjusted in the ledger.
+func TestDirectChangesReachLedger(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Direct User", "direct@example.com", "555-1717",
+		1200, 0.12, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	p := ln.Payments[0]
+
+	// Act
+	require.NoError(t, UpdatePayment(db, p.ID, p.Version, ln.ID, p.PaymentNumber, p.AmountDue, p.AmountDue, p.DueDate, p.DueDate))
+	extra, err := CreatePayment(db, ln.ID, 4, 50, 0, calendarDate(2024, time.May, 1), time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, DeletePayment(db, extra.ID))
+	require.NoError(t, UpdateLoan(db, ln.ID, ln.Version, 1300, ln.InterestRate, ln.TermMonths, ln.DayDue, ln.Status, ln.DateTaken))
+
+	// Assert, the tables and the ledger agree and delinquency follows the ledger
+	diffs, err := ReconcileLedger(db, ln.ID)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	d, err := GetLoanDelinquency(db, ln.ID, calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.False(t, d.IsDelinquent())
+
+	entries, err := GetLedgerEntries(db, ln.ID)
+	require.NoError(t, err)
+	require.InDelta(t, 1300, BalancesFromLedger(entries, calendarDate(2024, time.January, 1)).Principal, 0.01)
+
+	// a charged-off Loan's ledger is closed
+	_, err = ChargeOffLoan(db, ln.ID, calendarDate(2024, time.March, 15), "collections", "fraud")
+	require.NoError(t, err)
+	_, err = CreatePayment(db, ln.ID, 5, 50, 0, calendarDate(2024, time.June, 1), time.Time{})
+	require.Error(t, err)
+}
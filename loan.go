@@ -2,6 +2,15 @@ package delinquencytracker
 
 import "time"
 
+// Loan statuses
+const (
+	LoanStatusActive     = "active"
+	LoanStatusPaidOff    = "paid_off"
+	LoanStatusDefaulted  = "defaulted"
+	LoanStatusChargedOff = "charged_off"
+	LoanStatusSettled    = "settled"
+)
+
 type Loan struct {
 	ID           int64     // unique identifier for the loan
 	UserID       int64     // which user this loan belong to
@@ -9,10 +18,25 @@ type Loan struct {
 	InterestRate float64   // annual interest rate (0.05 for 5% etc...)
 	TermMonths   int       // how many months is the loan term
 	DayDue       int       // what day of the month is payment due (1-31)
-	Status       string    // current status: "active", "paid_off", "defaulted"
+	Status       string    // current status: "active", "paid_off", "defaulted", "charged_off", "settled"
 	DateTaken    time.Time // when was the loan taken
 	CreatedAt    time.Time // when was this record created
+	DeletedAt    time.Time // when was the loan soft-deleted (zero while it is not)
+	DeletedBy    string    // who deleted the loan
+	Version      int64     // bumped on every change, see UpdateLoan
+
+	Schedule ScheduleConfig // how the installments are structured (level, interest-only, balloon, graduated)
+	Rate     RateTerms      // index, margin and caps of an adjustable rate (empty for fixed-rate loans)
+
+	BusinessDayConvention BusinessDayConvention // how due dates on closed days are moved
+	HolidayCalendar       string                // name of the registered holiday calendar ("" for weekends only)
 
-	Payments []Payment // all payments associated with this loan
+	Payments      []Payment          // all payments associated with this loan
+	Modifications []LoanModification // modification and forbearance history of this loan
+
+}
 
+// closedOut reports whether a Loan was charged off or settled, its ledger takes no more schedule changes
+func (l Loan) closedOut() bool {
+	return l.Status == LoanStatusChargedOff || l.Status == LoanStatusSettled
 }
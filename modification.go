@@ -0,0 +1,733 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ModificationType names the kind of change made to a Loan for a hardship case.
+type ModificationType string
+
+const (
+	ModificationDeferral       ModificationType = "deferral"       // installments pushed to the end of the Loan
+	ModificationTermExtension  ModificationType = "term_extension" // remaining balance spread over more months
+	ModificationRateReduction  ModificationType = "rate_reduction" // remaining balance re-amortized at a lower rate
+	ModificationCapitalization ModificationType = "capitalization" // arrears added to the balance and re-amortized
+	ModificationForbearance    ModificationType = "forbearance"    // delinquency aging paused for a window
+)
+
+// LoanTerms is a snapshot of a Loan's terms around a modification.
+type LoanTerms struct {
+	InterestRate      float64   `json:"interest_rate"`      // annual rate charged on the remaining installments
+	TermMonths        int       `json:"term_months"`        // term of the Loan in months
+	Balance           float64   `json:"balance"`            // scheduled balance outstanding at the effective date
+	InstallmentAmount float64   `json:"installment_amount"` // amount due on the first affected installment
+	MaturityDate      time.Time `json:"maturity_date"`      // due date of the last installment
+}
+
+// LoanModification records one change made to a Loan and who approved it.
+type LoanModification struct {
+	ID                int64            // unique identifier for the modification
+	LoanID            int64            // which loan was modified
+	Type              ModificationType // what kind of modification this is
+	FromPayment       int64            // first installment affected
+	EffectiveDate     time.Time        // when the modification takes effect
+	EndDate           time.Time        // last day of a forbearance window (zero for other types)
+	Approver          string           // who approved the modification
+	Reason            string           // why the modification was granted
+	DeferredPayments  int              // deferral: how many installments were deferred
+	ExtensionMonths   int              // term_extension: how many months were added
+	NewRate           float64          // rate_reduction: the rate after the modification
+	CapitalizedAmount float64          // capitalization: arrears added to the balance
+	Before            LoanTerms        // terms before the modification
+	After             LoanTerms        // terms after the modification
+	CreatedAt         time.Time        // when was this record created
+}
+
+// inForbearance reports whether day falls inside this forbearance window
+func (m LoanModification) inForbearance(day time.Time) bool {
+	if m.Type != ModificationForbearance {
+		return false
+	}
+	return !day.Before(startOfDay(m.EffectiveDate)) && !day.After(startOfDay(m.EndDate))
+}
+
+// forbearanceDays counts the days in (from, to] covered by the Loan's forbearance windows.
+func forbearanceDays(mods []LoanModification, from, to time.Time) int {
+	hasWindow := false
+	for _, m := range mods {
+		hasWindow = hasWindow || m.Type == ModificationForbearance
+	}
+	if !hasWindow {
+		return 0
+	}
+
+	days := 0
+	for d := from.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		for _, m := range mods {
+			if m.inForbearance(d) {
+				days++
+				break
+			}
+		}
+	}
+	return days
+}
+
+// loanModificationState is what a modification needs to know about the Loan it changes
+type loanModificationState struct {
+	ln      Loan         // the Loan with payments and earlier modifications
+	history []RateChange // rate history, or the current rate for loans that predate it
+	first   int          // index in ln.Payments of the first affected installment
+	balance float64      // scheduled balance outstanding before the first affected installment
+}
+
+// firstPayment returns the first installment the modification affects
+func (s loanModificationState) firstPayment() Payment {
+	return s.ln.Payments[s.first]
+}
+
+// currentRate returns the rate charged on the first affected installment
+func (s loanModificationState) currentRate() float64 {
+	return rateForPayment(s.history, s.firstPayment().PaymentNumber)
+}
+
+// terms snapshots the Loan's terms as seen from the first affected installment
+func (s loanModificationState) terms(payments []Payment, rate float64, termMonths int, balance float64) LoanTerms {
+	t := LoanTerms{InterestRate: rate, TermMonths: termMonths, Balance: balance}
+	if len(payments) > 0 {
+		t.InstallmentAmount = payments[0].AmountDue
+		t.MaturityDate = payments[len(payments)-1].DueDate
+	}
+	return t
+}
+
+// loadModificationState locks a Loan and locates the installments a modification effective on the given date affects.
+// Those installments must not have received any payment yet.
+func loadModificationState(tx *sql.Tx, loanID int64, effectiveDate time.Time) (loanModificationState, error) {
+	ln, err := lockLoan(tx, loanID)
+	if err != nil {
+		return loanModificationState{}, err
+	}
+
+	history, err := GetRateHistory(tx, loanID)
+	if err != nil {
+		return loanModificationState{}, err
+	}
+	if len(history) == 0 {
+		history = []RateChange{{LoanID: loanID, FromPayment: 1, Rate: ln.InterestRate}}
+	}
+
+	effective := startOfDay(effectiveDate)
+	first := -1
+	for i, p := range ln.Payments {
+		if !startOfDay(p.DueDate).Before(effective) {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return loanModificationState{}, fmt.Errorf("Loan %d has no installments due on or after %s", loanID, effective.Format("2006-01-02"))
+	}
+
+	for _, p := range ln.Payments[first:] {
+		if p.AmountPaid != 0 {
+			return loanModificationState{}, fmt.Errorf("Payment %d has already been paid and cannot be modified", p.PaymentNumber)
+		}
+	}
+
+	state := loanModificationState{ln: ln, history: history, first: first}
+	state.balance = scheduledBalance(ln.TotalAmount, ln.Payments[:first], history)
+
+	return state, nil
+}
+
+// scheduledBalance rolls the principal forward through the given installments as if each was paid as scheduled
+func scheduledBalance(principal float64, payments []Payment, history []RateChange) float64 {
+	balance := principal
+	for _, p := range payments {
+		rate := rateForPayment(history, p.PaymentNumber)
+		balance = balance*(1+rate/12) - p.AmountDue
+	}
+	return balance
+}
+
+// installmentMonth returns how many months after the Loan was taken an installment falls,
+// accounting for the installments pushed back by earlier deferrals.
+func installmentMonth(ln Loan, paymentNumber int64) int {
+	month := int(paymentNumber)
+	for _, m := range ln.Modifications {
+		if m.Type == ModificationDeferral && m.FromPayment <= paymentNumber {
+			month += m.DeferredPayments
+		}
+	}
+	return month
+}
+
+// dueDateFor returns the business-day adjusted due date of the installment falling month months after the Loan was taken
+func dueDateFor(ln Loan, month int) (time.Time, error) {
+	due := []Payment{{DueDate: calculateDueDate(ln.DateTaken, month, ln.DayDue)}}
+	if err := adjustDueDates(due, ln.BusinessDayConvention, ln.HolidayCalendar); err != nil {
+		return time.Time{}, err
+	}
+	return due[0].DueDate, nil
+}
+
+// reamortize builds count installments repaying balance at rate, starting at the first affected installment
+func (s loanModificationState) reamortize(balance, rate float64, count int) ([]Payment, error) {
+	first := s.firstPayment()
+	elapsed := int(first.PaymentNumber - 1)
+
+	cfg, err := s.ln.Schedule.remaining(elapsed)
+	if err != nil {
+		// graduated loans are re-amortized on a level basis once modified
+		cfg = ScheduleConfig{Type: ScheduleLevel}
+	}
+	gen, err := cfg.Generator()
+	if err != nil {
+		return nil, err
+	}
+
+	start := calculateDueDate(s.ln.DateTaken, installmentMonth(s.ln, first.PaymentNumber)-1, s.ln.DayDue)
+	payments, err := gen.Generate(balance, rate, count, s.ln.DayDue, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-amortize Loan %d: %w", s.ln.ID, err)
+	}
+
+	for i := range payments {
+		payments[i].LoanID = s.ln.ID
+		payments[i].PaymentNumber += int64(elapsed)
+	}
+	if err := adjustDueDates(payments, s.ln.BusinessDayConvention, s.ln.HolidayCalendar); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// replaceInstallments moves the unpaid installments from the first affected one onward onto a new schedule.
+// Existing rows are updated in place so their allocations and history survive; installments the new
+// schedule no longer has are cancelled by zeroing what is due on them. It refuses if any of them has been paid.
+func replaceInstallments(tx *sql.Tx, loanID, fromPayment int64, payments []Payment) error {
+	var paid int64
+	err := tx.QueryRow(`
+	SELECT payment_number FROM payments WHERE loan_id = $1 AND payment_number >= $2 AND amount_paid <> 0
+	ORDER BY payment_number LIMIT 1
+	`, loanID, fromPayment).Scan(&paid)
+	if err == nil {
+		return fmt.Errorf("Payment %d has already been paid and cannot be modified", paid)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check installments of Loan %d: %w", loanID, err)
+	}
+
+	scheduled := make(map[int64]bool, len(payments))
+	for _, p := range payments {
+		scheduled[p.PaymentNumber] = true
+
+		res, err := tx.Exec(`
+		UPDATE payments SET amount_due = $1, due_date = $2
+		WHERE loan_id = $3 AND payment_number = $4
+		`, p.AmountDue, p.DueDate, loanID, p.PaymentNumber)
+		if err != nil {
+			return fmt.Errorf("failed to update Payment %d: %w", p.PaymentNumber, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to update Payment %d: %w", p.PaymentNumber, err)
+		} else if n > 0 {
+			continue
+		}
+
+		_, err = tx.Exec(`
+		INSERT INTO payments (loan_id, payment_number, amount_due, amount_paid, due_date, paid_date)
+		VALUES ($1, $2, $3, 0, $4, $5)
+		`, loanID, p.PaymentNumber, p.AmountDue, p.DueDate, time.Time{})
+		if err != nil {
+			return fmt.Errorf("failed to create Payment %d: %w", p.PaymentNumber, err)
+		}
+	}
+
+	rows, err := tx.Query(`SELECT payment_number FROM payments WHERE loan_id = $1 AND payment_number >= $2`, loanID, fromPayment)
+	if err != nil {
+		return fmt.Errorf("failed to query installments of Loan %d: %w", loanID, err)
+	}
+	var dropped []int64
+	for rows.Next() {
+		var n int64
+		if err := rows.Scan(&n); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan installment: %w", err)
+		}
+		if !scheduled[n] {
+			dropped = append(dropped, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query installments of Loan %d: %w", loanID, err)
+	}
+
+	for _, n := range dropped {
+		_, err := tx.Exec(`UPDATE payments SET amount_due = 0 WHERE loan_id = $1 AND payment_number = $2`, loanID, n)
+		if err != nil {
+			return fmt.Errorf("failed to cancel Payment %d: %w", n, err)
+		}
+	}
+
+	return nil
+}
+
+// createLoanModification stores a modification record
+func createLoanModification(tx execer, mod LoanModification) (LoanModification, error) {
+	before, err := json.Marshal(mod.Before)
+	if err != nil {
+		return LoanModification{}, fmt.Errorf("failed to encode terms: %w", err)
+	}
+	after, err := json.Marshal(mod.After)
+	if err != nil {
+		return LoanModification{}, fmt.Errorf("failed to encode terms: %w", err)
+	}
+
+	var endDate sql.NullTime
+	if !mod.EndDate.IsZero() {
+		endDate = sql.NullTime{Time: mod.EndDate, Valid: true}
+	}
+
+	query := `
+	INSERT INTO loan_modifications (loan_id, type, from_payment, effective_date, end_date, approver, reason,
+		deferred_payments, extension_months, new_rate, capitalized_amount, before_terms, after_terms)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING id, created_at
+	`
+
+	err = tx.QueryRow(query, mod.LoanID, mod.Type, mod.FromPayment, mod.EffectiveDate, endDate, mod.Approver, mod.Reason,
+		mod.DeferredPayments, mod.ExtensionMonths, mod.NewRate, mod.CapitalizedAmount, before, after,
+	).Scan(&mod.ID, &mod.CreatedAt)
+	if err != nil {
+		return LoanModification{}, fmt.Errorf("failed to create Loan modification: %w", err)
+	}
+
+	mod.EffectiveDate = mod.EffectiveDate.UTC()
+	mod.CreatedAt = mod.CreatedAt.UTC()
+
+	return mod, nil
+}
+
+// GetLoanModifications returns the modification history of a Loan, oldest first.
+func GetLoanModifications(db execer, loanID int64) ([]LoanModification, error) {
+	query := `
+	SELECT id, loan_id, type, from_payment, effective_date, end_date, approver, reason,
+		deferred_payments, extension_months, new_rate, capitalized_amount, before_terms, after_terms, created_at
+	FROM loan_modifications
+	WHERE loan_id = $1
+	ORDER BY effective_date, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query modifications for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var mods []LoanModification
+
+	for rows.Next() {
+		var m LoanModification
+		var endDate sql.NullTime
+		var before, after []byte
+
+		err := rows.Scan(
+			&m.ID,
+			&m.LoanID,
+			&m.Type,
+			&m.FromPayment,
+			&m.EffectiveDate,
+			&endDate,
+			&m.Approver,
+			&m.Reason,
+			&m.DeferredPayments,
+			&m.ExtensionMonths,
+			&m.NewRate,
+			&m.CapitalizedAmount,
+			&before,
+			&after,
+			&m.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Loan modification row: %w", err)
+		}
+
+		if err := json.Unmarshal(before, &m.Before); err != nil {
+			return nil, fmt.Errorf("failed to decode terms of modification %d: %w", m.ID, err)
+		}
+		if err := json.Unmarshal(after, &m.After); err != nil {
+			return nil, fmt.Errorf("failed to decode terms of modification %d: %w", m.ID, err)
+		}
+
+		m.EffectiveDate = m.EffectiveDate.UTC()
+		if endDate.Valid {
+			m.EndDate = endDate.Time.UTC()
+		}
+		m.CreatedAt = m.CreatedAt.UTC()
+
+		mods = append(mods, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan modification rows: %w", err)
+	}
+
+	return mods, nil
+}
+
+// validateModification checks the fields every modification needs
+func validateModification(approver string, effectiveDate time.Time) error {
+	if approver == "" {
+		return fmt.Errorf("a modification needs an approver")
+	}
+	if effectiveDate.IsZero() {
+		return fmt.Errorf("effectiveDate cannot be zero time")
+	}
+	return nil
+}
+
+// DeferPayments pushes the next n installments due on or after effectiveDate to the end of the Loan.
+// Every remaining installment moves n months later and the term grows by n months.
+func DeferPayments(db *sql.DB, loanID int64, n int, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	return DeferPaymentsContext(context.Background(), db, loanID, n, effectiveDate, approver, reason)
+}
+
+// DeferPaymentsContext is DeferPayments, audited as the actor in ctx.
+func DeferPaymentsContext(ctx context.Context, db *sql.DB, loanID int64, n int, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	if err := validateModification(approver, effectiveDate); err != nil {
+		return LoanModification{}, err
+	}
+	if n <= 0 {
+		return LoanModification{}, fmt.Errorf("deferred installments must be positive, got %d", n)
+	}
+
+	var mod LoanModification
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		state, err := loadModificationState(tx, loanID, effectiveDate)
+		if err != nil {
+			return err
+		}
+		ln := state.ln
+		remaining := ln.Payments[state.first:]
+
+		deferred := make([]Payment, len(remaining))
+		for i, p := range remaining {
+			due, err := dueDateFor(ln, installmentMonth(ln, p.PaymentNumber)+n)
+			if err != nil {
+				return err
+			}
+			p.DueDate = due
+			deferred[i] = p
+		}
+
+		rate := state.currentRate()
+		mod = LoanModification{
+			LoanID:           loanID,
+			Type:             ModificationDeferral,
+			FromPayment:      state.firstPayment().PaymentNumber,
+			EffectiveDate:    effectiveDate,
+			Approver:         approver,
+			Reason:           reason,
+			DeferredPayments: n,
+			Before:           state.terms(remaining, rate, ln.TermMonths, state.balance),
+			After:            state.terms(deferred, rate, ln.TermMonths+n, state.balance),
+		}
+
+		mod, err = commitModification(ctx, tx, mod, func(tx *sql.Tx) error {
+			for _, p := range deferred {
+				if _, err := tx.Exec(`UPDATE payments SET due_date = $1 WHERE id = $2`, p.DueDate, p.ID); err != nil {
+					return fmt.Errorf("failed to defer Payment %d: %w", p.PaymentNumber, err)
+				}
+			}
+			_, err := tx.Exec(`UPDATE loans SET term_months = $1 WHERE id = $2`, ln.TermMonths+n, loanID)
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
+
+// ExtendLoanTerm spreads the balance outstanding at effectiveDate over the remaining
+// installments plus extraMonths more, at the current rate.
+func ExtendLoanTerm(db *sql.DB, loanID int64, extraMonths int, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	return ExtendLoanTermContext(context.Background(), db, loanID, extraMonths, effectiveDate, approver, reason)
+}
+
+// ExtendLoanTermContext is ExtendLoanTerm, audited as the actor in ctx.
+func ExtendLoanTermContext(ctx context.Context, db *sql.DB, loanID int64, extraMonths int, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	if err := validateModification(approver, effectiveDate); err != nil {
+		return LoanModification{}, err
+	}
+	if extraMonths <= 0 {
+		return LoanModification{}, fmt.Errorf("extension months must be positive, got %d", extraMonths)
+	}
+
+	var mod LoanModification
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		state, err := loadModificationState(tx, loanID, effectiveDate)
+		if err != nil {
+			return err
+		}
+		ln := state.ln
+		remaining := ln.Payments[state.first:]
+		rate := state.currentRate()
+
+		schedule, err := state.reamortize(state.balance, rate, len(remaining)+extraMonths)
+		if err != nil {
+			return err
+		}
+
+		mod = LoanModification{
+			LoanID:          loanID,
+			Type:            ModificationTermExtension,
+			FromPayment:     state.firstPayment().PaymentNumber,
+			EffectiveDate:   effectiveDate,
+			Approver:        approver,
+			Reason:          reason,
+			ExtensionMonths: extraMonths,
+			Before:          state.terms(remaining, rate, ln.TermMonths, state.balance),
+			After:           state.terms(schedule, rate, ln.TermMonths+extraMonths, state.balance),
+		}
+
+		mod, err = commitModification(ctx, tx, mod, func(tx *sql.Tx) error {
+			if err := replaceInstallments(tx, loanID, mod.FromPayment, schedule); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`UPDATE loans SET term_months = $1 WHERE id = $2`, ln.TermMonths+extraMonths, loanID)
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
+
+// ReduceLoanRate re-amortizes the balance outstanding at effectiveDate over the remaining
+// installments at a lower rate. The new rate is added to the Loan's rate history.
+func ReduceLoanRate(db *sql.DB, loanID int64, newRate float64, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	return ReduceLoanRateContext(context.Background(), db, loanID, newRate, effectiveDate, approver, reason)
+}
+
+// ReduceLoanRateContext is ReduceLoanRate, audited as the actor in ctx.
+func ReduceLoanRateContext(ctx context.Context, db *sql.DB, loanID int64, newRate float64, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	if err := validateModification(approver, effectiveDate); err != nil {
+		return LoanModification{}, err
+	}
+
+	var mod LoanModification
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		state, err := loadModificationState(tx, loanID, effectiveDate)
+		if err != nil {
+			return err
+		}
+		ln := state.ln
+		remaining := ln.Payments[state.first:]
+		rate := state.currentRate()
+
+		if newRate < 0 || newRate >= rate {
+			return fmt.Errorf("new rate must be between 0 and the current rate %.4f, got %.4f", rate, newRate)
+		}
+
+		schedule, err := state.reamortize(state.balance, newRate, len(remaining))
+		if err != nil {
+			return err
+		}
+
+		mod = LoanModification{
+			LoanID:        loanID,
+			Type:          ModificationRateReduction,
+			FromPayment:   state.firstPayment().PaymentNumber,
+			EffectiveDate: effectiveDate,
+			Approver:      approver,
+			Reason:        reason,
+			NewRate:       newRate,
+			Before:        state.terms(remaining, rate, ln.TermMonths, state.balance),
+			After:         state.terms(schedule, newRate, ln.TermMonths, state.balance),
+		}
+
+		mod, err = commitModification(ctx, tx, mod, func(tx *sql.Tx) error {
+			if err := replaceInstallments(tx, loanID, mod.FromPayment, schedule); err != nil {
+				return err
+			}
+			if _, err := createRateChange(tx, loanID, mod.FromPayment, effectiveDate, 0, 0, newRate); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`UPDATE loans SET interest_rate = $1 WHERE id = $2`, newRate, loanID)
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
+
+// CapitalizeArrears closes the installments past due at effectiveDate by adding what is
+// still owed on them to the balance, and re-amortizes the balance over the remaining installments.
+func CapitalizeArrears(db *sql.DB, loanID int64, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	return CapitalizeArrearsContext(context.Background(), db, loanID, effectiveDate, approver, reason)
+}
+
+// CapitalizeArrearsContext is CapitalizeArrears, audited as the actor in ctx.
+func CapitalizeArrearsContext(ctx context.Context, db *sql.DB, loanID int64, effectiveDate time.Time, approver, reason string) (LoanModification, error) {
+	if err := validateModification(approver, effectiveDate); err != nil {
+		return LoanModification{}, err
+	}
+
+	var mod LoanModification
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		state, err := loadModificationState(tx, loanID, effectiveDate)
+		if err != nil {
+			return err
+		}
+		ln := state.ln
+		remaining := ln.Payments[state.first:]
+		rate := state.currentRate()
+
+		// the past due installments only keep what was actually paid on them
+		closed := make([]Payment, state.first)
+		var arrears float64
+		for i, p := range ln.Payments[:state.first] {
+			if owed := p.AmountDue - p.AmountPaid; owed > paymentTolerance {
+				arrears += owed
+				p.AmountDue = p.AmountPaid
+			}
+			closed[i] = p
+		}
+		if arrears == 0 {
+			return fmt.Errorf("Loan %d has no arrears to capitalize", loanID)
+		}
+
+		// rolling the reduced installments forward carries the arrears and their interest into the balance
+		balance := scheduledBalance(ln.TotalAmount, closed, state.history)
+
+		schedule, err := state.reamortize(balance, rate, len(remaining))
+		if err != nil {
+			return err
+		}
+
+		mod = LoanModification{
+			LoanID:            loanID,
+			Type:              ModificationCapitalization,
+			FromPayment:       state.firstPayment().PaymentNumber,
+			EffectiveDate:     effectiveDate,
+			Approver:          approver,
+			Reason:            reason,
+			CapitalizedAmount: arrears,
+			Before:            state.terms(remaining, rate, ln.TermMonths, state.balance),
+			After:             state.terms(schedule, rate, ln.TermMonths, balance),
+		}
+
+		mod, err = commitModification(ctx, tx, mod, func(tx *sql.Tx) error {
+			for i, p := range closed {
+				if p.AmountDue == ln.Payments[i].AmountDue {
+					continue
+				}
+				if _, err := tx.Exec(`UPDATE payments SET amount_due = $1 WHERE id = $2`, p.AmountDue, p.ID); err != nil {
+					return fmt.Errorf("failed to capitalize Payment %d: %w", p.PaymentNumber, err)
+				}
+			}
+			return replaceInstallments(tx, loanID, mod.FromPayment, schedule)
+		})
+		return err
+	})
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
+
+// GrantForbearance pauses delinquency aging of a Loan from start to end, inclusive.
+// The schedule is left as is, installments keep their due dates.
+func GrantForbearance(db *sql.DB, loanID int64, start, end time.Time, approver, reason string) (LoanModification, error) {
+	return GrantForbearanceContext(context.Background(), db, loanID, start, end, approver, reason)
+}
+
+// GrantForbearanceContext is GrantForbearance, audited as the actor in ctx.
+func GrantForbearanceContext(ctx context.Context, db *sql.DB, loanID int64, start, end time.Time, approver, reason string) (LoanModification, error) {
+	if err := validateModification(approver, start); err != nil {
+		return LoanModification{}, err
+	}
+	if end.Before(start) {
+		return LoanModification{}, fmt.Errorf("forbearance cannot end before it starts")
+	}
+
+	var mod LoanModification
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		ln, err := lockLoan(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		terms := LoanTerms{InterestRate: ln.InterestRate, TermMonths: ln.TermMonths}
+		if len(ln.Payments) > 0 {
+			terms.MaturityDate = ln.Payments[len(ln.Payments)-1].DueDate
+		}
+
+		mod = LoanModification{
+			LoanID:        loanID,
+			Type:          ModificationForbearance,
+			EffectiveDate: start,
+			EndDate:       end,
+			Approver:      approver,
+			Reason:        reason,
+			Before:        terms,
+			After:         terms,
+		}
+
+		mod, err = commitModification(ctx, tx, mod, nil)
+		return err
+	})
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
+
+// commitModification applies the schedule changes and records the modification in the
+// transaction the Loan was locked and its state loaded in
+func commitModification(ctx context.Context, tx *sql.Tx, mod LoanModification, apply func(tx *sql.Tx) error) (LoanModification, error) {
+	if apply != nil {
+		if err := apply(tx); err != nil {
+			return LoanModification{}, fmt.Errorf("failed to apply %s to Loan %d: %w", mod.Type, mod.LoanID, err)
+		}
+		if err := syncLedgerSchedule(tx, mod.LoanID); err != nil {
+			return LoanModification{}, err
+		}
+	}
+
+	mod, err := createLoanModification(tx, mod)
+	if err != nil {
+		return LoanModification{}, err
+	}
+
+	if err := recordAudit(ctx, tx, AuditModifyLoan, AuditLoan, mod.LoanID, mod.Before, mod, mod.Reason); err != nil {
+		return LoanModification{}, err
+	}
+
+	return mod, nil
+}
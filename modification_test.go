@@ -0,0 +1,205 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestEvaluateDelinquencyForbearance verifies aging pauses inside a forbearance window.
+func TestEvaluateDelinquencyForbearance(t *testing.T) {
+	ln := delinquentTestLoan()
+	ln.Modifications = []LoanModification{{
+		Type:          ModificationForbearance,
+		EffectiveDate: calendarDate(2024, time.September, 10),
+		EndDate:       calendarDate(2024, time.October, 9),
+	}}
+
+	// 8 days late when the window opens on the 10th
+	inWindow, err := EvaluateDelinquency(ln, calendarDate(2024, time.October, 5))
+	require.NoError(t, err)
+	require.True(t, inWindow.InForbearance)
+	require.Equal(t, 8, inWindow.DaysPastDue, "aging stops when the window opens")
+	require.Equal(t, 1, inWindow.MissedPayments, "the October installment fell due inside the window")
+
+	// the window covers 30 days, aging resumes after it
+	after, err := EvaluateDelinquency(ln, calendarDate(2024, time.October, 20))
+	require.NoError(t, err)
+	require.False(t, after.InForbearance)
+	require.Equal(t, 49-30, after.DaysPastDue)
+	require.Equal(t, 2, after.MissedPayments)
+}
+
+// TestInstallmentMonthAccountsForDeferrals verifies deferred installments move later in the calendar.
+func TestInstallmentMonthAccountsForDeferrals(t *testing.T) {
+	ln := Loan{Modifications: []LoanModification{
+		{Type: ModificationDeferral, FromPayment: 4, DeferredPayments: 2},
+		{Type: ModificationForbearance, FromPayment: 1},
+	}}
+
+	require.Equal(t, 3, installmentMonth(ln, 3))
+	require.Equal(t, 6, installmentMonth(ln, 4))
+	require.Equal(t, 12, installmentMonth(ln, 10))
+}
+
+// TestScheduledBalance verifies the balance before an installment of a level schedule.
+func TestScheduledBalance(t *testing.T) {
+	payments, err := LevelSchedule{}.Generate(12000, 0.06, 12, 1, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+	history := []RateChange{{FromPayment: 1, Rate: 0.06}}
+
+	require.Equal(t, 12000.0, scheduledBalance(12000, nil, history))
+	require.InDelta(t, 0, scheduledBalance(12000, payments, history), 0.01)
+
+	// half way through, the rest of the installments repay what is left
+	half := scheduledBalance(12000, payments[:6], history)
+	require.InDelta(t, calculateMonthlyPayment(half, 0.06, 6), payments[6].AmountDue, 0.01)
+}
+
+// TestDeferPayments verifies deferral moves the remaining installments and extends the term.
+func TestDeferPayments(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Hardship User", "hardship@example.com", "555-8080",
+		12000, 0.06, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	// Act
+	mod, err := DeferPayments(db, ln.ID, 2, calendarDate(2024, time.May, 1), "supervisor", "job loss")
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, int64(4), mod.FromPayment)
+	require.Equal(t, 14, mod.After.TermMonths)
+	require.Equal(t, calendarDate(2025, time.March, 1), mod.After.MaturityDate)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, 14, full.TermMonths)
+	require.Equal(t, calendarDate(2024, time.April, 1), full.Payments[2].DueDate, "earlier installments keep their date")
+	require.Equal(t, calendarDate(2024, time.July, 1), full.Payments[3].DueDate)
+	require.Len(t, full.Modifications, 1)
+	require.Equal(t, "supervisor", full.Modifications[0].Approver)
+
+	// a second deferral builds on the first
+	_, err = DeferPayments(db, ln.ID, 1, calendarDate(2024, time.July, 1), "supervisor", "still unemployed")
+	require.NoError(t, err)
+	full, err = GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, calendarDate(2024, time.August, 1), full.Payments[3].DueDate)
+	require.Equal(t, calendarDate(2025, time.April, 1), full.Payments[11].DueDate)
+}
+
+// TestExtendLoanTerm verifies the remaining balance is spread over more installments.
+func TestExtendLoanTerm(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Hardship User", "hardship@example.com", "555-8080",
+		12000, 0.06, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	mod, err := ExtendLoanTerm(db, ln.ID, 6, calendarDate(2024, time.July, 1), "supervisor", "reduced hours")
+
+	require.NoError(t, err)
+	require.Less(t, mod.After.InstallmentAmount, mod.Before.InstallmentAmount)
+	require.Equal(t, 18, mod.After.TermMonths)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, full.Payments, 18)
+	require.Equal(t, int64(18), full.Payments[17].PaymentNumber)
+	require.Equal(t, calendarDate(2025, time.July, 1), full.Payments[17].DueDate)
+	require.Equal(t, ln.Payments[4].AmountDue, full.Payments[4].AmountDue, "installments before the effective date are untouched")
+
+	// an installment paid since the schedule was read is not rewritten
+	_, err = db.Exec(`UPDATE payments SET amount_paid = 10 WHERE id = $1`, full.Payments[10].ID)
+	require.NoError(t, err)
+	err = inTx(db, func(tx *sql.Tx) error {
+		return replaceInstallments(tx, ln.ID, 7, full.Payments[6:])
+	})
+	require.ErrorContains(t, err, "Payment 11 has already been paid")
+}
+
+// TestReduceLoanRate verifies the lower rate is applied and recorded in the rate history.
+func TestReduceLoanRate(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Hardship User", "hardship@example.com", "555-8080",
+		12000, 0.06, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	_, err = ReduceLoanRate(db, ln.ID, 0.08, calendarDate(2024, time.July, 1), "supervisor", "")
+	require.Error(t, err, "the rate can only go down")
+	_, err = ReduceLoanRate(db, ln.ID, 0.02, calendarDate(2024, time.July, 1), "", "")
+	require.Error(t, err, "an approver is required")
+
+	mod, err := ReduceLoanRate(db, ln.ID, 0.02, calendarDate(2024, time.July, 1), "supervisor", "medical")
+	require.NoError(t, err)
+	require.Equal(t, 0.06, mod.Before.InterestRate)
+	require.Equal(t, 0.02, mod.After.InterestRate)
+
+	stored, err := GetLoanByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0.02, stored.InterestRate)
+
+	history, err := GetRateHistory(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, int64(7), history[1].FromPayment)
+}
+
+// TestCapitalizeArrears verifies past due amounts move into the remaining installments.
+func TestCapitalizeArrears(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Hardship User", "hardship@example.com", "555-8080",
+		12000, 0.06, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	mod, err := CapitalizeArrears(db, ln.ID, calendarDate(2024, time.April, 15), "supervisor", "catch up")
+
+	// the February, March and April installments were never paid
+	require.NoError(t, err)
+	require.InDelta(t, 3*ln.Payments[0].AmountDue, mod.CapitalizedAmount, 0.01)
+	require.Greater(t, mod.After.InstallmentAmount, mod.Before.InstallmentAmount)
+
+	d, err := GetLoanDelinquency(db, ln.ID, calendarDate(2024, time.April, 20))
+	require.NoError(t, err)
+	require.False(t, d.IsDelinquent(), "capitalized arrears are no longer past due")
+}
+
+// TestGrantForbearance verifies forbearance pauses delinquency aging.
+func TestGrantForbearance(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Hardship User", "hardship@example.com", "555-8080",
+		12000, 0.06, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	_, err = GrantForbearance(db, ln.ID, calendarDate(2024, time.March, 1), calendarDate(2024, time.February, 1), "supervisor", "")
+	require.Error(t, err)
+
+	_, err = GrantForbearance(db, ln.ID, calendarDate(2024, time.January, 15), calendarDate(2024, time.April, 30), "supervisor", "disaster area")
+	require.NoError(t, err)
+
+	d, err := GetLoanDelinquency(db, ln.ID, calendarDate(2024, time.April, 1))
+	require.NoError(t, err)
+	require.True(t, d.InForbearance)
+	require.False(t, d.IsDelinquent())
+
+	d, err = GetLoanDelinquency(db, ln.ID, calendarDate(2024, time.May, 11))
+	require.NoError(t, err)
+	require.Equal(t, 11, d.DaysPastDue)
+}
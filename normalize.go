@@ -0,0 +1,243 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// DefaultPhoneRegion is the ISO 3166-1 region assumed for phone numbers written without a country code.
+// Change it at start-up for portfolios outside the US.
+var DefaultPhoneRegion = "US"
+
+// callingCodes maps the regions we lend in to their international calling codes
+var callingCodes = map[string]string{
+	"US": "1", "CA": "1", "PR": "1",
+	"MX": "52", "BR": "55", "AR": "54", "CO": "57", "CL": "56", "PE": "51",
+	"GB": "44", "IE": "353", "FR": "33", "DE": "49", "ES": "34", "IT": "39", "PT": "351",
+	"NL": "31", "BE": "32", "CH": "41", "AT": "43", "SE": "46", "NO": "47", "DK": "45", "FI": "358", "PL": "48",
+	"IN": "91", "CN": "86", "JP": "81", "PH": "63", "SG": "65", "IL": "972", "AE": "971",
+	"AU": "61", "NZ": "64", "ZA": "27", "NG": "234", "KE": "254",
+}
+
+// keepsTrunkZero lists regions whose national numbers keep their leading 0 after the calling code
+var keepsTrunkZero = map[string]bool{"IT": true}
+
+// E.164 allows at most 15 digits; anything under 7 cannot be a reachable number
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// NormalizePhone returns a phone number in E.164 form, e.g. "+15550101", reading numbers
+// without a country code as DefaultPhoneRegion numbers.
+func NormalizePhone(phone string) (string, error) {
+	return NormalizePhoneIn(phone, DefaultPhoneRegion)
+}
+
+// NormalizePhoneIn is NormalizePhone with the region given explicitly.
+func NormalizePhoneIn(phone, region string) (string, error) {
+	var digits strings.Builder
+	international := false
+
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case strings.ContainsRune(" -.()/", r):
+		default:
+			return "", fmt.Errorf("invalid phone number %q", phone)
+		}
+	}
+
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		number, international = number[2:], true
+	}
+
+	if !international {
+		code, ok := callingCodes[region]
+		if !ok {
+			return "", fmt.Errorf("unknown phone region %q", region)
+		}
+		switch {
+		case code == "1" && len(number) == 11 && number[0] == '1':
+			number = number[1:]
+		case code != "1" && !keepsTrunkZero[region]:
+			number = strings.TrimPrefix(number, "0")
+		}
+		number = code + number
+	}
+
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' {
+		return "", fmt.Errorf("invalid phone number %q", phone)
+	}
+
+	return "+" + number, nil
+}
+
+// NormalizeEmail returns an email address trimmed and lowercased, or an error when it is not a bare address.
+func NormalizeEmail(email string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(email))
+
+	addr, err := mail.ParseAddress(normalized)
+	if err != nil || addr.Address != normalized || addr.Name != "" {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+
+	at := strings.LastIndex(normalized, "@")
+	if !strings.Contains(normalized[at+1:], ".") {
+		return "", fmt.Errorf("invalid email %q: domain has no dot", email)
+	}
+
+	return normalized, nil
+}
+
+// normalizeContact normalizes the email and phone of a User about to be stored
+func normalizeContact(email, phone string) (string, string, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return "", "", err
+	}
+	phone, err = NormalizePhone(phone)
+	if err != nil {
+		return "", "", err
+	}
+	return email, phone, nil
+}
+
+// ContactIssue is a User whose stored email or phone cannot be normalized
+type ContactIssue struct {
+	UserID int64  // the User
+	Field  string // "email" or "phone"
+	Value  string // what is stored
+	Error  string // why it cannot be normalized
+}
+
+// ContactCollision is a set of users whose emails or phones are the same once normalized
+type ContactCollision struct {
+	Field   string  // "email" or "phone"
+	Value   string  // the normalized value they share
+	UserIDs []int64 // the users, lowest id first
+}
+
+// NormalizationReport is the outcome of NormalizeStoredContacts
+type NormalizationReport struct {
+	Checked    int                // users looked at, deleted ones included
+	Updated    int                // users whose email or phone was rewritten
+	Invalid    []ContactIssue     // values left as they were because they cannot be normalized
+	Collisions []ContactCollision // values shared by more than one User once normalized
+}
+
+// NormalizeStoredContacts rewrites the email and phone of every User in normalized form. It is a one-off
+// migration for rows stored before normalization. Values that cannot be normalized are left alone and reported.
+// Users who collide on an email keep their old one, since emails are unique, while colliding phones are rewritten.
+// Both kinds of collision are reported for review. With dryRun nothing is written.
+func NormalizeStoredContacts(ctx context.Context, db *sql.DB, dryRun bool) (NormalizationReport, error) {
+	if _, ok := callingCodes[DefaultPhoneRegion]; !ok {
+		return NormalizationReport{}, fmt.Errorf("unknown phone region %q", DefaultPhoneRegion)
+	}
+
+	var report NormalizationReport
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		users, err := lockAllUsers(tx)
+		if err != nil {
+			return err
+		}
+		report.Checked = len(users)
+
+		emails := map[string][]int64{}
+		phones := map[string][]int64{}
+		normalized := make([]User, len(users))
+
+		for i, usr := range users {
+			normalized[i] = usr
+			if email, err := NormalizeEmail(usr.Email); err != nil {
+				report.Invalid = append(report.Invalid, ContactIssue{usr.ID, "email", usr.Email, err.Error()})
+			} else {
+				normalized[i].Email = email
+				emails[email] = append(emails[email], usr.ID)
+			}
+			if phone, err := NormalizePhone(usr.Phone); err != nil {
+				report.Invalid = append(report.Invalid, ContactIssue{usr.ID, "phone", usr.Phone, err.Error()})
+			} else {
+				normalized[i].Phone = phone
+				phones[phone] = append(phones[phone], usr.ID)
+			}
+		}
+
+		report.Collisions = append(collisions("email", emails), collisions("phone", phones)...)
+
+		for i, usr := range users {
+			after := normalized[i]
+			if len(emails[after.Email]) > 1 {
+				after.Email = usr.Email
+			}
+			if after.Email == usr.Email && after.Phone == usr.Phone {
+				continue
+			}
+			report.Updated++
+			if dryRun {
+				continue
+			}
+
+			_, err := tx.Exec(`UPDATE users SET email = $1, phone = $2 WHERE id = $3`, after.Email, after.Phone, usr.ID)
+			if err != nil {
+				return fmt.Errorf("failed to normalize User %d: %w", usr.ID, err)
+			}
+			if err := recordAudit(ctx, tx, AuditUpdate, AuditUser, usr.ID, usr, after, "contact normalization"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return NormalizationReport{}, err
+	}
+
+	return report, nil
+}
+
+// lockAllUsers reads every User, deleted ones included, and locks them against concurrent edits
+func lockAllUsers(tx *sql.Tx) ([]User, error) {
+	rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+
+	for rows.Next() {
+		usr, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan User row: %w", err)
+		}
+		users = append(users, usr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating User rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// collisions lists the values held by more than one User, in value order
+func collisions(field string, holders map[string][]int64) []ContactCollision {
+	var found []ContactCollision
+	for value, ids := range holders {
+		if len(ids) > 1 {
+			found = append(found, ContactCollision{Field: field, Value: value, UserIDs: ids})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Value < found[j].Value })
+	return found
+}
@@ -0,0 +1,126 @@
+package delinquencytracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestNormalizePhone verifies the ways one number can be written all normalize to the same E.164 form.
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone, region, want string
+	}{
+		{"555-0101", "US", "+15550101"},
+		{"(555) 0101", "US", "+15550101"},
+		{"+15550101", "US", "+15550101"},
+		{"1 (312) 555-0101", "US", "+13125550101"},
+		{"312.555.0101", "CA", "+13125550101"},
+		{"020 7946 0018", "GB", "+442079460018"},
+		{"0044 20 7946 0018", "US", "+442079460018"},
+		{"06 1234 5678", "IT", "+390612345678"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhoneIn(tt.phone, tt.region)
+		require.NoError(t, err, tt.phone)
+		require.Equal(t, tt.want, got, tt.phone)
+	}
+
+	for _, bad := range []string{"", "555", "555-0101 ext 2", "+1234567890123456", "1+5550101"} {
+		_, err := NormalizePhoneIn(bad, "US")
+		require.Error(t, err, bad)
+	}
+	_, err := NormalizePhoneIn("555-0101", "XX")
+	require.Error(t, err, "unknown region")
+}
+
+// TestNormalizeEmail verifies emails are trimmed and lowercased and that non-addresses are refused.
+func TestNormalizeEmail(t *testing.T) {
+	got, err := NormalizeEmail("  Jane.Doe@Example.COM ")
+	require.NoError(t, err)
+	require.Equal(t, "jane.doe@example.com", got)
+
+	for _, bad := range []string{"", "jane", "jane@localhost", "Jane <jane@example.com>", "jane@@example.com"} {
+		_, err := NormalizeEmail(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+// TestCreateUserNormalizesContact verifies one borrower written three ways is one User.
+func TestCreateUserNormalizesContact(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Formatted User", " Formatted@Example.com", "(555) 0101")
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, "formatted@example.com", usr.Email)
+	require.Equal(t, "+15550101", usr.Phone)
+
+	for _, phone := range []string{"555-0101", "+15550101", "555.0101"} {
+		found, err := GetUserByPhone(db, phone)
+		require.NoError(t, err, phone)
+		require.Equal(t, usr.ID, found.ID, phone)
+	}
+	found, err := GetUserByEmail(db, "FORMATTED@example.com")
+	require.NoError(t, err)
+	require.Equal(t, usr.ID, found.ID)
+
+	_, err = CreateUser(db, "Formatted Twin", "formatted@EXAMPLE.com", "555-0102")
+	require.Error(t, err, "emails differing only in case are the same email")
+	_, err = CreateUser(db, "Bad Phone", "badphone@example.com", "call me")
+	require.Error(t, err)
+}
+
+// TestNormalizeStoredContacts verifies the migration rewrites old rows and reports what it cannot fix.
+func TestNormalizeStoredContacts(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange: rows stored before normalization
+	var ids [4]int64
+	rows := [][2]string{
+		{"Old@Example.com", "555-0101"},
+		{"twin@example.com", "(555) 0101"},
+		{"TWIN@example.com", "555-0202"},
+		{"nobody", "n/a"},
+	}
+	for i, r := range rows {
+		err := db.QueryRow(`INSERT INTO users (name, email, phone) VALUES ('Old User', $1, $2) RETURNING id`, r[0], r[1]).
+			Scan(&ids[i])
+		require.NoError(t, err)
+	}
+
+	// Act
+	dry, err := NormalizeStoredContacts(context.Background(), db, true)
+	require.NoError(t, err)
+	report, err := NormalizeStoredContacts(context.Background(), db, false)
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, dry, report, "a dry run reports what the real run does")
+	require.Equal(t, 4, report.Checked)
+	require.Equal(t, 3, report.Updated)
+	require.Len(t, report.Invalid, 2)
+	require.Equal(t, []ContactCollision{
+		{Field: "email", Value: "twin@example.com", UserIDs: []int64{ids[1], ids[2]}},
+		{Field: "phone", Value: "+15550101", UserIDs: []int64{ids[0], ids[1]}},
+	}, report.Collisions)
+
+	first, err := GetUserByID(db, ids[0])
+	require.NoError(t, err)
+	require.Equal(t, "old@example.com", first.Email)
+	require.Equal(t, "+15550101", first.Phone)
+
+	twin, err := GetUserByID(db, ids[2])
+	require.NoError(t, err)
+	require.Equal(t, "TWIN@example.com", twin.Email, "a colliding email is left for review")
+	require.Equal(t, "+15550202", twin.Phone)
+
+	again, err := NormalizeStoredContacts(context.Background(), db, false)
+	require.NoError(t, err)
+	require.Zero(t, again.Updated, "the migration is idempotent")
+}
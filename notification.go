@@ -0,0 +1,561 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notice kinds
+const (
+	NoticeUpcomingDue   = "upcoming_due"   // an installment falls due soon
+	NoticeMissedPayment = "missed_payment" // an installment fell due and was not paid
+	NoticePayoff        = "payoff"         // the Loan is paid in full
+)
+
+// ChannelInApp delivers notices to the borrower's in-app inbox
+const ChannelInApp = "in_app"
+
+// Delivery statuses
+const (
+	DeliveryPending = "pending" // claimed by a run that has not finished sending it; taken over once the claim is stale
+	DeliverySent    = "sent"    // handed to the channel
+	DeliveryFailed  = "failed"  // the channel refused it; the next run tries again
+	DeliverySkipped = "skipped" // the borrower cannot be reached on any channel we have
+)
+
+// Defaults for a NotificationService
+const (
+	DefaultReminderDays       = 3  // remind this many days before an installment falls due
+	DefaultPayoffLookbackDays = 30 // confirm payoffs made this many days back, for runs that were missed
+
+	DefaultClaimTimeout = 15 * time.Minute // how long a run's claim on a notice holds before another run may send it
+)
+
+// Message is one notice to one borrower on one channel
+type Message struct {
+	UserID  int64  // the borrower
+	Kind    string // "upcoming_due", "missed_payment" or "payoff"
+	To      string // email address or phone number, empty for in-app
+	Subject string
+	Body    string // plain text
+	HTML    string // HTML alternative to Body, email only ("" for plain text only)
+}
+
+// Notifier delivers messages on one channel
+type Notifier interface {
+	Channel() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPNotifier sends email through an SMTP server
+type SMTPNotifier struct {
+	Addr string    // host:port of the server
+	From string    // the sender address
+	Auth smtp.Auth // nil to send without authenticating
+}
+
+// Channel returns "email".
+func (n SMTPNotifier) Channel() string { return ChannelEmail }
+
+// Send emails the message as plain text, with its HTML as an alternative when it has one.
+func (n SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("User %d has no email address", msg.UserID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(text)
+	} else {
+		const boundary = "delinquencytracker-alternative"
+		fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n", boundary)
+		fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(text)
+		fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+		b.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+		b.WriteString(strings.ReplaceAll(msg.HTML, "\n", "\r\n"))
+		fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	}
+
+	if err := smtp.SendMail(n.Addr, n.Auth, n.From, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to email %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// SMSProvider sends text messages through a gateway
+type SMSProvider interface {
+	SendSMS(ctx context.Context, to, body string) error
+}
+
+// SMSNotifier sends text messages through an SMSProvider
+type SMSNotifier struct {
+	Provider SMSProvider
+}
+
+// Channel returns "sms".
+func (n SMSNotifier) Channel() string { return ChannelSMS }
+
+// Send texts the body of the message; SMS has no subject.
+func (n SMSNotifier) Send(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return fmt.Errorf("User %d has no phone number", msg.UserID)
+	}
+	if err := n.Provider.SendSMS(ctx, msg.To, msg.Body); err != nil {
+		return fmt.Errorf("failed to text %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// FileSMSProvider stands in for an SMS gateway by appending each text to a file as a JSON line
+type FileSMSProvider struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// SentSMS is one line written by a FileSMSProvider
+type SentSMS struct {
+	To     string    `json:"to"`
+	Body   string    `json:"body"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// SendSMS appends the text to the file.
+func (p *FileSMSProvider) SendSMS(ctx context.Context, to, body string) error {
+	line, err := json.Marshal(SentSMS{To: to, Body: body, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// InAppNotifier puts messages in the borrower's in-app inbox
+type InAppNotifier struct {
+	DB *sql.DB
+}
+
+// Channel returns "in_app".
+func (n InAppNotifier) Channel() string { return ChannelInApp }
+
+// Send adds the message to the borrower's inbox.
+func (n InAppNotifier) Send(ctx context.Context, msg Message) error {
+	_, err := n.DB.ExecContext(ctx, `INSERT INTO in_app_messages (user_id, kind, subject, body) VALUES ($1, $2, $3, $4)`,
+		msg.UserID, msg.Kind, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to add message to the inbox of User %d: %w", msg.UserID, err)
+	}
+	return nil
+}
+
+// InAppMessage is a message in a borrower's in-app inbox
+type InAppMessage struct {
+	ID        int64
+	UserID    int64
+	Kind      string
+	Subject   string
+	Body      string
+	CreatedAt time.Time
+	ReadAt    time.Time // zero while unread
+}
+
+// GetInAppMessages returns a User's inbox, newest first.
+func GetInAppMessages(db execer, userID int64) ([]InAppMessage, error) {
+	rows, err := db.Query(`
+	SELECT id, user_id, kind, subject, body, created_at, read_at
+	FROM in_app_messages
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inbox of User %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var messages []InAppMessage
+	for rows.Next() {
+		var m InAppMessage
+		var readAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Kind, &m.Subject, &m.Body, &m.CreatedAt, &readAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		m.CreatedAt = m.CreatedAt.UTC()
+		if readAt.Valid {
+			m.ReadAt = readAt.Time.UTC()
+		}
+		messages = append(messages, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message rows: %w", err)
+	}
+
+	return messages, nil
+}
+
+// Notification is a notice as logged: who it went to, how, and whether it was delivered
+type Notification struct {
+	ID            int64
+	UserID        int64
+	LoanID        int64
+	Kind          string // "upcoming_due", "missed_payment" or "payoff"
+	PaymentNumber int64  // the installment it is about (0 for a payoff)
+	Channel       string // "email", "sms" or "in_app" ("" when skipped)
+	Recipient     string // the address or number it was sent to
+	Subject       string
+	Body          string
+	Status        string // "pending", "sent", "failed" or "skipped"
+	Error         string // why it failed or was skipped
+	Attempts      int    // how many runs tried to send it
+	CreatedAt     time.Time
+	SentAt        time.Time // zero until sent
+}
+
+// NotificationRun is what a NotificationService run did
+type NotificationRun struct {
+	Sent    []Notification
+	Failed  []Notification
+	Skipped []Notification
+}
+
+// NotificationService sends borrowers reminders before installments fall due, notices when
+// they are missed and confirmations when a Loan is paid off. Missed-payment notices go to every
+// co-borrower and guarantor too, and are held back while the Loan has a pending promise to pay
+// or an active repayment plan. Each notice is logged per user and sent once: a rerun skips what
+// was already sent or skipped and retries what failed or what a crashed run left pending.
+type NotificationService struct {
+	DB                 *sql.DB
+	Notifiers          []Notifier        // tried in the borrower's preferred order, see pickNotifier
+	ReminderDays       int               // days before due to remind (0 for DefaultReminderDays)
+	PayoffLookbackDays int               // days back to confirm payoffs (0 for DefaultPayoffLookbackDays)
+	ClaimTimeout       time.Duration     // how long a pending notice stays claimed (0 for DefaultClaimTimeout)
+	Templates          *TemplateRegistry // what notices say in each language (nil for NewTemplateRegistry)
+}
+
+// notice is a notice a run decided a borrower should get
+type notice struct {
+	user          User
+	loan          Loan
+	kind          string
+	paymentNumber int64
+	data          NoticeData
+}
+
+// Run sends the notices due as of a day.
+func (s NotificationService) Run(ctx context.Context, asOf time.Time) (NotificationRun, error) {
+	day := startOfDay(asOf)
+	var run NotificationRun
+	if s.Templates == nil {
+		s.Templates = NewTemplateRegistry()
+	}
+
+	notices, err := s.notices(day)
+	if err != nil {
+		return run, err
+	}
+
+	for _, n := range notices {
+		sent, err := s.deliver(ctx, n)
+		if err != nil {
+			return run, err
+		}
+		switch sent.Status {
+		case DeliverySent:
+			run.Sent = append(run.Sent, sent)
+		case DeliveryFailed:
+			run.Failed = append(run.Failed, sent)
+		case DeliverySkipped:
+			run.Skipped = append(run.Skipped, sent)
+		}
+	}
+
+	return run, nil
+}
+
+// notices works out what each borrower should be told as of a day
+func (s NotificationService) notices(day time.Time) ([]notice, error) {
+	reminderDays := s.ReminderDays
+	if reminderDays <= 0 {
+		reminderDays = DefaultReminderDays
+	}
+	lookbackDays := s.PayoffLookbackDays
+	if lookbackDays <= 0 {
+		lookbackDays = DefaultPayoffLookbackDays
+	}
+
+	rows, err := s.DB.Query(`SELECT id FROM loans WHERE status IN ($1, $2, $3) AND deleted_at IS NULL ORDER BY id`,
+		LoanStatusActive, LoanStatusDefaulted, LoanStatusPaidOff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loans: %w", err)
+	}
+
+	var loanIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan Loan row: %w", err)
+		}
+		loanIDs = append(loanIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan rows: %w", err)
+	}
+
+	var notices []notice
+	for _, id := range loanIDs {
+		ln, err := LoanFromLedger(s.DB, id)
+		if err != nil {
+			return nil, err
+		}
+		user, err := GetUserByID(s.DB, ln.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		if ln.Status == LoanStatusPaidOff {
+			var paidOn time.Time
+			for _, p := range ln.Payments {
+				if p.PaidDate.After(paidOn) {
+					paidOn = p.PaidDate
+				}
+			}
+			paidOn = startOfDay(paidOn)
+			if !paidOn.After(day) && !paidOn.Before(day.AddDate(0, 0, -lookbackDays)) {
+				data, err := noticeData(s.DB, user, ln, Payment{}, day)
+				if err != nil {
+					return nil, err
+				}
+				notices = append(notices, notice{user: user, loan: ln, kind: NoticePayoff, data: data})
+			}
+			continue
+		}
+
+		d, err := EvaluateDelinquency(ln, day)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", ln.ID, err)
+		}
+
+		// a borrower keeping to a promise or a plan is not told they missed a payment
+		promised, err := hasActivePromise(s.DB, ln.ID, day)
+		if err != nil {
+			return nil, err
+		}
+		onPlan, err := hasActivePlan(s.DB, ln.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// one missed-payment notice per newly missed installment, and a reminder for the next one due
+		var missed, upcoming Payment
+		for _, p := range ln.Payments {
+			if p.AmountDue-paidAsOf(p, day) <= paymentTolerance {
+				continue
+			}
+			due := startOfDay(p.DueDate)
+			if due.Before(day) && d.IsDelinquent() && !promised && !onPlan {
+				missed = p
+			}
+			if due.After(day) && !due.After(day.AddDate(0, 0, reminderDays)) && upcoming.ID == 0 {
+				upcoming = p
+			}
+		}
+		if upcoming.ID != 0 {
+			data, err := noticeData(s.DB, user, ln, upcoming, day)
+			if err != nil {
+				return nil, err
+			}
+			notices = append(notices, notice{user: user, loan: ln, kind: NoticeUpcomingDue, paymentNumber: upcoming.PaymentNumber, data: data})
+		}
+		if missed.ID == 0 {
+			continue
+		}
+
+		// everyone liable for the Loan hears about a missed payment
+		parties, err := GetLoanParties(s.DB, ln.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, party := range parties {
+			liable := user
+			if party.Role != PartyPrimary {
+				if liable, err = GetUserByID(s.DB, party.UserID); err != nil {
+					return nil, err
+				}
+			}
+			data, err := noticeData(s.DB, liable, ln, missed, day)
+			if err != nil {
+				return nil, err
+			}
+			notices = append(notices, notice{user: liable, loan: ln, kind: NoticeMissedPayment, paymentNumber: missed.PaymentNumber, data: data})
+		}
+	}
+
+	return notices, nil
+}
+
+// pickNotifier returns the notifier for the borrower's preferred channel, or else the first
+// notifier on a channel they have not opted out of and can be reached on
+func (s NotificationService) pickNotifier(user User) (Notifier, string) {
+	recipient := func(channel string) (string, bool) {
+		switch channel {
+		case ChannelEmail:
+			return user.Email, user.Email != ""
+		case ChannelSMS:
+			return user.Phone, user.Phone != ""
+		default:
+			return "", true
+		}
+	}
+
+	pref := user.Profile.Contact
+	for _, preferredOnly := range []bool{true, false} {
+		for _, n := range s.Notifiers {
+			if preferredOnly && n.Channel() != pref.Channel {
+				continue
+			}
+			if !pref.Allows(n.Channel()) {
+				continue
+			}
+			if to, ok := recipient(n.Channel()); ok {
+				return n, to
+			}
+		}
+	}
+	return nil, ""
+}
+
+// deliver logs a notice and sends it in the borrower's language, unless it was already sent or skipped
+func (s NotificationService) deliver(ctx context.Context, n notice) (Notification, error) {
+	notifier, to := s.pickNotifier(n.user)
+	channel := ChannelInApp // what is logged for a notice nobody can be sent
+	if notifier != nil {
+		channel = notifier.Channel()
+	}
+
+	msg, err := s.Templates.Render(n.kind, channel, n.user.Profile.Language, n.data)
+	if err != nil {
+		return Notification{}, err
+	}
+	msg.UserID, msg.To = n.user.ID, to
+
+	nt := Notification{
+		UserID:        n.user.ID,
+		LoanID:        n.loan.ID,
+		Kind:          n.kind,
+		PaymentNumber: n.paymentNumber,
+		Recipient:     to,
+		Subject:       msg.Subject,
+		Body:          msg.Body,
+	}
+	if notifier != nil {
+		nt.Channel = notifier.Channel()
+	}
+
+	timeout := s.ClaimTimeout
+	if timeout <= 0 {
+		timeout = DefaultClaimTimeout
+	}
+
+	// claim the notice: new ones, ones that failed before and ones a run claimed but never
+	// finished, e.g. because it crashed mid-send, are ours to send
+	err = s.DB.QueryRow(`
+	INSERT INTO notifications (user_id, loan_id, kind, payment_number, channel, recipient, subject, body, status, attempts, claimed_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 1, NOW())
+	ON CONFLICT (user_id, loan_id, kind, payment_number) DO UPDATE
+	SET channel = EXCLUDED.channel, recipient = EXCLUDED.recipient, subject = EXCLUDED.subject, body = EXCLUDED.body,
+		status = EXCLUDED.status, error = '', attempts = notifications.attempts + 1, claimed_at = NOW()
+	WHERE notifications.status = $10
+	   OR (notifications.status = $9 AND notifications.claimed_at < NOW() - make_interval(secs => $11))
+	RETURNING id, attempts, created_at
+	`, nt.UserID, nt.LoanID, nt.Kind, nt.PaymentNumber, nt.Channel, nt.Recipient, nt.Subject, nt.Body, DeliveryPending, DeliveryFailed,
+		timeout.Seconds()).
+		Scan(&nt.ID, &nt.Attempts, &nt.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Notification{}, nil
+	}
+	if err != nil {
+		return Notification{}, fmt.Errorf("failed to log %s notice for User %d: %w", n.kind, n.user.ID, err)
+	}
+	nt.CreatedAt = nt.CreatedAt.UTC()
+
+	if notifier == nil {
+		nt.Status, nt.Error = DeliverySkipped, "no channel reaches the borrower"
+	} else if err := notifier.Send(ctx, msg); err != nil {
+		nt.Status, nt.Error = DeliveryFailed, err.Error()
+	} else {
+		nt.Status, nt.SentAt = DeliverySent, time.Now().UTC()
+	}
+
+	_, err = s.DB.Exec(`UPDATE notifications SET status = $1, error = $2, sent_at = $3 WHERE id = $4`,
+		nt.Status, nt.Error, nullDate(nt.SentAt), nt.ID)
+	if err != nil {
+		return Notification{}, fmt.Errorf("failed to record delivery of notification %d: %w", nt.ID, err)
+	}
+
+	return nt, nil
+}
+
+// GetNotificationsByUserID returns the notices logged for a User, oldest first.
+func GetNotificationsByUserID(db execer, userID int64) ([]Notification, error) {
+	rows, err := db.Query(`
+	SELECT id, user_id, loan_id, kind, payment_number, channel, recipient, subject, body,
+		status, error, attempts, created_at, sent_at
+	FROM notifications
+	WHERE user_id = $1
+	ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications of User %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var nt Notification
+		var sentAt sql.NullTime
+		err := rows.Scan(&nt.ID, &nt.UserID, &nt.LoanID, &nt.Kind, &nt.PaymentNumber, &nt.Channel, &nt.Recipient,
+			&nt.Subject, &nt.Body, &nt.Status, &nt.Error, &nt.Attempts, &nt.CreatedAt, &sentAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification row: %w", err)
+		}
+		nt.CreatedAt = nt.CreatedAt.UTC()
+		if sentAt.Valid {
+			nt.SentAt = sentAt.Time.UTC()
+		}
+		notifications = append(notifications, nt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification rows: %w", err)
+	}
+
+	return notifications, nil
+}
@@ -0,0 +1,281 @@
+package delinquencytracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts one connection at a time on a local port and keeps the mail it is sent
+type fakeSMTPServer struct {
+	ln   net.Listener
+	mail chan string
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTPServer{ln: ln, mail: make(chan string, 10)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost fake SMTP")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				s.mail <- data.String()
+				data.Reset()
+				reply("250 OK")
+				continue
+			}
+			data.WriteString(line)
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			inData = true
+			reply("354 go ahead")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// TestSMTPNotifier verifies email goes through an SMTP server with its headers and body.
+func TestSMTPNotifier(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	n := SMTPNotifier{Addr: server.ln.Addr().String(), From: "loans@example.com"}
+	require.Equal(t, ChannelEmail, n.Channel())
+
+	// Act
+	err := n.Send(context.Background(), Message{UserID: 1, To: "borrower@example.com", Subject: "Payment due", Body: "Hi,\nyour payment is due."})
+
+	// Assert
+	require.NoError(t, err)
+	select {
+	case mail := <-server.mail:
+		require.Contains(t, mail, "From: loans@example.com\r\n")
+		require.Contains(t, mail, "To: borrower@example.com\r\n")
+		require.Contains(t, mail, "Subject: Payment due\r\n")
+		require.Contains(t, mail, "\r\n\r\nHi,\r\nyour payment is due.")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail arrived")
+	}
+
+	// with HTML the mail carries both bodies
+	err = n.Send(context.Background(), Message{UserID: 1, To: "borrower@example.com", Subject: "Pago vencido", Body: "Hola", HTML: "<p>Hola</p>"})
+	require.NoError(t, err)
+	select {
+	case mail := <-server.mail:
+		require.Contains(t, mail, "Content-Type: multipart/alternative")
+		require.Contains(t, mail, "Content-Type: text/plain; charset=UTF-8\r\n\r\nHola\r\n")
+		require.Contains(t, mail, "Content-Type: text/html; charset=UTF-8\r\n\r\n<p>Hola</p>\r\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail arrived")
+	}
+
+	require.Error(t, n.Send(context.Background(), Message{UserID: 1}), "a message needs an address")
+}
+
+// TestFileSMSProvider verifies texts are appended to the stub's file one JSON line each.
+func TestFileSMSProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.jsonl")
+	n := SMSNotifier{Provider: &FileSMSProvider{Path: path}}
+
+	require.NoError(t, n.Send(context.Background(), Message{To: "+15551234567", Subject: "ignored", Body: "first"}))
+	require.NoError(t, n.Send(context.Background(), Message{To: "+15557654321", Body: "second"}))
+	require.Error(t, n.Send(context.Background(), Message{Body: "nowhere"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var sms SentSMS
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &sms))
+	require.Equal(t, "+15551234567", sms.To)
+	require.Equal(t, "first", sms.Body)
+}
+
+// recordingNotifier keeps what it is sent, or fails while failing is set
+type recordingNotifier struct {
+	channel string
+	failing bool
+	sent    []Message
+}
+
+func (n *recordingNotifier) Channel() string { return n.channel }
+
+func (n *recordingNotifier) Send(ctx context.Context, msg Message) error {
+	if n.failing {
+		return errors.New("gateway down")
+	}
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+// TestNotificationService verifies reminders, missed-payment notices and payoff confirmations are
+// sent once each, failures are retried and opted-out borrowers fall back to their in-app inbox.
+func TestNotificationService(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan first due Feb 1, one paid off on Jan 15 and a borrower who will not take email
+	user, err := InitializeUserWithLoan(db, "Notified User", "notified@example.com", "555-2020",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	paid, err := InitializeUserWithLoan(db, "Paid User", "paid@example.com", "555-2121",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	_, err = PostPayment(db, paid.Loans[0].ID, 1200, calendarDate(2024, time.January, 15))
+	require.NoError(t, err)
+	optedOut, err := InitializeUserWithLoan(db, "Quiet User", "quiet@example.com", "555-2222",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	_, err = PatchUser(db, optedOut.ID, UserPatch{Contact: &ContactPreferences{Channel: ChannelSMS, OptedOut: []string{ChannelEmail}}})
+	require.NoError(t, err)
+
+	email := &recordingNotifier{channel: ChannelEmail}
+	svc := NotificationService{DB: db, Notifiers: []Notifier{email, InAppNotifier{DB: db}}}
+
+	// Act, three days before the first installment
+	run, err := svc.Run(context.Background(), calendarDate(2024, time.January, 29))
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 3)
+	require.Len(t, email.sent, 2)
+	require.Equal(t, NoticeUpcomingDue, email.sent[0].Kind)
+	require.Equal(t, "notified@example.com", email.sent[0].To)
+	require.Contains(t, email.sent[0].Body, "February 1, 2024")
+	require.Contains(t, email.sent[0].HTML, "<p>Hi Notified User,</p>")
+	require.Equal(t, NoticePayoff, email.sent[1].Kind)
+
+	inbox, err := GetInAppMessages(db, optedOut.ID)
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+	require.Equal(t, NoticeUpcomingDue, inbox[0].Kind)
+
+	// a rerun sends nothing again
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.January, 29))
+	require.NoError(t, err)
+	require.Empty(t, run.Sent)
+	require.Len(t, email.sent, 2)
+
+	// a missed installment is noticed once
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 2)
+	require.Equal(t, NoticeMissedPayment, email.sent[2].Kind)
+	require.Contains(t, email.sent[2].Body, "9 days past due")
+
+	// a failed send is logged and retried by the next run
+	email.failing = true
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.February, 27))
+	require.NoError(t, err)
+	require.Len(t, run.Failed, 1)
+	require.Equal(t, "notified@example.com", run.Failed[0].Recipient)
+
+	email.failing = false
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.February, 28))
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 1)
+	require.Equal(t, 2, run.Sent[0].Attempts)
+
+	log, err := GetNotificationsByUserID(db, user.ID)
+	require.NoError(t, err)
+	require.Len(t, log, 3)
+	for _, nt := range log {
+		require.Equal(t, DeliverySent, nt.Status)
+		require.Equal(t, ChannelEmail, nt.Channel)
+		require.False(t, nt.SentAt.IsZero())
+	}
+}
+
+// TestNotificationServiceParties verifies missed-payment notices reach every party, hold off while
+// a promise is pending and are re-sent when a run claimed them but never finished.
+func TestNotificationServiceParties(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan with a co-borrower and one with a pending promise, both first due Feb 1
+	user, err := InitializeUserWithLoan(db, "Primary User", "primary@example.com", "555-2323",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	co, err := CreateUser(db, "Co User", "co@example.com", "555-2424")
+	require.NoError(t, err)
+	_, err = AddLoanParty(db, user.Loans[0].ID, co.ID, PartyCoBorrower)
+	require.NoError(t, err)
+	promised, err := InitializeUserWithLoan(db, "Promised User", "promised@example.com", "555-2525",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	_, err = RecordPromise(db, PromiseToPay{LoanID: promised.Loans[0].ID, Collector: "bob", Amount: 100,
+		MadeOn: calendarDate(2024, time.February, 5), PromisedDate: calendarDate(2024, time.February, 20)})
+	require.NoError(t, err)
+
+	email := &recordingNotifier{channel: ChannelEmail}
+	svc := NotificationService{DB: db, Notifiers: []Notifier{email}}
+
+	// Act
+	run, err := svc.Run(context.Background(), calendarDate(2024, time.February, 10))
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 2)
+	var to []string
+	for _, msg := range email.sent {
+		require.Equal(t, NoticeMissedPayment, msg.Kind)
+		to = append(to, msg.To)
+	}
+	require.ElementsMatch(t, []string{"primary@example.com", "co@example.com"}, to)
+
+	// a notice claimed a moment ago is left to the run sending it, one claimed long ago is taken over
+	_, err = db.Exec(`UPDATE notifications SET status = $1, claimed_at = NOW() WHERE user_id = $2`, DeliveryPending, user.ID)
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE notifications SET status = $1, claimed_at = NOW() - INTERVAL '1 hour' WHERE user_id = $2`, DeliveryPending, co.ID)
+	require.NoError(t, err)
+
+	run, err = svc.Run(context.Background(), calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Len(t, run.Sent, 1)
+	require.Equal(t, "co@example.com", run.Sent[0].Recipient)
+	require.Equal(t, 2, run.Sent[0].Attempts)
+}
@@ -0,0 +1,233 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Roles of the parties to a Loan
+const (
+	PartyPrimary    = "primary"     // the borrower the Loan belongs to, Loan.UserID
+	PartyCoBorrower = "co_borrower" // equally liable and equally entitled
+	PartyGuarantor  = "guarantor"   // liable when the borrowers do not pay
+)
+
+// LoanParty is a User liable for a Loan
+type LoanParty struct {
+	ID      int64     // unique identifier for the relationship (zero for the primary borrower)
+	LoanID  int64     // the Loan
+	UserID  int64     // the User liable for it
+	Role    string    // "primary", "co_borrower" or "guarantor"
+	AddedAt time.Time // when they became liable (the Loan's creation for the primary borrower)
+}
+
+// validatePartyRole checks a role can be added to a Loan; the primary borrower comes with the Loan
+func validatePartyRole(role string) error {
+	switch role {
+	case PartyCoBorrower, PartyGuarantor:
+		return nil
+	case PartyPrimary:
+		return fmt.Errorf("a Loan's primary borrower is its User and cannot be added as a party")
+	default:
+		return fmt.Errorf("unknown Loan party role %q", role)
+	}
+}
+
+// AddLoanParty makes a User a co-borrower or guarantor of a Loan.
+func AddLoanParty(db *sql.DB, loanID, userID int64, role string) (LoanParty, error) {
+	return AddLoanPartyContext(context.Background(), db, loanID, userID, role)
+}
+
+// AddLoanPartyContext is AddLoanParty, audited as the actor in ctx
+func AddLoanPartyContext(ctx context.Context, db *sql.DB, loanID, userID int64, role string) (LoanParty, error) {
+	var party LoanParty
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		ln, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		party, err = addLoanParty(ctx, tx, ln, LoanParty{UserID: userID, Role: role})
+		return err
+	})
+	if err != nil {
+		return LoanParty{}, err
+	}
+
+	return party, nil
+}
+
+// addLoanParty adds a party to a Loan inside a transaction
+func addLoanParty(ctx context.Context, tx *sql.Tx, ln Loan, party LoanParty) (LoanParty, error) {
+	if err := validatePartyRole(party.Role); err != nil {
+		return LoanParty{}, err
+	}
+
+	usr, err := GetUserByID(tx, party.UserID)
+	if err != nil {
+		return LoanParty{}, err
+	}
+	if usr.ID == ln.UserID {
+		return LoanParty{}, fmt.Errorf("User %d is already the primary borrower of Loan %d", usr.ID, ln.ID)
+	}
+
+	query := `
+	INSERT INTO loan_parties (loan_id, user_id, role)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (loan_id, user_id) DO NOTHING
+	RETURNING id, added_at
+	`
+
+	party = LoanParty{LoanID: ln.ID, UserID: usr.ID, Role: party.Role}
+	err = tx.QueryRow(query, ln.ID, usr.ID, party.Role).Scan(&party.ID, &party.AddedAt)
+	if err == sql.ErrNoRows {
+		return LoanParty{}, fmt.Errorf("User %d is already a party to Loan %d", usr.ID, ln.ID)
+	}
+	if err != nil {
+		return LoanParty{}, fmt.Errorf("failed to add User %d to Loan %d: %w", usr.ID, ln.ID, err)
+	}
+	party.AddedAt = party.AddedAt.UTC()
+
+	if err := recordAudit(ctx, tx, AuditCreate, AuditLoanParty, party.ID, nil, party, ""); err != nil {
+		return LoanParty{}, err
+	}
+
+	return party, nil
+}
+
+// RemoveLoanParty releases a co-borrower or guarantor from a Loan.
+func RemoveLoanParty(db *sql.DB, loanID, userID int64) error {
+	return RemoveLoanPartyContext(context.Background(), db, loanID, userID)
+}
+
+// RemoveLoanPartyContext is RemoveLoanParty, audited as the actor in ctx
+func RemoveLoanPartyContext(ctx context.Context, db *sql.DB, loanID, userID int64) error {
+	return inTx(db, func(tx *sql.Tx) error {
+		var party LoanParty
+
+		err := tx.QueryRow(`DELETE FROM loan_parties WHERE loan_id = $1 AND user_id = $2 RETURNING `+partyColumns, loanID, userID).
+			Scan(&party.ID, &party.LoanID, &party.UserID, &party.Role, &party.AddedAt)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("User %d is not a co-borrower or guarantor of Loan %d", userID, loanID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove User %d from Loan %d: %w", userID, loanID, err)
+		}
+		party.AddedAt = party.AddedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditDelete, AuditLoanParty, party.ID, party, nil, "")
+	})
+}
+
+const partyColumns = `id, loan_id, user_id, role, added_at`
+
+// GetLoanParties returns everyone liable for a Loan: the primary borrower first,
+// then co-borrowers, then guarantors, each in the order they were added.
+func GetLoanParties(db execer, loanID int64) ([]LoanParty, error) {
+	ln, err := GetLoanByLoanID(db, loanID, IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	parties := []LoanParty{{LoanID: ln.ID, UserID: ln.UserID, Role: PartyPrimary, AddedAt: ln.CreatedAt}}
+
+	query := `
+	SELECT ` + partyColumns + `
+	FROM loan_parties
+	WHERE loan_id = $1
+	ORDER BY role = '` + PartyGuarantor + `', added_at, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parties of Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p LoanParty
+		if err := rows.Scan(&p.ID, &p.LoanID, &p.UserID, &p.Role, &p.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan Loan party row: %w", err)
+		}
+		p.AddedAt = p.AddedAt.UTC()
+		parties = append(parties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan party rows: %w", err)
+	}
+
+	return parties, nil
+}
+
+// mergeLoanParties hands the co-borrower and guarantor roles of fromID to intoID, once their loans have moved.
+// A role intoID already has on the same Loan, or that would make them a party to their own Loan, is dropped.
+// Every dropped and moved role is audited with reason.
+func mergeLoanParties(ctx context.Context, tx *sql.Tx, fromID, intoID int64, reason string) error {
+	rows, err := tx.Query(`
+	SELECT p.id, p.loan_id, p.user_id, p.role, p.added_at, l.user_id
+	FROM loan_parties p
+	JOIN loans l ON l.id = p.loan_id
+	WHERE p.user_id IN ($1, $2)
+	ORDER BY p.id
+	FOR UPDATE OF p
+	`, fromID, intoID)
+	if err != nil {
+		return fmt.Errorf("failed to query Loan parties of users %d and %d: %w", fromID, intoID, err)
+	}
+
+	var parties []LoanParty
+	owners := map[int64]int64{}
+	for rows.Next() {
+		var p LoanParty
+		var owner int64
+		if err := rows.Scan(&p.ID, &p.LoanID, &p.UserID, &p.Role, &p.AddedAt, &owner); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan Loan party row: %w", err)
+		}
+		p.AddedAt = p.AddedAt.UTC()
+		parties = append(parties, p)
+		owners[p.LoanID] = owner
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating Loan party rows: %w", err)
+	}
+
+	// the loans intoID keeps a role on once the merge is done
+	kept := map[int64]bool{}
+	for _, p := range parties {
+		if p.UserID == intoID && owners[p.LoanID] != intoID {
+			kept[p.LoanID] = true
+		}
+	}
+
+	for _, p := range parties {
+		if owners[p.LoanID] == intoID || (p.UserID == fromID && kept[p.LoanID]) {
+			if _, err := tx.Exec(`DELETE FROM loan_parties WHERE id = $1`, p.ID); err != nil {
+				return fmt.Errorf("failed to drop User %d from Loan %d: %w", p.UserID, p.LoanID, err)
+			}
+			if err := recordAudit(ctx, tx, AuditDelete, AuditLoanParty, p.ID, p, nil, reason); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.UserID != fromID {
+			continue
+		}
+
+		if _, err := tx.Exec(`UPDATE loan_parties SET user_id = $1 WHERE id = $2`, intoID, p.ID); err != nil {
+			return fmt.Errorf("failed to move Loan party %d to User %d: %w", p.ID, intoID, err)
+		}
+		after := p
+		after.UserID = intoID
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditLoanParty, p.ID, p, after, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoanParties verifies co-borrowers and guarantors are added at origination and afterwards,
+// and that their loans can be listed with IncludeAsParty.
+func TestLoanParties(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	borrower, err := CreateUser(db, "Main Borrower", "main@example.com", "555-0501")
+	require.NoError(t, err)
+	spouse, err := CreateUser(db, "Co Borrower", "co@example.com", "555-0502")
+	require.NoError(t, err)
+	parent, err := CreateUser(db, "Guarantor Parent", "guarantor@example.com", "555-0503")
+	require.NoError(t, err)
+
+	// Act
+	ln, err := AddLoanToExistingUserOptions(db, borrower.ID, 5000, 6, 24, 1, time.Now().UTC(), false,
+		LoanOptions{Parties: []LoanParty{{UserID: spouse.ID, Role: PartyCoBorrower}}})
+	require.NoError(t, err)
+	_, err = AddLoanParty(db, ln.ID, parent.ID, PartyGuarantor)
+	require.NoError(t, err)
+
+	// Assert
+	parties, err := GetLoanParties(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, parties, 3)
+	require.Equal(t, []string{PartyPrimary, PartyCoBorrower, PartyGuarantor},
+		[]string{parties[0].Role, parties[1].Role, parties[2].Role})
+	require.Equal(t, []int64{borrower.ID, spouse.ID, parent.ID},
+		[]int64{parties[0].UserID, parties[1].UserID, parties[2].UserID})
+
+	own, err := GetLoansByUserID(db, parent.ID)
+	require.NoError(t, err)
+	require.Empty(t, own)
+	liable, err := GetLoansByUserID(db, parent.ID, IncludeAsParty)
+	require.NoError(t, err)
+	require.Len(t, liable, 1)
+	require.Equal(t, ln.ID, liable[0].ID)
+
+	_, err = AddLoanParty(db, ln.ID, parent.ID, PartyCoBorrower)
+	require.Error(t, err, "a User is a party to a Loan once")
+	_, err = AddLoanParty(db, ln.ID, borrower.ID, PartyGuarantor)
+	require.Error(t, err, "the primary borrower cannot guarantee their own Loan")
+	_, err = AddLoanParty(db, ln.ID, parent.ID, PartyPrimary)
+	require.Error(t, err)
+
+	require.NoError(t, RemoveLoanParty(db, ln.ID, parent.ID))
+	parties, err = GetLoanParties(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, parties, 2)
+}
+
+// TestMergeUsersMovesParties verifies the duplicate's roles move to the kept User or are dropped, and both are audited.
+func TestMergeUsersMovesParties(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, the duplicate guarantees one Loan and co-borrows one of the kept User's own
+	kept, err := CreateUser(db, "Kept Party", "keptparty@example.com", "555-0511")
+	require.NoError(t, err)
+	dup, err := CreateUser(db, "Kept Party", "keptparty2@example.com", "555-0512")
+	require.NoError(t, err)
+	other, err := CreateUser(db, "Other Borrower", "otherparty@example.com", "555-0513")
+	require.NoError(t, err)
+
+	guaranteed, err := AddLoanToExistingUserOptions(db, other.ID, 5000, 6, 24, 1, time.Now().UTC(), false,
+		LoanOptions{Parties: []LoanParty{{UserID: dup.ID, Role: PartyGuarantor}}})
+	require.NoError(t, err)
+	own, err := AddLoanToExistingUserOptions(db, kept.ID, 5000, 6, 24, 1, time.Now().UTC(), false,
+		LoanOptions{Parties: []LoanParty{{UserID: dup.ID, Role: PartyCoBorrower}}})
+	require.NoError(t, err)
+
+	// Act
+	_, err = MergeUsers(db, dup.ID, kept.ID)
+	require.NoError(t, err)
+
+	// Assert
+	parties, err := GetLoanParties(db, guaranteed.ID)
+	require.NoError(t, err)
+	require.Len(t, parties, 2)
+	require.Equal(t, kept.ID, parties[1].UserID)
+	parties, err = GetLoanParties(db, own.ID)
+	require.NoError(t, err)
+	require.Len(t, parties, 1)
+
+	entries, err := GetAuditLog(db, AuditFilter{EntityType: AuditLoanParty})
+	require.NoError(t, err)
+	var ops []string
+	for _, e := range entries {
+		if e.Reason != "" {
+			ops = append(ops, e.Operation)
+		}
+	}
+	require.ElementsMatch(t, []string{AuditUpdate, AuditDelete}, ops)
+}
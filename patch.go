@@ -0,0 +1,329 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UserPatch holds the User fields to change. Nil fields are left as they are.
+type UserPatch struct {
+	Name  *string
+	Email *string
+	Phone *string
+
+	DateOfBirth *time.Time
+	TimeZone    *string
+	Language    *string
+	Contact     *ContactPreferences
+}
+
+// LoanPatch holds the Loan fields to change. Nil fields are left as they are.
+type LoanPatch struct {
+	TotalAmount  *float64
+	InterestRate *float64
+	TermMonths   *int
+	DayDue       *int
+	Status       *string
+	DateTaken    *time.Time
+
+	Version int64 // when set, the patch fails with a *ConflictError unless the Loan is still at this version
+}
+
+// PaymentPatch holds the Payment fields to change. Nil fields are left as they are.
+type PaymentPatch struct {
+	PaymentNumber *int64
+	AmountDue     *float64
+	AmountPaid    *float64
+	DueDate       *time.Time
+	PaidDate      *time.Time
+
+	Version int64 // when set, the patch fails with a *ConflictError unless the Payment is still at this version
+}
+
+// loanStatuses are the statuses a Loan can be patched to.
+// A Loan is charged off or settled through ChargeOffLoan and the settlement workflow, which close out its ledger,
+// and it stays that way.
+var loanStatuses = map[string]bool{
+	LoanStatusActive:    true,
+	LoanStatusPaidOff:   true,
+	LoanStatusDefaulted: true,
+}
+
+// patchSet collects the SET clauses of a patch in the order the fields were supplied
+type patchSet struct {
+	clauses []string
+	args    []any
+}
+
+func (s *patchSet) set(column string, value any) {
+	s.args = append(s.args, value)
+	s.clauses = append(s.clauses, fmt.Sprintf("%s = $%d", column, len(s.args)))
+}
+
+// exec updates only the supplied columns of one row
+func (s *patchSet) exec(tx *sql.Tx, table string, id int64) error {
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d", table, strings.Join(s.clauses, ", "), len(s.args)+1)
+	_, err := tx.Exec(query, append(s.args, id)...)
+	return err
+}
+
+// PatchUser changes only the supplied fields of a User and returns the updated User.
+func PatchUser(db *sql.DB, userID int64, patch UserPatch) (User, error) {
+	return PatchUserContext(context.Background(), db, userID, patch)
+}
+
+// PatchUserContext is PatchUser, audited as the actor in ctx
+func PatchUserContext(ctx context.Context, db *sql.DB, userID int64, patch UserPatch) (User, error) {
+	var set patchSet
+	if patch.Name != nil {
+		if strings.TrimSpace(*patch.Name) == "" {
+			return User{}, fmt.Errorf("name cannot be empty")
+		}
+		set.set("name", *patch.Name)
+	}
+	if patch.Email != nil {
+		email, err := NormalizeEmail(*patch.Email)
+		if err != nil {
+			return User{}, err
+		}
+		set.set("email", email)
+	}
+	if patch.Phone != nil {
+		phone, err := NormalizePhone(*patch.Phone)
+		if err != nil {
+			return User{}, err
+		}
+		set.set("phone", phone)
+	}
+	if patch.DateOfBirth != nil {
+		if err := validateDateOfBirth(*patch.DateOfBirth); err != nil {
+			return User{}, err
+		}
+		set.set("date_of_birth", nullDate(UserProfile{DateOfBirth: *patch.DateOfBirth}.normalized().DateOfBirth))
+	}
+	if patch.TimeZone != nil {
+		if err := validateTimeZone(*patch.TimeZone); err != nil {
+			return User{}, err
+		}
+		set.set("time_zone", *patch.TimeZone)
+	}
+	if patch.Language != nil {
+		if err := validateLanguage(*patch.Language); err != nil {
+			return User{}, err
+		}
+		set.set("language", *patch.Language)
+	}
+	if patch.Contact != nil {
+		if err := patch.Contact.Validate(); err != nil {
+			return User{}, err
+		}
+		set.set("contact_channel", patch.Contact.Channel)
+		set.set("contact_opt_outs", patch.Contact.optedOutArray())
+	}
+
+	var after User
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		before, err := GetUserByID(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if len(set.clauses) == 0 {
+			after = before
+			return nil
+		}
+
+		if err := set.exec(tx, "users", before.ID); err != nil {
+			return fmt.Errorf("failed to update User: %w", err)
+		}
+
+		after, err = GetUserByID(tx, before.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, AuditUpdate, AuditUser, before.ID, before, after, "")
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return after, nil
+}
+
+// PatchLoan changes only the supplied fields of a Loan and returns the updated Loan.
+// The Loan's terms must still be valid once the patch is applied.
+func PatchLoan(db *sql.DB, loanID int64, patch LoanPatch) (Loan, error) {
+	return PatchLoanContext(context.Background(), db, loanID, patch)
+}
+
+// PatchLoanContext is PatchLoan, audited as the actor in ctx
+func PatchLoanContext(ctx context.Context, db *sql.DB, loanID int64, patch LoanPatch) (Loan, error) {
+	if patch.Status != nil && !loanStatuses[*patch.Status] {
+		return Loan{}, fmt.Errorf("unknown Loan status %q", *patch.Status)
+	}
+
+	var after Loan
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		if patch.Version != 0 {
+			if err := lockVersion(tx, "loans", "Loan", loanID, patch.Version); err != nil {
+				return err
+			}
+		}
+
+		before, err := GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if patch.Status != nil && *patch.Status != before.Status && before.closedOut() {
+			return fmt.Errorf("Loan %d is %s and cannot be reopened", loanID, before.Status)
+		}
+
+		var set patchSet
+		after = before
+		if patch.TotalAmount != nil {
+			after.TotalAmount = *patch.TotalAmount
+			set.set("total_amount", after.TotalAmount)
+		}
+		if patch.InterestRate != nil {
+			after.InterestRate = *patch.InterestRate
+			set.set("interest_rate", after.InterestRate)
+		}
+		if patch.TermMonths != nil {
+			after.TermMonths = *patch.TermMonths
+			set.set("term_months", after.TermMonths)
+		}
+		if patch.DayDue != nil {
+			after.DayDue = *patch.DayDue
+			set.set("day_due", after.DayDue)
+		}
+		if patch.Status != nil {
+			after.Status = *patch.Status
+			set.set("status", after.Status)
+		}
+		if patch.DateTaken != nil {
+			after.DateTaken = patch.DateTaken.UTC()
+			set.set("date_taken", after.DateTaken)
+		}
+
+		err = validateLoanParameters(after.TotalAmount, after.InterestRate, after.TermMonths, after.DayDue, after.DateTaken)
+		if err != nil {
+			return fmt.Errorf("invalid loan parameters: %w", err)
+		}
+
+		if len(set.clauses) == 0 {
+			after = before
+			return nil
+		}
+
+		if err := set.exec(tx, "loans", loanID); err != nil {
+			return fmt.Errorf("failed to update Loan: %w", err)
+		}
+
+		after, err = GetLoanByLoanID(tx, loanID)
+		if err != nil {
+			return err
+		}
+
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditLoan, loanID, before, after, ""); err != nil {
+			return err
+		}
+
+		// the principal lent and the rate it is split at live in the ledger too
+		if patch.TotalAmount != nil || patch.InterestRate != nil || patch.DateTaken != nil {
+			return syncLedger(tx, loanID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Loan{}, err
+	}
+
+	return after, nil
+}
+
+// PatchPayment changes only the supplied fields of a Payment and returns the updated Payment.
+func PatchPayment(db *sql.DB, paymentID int64, patch PaymentPatch) (Payment, error) {
+	return PatchPaymentContext(context.Background(), db, paymentID, patch)
+}
+
+// PatchPaymentContext is PatchPayment, audited as the actor in ctx
+func PatchPaymentContext(ctx context.Context, db *sql.DB, paymentID int64, patch PaymentPatch) (Payment, error) {
+	var set patchSet
+	if patch.PaymentNumber != nil {
+		if *patch.PaymentNumber <= 0 {
+			return Payment{}, fmt.Errorf("payment number must be positive, got %d", *patch.PaymentNumber)
+		}
+		set.set("payment_number", *patch.PaymentNumber)
+	}
+	if patch.AmountDue != nil {
+		if *patch.AmountDue < 0 {
+			return Payment{}, fmt.Errorf("amount due cannot be negative, got %.2f", *patch.AmountDue)
+		}
+		set.set("amount_due", *patch.AmountDue)
+	}
+	if patch.AmountPaid != nil {
+		if *patch.AmountPaid < 0 {
+			return Payment{}, fmt.Errorf("amount paid cannot be negative, got %.2f", *patch.AmountPaid)
+		}
+		set.set("amount_paid", *patch.AmountPaid)
+	}
+	if patch.DueDate != nil {
+		if patch.DueDate.IsZero() {
+			return Payment{}, fmt.Errorf("due date cannot be zero time")
+		}
+		set.set("due_date", patch.DueDate.UTC())
+	}
+	if patch.PaidDate != nil {
+		set.set("paid_date", patch.PaidDate.UTC())
+	}
+
+	var after Payment
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		if patch.Version != 0 {
+			if err := lockVersion(tx, "payments", "Payment", paymentID, patch.Version); err != nil {
+				return err
+			}
+		}
+
+		before, err := GetPaymentByID(tx, paymentID)
+		if err != nil {
+			return err
+		}
+
+		if len(set.clauses) == 0 {
+			after = before
+			return nil
+		}
+
+		if err := set.exec(tx, "payments", paymentID); err != nil {
+			return fmt.Errorf("failed to update Payment: %w", err)
+		}
+
+		after, err = GetPaymentByID(tx, paymentID)
+		if err != nil {
+			return err
+		}
+
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditPayment, paymentID, before, after, ""); err != nil {
+			return err
+		}
+
+		// the schedule and what was paid on it live in the ledger too
+		if patch.PaymentNumber != nil || patch.AmountDue != nil || patch.AmountPaid != nil || patch.DueDate != nil || patch.PaidDate != nil {
+			return syncLedger(tx, after.LoanID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return after, nil
+}
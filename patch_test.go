@@ -0,0 +1,103 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPatchUser verifies a patch changes only the fields it supplies.
+func TestPatchUser(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Patched User", "patched@example.com", "555-2626")
+	require.NoError(t, err)
+	phone := "555-2727"
+
+	// Act
+	patched, err := PatchUser(db, usr.ID, UserPatch{Phone: &phone})
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, "Patched User", patched.Name)
+	require.Equal(t, "patched@example.com", patched.Email)
+	require.Equal(t, "+15552727", patched.Phone)
+
+	bad := "not-an-email"
+	_, err = PatchUser(db, usr.ID, UserPatch{Email: &bad})
+	require.Error(t, err)
+}
+
+// TestPatchLoan verifies a patched Loan must still have valid terms.
+func TestPatchLoan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Patched Loan User", "patchedloan@example.com", "555-2828")
+	require.NoError(t, err)
+	ln, err := CreateLoan(db, usr.ID, 10000, 0.05, 36, 15, LoanStatusActive, calendarDate(2024, time.January, 1))
+	require.NoError(t, err)
+
+	// Act
+	status := LoanStatusDefaulted
+	patched, err := PatchLoan(db, ln.ID, LoanPatch{Status: &status, Version: ln.Version})
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusDefaulted, patched.Status)
+	require.Equal(t, ln.TotalAmount, patched.TotalAmount)
+	require.Equal(t, ln.Version+1, patched.Version)
+
+	dayDue := 32
+	_, err = PatchLoan(db, ln.ID, LoanPatch{DayDue: &dayDue})
+	require.Error(t, err)
+
+	unknown := "refinanced"
+	_, err = PatchLoan(db, ln.ID, LoanPatch{Status: &unknown})
+	require.Error(t, err)
+
+	// charging off goes through ChargeOffLoan, which closes out the ledger
+	chargedOff := LoanStatusChargedOff
+	_, err = PatchLoan(db, ln.ID, LoanPatch{Status: &chargedOff})
+	require.Error(t, err)
+
+	// the patch above was made against a version that is now stale
+	_, err = PatchLoan(db, ln.ID, LoanPatch{Status: &status, Version: ln.Version})
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+}
+
+// TestPatchPayment verifies a Payment patch leaves the fields it does not supply alone.
+func TestPatchPayment(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	user, err := InitializeUserWithLoan(db, "Patched Payment User", "patchedpayment@example.com", "555-2929",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	p := user.Loans[0].Payments[1]
+	paid, paidDate := 250.0, calendarDate(2024, time.March, 2)
+
+	// Act
+	patched, err := PatchPayment(db, p.ID, PaymentPatch{AmountPaid: &paid, PaidDate: &paidDate})
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, paid, patched.AmountPaid)
+	require.Equal(t, paidDate, patched.PaidDate)
+	require.Equal(t, p.AmountDue, patched.AmountDue)
+	require.Equal(t, p.DueDate, patched.DueDate)
+
+	diffs, err := ReconcileLedger(db, p.LoanID)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	negative := -1.0
+	_, err = PatchPayment(db, p.ID, PaymentPatch{AmountDue: &negative})
+	require.Error(t, err)
+}
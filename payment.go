@@ -11,4 +11,5 @@ type Payment struct {
 	DueDate       time.Time // when is this payment due
 	PaidDate      time.Time // when was this payment actually made (nil if unpaid)
 	CreatedAt     time.Time // when was this record created
+	Version       int64     // bumped on every change, see UpdatePayment
 }
@@ -0,0 +1,402 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Posted payment kinds
+const (
+	PostingPayment  = "payment"  // applied to the schedule and fees
+	PostingRecovery = "recovery" // received after charge-off, kept off the old schedule
+)
+
+// PostedPayment is money received from a borrower and how it was applied.
+type PostedPayment struct {
+	ID              int64               // unique identifier for the posted payment
+	LoanID          int64               // which loan the money was received for
+	Amount          float64             // how much was received
+	ReceivedDate    time.Time           // when the money was received
+	Kind            string              // "payment" or "recovery"
+	ChargeOffID     int64               // recovery: the charge-off it recovers against (0 otherwise)
+	UnappliedAmount float64             // what was left after everything owed was covered
+	Allocations     []PaymentAllocation // where the money went
+	Reversed        bool                // whether the payment came back unpaid
+	CreatedAt       time.Time           // when was this record created
+}
+
+// PaymentAllocation is the part of a posted payment applied to one installment or fee.
+type PaymentAllocation struct {
+	ID              int64   // unique identifier for the allocation
+	PostedPaymentID int64   // which posted payment the money came from
+	PaymentID       int64   // installment the money went to (0 for fees)
+	FeeID           int64   // fee the money went to (0 for installments)
+	Amount          float64 // how much was applied
+}
+
+// PostPayment records money received for a Loan.
+// On a charged-off Loan the money is tracked as a recovery and the schedule is left alone.
+// Otherwise it is applied to installments due by receivedDate, oldest first, then to
+// outstanding fees, then to future installments. A Loan with nothing left owing is marked paid off.
+func PostPayment(db *sql.DB, loanID int64, amount float64, receivedDate time.Time) (PostedPayment, error) {
+	return PostPaymentContext(context.Background(), db, loanID, amount, receivedDate)
+}
+
+// PostPaymentContext is PostPayment, audited as the actor in ctx.
+// With an idempotency key in ctx a retried payment is posted once and the retry gets the first posting back.
+func PostPaymentContext(ctx context.Context, db *sql.DB, loanID int64, amount float64, receivedDate time.Time) (PostedPayment, error) {
+	request := []any{loanID, amount, receivedDate.UTC()}
+	return idempotent(ctx, db, IdempotentPostPayment, request, func(tx *sql.Tx) (PostedPayment, error) {
+		return postPayment(ctx, tx, loanID, amount, receivedDate)
+	})
+}
+
+// postPayment applies a payment to a Loan inside the caller's transaction
+func postPayment(ctx context.Context, tx *sql.Tx, loanID int64, amount float64, receivedDate time.Time) (PostedPayment, error) {
+	if amount <= 0 {
+		return PostedPayment{}, fmt.Errorf("payment amount must be positive, got %.2f", amount)
+	}
+	if receivedDate.IsZero() {
+		return PostedPayment{}, fmt.Errorf("receivedDate cannot be zero time")
+	}
+
+	// concurrent postings on the same Loan wait here, so each one sees what the last applied
+	ln, err := lockLoan(tx, loanID)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+	if ln.Status == LoanStatusSettled {
+		return PostedPayment{}, fmt.Errorf("Loan %d is settled", loanID)
+	}
+
+	var pp PostedPayment
+	if ln.Status == LoanStatusChargedOff {
+		pp, err = postRecovery(tx, ln, amount, receivedDate)
+	} else {
+		pp, err = applyPayment(tx, ln, amount, receivedDate)
+	}
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	if err := recordAudit(ctx, tx, AuditPostPayment, AuditLoan, loanID, nil, pp, ""); err != nil {
+		return PostedPayment{}, err
+	}
+
+	return pp, nil
+}
+
+// postRecovery records money received on a charged-off Loan against its charge-off
+func postRecovery(tx *sql.Tx, ln Loan, amount float64, receivedDate time.Time) (PostedPayment, error) {
+	co, err := GetChargeOffByLoanID(tx, ln.ID)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	pp := PostedPayment{
+		LoanID:       ln.ID,
+		Amount:       amount,
+		ReceivedDate: receivedDate.UTC(),
+		Kind:         PostingRecovery,
+		ChargeOffID:  co.ID,
+	}
+
+	pp, err = createPostedPayment(tx, pp)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	err = postLedgerEntries(tx, LedgerEntry{LoanID: ln.ID, Type: EntryRecovery, DebitAccount: AccountCash,
+		CreditAccount: AccountRecoveryIncome, Amount: amount, PostedPaymentID: pp.ID, EffectiveDate: receivedDate})
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	return pp, nil
+}
+
+// applyPayment runs the payment waterfall and records where the money went
+func applyPayment(tx *sql.Tx, ln Loan, amount float64, receivedDate time.Time) (PostedPayment, error) {
+	fees, err := GetFeesByLoanID(tx, ln.ID)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	received := startOfDay(receivedDate)
+	remaining := amount
+	var allocations []PaymentAllocation
+
+	applyInstallments := func(due bool) {
+		for i, p := range ln.Payments {
+			if remaining <= 0 || startOfDay(p.DueDate).After(received) == due {
+				continue
+			}
+			owed := p.AmountDue - p.AmountPaid
+			if owed <= paymentTolerance {
+				continue
+			}
+			applied := min(owed, remaining)
+			ln.Payments[i].AmountPaid += applied
+			remaining -= applied
+			allocations = append(allocations, PaymentAllocation{PaymentID: p.ID, Amount: applied})
+		}
+	}
+
+	// Step 1: installments already due, oldest first
+	applyInstallments(true)
+
+	// Step 2: fees
+	for i, f := range fees {
+		owed := f.Outstanding()
+		if remaining <= 0 || owed <= paymentTolerance {
+			continue
+		}
+		applied := min(owed, remaining)
+		fees[i].AmountPaid += applied
+		remaining -= applied
+		allocations = append(allocations, PaymentAllocation{FeeID: f.ID, Amount: applied})
+	}
+
+	// Step 3: installments not due yet
+	applyInstallments(false)
+
+	for _, a := range allocations {
+		if a.PaymentID != 0 {
+			_, err = tx.Exec(`UPDATE payments SET amount_paid = amount_paid + $1, paid_date = $2 WHERE id = $3`,
+				a.Amount, receivedDate, a.PaymentID)
+		} else {
+			_, err = tx.Exec(`UPDATE loan_fees SET amount_paid = amount_paid + $1 WHERE id = $2`, a.Amount, a.FeeID)
+		}
+		if err != nil {
+			return PostedPayment{}, fmt.Errorf("failed to apply payment: %w", err)
+		}
+	}
+
+	pp := PostedPayment{
+		LoanID:          ln.ID,
+		Amount:          amount,
+		ReceivedDate:    receivedDate.UTC(),
+		Kind:            PostingPayment,
+		UnappliedAmount: remaining,
+		Allocations:     allocations,
+	}
+
+	pp, err = createPostedPayment(tx, pp)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	if err := postPaymentEntries(tx, ln, pp); err != nil {
+		return PostedPayment{}, err
+	}
+
+	if ln.Status == LoanStatusActive && isPaidOff(ln.Payments, fees) {
+		if _, err := tx.Exec(`UPDATE loans SET status = $1 WHERE id = $2`, LoanStatusPaidOff, ln.ID); err != nil {
+			return PostedPayment{}, fmt.Errorf("failed to mark Loan %d paid off: %w", ln.ID, err)
+		}
+	}
+
+	return pp, nil
+}
+
+// postPaymentEntries records in the ledger where a posted payment went
+func postPaymentEntries(tx execer, ln Loan, pp PostedPayment) error {
+	numbers := map[int64]int64{}
+	for _, p := range ln.Payments {
+		numbers[p.ID] = p.PaymentNumber
+	}
+
+	entries := []LedgerEntry{{LoanID: ln.ID, Type: EntryPayment, DebitAccount: AccountCash, CreditAccount: AccountUnapplied,
+		Amount: pp.UnappliedAmount, PostedPaymentID: pp.ID, EffectiveDate: pp.ReceivedDate}}
+	for _, a := range pp.Allocations {
+		e := LedgerEntry{LoanID: ln.ID, Type: EntryPayment, DebitAccount: AccountCash, Amount: a.Amount,
+			PostedPaymentID: pp.ID, EffectiveDate: pp.ReceivedDate}
+		if a.FeeID != 0 {
+			e.CreditAccount, e.FeeID = AccountFees, a.FeeID
+		} else {
+			e.CreditAccount, e.PaymentNumber = AccountDue, numbers[a.PaymentID]
+		}
+		entries = append(entries, e)
+	}
+
+	return postLedgerEntries(tx, entries...)
+}
+
+// isPaidOff reports whether every installment and fee has been paid in full
+func isPaidOff(payments []Payment, fees []Fee) bool {
+	for _, p := range payments {
+		if p.AmountDue-p.AmountPaid > paymentTolerance {
+			return false
+		}
+	}
+	return outstandingFees(fees) == 0
+}
+
+// createPostedPayment stores a posted payment and its allocations
+func createPostedPayment(tx execer, pp PostedPayment) (PostedPayment, error) {
+	var chargeOffID sql.NullInt64
+	if pp.ChargeOffID != 0 {
+		chargeOffID = sql.NullInt64{Int64: pp.ChargeOffID, Valid: true}
+	}
+
+	query := `
+	INSERT INTO posted_payments (loan_id, amount, received_date, kind, charge_off_id, unapplied_amount)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	err := tx.QueryRow(query, pp.LoanID, pp.Amount, pp.ReceivedDate, pp.Kind, chargeOffID, pp.UnappliedAmount).Scan(&pp.ID, &pp.CreatedAt)
+	if err != nil {
+		return PostedPayment{}, fmt.Errorf("failed to post payment: %w", err)
+	}
+	pp.CreatedAt = pp.CreatedAt.UTC()
+
+	for i := range pp.Allocations {
+		a := &pp.Allocations[i]
+		a.PostedPaymentID = pp.ID
+
+		var paymentID, feeID sql.NullInt64
+		if a.PaymentID != 0 {
+			paymentID = sql.NullInt64{Int64: a.PaymentID, Valid: true}
+		}
+		if a.FeeID != 0 {
+			feeID = sql.NullInt64{Int64: a.FeeID, Valid: true}
+		}
+
+		err := tx.QueryRow(`
+		INSERT INTO payment_allocations (posted_payment_id, payment_id, fee_id, amount)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+		`, pp.ID, paymentID, feeID, a.Amount).Scan(&a.ID)
+		if err != nil {
+			return PostedPayment{}, fmt.Errorf("failed to record payment allocation: %w", err)
+		}
+	}
+
+	return pp, nil
+}
+
+// postedPaymentColumns is the column list every PostedPayment query selects
+const postedPaymentColumns = `id, loan_id, amount, received_date, kind, COALESCE(charge_off_id, 0), unapplied_amount, reversed, created_at`
+
+// scanPostedPayment reads a row selected with postedPaymentColumns
+func scanPostedPayment(row rowScanner) (PostedPayment, error) {
+	var pp PostedPayment
+
+	err := row.Scan(
+		&pp.ID,
+		&pp.LoanID,
+		&pp.Amount,
+		&pp.ReceivedDate,
+		&pp.Kind,
+		&pp.ChargeOffID,
+		&pp.UnappliedAmount,
+		&pp.Reversed,
+		&pp.CreatedAt,
+	)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	pp.ReceivedDate = pp.ReceivedDate.UTC()
+	pp.CreatedAt = pp.CreatedAt.UTC()
+
+	return pp, nil
+}
+
+// getAllocations loads the allocations of a posted payment
+func getAllocations(db execer, postedPaymentID int64) ([]PaymentAllocation, error) {
+	query := `
+	SELECT id, posted_payment_id, COALESCE(payment_id, 0), COALESCE(fee_id, 0), amount
+	FROM payment_allocations
+	WHERE posted_payment_id = $1
+	ORDER BY id
+	`
+
+	rows, err := db.Query(query, postedPaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query allocations: %w", err)
+	}
+	defer rows.Close()
+
+	var allocations []PaymentAllocation
+
+	for rows.Next() {
+		var a PaymentAllocation
+		if err := rows.Scan(&a.ID, &a.PostedPaymentID, &a.PaymentID, &a.FeeID, &a.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan allocation row: %w", err)
+		}
+		allocations = append(allocations, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating allocation rows: %w", err)
+	}
+
+	return allocations, nil
+}
+
+// GetPostedPaymentByID returns a posted payment with its allocations.
+func GetPostedPaymentByID(db execer, postedPaymentID int64) (PostedPayment, error) {
+	query := `
+	SELECT ` + postedPaymentColumns + `
+	FROM posted_payments
+	WHERE id = $1
+	`
+
+	pp, err := scanPostedPayment(db.QueryRow(query, postedPaymentID))
+	if err == sql.ErrNoRows {
+		return PostedPayment{}, fmt.Errorf("posted payment with ID %d not found", postedPaymentID)
+	}
+	if err != nil {
+		return PostedPayment{}, fmt.Errorf("failed to get posted payment: %w", err)
+	}
+
+	pp.Allocations, err = getAllocations(db, pp.ID)
+	if err != nil {
+		return PostedPayment{}, err
+	}
+
+	return pp, nil
+}
+
+// GetPostedPaymentsByLoanID returns every payment received for a Loan, oldest first, with allocations.
+func GetPostedPaymentsByLoanID(db execer, loanID int64) ([]PostedPayment, error) {
+	query := `
+	SELECT ` + postedPaymentColumns + `
+	FROM posted_payments
+	WHERE loan_id = $1
+	ORDER BY received_date, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query posted payments for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var posted []PostedPayment
+
+	for rows.Next() {
+		pp, err := scanPostedPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan posted payment row: %w", err)
+		}
+		posted = append(posted, pp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating posted payment rows: %w", err)
+	}
+
+	for i := range posted {
+		posted[i].Allocations, err = getAllocations(db, posted[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return posted, nil
+}
@@ -0,0 +1,313 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones validate the same on hosts without a zoneinfo database
+
+	"github.com/lib/pq"
+)
+
+// Contact channels
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPhone = "phone"
+	ChannelMail  = "mail"
+)
+
+var contactChannels = map[string]bool{ChannelEmail: true, ChannelSMS: true, ChannelPhone: true, ChannelMail: true}
+
+// languageTag matches the language tags we store, e.g. "en", "es-MX" or "es-419"
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-([A-Z]{2}|[0-9]{3}))?$`)
+
+// usZIP matches a US ZIP or ZIP+4 code
+var usZIP = regexp.MustCompile(`^\d{5}(-\d{4})?$`)
+
+// oldestBorrower bounds how far back a date of birth can plausibly be
+const oldestBorrower = 130
+
+// UserProfile holds what we know about a borrower beyond their name, email and phone.
+// Zero fields are unknown.
+type UserProfile struct {
+	DateOfBirth time.Time          // calendar date of birth, midnight UTC
+	TimeZone    string             // IANA time zone the borrower lives in, e.g. "America/Chicago"
+	Language    string             // preferred language tag, e.g. "en" or "es-MX"
+	Contact     ContactPreferences // how the borrower wants to be reached
+}
+
+// ContactPreferences is how a borrower wants to be reached
+type ContactPreferences struct {
+	Channel  string   // preferred channel: "email", "sms", "phone" or "mail"
+	OptedOut []string // channels the borrower asked us not to use
+}
+
+// optedOutArray is OptedOut as a never-NULL text array
+func (c ContactPreferences) optedOutArray() any {
+	return pq.Array(append([]string{}, c.OptedOut...))
+}
+
+// Allows reports whether the borrower may be contacted on a channel
+func (c ContactPreferences) Allows(channel string) bool {
+	for _, ch := range c.OptedOut {
+		if ch == channel {
+			return false
+		}
+	}
+	return true
+}
+
+// Location returns the borrower's time zone, or UTC when it is unknown
+func (p UserProfile) Location() *time.Location {
+	if p.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// normalized returns the profile as it is stored: the date of birth as a UTC calendar date
+// and no opted-out channels as nil
+func (p UserProfile) normalized() UserProfile {
+	if !p.DateOfBirth.IsZero() {
+		p.DateOfBirth = calendarDate(p.DateOfBirth.Date())
+	}
+	if len(p.Contact.OptedOut) == 0 {
+		p.Contact.OptedOut = nil
+	}
+	return p
+}
+
+// nullDate stores a zero time as NULL
+func nullDate(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// Validate checks every known field of the profile
+func (p UserProfile) Validate() error {
+	if err := validateDateOfBirth(p.DateOfBirth); err != nil {
+		return err
+	}
+	if err := validateTimeZone(p.TimeZone); err != nil {
+		return err
+	}
+	if err := validateLanguage(p.Language); err != nil {
+		return err
+	}
+	return p.Contact.Validate()
+}
+
+// Validate checks the channels are ones we know and that the preferred one is not opted out of
+func (c ContactPreferences) Validate() error {
+	if c.Channel != "" && !contactChannels[c.Channel] {
+		return fmt.Errorf("unknown contact channel %q", c.Channel)
+	}
+	for _, ch := range c.OptedOut {
+		if !contactChannels[ch] {
+			return fmt.Errorf("unknown contact channel %q", ch)
+		}
+	}
+	if c.Channel != "" && !c.Allows(c.Channel) {
+		return fmt.Errorf("preferred contact channel %q is opted out of", c.Channel)
+	}
+	return nil
+}
+
+func validateDateOfBirth(dob time.Time) error {
+	if dob.IsZero() {
+		return nil
+	}
+	today := startOfDay(time.Now().UTC())
+	if !dob.Before(today) {
+		return fmt.Errorf("date of birth %s is not in the past", dob.Format("2006-01-02"))
+	}
+	if dob.Before(today.AddDate(-oldestBorrower, 0, 0)) {
+		return fmt.Errorf("date of birth %s is more than %d years ago", dob.Format("2006-01-02"), oldestBorrower)
+	}
+	return nil
+}
+
+func validateTimeZone(tz string) error {
+	if tz == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+		return fmt.Errorf("unknown time zone %q", tz)
+	}
+	return nil
+}
+
+func validateLanguage(lang string) error {
+	if lang != "" && !languageTag.MatchString(lang) {
+		return fmt.Errorf("invalid language tag %q, want e.g. \"en\" or \"es-MX\"", lang)
+	}
+	return nil
+}
+
+// Address is a mailing address a User had from ValidFrom until ValidTo
+type Address struct {
+	ID         int64     // unique identifier for the address
+	UserID     int64     // whose address it is
+	Line1      string    // street address
+	Line2      string    // apartment, suite, etc. (optional)
+	City       string    // city or locality
+	Region     string    // state, province or region
+	PostalCode string    // ZIP or postal code
+	Country    string    // ISO 3166-1 alpha-2 country code, e.g. "US"
+	ValidFrom  time.Time // when the User started receiving mail here
+	ValidTo    time.Time // when they stopped (zero for the current address)
+	CreatedAt  time.Time // when the address was recorded
+}
+
+// Validate checks the address has enough to deliver mail to
+func (a Address) Validate() error {
+	if strings.TrimSpace(a.Line1) == "" {
+		return fmt.Errorf("address line 1 cannot be empty")
+	}
+	if strings.TrimSpace(a.City) == "" {
+		return fmt.Errorf("address city cannot be empty")
+	}
+	if len(a.Country) != 2 || strings.ToUpper(a.Country) != a.Country {
+		return fmt.Errorf("address country must be an ISO 3166-1 alpha-2 code, got %q", a.Country)
+	}
+	if a.Country == "US" && !usZIP.MatchString(a.PostalCode) {
+		return fmt.Errorf("invalid US ZIP code %q", a.PostalCode)
+	}
+	return nil
+}
+
+// SetUserAddress makes addr the User's mailing address from validFrom on, closing the one it replaces.
+// The replaced address stays in the User's address history.
+func SetUserAddress(db *sql.DB, userID int64, addr Address, validFrom time.Time) (Address, error) {
+	return SetUserAddressContext(context.Background(), db, userID, addr, validFrom)
+}
+
+// SetUserAddressContext is SetUserAddress, audited as the actor in ctx
+func SetUserAddressContext(ctx context.Context, db *sql.DB, userID int64, addr Address, validFrom time.Time) (Address, error) {
+	if err := addr.Validate(); err != nil {
+		return Address{}, err
+	}
+	if validFrom.IsZero() {
+		return Address{}, fmt.Errorf("validFrom cannot be zero time")
+	}
+	validFrom = validFrom.UTC()
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		usr, err := GetUserByID(tx, userID)
+		if err != nil {
+			return err
+		}
+		userID = usr.ID
+
+		before, err := GetUserAddress(tx, userID)
+		if err != nil {
+			return err
+		}
+		if before.ID != 0 {
+			if validFrom.Before(before.ValidFrom) {
+				return fmt.Errorf("new address cannot start before the current one, which started %s", before.ValidFrom.Format("2006-01-02"))
+			}
+			if _, err := tx.Exec(`UPDATE user_addresses SET valid_to = $1 WHERE id = $2`, validFrom, before.ID); err != nil {
+				return fmt.Errorf("failed to close address of User %d: %w", userID, err)
+			}
+		}
+
+		query := `
+		INSERT INTO user_addresses (user_id, line1, line2, city, region, postal_code, country, valid_from)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+		`
+		err = tx.QueryRow(query, userID, addr.Line1, addr.Line2, addr.City, addr.Region, addr.PostalCode, addr.Country, validFrom).
+			Scan(&addr.ID, &addr.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to set address of User %d: %w", userID, err)
+		}
+		addr.UserID, addr.ValidFrom, addr.ValidTo = userID, validFrom, time.Time{}
+		addr.CreatedAt = addr.CreatedAt.UTC()
+
+		var previous any
+		if before.ID != 0 {
+			previous = before
+		}
+		return recordAudit(ctx, tx, AuditUpdate, AuditUser, userID, previous, addr, "address change")
+	})
+	if err != nil {
+		return Address{}, err
+	}
+
+	return addr, nil
+}
+
+const addressColumns = `id, user_id, line1, line2, city, region, postal_code, country, valid_from, valid_to, created_at`
+
+// scanAddress reads a row selected with addressColumns into an Address
+func scanAddress(row rowScanner) (Address, error) {
+	var a Address
+	var validTo sql.NullTime
+
+	err := row.Scan(&a.ID, &a.UserID, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country,
+		&a.ValidFrom, &validTo, &a.CreatedAt)
+	if err != nil {
+		return Address{}, err
+	}
+
+	a.ValidFrom = a.ValidFrom.UTC()
+	a.CreatedAt = a.CreatedAt.UTC()
+	if validTo.Valid {
+		a.ValidTo = validTo.Time.UTC()
+	}
+
+	return a, nil
+}
+
+// GetUserAddress returns a User's current mailing address, or a zero Address when none is on file.
+func GetUserAddress(db execer, userID int64) (Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM user_addresses WHERE user_id = $1 AND valid_to IS NULL`
+
+	a, err := scanAddress(db.QueryRow(query, userID))
+	if err == sql.ErrNoRows {
+		return Address{}, nil
+	}
+	if err != nil {
+		return Address{}, fmt.Errorf("failed to get address of User %d: %w", userID, err)
+	}
+
+	return a, nil
+}
+
+// GetUserAddressHistory returns every mailing address a User has had, oldest first.
+func GetUserAddressHistory(db execer, userID int64) ([]Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM user_addresses WHERE user_id = $1 ORDER BY valid_from, id`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query addresses of User %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var addresses []Address
+
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan address row: %w", err)
+		}
+		addresses = append(addresses, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating address rows: %w", err)
+	}
+
+	return addresses, nil
+}
@@ -0,0 +1,169 @@
+package delinquencytracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestUserProfileValidate verifies each profile field is checked.
+func TestUserProfileValidate(t *testing.T) {
+	valid := UserProfile{
+		DateOfBirth: calendarDate(1980, time.May, 17),
+		TimeZone:    "America/Chicago",
+		Language:    "es-MX",
+		Contact:     ContactPreferences{Channel: ChannelSMS, OptedOut: []string{ChannelPhone}},
+	}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, UserProfile{}.Validate(), "an unknown profile is valid")
+
+	tests := map[string]func(p *UserProfile){
+		"born tomorrow":        func(p *UserProfile) { p.DateOfBirth = time.Now().UTC().AddDate(0, 0, 1) },
+		"born too long ago":    func(p *UserProfile) { p.DateOfBirth = calendarDate(1850, time.January, 1) },
+		"unknown time zone":    func(p *UserProfile) { p.TimeZone = "Mars/Olympus_Mons" },
+		"bad language":         func(p *UserProfile) { p.Language = "Spanish" },
+		"unknown channel":      func(p *UserProfile) { p.Contact.Channel = "fax" },
+		"opted out of channel": func(p *UserProfile) { p.Contact.OptedOut = []string{ChannelSMS} },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := valid
+			mutate(&p)
+			require.Error(t, p.Validate())
+		})
+	}
+}
+
+// TestAddressValidate verifies an address needs a street, a city and a country code.
+func TestAddressValidate(t *testing.T) {
+	a := Address{Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"}
+	require.NoError(t, a.Validate())
+
+	noCity := a
+	noCity.City = ""
+	require.Error(t, noCity.Validate())
+
+	badZIP := a
+	badZIP.PostalCode = "6270"
+	require.Error(t, badZIP.Validate())
+
+	badCountry := a
+	badCountry.Country = "USA"
+	require.Error(t, badCountry.Validate())
+}
+
+// TestUserProfileStored verifies a profile is stored, read back and patched field by field.
+func TestUserProfileStored(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	profile := UserProfile{
+		DateOfBirth: calendarDate(1975, time.October, 3),
+		TimeZone:    "America/New_York",
+		Language:    "en",
+		Contact:     ContactPreferences{Channel: ChannelEmail, OptedOut: []string{ChannelSMS, ChannelPhone}},
+	}
+
+	// Act
+	usr, err := CreateUserWithProfile(db, "Profiled User", "profiled@example.com", "555-3030", profile)
+	require.NoError(t, err)
+
+	// Assert
+	got, err := GetUserByID(db, usr.ID)
+	require.NoError(t, err)
+	require.Equal(t, profile, got.Profile)
+	require.Equal(t, usr, got)
+
+	tz := "America/Los_Angeles"
+	patched, err := PatchUser(db, usr.ID, UserPatch{TimeZone: &tz})
+	require.NoError(t, err)
+	require.Equal(t, tz, patched.Profile.TimeZone)
+	require.Equal(t, profile.Contact, patched.Profile.Contact)
+
+	bad := "Nowhere/Special"
+	_, err = PatchUser(db, usr.ID, UserPatch{TimeZone: &bad})
+	require.Error(t, err)
+}
+
+// TestSetUserAddress verifies a move closes the old address and keeps it in the history.
+func TestSetUserAddress(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Moving User", "moving@example.com", "555-3131")
+	require.NoError(t, err)
+	first := Address{Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"}
+	second := Address{Line1: "9 Elm St", Line2: "Apt 2", City: "Chicago", Region: "IL", PostalCode: "60601", Country: "US"}
+
+	// Act
+	_, err = SetUserAddress(db, usr.ID, first, calendarDate(2020, time.January, 1))
+	require.NoError(t, err)
+	_, err = SetUserAddress(db, usr.ID, second, calendarDate(2023, time.June, 1))
+	require.NoError(t, err)
+
+	// Assert
+	full, err := GetFullUserByID(db, usr.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Chicago", full.Address.City)
+	require.True(t, full.Address.ValidTo.IsZero())
+
+	history, err := GetUserAddressHistory(db, usr.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, calendarDate(2023, time.June, 1), history[0].ValidTo)
+
+	_, err = SetUserAddress(db, usr.ID, first, calendarDate(2022, time.January, 1))
+	require.Error(t, err, "a new address cannot start before the current one")
+}
+
+// TestProfileHandlers verifies the profile and addresses of a User are read and changed over HTTP.
+func TestProfileHandlers(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Handler User", "profile.handler@example.com", "555-3232")
+	require.NoError(t, err)
+	handler := NewHandler(db)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+	profile := fmt.Sprintf("/users/%d/profile", usr.ID)
+	addresses := fmt.Sprintf("/users/%d/addresses", usr.ID)
+
+	// Act
+	rec := serve(http.MethodPatch, profile, `{"Language": "es-MX", "Contact": {"Channel": "sms"}}`)
+
+	// Assert
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serve(http.MethodGet, profile, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got UserProfile
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.Equal(t, "es-MX", got.Language)
+	require.Equal(t, ChannelSMS, got.Contact.Channel)
+
+	rec = serve(http.MethodPost, addresses, `{"Line1": "1 Main St", "City": "Springfield", "PostalCode": "62701", "Country": "US", "ValidFrom": "2023-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = serve(http.MethodGet, addresses, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var history []Address
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	require.Len(t, history, 1)
+	require.Equal(t, calendarDate(2023, time.January, 1), history[0].ValidFrom)
+
+	require.Equal(t, http.StatusBadRequest, serve(http.MethodPatch, profile, `{"Language": "Spanish"}`).Code)
+	require.Equal(t, http.StatusBadRequest, serve(http.MethodPatch, profile, `{"Name": "Someone Else"}`).Code, "only profile fields")
+	require.Equal(t, http.StatusBadRequest, serve(http.MethodPost, addresses, `{"Line1": "1 Main St"}`).Code)
+	require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/users/999999/profile", "").Code)
+}
@@ -0,0 +1,313 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Promise-to-pay statuses
+const (
+	PromisePending       = "pending"        // the promised day has not passed and it is not paid yet
+	PromiseKept          = "kept"           // the promised amount was paid by the promised day
+	PromisePartiallyKept = "partially_kept" // some but not all of it was paid by then
+	PromiseBroken        = "broken"         // nothing was paid by then
+)
+
+// PromiseToPay is a borrower's promise to pay an amount on a Loan by a day, e.g. "$300 on the 20th".
+// While it is pending and the day has not passed, collection actions on the Loan hold off.
+type PromiseToPay struct {
+	ID           int64     // unique identifier for the promise
+	LoanID       int64     // the Loan the money is promised for
+	CaseID       int64     // the collection case open when it was made (0 if none)
+	UserID       int64     // the party who promised
+	Collector    string    // who took the promise
+	Amount       float64   // how much was promised
+	MadeOn       time.Time // the day the promise was made; payments from then on count towards it
+	PromisedDate time.Time // the day it is to be paid by
+	Status       string    // "pending", "kept", "partially_kept" or "broken"
+	AmountPaid   float64   // what was paid towards it when it was last evaluated
+	EvaluatedAt  time.Time // when it was settled as kept, partially kept or broken (zero while pending)
+	CreatedAt    time.Time // when was this record created
+}
+
+// RecordPromise records a promise to pay on a Loan, linked to its open collection case if it has one.
+// The User defaults to the primary borrower and must be a party to the Loan, and MadeOn defaults to today.
+// A Loan has at most one pending promise at a time.
+func RecordPromise(db *sql.DB, promise PromiseToPay) (PromiseToPay, error) {
+	return RecordPromiseContext(context.Background(), db, promise)
+}
+
+// RecordPromiseContext is RecordPromise, audited as the actor in ctx.
+// The collector defaults to the actor.
+func RecordPromiseContext(ctx context.Context, db *sql.DB, promise PromiseToPay) (PromiseToPay, error) {
+	if promise.Amount <= 0 {
+		return PromiseToPay{}, fmt.Errorf("promised amount must be positive, got %.2f", promise.Amount)
+	}
+	if promise.PromisedDate.IsZero() {
+		return PromiseToPay{}, fmt.Errorf("a promise needs the day it is to be paid by")
+	}
+	if promise.MadeOn.IsZero() {
+		promise.MadeOn = time.Now()
+	}
+	promise.MadeOn, promise.PromisedDate = startOfDay(promise.MadeOn), startOfDay(promise.PromisedDate)
+	if promise.PromisedDate.Before(promise.MadeOn) {
+		return PromiseToPay{}, fmt.Errorf("promised date %s is before the promise was made on %s",
+			promise.PromisedDate.Format("2006-01-02"), promise.MadeOn.Format("2006-01-02"))
+	}
+	if promise.Collector == "" {
+		promise.Collector = ActorFromContext(ctx)
+	}
+	promise.Status = PromisePending
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		ln, err := GetLoanByLoanID(tx, promise.LoanID)
+		if err != nil {
+			return err
+		}
+		if ln.Status == LoanStatusPaidOff {
+			return fmt.Errorf("Loan %d is paid off", ln.ID)
+		}
+
+		parties, err := GetLoanParties(tx, ln.ID)
+		if err != nil {
+			return err
+		}
+		if promise.UserID == 0 {
+			promise.UserID = parties[0].UserID
+		}
+		if !isParty(parties, promise.UserID) {
+			return fmt.Errorf("User %d is not a party to Loan %d", promise.UserID, ln.ID)
+		}
+
+		var pending int64
+		err = tx.QueryRow(`SELECT id FROM promises_to_pay WHERE loan_id = $1 AND status = $2`, ln.ID, PromisePending).Scan(&pending)
+		if err == nil {
+			return fmt.Errorf("Loan %d already has pending promise %d", ln.ID, pending)
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to check promises of Loan %d: %w", ln.ID, err)
+		}
+
+		open, err := getOpenCase(tx, ln.ID)
+		if err != nil {
+			return err
+		}
+		promise.CaseID = open.ID
+
+		err = tx.QueryRow(`
+		INSERT INTO promises_to_pay (loan_id, case_id, user_id, collector, amount, made_on, promised_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`, promise.LoanID, sql.NullInt64{Int64: promise.CaseID, Valid: promise.CaseID != 0}, promise.UserID, promise.Collector, promise.Amount, promise.MadeOn, promise.PromisedDate).
+			Scan(&promise.ID, &promise.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record promise on Loan %d: %w", promise.LoanID, err)
+		}
+		promise.CreatedAt = promise.CreatedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditCreate, AuditPromise, promise.ID, nil, promise, "")
+	})
+	if err != nil {
+		return PromiseToPay{}, err
+	}
+
+	return promise, nil
+}
+
+// EvaluatePromises settles pending promises against the payments posted for their loans as of a day.
+// A promise is kept as soon as the promised amount has been paid. Once the promised day has passed,
+// one paid in part is partially kept and one with nothing paid is broken. Reversed payments do not count.
+// It returns the promises it settled.
+func EvaluatePromises(db *sql.DB, asOf time.Time) ([]PromiseToPay, error) {
+	return EvaluatePromisesContext(context.Background(), db, asOf)
+}
+
+// EvaluatePromisesContext is EvaluatePromises, audited as the actor in ctx.
+func EvaluatePromisesContext(ctx context.Context, db *sql.DB, asOf time.Time) ([]PromiseToPay, error) {
+	var settled []PromiseToPay
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		pending, err := queryPromises(tx, `WHERE status = $1 AND made_on <= $2 ORDER BY id FOR UPDATE`, PromisePending, startOfDay(asOf))
+		if err != nil {
+			return err
+		}
+
+		for _, before := range pending {
+			after, err := evaluatePromise(tx, before, asOf)
+			if err != nil {
+				return err
+			}
+			if after.Status == PromisePending {
+				continue
+			}
+
+			err = tx.QueryRow(`
+			UPDATE promises_to_pay SET status = $1, amount_paid = $2, evaluated_at = NOW()
+			WHERE id = $3
+			RETURNING evaluated_at
+			`, after.Status, after.AmountPaid, after.ID).Scan(&after.EvaluatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to settle promise %d: %w", after.ID, err)
+			}
+			after.EvaluatedAt = after.EvaluatedAt.UTC()
+
+			reason := fmt.Sprintf("paid %.2f of %.2f promised by %s", after.AmountPaid, after.Amount, after.PromisedDate.Format("2006-01-02"))
+			if err := recordAudit(ctx, tx, AuditUpdate, AuditPromise, after.ID, before, after, reason); err != nil {
+				return err
+			}
+			settled = append(settled, after)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return settled, nil
+}
+
+// evaluatePromise works out the status of a pending promise from the payments received
+// between the day it was made and the promised day, as of a day
+func evaluatePromise(tx *sql.Tx, p PromiseToPay, asOf time.Time) (PromiseToPay, error) {
+	day := startOfDay(asOf)
+	until := p.PromisedDate
+	if day.Before(until) {
+		until = day
+	}
+
+	err := tx.QueryRow(`
+	SELECT COALESCE(SUM(amount), 0)
+	FROM posted_payments
+	WHERE loan_id = $1 AND NOT reversed AND received_date >= $2 AND received_date < $3
+	`, p.LoanID, p.MadeOn, until.AddDate(0, 0, 1)).Scan(&p.AmountPaid)
+	if err != nil {
+		return PromiseToPay{}, fmt.Errorf("failed to sum payments towards promise %d: %w", p.ID, err)
+	}
+
+	switch {
+	case p.AmountPaid >= p.Amount-paymentTolerance:
+		p.Status = PromiseKept
+	case !day.After(p.PromisedDate):
+		p.Status = PromisePending
+	case p.AmountPaid > paymentTolerance:
+		p.Status = PromisePartiallyKept
+	default:
+		p.Status = PromiseBroken
+	}
+
+	return p, nil
+}
+
+// hasActivePromise reports whether a Loan has a pending promise whose day has not passed as of a day
+func hasActivePromise(db execer, loanID int64, asOf time.Time) (bool, error) {
+	var active bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM promises_to_pay WHERE loan_id = $1 AND status = $2 AND promised_date >= $3)`,
+		loanID, PromisePending, startOfDay(asOf)).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check promises of Loan %d: %w", loanID, err)
+	}
+	return active, nil
+}
+
+// GetPromisesByLoanID returns the promises made on a Loan, oldest first.
+func GetPromisesByLoanID(db execer, loanID int64) ([]PromiseToPay, error) {
+	return queryPromises(db, `WHERE loan_id = $1 ORDER BY made_on, id`, loanID)
+}
+
+const promiseColumns = `id, loan_id, case_id, user_id, collector, amount, made_on, promised_date,
+	status, amount_paid, evaluated_at, created_at`
+
+// queryPromises selects promises with the conditions and ordering in tail
+func queryPromises(db execer, tail string, args ...any) ([]PromiseToPay, error) {
+	rows, err := db.Query(`SELECT `+promiseColumns+` FROM promises_to_pay `+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promises: %w", err)
+	}
+	defer rows.Close()
+
+	promises := []PromiseToPay{}
+
+	for rows.Next() {
+		var p PromiseToPay
+		var caseID sql.NullInt64
+		var evaluatedAt sql.NullTime
+		err := rows.Scan(&p.ID, &p.LoanID, &caseID, &p.UserID, &p.Collector, &p.Amount, &p.MadeOn, &p.PromisedDate,
+			&p.Status, &p.AmountPaid, &evaluatedAt, &p.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan promise row: %w", err)
+		}
+		p.CaseID = caseID.Int64
+		p.MadeOn, p.PromisedDate, p.CreatedAt = p.MadeOn.UTC(), p.PromisedDate.UTC(), p.CreatedAt.UTC()
+		if evaluatedAt.Valid {
+			p.EvaluatedAt = evaluatedAt.Time.UTC()
+		}
+		promises = append(promises, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating promise rows: %w", err)
+	}
+
+	return promises, nil
+}
+
+// PromiseRate is how well the promises one collector took were kept
+type PromiseRate struct {
+	Collector      string
+	Promises       int     // promises due in the period
+	Kept           int     // paid in full
+	PartiallyKept  int     // paid in part
+	Broken         int     // not paid at all
+	Pending        int     // not settled yet
+	AmountPromised float64 // total promised
+	AmountPaid     float64 // total paid towards them
+	KeptRate       float64 // kept over settled promises, from 0 to 1
+}
+
+// PromiseKeptRates reports, per collector, how many of the promises due between two days were kept,
+// partially kept or broken. Run EvaluatePromises first so the period's promises are settled.
+func PromiseKeptRates(db execer, from, to time.Time) ([]PromiseRate, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("report end %s is before start %s", to.Format("2006-01-02"), from.Format("2006-01-02"))
+	}
+
+	rows, err := db.Query(`
+	SELECT collector, COUNT(*),
+		COUNT(*) FILTER (WHERE status = $1),
+		COUNT(*) FILTER (WHERE status = $2),
+		COUNT(*) FILTER (WHERE status = $3),
+		COUNT(*) FILTER (WHERE status = $4),
+		SUM(amount), SUM(amount_paid)
+	FROM promises_to_pay
+	WHERE promised_date >= $5 AND promised_date < $6
+	GROUP BY collector
+	ORDER BY collector
+	`, PromiseKept, PromisePartiallyKept, PromiseBroken, PromisePending, startOfDay(from), startOfDay(to).AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query promise rates: %w", err)
+	}
+	defer rows.Close()
+
+	rates := []PromiseRate{}
+
+	for rows.Next() {
+		var r PromiseRate
+		err := rows.Scan(&r.Collector, &r.Promises, &r.Kept, &r.PartiallyKept, &r.Broken, &r.Pending, &r.AmountPromised, &r.AmountPaid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan promise rate row: %w", err)
+		}
+		if settled := r.Kept + r.PartiallyKept + r.Broken; settled > 0 {
+			r.KeptRate = float64(r.Kept) / float64(settled)
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating promise rate rows: %w", err)
+	}
+
+	return rates, nil
+}
@@ -0,0 +1,136 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPromisesToPay verifies promises are settled from the payments posted by their day,
+// hold off collection actions while active and are reported per collector.
+func TestPromisesToPay(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, two loans whose first installment, due February 1st, was missed
+	first, err := InitializeUserWithLoan(db, "Promising User", "promising@example.com", "555-0701",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	worked := first.Loans[0]
+	second, err := InitializeUserWithLoan(db, "Quiet User", "quiet@example.com", "555-0702",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	held := second.Loans[0]
+
+	run, err := RunCollections(db, calendarDate(2024, time.February, 3), 0)
+	require.NoError(t, err)
+	require.Empty(t, run.Opened)
+
+	// a promise before the threshold keeps a case from being opened
+	broken, err := RecordPromise(db, PromiseToPay{LoanID: held.ID, Collector: "bob", Amount: 100,
+		MadeOn: calendarDate(2024, time.February, 3), PromisedDate: calendarDate(2024, time.February, 20)})
+	require.NoError(t, err)
+	require.Equal(t, second.ID, broken.UserID, "the primary borrower promises by default")
+	require.Equal(t, PromisePending, broken.Status)
+
+	run, err = RunCollections(db, calendarDate(2024, time.February, 15), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Opened, 1)
+	require.Equal(t, worked.ID, run.Opened[0].LoanID)
+	caseID := run.Opened[0].ID
+
+	// Act, a promise on the open case takes it off the work queue until its day
+	partial, err := RecordPromise(db, PromiseToPay{LoanID: worked.ID, Collector: "alice", Amount: 500,
+		MadeOn: calendarDate(2024, time.February, 15), PromisedDate: calendarDate(2024, time.February, 20)})
+	require.NoError(t, err)
+	require.Equal(t, caseID, partial.CaseID)
+
+	_, err = RecordPromise(db, PromiseToPay{LoanID: worked.ID, Collector: "alice", Amount: 50,
+		MadeOn: calendarDate(2024, time.February, 15), PromisedDate: calendarDate(2024, time.February, 25)})
+	require.Error(t, err, "a Loan has one pending promise at a time")
+
+	queue, err := GetWorkQueue(db, WorkQueueFilter{AsOf: calendarDate(2024, time.February, 18)})
+	require.NoError(t, err)
+	require.Empty(t, queue)
+	queue, err = GetWorkQueue(db, WorkQueueFilter{AsOf: calendarDate(2024, time.February, 18), IncludePromised: true})
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+
+	_, err = PostPayment(db, worked.ID, 300, calendarDate(2024, time.February, 19))
+	require.NoError(t, err)
+
+	run, err = RunCollections(db, calendarDate(2024, time.February, 18), 0)
+	require.NoError(t, err)
+	require.Empty(t, run.Promises, "nothing is settled before the promised day unless paid in full")
+
+	// Act, the promised day has passed
+	run, err = RunCollections(db, calendarDate(2024, time.February, 21), 0)
+	require.NoError(t, err)
+
+	// Assert
+	require.Len(t, run.Promises, 2)
+	require.Equal(t, PromisePartiallyKept, run.Promises[1].Status)
+	require.InDelta(t, 300, run.Promises[1].AmountPaid, 0.001)
+	require.Equal(t, PromiseBroken, run.Promises[0].Status)
+	require.Len(t, run.Opened, 1, "the broken promise no longer holds off a case")
+	require.Equal(t, held.ID, run.Opened[0].LoanID)
+
+	// Act, a promise paid in full is kept before its day
+	kept, err := RecordPromise(db, PromiseToPay{LoanID: worked.ID, Collector: "alice", Amount: 200,
+		MadeOn: calendarDate(2024, time.February, 21), PromisedDate: calendarDate(2024, time.February, 28)})
+	require.NoError(t, err)
+	_, err = PostPayment(db, worked.ID, 200, calendarDate(2024, time.February, 23))
+	require.NoError(t, err)
+	settled, err := EvaluatePromises(db, calendarDate(2024, time.February, 24))
+	require.NoError(t, err)
+	require.Len(t, settled, 1)
+	require.Equal(t, kept.ID, settled[0].ID)
+	require.Equal(t, PromiseKept, settled[0].Status)
+	require.False(t, settled[0].EvaluatedAt.IsZero())
+
+	// Assert, the report
+	rates, err := PromiseKeptRates(db, calendarDate(2024, time.February, 1), calendarDate(2024, time.February, 29))
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	require.Equal(t, "alice", rates[0].Collector)
+	require.Equal(t, 2, rates[0].Promises)
+	require.Equal(t, 1, rates[0].Kept)
+	require.Equal(t, 1, rates[0].PartiallyKept)
+	require.InDelta(t, 0.5, rates[0].KeptRate, 0.001)
+	require.InDelta(t, 700, rates[0].AmountPromised, 0.001)
+	require.Equal(t, "bob", rates[1].Collector)
+	require.Equal(t, 1, rates[1].Broken)
+	require.Equal(t, 0.0, rates[1].KeptRate)
+
+	promises, err := GetPromisesByLoanID(db, worked.ID)
+	require.NoError(t, err)
+	require.Len(t, promises, 2)
+}
+
+// TestRecordPromiseValidation verifies promises that cannot be kept track of are refused.
+func TestRecordPromiseValidation(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Validated User", "validated@example.com", "555-0711",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	stranger, err := CreateUser(db, "Stranger", "stranger@example.com", "555-0712")
+	require.NoError(t, err)
+
+	day := calendarDate(2024, time.February, 10)
+	tests := map[string]PromiseToPay{
+		"no amount":        {LoanID: user.Loans[0].ID, MadeOn: day, PromisedDate: day},
+		"no day":           {LoanID: user.Loans[0].ID, MadeOn: day, Amount: 100},
+		"day already gone": {LoanID: user.Loans[0].ID, MadeOn: day, PromisedDate: day.AddDate(0, 0, -1), Amount: 100},
+		"not a party":      {LoanID: user.Loans[0].ID, UserID: stranger.ID, MadeOn: day, PromisedDate: day, Amount: 100},
+		"no such Loan":     {LoanID: -1, MadeOn: day, PromisedDate: day, Amount: 100},
+	}
+	for name, p := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := RecordPromise(db, p)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateTerms describes how the rate of an adjustable-rate Loan follows an index.
+// A Loan with an empty IndexName has a fixed rate and the other fields are ignored.
+type RateTerms struct {
+	IndexName        string  // the rate index the Loan references ("" for fixed-rate loans)
+	Margin           float64 // added to the index value on every reset (0.0275 for 2.75%)
+	FirstResetMonths int     // installments paid at the initial rate before the first reset
+	ResetMonths      int     // installments between resets after the first one
+	PeriodicCap      float64 // largest increase allowed at a single reset (0 for no cap)
+	PeriodicFloor    float64 // largest decrease allowed at a single reset (0 for no floor)
+	LifetimeCap      float64 // highest rate the Loan can ever carry (0 for no cap)
+	LifetimeFloor    float64 // lowest rate the Loan can ever carry
+}
+
+// RateIndexValue is the value an index published on a given date.
+type RateIndexValue struct {
+	Date time.Time // when the value took effect
+	Rate float64   // the index value (0.0525 for 5.25%)
+}
+
+// RateChange is one entry of a Loan's rate history.
+// The first entry holds the origination rate, each later entry is a reset.
+type RateChange struct {
+	ID            int64     // unique identifier for the rate change
+	LoanID        int64     // which loan the rate applies to
+	FromPayment   int64     // first installment charged at this rate
+	EffectiveDate time.Time // when the rate took effect
+	IndexValue    float64   // index value used (0 for fixed or initial rates)
+	Margin        float64   // margin added to the index
+	Rate          float64   // resulting annual rate after caps and floors
+	CreatedAt     time.Time // when was this record created
+}
+
+// IsVariable reports whether the terms reference a rate index.
+func (r RateTerms) IsVariable() bool {
+	return r.IndexName != ""
+}
+
+// validate checks the terms against the Loan they are attached to.
+func (r RateTerms) validate(termMonths int, schedule ScheduleConfig) error {
+	if !r.IsVariable() {
+		return nil
+	}
+
+	if r.FirstResetMonths <= 0 || r.FirstResetMonths >= termMonths {
+		return fmt.Errorf("first reset must be between 1 and %d months, got %d", termMonths-1, r.FirstResetMonths)
+	}
+	if r.ResetMonths <= 0 {
+		return fmt.Errorf("reset months must be positive, got %d", r.ResetMonths)
+	}
+	if r.PeriodicCap < 0 || r.PeriodicFloor < 0 || r.LifetimeCap < 0 || r.LifetimeFloor < 0 {
+		return fmt.Errorf("rate caps and floors cannot be negative")
+	}
+	if r.LifetimeCap > 0 && r.LifetimeCap < r.LifetimeFloor {
+		return fmt.Errorf("lifetime cap %.4f is below lifetime floor %.4f", r.LifetimeCap, r.LifetimeFloor)
+	}
+	if schedule.Type == ScheduleGraduated {
+		return fmt.Errorf("graduated schedules cannot carry a variable rate")
+	}
+
+	return nil
+}
+
+// adjust returns the rate for a reset from the current rate and the new index value,
+// applying the periodic limits first and the lifetime limits last.
+func (r RateTerms) adjust(currentRate, indexValue float64) float64 {
+	rate := indexValue + r.Margin
+
+	if r.PeriodicCap > 0 && rate > currentRate+r.PeriodicCap {
+		rate = currentRate + r.PeriodicCap
+	}
+	if r.PeriodicFloor > 0 && rate < currentRate-r.PeriodicFloor {
+		rate = currentRate - r.PeriodicFloor
+	}
+	if r.LifetimeCap > 0 && rate > r.LifetimeCap {
+		rate = r.LifetimeCap
+	}
+	if rate < r.LifetimeFloor {
+		rate = r.LifetimeFloor
+	}
+	if rate < 0 {
+		rate = 0
+	}
+
+	return rate
+}
+
+// resetPayments returns the installment numbers at which the rate resets.
+// The new rate applies from the installment after each returned number.
+func (r RateTerms) resetPayments(termMonths int) []int {
+	if !r.IsVariable() {
+		return nil
+	}
+
+	var resets []int
+	for m := r.FirstResetMonths; m < termMonths; m += r.ResetMonths {
+		resets = append(resets, m)
+	}
+
+	return resets
+}
+
+// RateResetDates returns the dates on which the Loan's rate resets.
+func RateResetDates(ln Loan) []time.Time {
+	var dates []time.Time
+	for _, m := range ln.Rate.resetPayments(ln.TermMonths) {
+		dates = append(dates, calculateDueDate(ln.DateTaken, m, ln.DayDue))
+	}
+
+	return dates
+}
+
+// remaining returns the schedule structure that applies to the installments left after elapsed months.
+func (c ScheduleConfig) remaining(elapsed int) (ScheduleConfig, error) {
+	c = c.normalized()
+
+	switch c.Type {
+	case ScheduleInterestOnly:
+		c.InterestOnlyMonths -= elapsed
+		if c.InterestOnlyMonths < 0 {
+			c.InterestOnlyMonths = 0
+		}
+	case ScheduleBalloon:
+		c.AmortizationMonths -= elapsed
+	case ScheduleGraduated:
+		return ScheduleConfig{}, fmt.Errorf("graduated schedules cannot be re-amortized")
+	}
+
+	return c, nil
+}
+
+// buildSchedule reproduces a Loan's installments from its rate history.
+// The schedule is generated at the first rate, and at each later change the balance
+// still owed is re-amortized at the new rate over the installments that are left.
+func buildSchedule(ln Loan, history []RateChange) ([]Payment, error) {
+	if len(history) == 0 {
+		return nil, fmt.Errorf("Loan %d has no rate history", ln.ID)
+	}
+
+	gen, err := ln.Schedule.Generator()
+	if err != nil {
+		return nil, err
+	}
+	payments, err := gen.Generate(ln.TotalAmount, history[0].Rate, ln.TermMonths, ln.DayDue, ln.DateTaken)
+	if err != nil {
+		return nil, err
+	}
+
+	for h := 1; h < len(history); h++ {
+		elapsed := int(history[h].FromPayment - 1)
+
+		// roll the balance forward through the installments already charged
+		balance := ln.TotalAmount
+		for i := 0; i < elapsed; i++ {
+			rate := rateForPayment(history[:h], int64(i+1))
+			balance = balance*(1+rate/12) - payments[i].AmountDue
+		}
+
+		cfg, err := ln.Schedule.remaining(elapsed)
+		if err != nil {
+			return nil, err
+		}
+		gen, err := cfg.Generator()
+		if err != nil {
+			return nil, err
+		}
+
+		start := calculateDueDate(ln.DateTaken, elapsed, ln.DayDue)
+		rest, err := gen.Generate(balance, history[h].Rate, ln.TermMonths-elapsed, ln.DayDue, start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-amortize from Payment %d: %w", history[h].FromPayment, err)
+		}
+
+		for i := range rest {
+			rest[i].PaymentNumber += int64(elapsed)
+		}
+		payments = append(payments[:elapsed], rest...)
+	}
+
+	if err := adjustDueDates(payments, ln.BusinessDayConvention, ln.HolidayCalendar); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// rateForPayment returns the rate charged on an installment according to the history.
+func rateForPayment(history []RateChange, paymentNumber int64) float64 {
+	rate := history[0].Rate
+	for _, h := range history {
+		if h.FromPayment <= paymentNumber {
+			rate = h.Rate
+		}
+	}
+
+	return rate
+}
+
+// LoadRateIndexCSV reads index values from a CSV file of "date,rate" rows.
+// Dates use the 2006-01-02 format, a header row is optional.
+func LoadRateIndexCSV(path string) ([]RateIndexValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rate index file: %w", err)
+	}
+	defer f.Close()
+
+	return parseRateIndexCSV(f)
+}
+
+func parseRateIndexCSV(r io.Reader) ([]RateIndexValue, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var values []RateIndexValue
+	line := 0
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rate index: %w", err)
+		}
+		line++
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[0])
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, record[1])
+		}
+
+		values = append(values, RateIndexValue{Date: date, Rate: rate})
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i].Date.Before(values[j].Date) })
+
+	return values, nil
+}
+
+// ImportRateIndexCSV loads a CSV of index values into the rate index table.
+// Values already stored for the same date are overwritten. It returns how many rows were imported.
+func ImportRateIndexCSV(db *sql.DB, indexName, path string) (int, error) {
+	return ImportRateIndexCSVContext(context.Background(), db, indexName, path)
+}
+
+// ImportRateIndexCSVContext is ImportRateIndexCSV, audited as the actor in ctx.
+func ImportRateIndexCSVContext(ctx context.Context, db *sql.DB, indexName, path string) (int, error) {
+	values, err := LoadRateIndexCSV(path)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+	INSERT INTO rate_index_values (index_name, effective_date, rate)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (index_name, effective_date) DO UPDATE SET rate = EXCLUDED.rate
+	`
+
+	err = inTx(db, func(tx *sql.Tx) error {
+		for _, v := range values {
+			if _, err := tx.Exec(query, indexName, v.Date, v.Rate); err != nil {
+				return fmt.Errorf("failed to import %s value for %s: %w", indexName, v.Date.Format("2006-01-02"), err)
+			}
+		}
+		imported := map[string]any{"index": indexName, "file": path, "values": len(values)}
+		return recordAudit(ctx, tx, AuditImport, AuditRateIndex, 0, nil, imported, "")
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(values), nil
+}
+
+// GetRateIndexValue returns the most recent value of an index published on or before date.
+func GetRateIndexValue(db execer, indexName string, date time.Time) (float64, error) {
+	query := `
+	SELECT rate
+	FROM rate_index_values
+	WHERE index_name = $1 AND effective_date <= $2
+	ORDER BY effective_date DESC
+	LIMIT 1
+	`
+
+	var rate float64
+
+	err := db.QueryRow(query, indexName, date).Scan(&rate)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no %s index value on or before %s", indexName, date.Format("2006-01-02"))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rate index value: %w", err)
+	}
+
+	return rate, nil
+}
+
+// createRateChange appends an entry to a Loan's rate history
+func createRateChange(db execer, loanID, fromPayment int64, effectiveDate time.Time, indexValue, margin, rate float64) (RateChange, error) {
+	query := `
+	INSERT INTO loan_rate_history (loan_id, from_payment, effective_date, index_value, margin, rate)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	rc := RateChange{
+		LoanID:        loanID,
+		FromPayment:   fromPayment,
+		EffectiveDate: effectiveDate.UTC(),
+		IndexValue:    indexValue,
+		Margin:        margin,
+		Rate:          rate,
+	}
+
+	err := db.QueryRow(query, loanID, fromPayment, effectiveDate, indexValue, margin, rate).Scan(&rc.ID, &rc.CreatedAt)
+	if err != nil {
+		return RateChange{}, fmt.Errorf("failed to record rate change: %w", err)
+	}
+	rc.CreatedAt = rc.CreatedAt.UTC()
+
+	return rc, nil
+}
+
+// GetRateHistory returns the rate history of a Loan, oldest first.
+func GetRateHistory(db execer, loanID int64) ([]RateChange, error) {
+	query := `
+	SELECT id, loan_id, from_payment, effective_date, index_value, margin, rate, created_at
+	FROM loan_rate_history
+	WHERE loan_id = $1
+	ORDER BY from_payment, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rate history for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var history []RateChange
+
+	for rows.Next() {
+		var rc RateChange
+
+		err := rows.Scan(
+			&rc.ID,
+			&rc.LoanID,
+			&rc.FromPayment,
+			&rc.EffectiveDate,
+			&rc.IndexValue,
+			&rc.Margin,
+			&rc.Rate,
+			&rc.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rate history row: %w", err)
+		}
+
+		rc.EffectiveDate = rc.EffectiveDate.UTC()
+		rc.CreatedAt = rc.CreatedAt.UTC()
+
+		history = append(history, rc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rate history rows: %w", err)
+	}
+
+	return history, nil
+}
+
+// ApplyRateResets applies every reset of a variable-rate Loan that falls on or before asOf
+// and has not been applied yet. For each reset the rate is looked up from the index,
+// recorded in the history, and the unpaid installments are recomputed.
+// It returns the rate changes it applied.
+func ApplyRateResets(db *sql.DB, loanID int64, asOf time.Time) ([]RateChange, error) {
+	return ApplyRateResetsContext(context.Background(), db, loanID, asOf)
+}
+
+// ApplyRateResetsContext is ApplyRateResets, audited as the actor in ctx.
+func ApplyRateResetsContext(ctx context.Context, db *sql.DB, loanID int64, asOf time.Time) ([]RateChange, error) {
+	ln, err := GetLoanByLoanID(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+	if !ln.Rate.IsVariable() {
+		return nil, fmt.Errorf("Loan %d has a fixed rate", loanID)
+	}
+
+	var applied []RateChange
+
+	for _, m := range ln.Rate.resetPayments(ln.TermMonths) {
+		resetDate := calculateDueDate(ln.DateTaken, m, ln.DayDue)
+		if resetDate.After(asOf) {
+			break
+		}
+
+		// a reset lands in the history, the schedule and the ledger together or not at all
+		var rc RateChange
+		err := inTx(db, func(tx *sql.Tx) error {
+			var err error
+			rc, err = applyRateReset(ctx, tx, loanID, int64(m+1), resetDate)
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		if rc.ID != 0 {
+			applied = append(applied, rc)
+		}
+	}
+
+	return applied, nil
+}
+
+// applyRateReset locks a Loan and applies its reset from an installment on, unless it was applied already.
+// It returns the zero RateChange when there was nothing to apply.
+func applyRateReset(ctx context.Context, tx *sql.Tx, loanID, fromPayment int64, resetDate time.Time) (RateChange, error) {
+	ln, err := lockLoan(tx, loanID)
+	if err != nil {
+		return RateChange{}, err
+	}
+
+	history, err := GetRateHistory(tx, loanID)
+	if err != nil {
+		return RateChange{}, err
+	}
+	if len(history) == 0 {
+		return RateChange{}, fmt.Errorf("Loan %d has no rate history", loanID)
+	}
+	if history[len(history)-1].FromPayment >= fromPayment {
+		return RateChange{}, nil // already applied
+	}
+
+	indexValue, err := GetRateIndexValue(tx, ln.Rate.IndexName, resetDate)
+	if err != nil {
+		return RateChange{}, err
+	}
+
+	current := history[len(history)-1].Rate
+	rate := ln.Rate.adjust(current, indexValue)
+
+	rc, err := createRateChange(tx, loanID, fromPayment, resetDate, indexValue, ln.Rate.Margin, rate)
+	if err != nil {
+		return RateChange{}, err
+	}
+	history = append(history, rc)
+
+	if err := rescheduleUnpaidPayments(tx, ln, history); err != nil {
+		return RateChange{}, err
+	}
+
+	if err := recordAudit(ctx, tx, AuditRateReset, AuditLoan, loanID, history[len(history)-2], rc, ""); err != nil {
+		return RateChange{}, err
+	}
+
+	return rc, nil
+}
+
+// rescheduleUnpaidPayments rewrites the amount due of the installments that have not been paid yet
+// from the schedule reproduced with the given history, and stores the current rate on the Loan.
+func rescheduleUnpaidPayments(db execer, ln Loan, history []RateChange) error {
+	schedule, err := buildSchedule(ln, history)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild schedule for Loan %d: %w", ln.ID, err)
+	}
+
+	query := `
+	UPDATE payments
+	SET amount_due = $1
+	WHERE loan_id = $2 AND payment_number = $3 AND amount_paid = 0
+	`
+
+	for _, p := range schedule {
+		if _, err := db.Exec(query, p.AmountDue, ln.ID, p.PaymentNumber); err != nil {
+			return fmt.Errorf("failed to reschedule Payment %d: %w", p.PaymentNumber, err)
+		}
+	}
+
+	current := history[len(history)-1].Rate
+	if _, err := db.Exec(`UPDATE loans SET interest_rate = $1 WHERE id = $2`, current, ln.ID); err != nil {
+		return fmt.Errorf("failed to update Loan rate: %w", err)
+	}
+
+	return syncLedgerSchedule(db, ln.ID)
+}
+
+// ReproduceSchedule rebuilds the schedule of a Loan exactly as it stood at asOf,
+// using only the rate changes that had taken effect by then.
+func ReproduceSchedule(db *sql.DB, loanID int64, asOf time.Time) ([]Payment, error) {
+	ln, err := GetLoanByLoanID(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := GetRateHistory(db, loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	var known []RateChange
+	for _, h := range history {
+		if h.FromPayment == 1 || !h.EffectiveDate.After(asOf) {
+			known = append(known, h)
+		}
+	}
+
+	return buildSchedule(ln, known)
+}
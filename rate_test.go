@@ -0,0 +1,173 @@
+package delinquencytracker
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadRateIndexCSV verifies index values are parsed and sorted by date.
+func TestLoadRateIndexCSV(t *testing.T) {
+	values, err := LoadRateIndexCSV(filepath.Join("testdata", "rate_index.csv"))
+
+	require.NoError(t, err)
+	require.Len(t, values, 4)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), values[0].Date)
+	require.Equal(t, 0.053, values[0].Rate)
+	require.Equal(t, 0.048, values[3].Rate)
+}
+
+// TestParseRateIndexCSVRejectsBadRows verifies malformed rows are reported.
+func TestParseRateIndexCSVRejectsBadRows(t *testing.T) {
+	_, err := parseRateIndexCSV(strings.NewReader("2024-01-01,abc\n"))
+	require.Error(t, err)
+
+	_, err = parseRateIndexCSV(strings.NewReader("01/01/2024,0.05\n"))
+	require.Error(t, err)
+}
+
+// TestRateTermsAdjust verifies periodic and lifetime caps and floors.
+func TestRateTermsAdjust(t *testing.T) {
+	terms := RateTerms{
+		IndexName:     "SOFR",
+		Margin:        0.0275,
+		PeriodicCap:   0.02,
+		PeriodicFloor: 0.01,
+		LifetimeCap:   0.10,
+		LifetimeFloor: 0.04,
+	}
+
+	tests := []struct {
+		name     string
+		current  float64
+		index    float64
+		expected float64
+	}{
+		{"Index plus margin", 0.07, 0.0525, 0.08},
+		{"Periodic cap", 0.06, 0.07, 0.08},
+		{"Periodic floor", 0.08, 0.03, 0.07},
+		{"Lifetime cap", 0.095, 0.09, 0.10},
+		{"Lifetime floor", 0.045, 0.005, 0.04},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.InDelta(t, tt.expected, terms.adjust(tt.current, tt.index), 1e-9)
+		})
+	}
+}
+
+// TestRateTermsValidate verifies variable rate terms are checked at origination.
+func TestRateTermsValidate(t *testing.T) {
+	level := ScheduleConfig{Type: ScheduleLevel}
+
+	require.NoError(t, RateTerms{}.validate(12, level), "fixed rate loans need no terms")
+	require.NoError(t, RateTerms{IndexName: "SOFR", FirstResetMonths: 12, ResetMonths: 6}.validate(36, level))
+	require.Error(t, RateTerms{IndexName: "SOFR", FirstResetMonths: 36, ResetMonths: 6}.validate(36, level))
+	require.Error(t, RateTerms{IndexName: "SOFR", FirstResetMonths: 12}.validate(36, level))
+	require.Error(t, RateTerms{IndexName: "SOFR", FirstResetMonths: 12, ResetMonths: 6, LifetimeCap: 0.03, LifetimeFloor: 0.05}.validate(36, level))
+	require.Error(t, RateTerms{IndexName: "SOFR", FirstResetMonths: 12, ResetMonths: 6}.validate(36,
+		ScheduleConfig{Type: ScheduleGraduated, StepRate: 0.05, Steps: 2}))
+}
+
+// TestRateResetDates verifies resets fall on the due date of the installment before each new rate.
+func TestRateResetDates(t *testing.T) {
+	ln := Loan{
+		TermMonths: 36,
+		DayDue:     1,
+		DateTaken:  time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		Rate:       RateTerms{IndexName: "SOFR", FirstResetMonths: 12, ResetMonths: 12},
+	}
+
+	dates := RateResetDates(ln)
+
+	require.Equal(t, []time.Time{
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, dates)
+	require.Empty(t, RateResetDates(Loan{TermMonths: 36}), "fixed rate loans never reset")
+}
+
+// TestBuildScheduleReamortizesAfterReset verifies a reset keeps the paid installments
+// and re-amortizes the remaining balance at the new rate.
+func TestBuildScheduleReamortizesAfterReset(t *testing.T) {
+	ln := Loan{
+		ID:          1,
+		TotalAmount: 100000,
+		TermMonths:  24,
+		DayDue:      15,
+		DateTaken:   time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		Schedule:    ScheduleConfig{Type: ScheduleLevel},
+	}
+	initial := []RateChange{{FromPayment: 1, Rate: 0.06}}
+
+	original, err := buildSchedule(ln, initial)
+	require.NoError(t, err)
+
+	withReset := append(initial, RateChange{FromPayment: 13, Rate: 0.09})
+	reset, err := buildSchedule(ln, withReset)
+	require.NoError(t, err)
+	require.Len(t, reset, 24)
+
+	// the first year is untouched and the second year costs more
+	require.Equal(t, original[:12], reset[:12])
+	require.Greater(t, reset[12].AmountDue, original[12].AmountDue)
+	require.Equal(t, int64(13), reset[12].PaymentNumber)
+	require.Equal(t, original[12].DueDate, reset[12].DueDate)
+
+	// the installments still pay the Loan off exactly
+	balance := ln.TotalAmount
+	for _, p := range reset {
+		balance = balance*(1+rateForPayment(withReset, p.PaymentNumber)/12) - p.AmountDue
+	}
+	require.InDelta(t, 0, balance, 0.01)
+}
+
+// TestApplyRateResets verifies resets look up the index, record the history and reschedule unpaid installments.
+func TestApplyRateResets(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	_, err := ImportRateIndexCSV(db, "TEST", filepath.Join("testdata", "rate_index.csv"))
+	require.NoError(t, err)
+
+	dateTaken := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := LoanOptions{Rate: RateTerms{IndexName: "TEST", Margin: 0.02, FirstResetMonths: 12, ResetMonths: 6, PeriodicCap: 0.01}}
+	user, err := InitializeUserWithLoanOptions(db, "Arm User", "arm@example.com", "555-2020",
+		100000, 0.05, 36, 1, dateTaken, false, opts)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	before := ln.Payments
+
+	// Act, the first reset is on 2025-01-01 and the second on 2025-07-01
+	applied, err := ApplyRateResets(db, ln.ID, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	// Assert, index 0.061 + margin 0.02 is capped at 0.05 + 0.01
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	require.Equal(t, int64(13), applied[0].FromPayment)
+	require.InDelta(t, 0.061, applied[0].IndexValue, 1e-9)
+	require.InDelta(t, 0.06, applied[0].Rate, 1e-9)
+
+	stored, err := GetLoanByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.InDelta(t, 0.06, stored.InterestRate, 1e-9)
+
+	after, err := GetPaymentsByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, before[11].AmountDue, after[11].AmountDue, "installments before the reset keep their amount")
+	require.Greater(t, after[12].AmountDue, before[12].AmountDue, "installments after the reset are recomputed")
+
+	// applying again is a no-op, and the schedule before the reset can still be reproduced
+	applied, err = ApplyRateResets(db, ln.ID, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	past, err := ReproduceSchedule(db, ln.ID, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, before[12].AmountDue, past[12].AmountDue)
+}
@@ -0,0 +1,538 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Repayment plan statuses
+const (
+	PlanActive    = "active"    // the borrower is paying off the arrears
+	PlanCompleted = "completed" // every plan installment was paid
+	PlanBroken    = "broken"    // the borrower fell behind the plan and the Loan is back in collections
+	PlanCancelled = "cancelled" // the plan was called off, or the Loan was paid off, charged off or deleted
+)
+
+// Break rules used when a plan does not set its own
+const (
+	DefaultPlanGraceDays = 15
+	DefaultPlanMaxMissed = 2
+)
+
+// PlanBreakRules decide when a repayment plan is broken. A zero field uses its default.
+type PlanBreakRules struct {
+	GraceDays int // a plan breaks once anything it covers is more than this many days past due
+	MaxMissed int // or once payments due on this many days are past due together
+}
+
+func (r PlanBreakRules) normalized() PlanBreakRules {
+	if r.GraceDays <= 0 {
+		r.GraceDays = DefaultPlanGraceDays
+	}
+	if r.MaxMissed <= 0 {
+		r.MaxMissed = DefaultPlanMaxMissed
+	}
+	return r
+}
+
+// RepaymentPlan spreads what a Loan had past due when the plan started over installments
+// due on top of its regular payments. The plan's installments are kept apart from the Loan's schedule.
+type RepaymentPlan struct {
+	ID           int64             // unique identifier for the plan
+	LoanID       int64             // the Loan in arrears
+	Arrears      float64           // what was past due on the start date
+	StartDate    time.Time         // payments received from this day on count towards the plan
+	Rules        PlanBreakRules    // when the plan breaks
+	Status       string            // "active", "completed", "broken" or "cancelled"
+	Approver     string            // who agreed the plan with the borrower
+	Reason       string            // why it was offered
+	EndedAt      time.Time         // when it completed, broke or was cancelled (zero while active)
+	EndReason    string            // why it ended
+	CreatedAt    time.Time         // when was this record created
+	Installments []PlanInstallment // the arrears installments, in order
+}
+
+// PlanInstallment is one part of the arrears, due together with a regular installment
+type PlanInstallment struct {
+	ID          int64     // unique identifier for the installment
+	PlanID      int64     // the plan it belongs to
+	Number      int       // 1 for the first installment of the plan
+	DueDate     time.Time // the due date of the regular installment it is paid with
+	AmountDue   float64   // this part of the arrears
+	AmountPaid  float64   // covered by payments as of the last evaluation (filled by EvaluateRepaymentPlan)
+	PastDueDays int       // days it has been past due as of the last evaluation (0 when paid or not yet due)
+}
+
+// PlanDelinquency is how far a borrower is behind a repayment plan on a given day.
+// Regular installments that fall due while the plan runs count as much as the plan's own.
+type PlanDelinquency struct {
+	PlanID         int64
+	AsOf           time.Time
+	Received       float64 // payments received since the plan started
+	DaysPastDue    int     // days since the oldest unpaid installment the plan covers fell due
+	PastDueAmount  float64 // still owed on installments past due
+	MissedPayments int     // how many due days have something past due
+	Remaining      float64 // arrears still to be paid through the plan
+	Installments   []PlanInstallment
+}
+
+// breaks reports why a plan evaluated as d breaks under rules, or "" when it holds
+func (d PlanDelinquency) breaks(rules PlanBreakRules) string {
+	rules = rules.normalized()
+	switch {
+	case d.DaysPastDue > rules.GraceDays:
+		return fmt.Sprintf("%d days past due on the plan, more than the %d allowed", d.DaysPastDue, rules.GraceDays)
+	case d.MissedPayments >= rules.MaxMissed:
+		return fmt.Sprintf("%d payments missed on the plan", d.MissedPayments)
+	}
+	return ""
+}
+
+// evaluatePlan applies the money received since a plan started to what fell due since, oldest first,
+// with the regular installment due on a day covered before the plan installment due with it.
+// regular are the Loan's installments; those due on or before the start date are part of the arrears.
+func evaluatePlan(plan RepaymentPlan, regular []Payment, received float64, asOf time.Time) PlanDelinquency {
+	asOf = startOfDay(asOf)
+	d := PlanDelinquency{PlanID: plan.ID, AsOf: asOf, Received: received}
+
+	type obligation struct {
+		due    time.Time
+		amount float64
+		plan   int // index into the plan's installments, -1 for a regular installment
+	}
+
+	var last time.Time
+	for _, inst := range plan.Installments {
+		if inst.DueDate.After(last) {
+			last = inst.DueDate
+		}
+	}
+
+	var obligations []obligation
+	for _, p := range regular {
+		due := startOfDay(p.DueDate)
+		if due.After(plan.StartDate) && !due.After(last) {
+			obligations = append(obligations, obligation{due: due, amount: p.AmountDue, plan: -1})
+		}
+	}
+	for i, inst := range plan.Installments {
+		obligations = append(obligations, obligation{due: startOfDay(inst.DueDate), amount: inst.AmountDue, plan: i})
+	}
+	sort.SliceStable(obligations, func(i, j int) bool {
+		if !obligations[i].due.Equal(obligations[j].due) {
+			return obligations[i].due.Before(obligations[j].due)
+		}
+		return obligations[i].plan < obligations[j].plan
+	})
+
+	d.Installments = make([]PlanInstallment, len(plan.Installments))
+	copy(d.Installments, plan.Installments)
+
+	missed := map[time.Time]bool{}
+	left := received
+	for _, o := range obligations {
+		paid := min(left, o.amount)
+		left -= paid
+		if o.plan >= 0 {
+			d.Installments[o.plan].AmountPaid = paid
+			d.Remaining += o.amount - paid
+		}
+
+		owed := o.amount - paid
+		if owed <= paymentTolerance || !o.due.Before(asOf) {
+			continue
+		}
+		aged := int(asOf.Sub(o.due).Hours() / 24)
+		if o.plan >= 0 {
+			d.Installments[o.plan].PastDueDays = aged
+		}
+		d.PastDueAmount += owed
+		d.DaysPastDue = max(d.DaysPastDue, aged)
+		missed[o.due] = true
+	}
+	d.MissedPayments = len(missed)
+
+	if d.Remaining <= paymentTolerance {
+		d.Remaining = 0
+	}
+
+	return d
+}
+
+// splitEvenly divides an amount into n installments of whole cents, the last taking what rounding leaves
+func splitEvenly(amount float64, n int) []float64 {
+	each := math.Floor(amount/float64(n)*100) / 100
+	amounts := make([]float64, n)
+	for i := range amounts {
+		amounts[i] = each
+	}
+	amounts[n-1] = amount - each*float64(n-1)
+	return amounts
+}
+
+// CreateRepaymentPlan sets up a plan paying off what a Loan has past due on startDate over the next
+// installments regular installments, each plan installment due with one of them. The Loan's own schedule
+// is left as it is. Its open collection case is closed, and collections hold off while the plan is active.
+func CreateRepaymentPlan(db *sql.DB, loanID int64, installments int, startDate time.Time, rules PlanBreakRules, approver, reason string) (RepaymentPlan, error) {
+	return CreateRepaymentPlanContext(context.Background(), db, loanID, installments, startDate, rules, approver, reason)
+}
+
+// CreateRepaymentPlanContext is CreateRepaymentPlan, audited as the actor in ctx.
+func CreateRepaymentPlanContext(ctx context.Context, db *sql.DB, loanID int64, installments int, startDate time.Time, rules PlanBreakRules, approver, reason string) (RepaymentPlan, error) {
+	if approver == "" {
+		return RepaymentPlan{}, fmt.Errorf("a repayment plan needs an approver")
+	}
+	if startDate.IsZero() {
+		return RepaymentPlan{}, fmt.Errorf("startDate cannot be zero time")
+	}
+	if installments < 1 {
+		return RepaymentPlan{}, fmt.Errorf("a repayment plan needs at least one installment, got %d", installments)
+	}
+	if rules.GraceDays < 0 || rules.MaxMissed < 0 {
+		return RepaymentPlan{}, fmt.Errorf("plan break rules cannot be negative")
+	}
+
+	ln, err := LoanFromLedger(db, loanID)
+	if err != nil {
+		return RepaymentPlan{}, err
+	}
+	if ln.Status != LoanStatusActive && ln.Status != LoanStatusDefaulted {
+		return RepaymentPlan{}, fmt.Errorf("Loan %d is %s", loanID, ln.Status)
+	}
+
+	d, err := EvaluateDelinquency(ln, startDate)
+	if err != nil {
+		return RepaymentPlan{}, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", loanID, err)
+	}
+	if !d.IsDelinquent() {
+		return RepaymentPlan{}, fmt.Errorf("Loan %d has nothing past due on %s", loanID, startDate.Format("2006-01-02"))
+	}
+
+	plan := RepaymentPlan{
+		LoanID:    loanID,
+		Arrears:   d.PastDueAmount,
+		StartDate: startOfDay(startDate),
+		Rules:     rules.normalized(),
+		Status:    PlanActive,
+		Approver:  approver,
+		Reason:    reason,
+	}
+
+	for _, p := range ln.Payments {
+		if len(plan.Installments) < installments && startOfDay(p.DueDate).After(plan.StartDate) {
+			plan.Installments = append(plan.Installments, PlanInstallment{Number: len(plan.Installments) + 1, DueDate: startOfDay(p.DueDate)})
+		}
+	}
+	if len(plan.Installments) < installments {
+		return RepaymentPlan{}, fmt.Errorf("Loan %d has only %d installments left to spread arrears over, not %d",
+			loanID, len(plan.Installments), installments)
+	}
+	for i, amount := range splitEvenly(plan.Arrears, installments) {
+		plan.Installments[i].AmountDue = amount
+	}
+
+	err = inTx(db, func(tx *sql.Tx) error {
+		onPlan, err := hasActivePlan(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if onPlan {
+			return fmt.Errorf("Loan %d is already on a repayment plan", loanID)
+		}
+
+		err = tx.QueryRow(`
+		INSERT INTO repayment_plans (loan_id, arrears, start_date, grace_days, max_missed, approver, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`, loanID, plan.Arrears, plan.StartDate, plan.Rules.GraceDays, plan.Rules.MaxMissed, approver, reason).
+			Scan(&plan.ID, &plan.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create repayment plan for Loan %d: %w", loanID, err)
+		}
+		plan.CreatedAt = plan.CreatedAt.UTC()
+
+		for i := range plan.Installments {
+			inst := &plan.Installments[i]
+			inst.PlanID = plan.ID
+			err := tx.QueryRow(`
+			INSERT INTO repayment_plan_installments (plan_id, number, due_date, amount_due)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+			`, plan.ID, inst.Number, inst.DueDate, inst.AmountDue).Scan(&inst.ID)
+			if err != nil {
+				return fmt.Errorf("failed to create installment %d of repayment plan %d: %w", inst.Number, plan.ID, err)
+			}
+		}
+
+		open, err := getOpenCase(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if open.ID != 0 {
+			if _, err := closeCase(ctx, tx, open, CloseRepaymentPlan); err != nil {
+				return err
+			}
+		}
+
+		return recordAudit(ctx, tx, AuditCreate, AuditRepaymentPlan, plan.ID, nil, plan, reason)
+	})
+	if err != nil {
+		return RepaymentPlan{}, err
+	}
+
+	return plan, nil
+}
+
+// CancelRepaymentPlan calls off an active plan. The Loan goes back to standard collections.
+func CancelRepaymentPlan(db *sql.DB, planID int64, reason string) (RepaymentPlan, error) {
+	return CancelRepaymentPlanContext(context.Background(), db, planID, reason)
+}
+
+// CancelRepaymentPlanContext is CancelRepaymentPlan, audited as the actor in ctx.
+func CancelRepaymentPlanContext(ctx context.Context, db *sql.DB, planID int64, reason string) (RepaymentPlan, error) {
+	var after RepaymentPlan
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		plan, err := getRepaymentPlan(tx, `WHERE id = $1 FOR UPDATE`, planID)
+		if err != nil {
+			return err
+		}
+		if plan.ID == 0 {
+			return fmt.Errorf("repayment plan with ID %d not found", planID)
+		}
+		if plan.Status != PlanActive {
+			return fmt.Errorf("repayment plan %d is %s", planID, plan.Status)
+		}
+
+		after, err = endPlan(ctx, tx, plan, PlanCancelled, reason)
+		return err
+	})
+	if err != nil {
+		return RepaymentPlan{}, err
+	}
+
+	return after, nil
+}
+
+// endPlan moves an active plan to its final status
+func endPlan(ctx context.Context, tx *sql.Tx, plan RepaymentPlan, status, reason string) (RepaymentPlan, error) {
+	after := plan
+	after.Status, after.EndReason = status, reason
+
+	err := tx.QueryRow(`UPDATE repayment_plans SET status = $1, end_reason = $2, ended_at = NOW() WHERE id = $3 RETURNING ended_at`,
+		status, reason, plan.ID).Scan(&after.EndedAt)
+	if err != nil {
+		return RepaymentPlan{}, fmt.Errorf("failed to end repayment plan %d: %w", plan.ID, err)
+	}
+	after.EndedAt = after.EndedAt.UTC()
+
+	if err := recordAudit(ctx, tx, AuditUpdate, AuditRepaymentPlan, plan.ID, plan, after, reason); err != nil {
+		return RepaymentPlan{}, err
+	}
+
+	return after, nil
+}
+
+// EvaluateRepaymentPlan works out how far a borrower is behind a plan as of a day.
+func EvaluateRepaymentPlan(db execer, planID int64, asOf time.Time) (PlanDelinquency, error) {
+	plan, err := GetRepaymentPlan(db, planID)
+	if err != nil {
+		return PlanDelinquency{}, err
+	}
+	return loadPlanDelinquency(db, plan, asOf)
+}
+
+// loadPlanDelinquency loads what a plan is evaluated against and evaluates it
+func loadPlanDelinquency(db execer, plan RepaymentPlan, asOf time.Time) (PlanDelinquency, error) {
+	regular, err := GetPaymentsByLoanID(db, plan.LoanID)
+	if err != nil {
+		return PlanDelinquency{}, err
+	}
+
+	var received float64
+	err = db.QueryRow(`
+	SELECT COALESCE(SUM(amount), 0)
+	FROM posted_payments
+	WHERE loan_id = $1 AND kind = $2 AND NOT reversed AND received_date >= $3 AND received_date < $4
+	`, plan.LoanID, PostingPayment, plan.StartDate, startOfDay(asOf).AddDate(0, 0, 1)).Scan(&received)
+	if err != nil {
+		return PlanDelinquency{}, fmt.Errorf("failed to sum payments towards repayment plan %d: %w", plan.ID, err)
+	}
+
+	return evaluatePlan(plan, regular, received, asOf), nil
+}
+
+// EvaluateRepaymentPlans checks every active plan as of a day. A plan whose installments are all paid
+// is completed, one whose Loan is no longer active or defaulted is cancelled, and one the borrower fell
+// behind on, as its break rules judge, is broken and its Loan goes back to standard collections.
+// It returns the plans that ended.
+func EvaluateRepaymentPlans(db *sql.DB, asOf time.Time) ([]RepaymentPlan, error) {
+	return EvaluateRepaymentPlansContext(context.Background(), db, asOf)
+}
+
+// EvaluateRepaymentPlansContext is EvaluateRepaymentPlans, audited as the actor in ctx.
+func EvaluateRepaymentPlansContext(ctx context.Context, db *sql.DB, asOf time.Time) ([]RepaymentPlan, error) {
+	var ended []RepaymentPlan
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		plans, err := queryRepaymentPlans(tx, `WHERE status = $1 AND start_date <= $2 ORDER BY id FOR UPDATE`, PlanActive, startOfDay(asOf))
+		if err != nil {
+			return err
+		}
+
+		for _, plan := range plans {
+			ln, err := GetLoanByLoanID(tx, plan.LoanID, IncludeDeleted)
+			if err != nil {
+				return err
+			}
+			d, err := loadPlanDelinquency(tx, plan, asOf)
+			if err != nil {
+				return err
+			}
+
+			status, reason := "", ""
+			switch {
+			case !ln.DeletedAt.IsZero():
+				status, reason = PlanCancelled, "Loan was deleted"
+			case ln.Status != LoanStatusActive && ln.Status != LoanStatusDefaulted:
+				status, reason = PlanCancelled, "Loan is "+ln.Status
+			case d.Remaining == 0:
+				status, reason = PlanCompleted, "arrears paid"
+			default:
+				if why := d.breaks(plan.Rules); why != "" {
+					status, reason = PlanBroken, why
+				}
+			}
+			if status == "" {
+				continue
+			}
+
+			after, err := endPlan(ctx, tx, plan, status, reason)
+			if err != nil {
+				return err
+			}
+			ended = append(ended, after)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ended, nil
+}
+
+// hasActivePlan reports whether a Loan is on an active repayment plan
+func hasActivePlan(db execer, loanID int64) (bool, error) {
+	var active bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM repayment_plans WHERE loan_id = $1 AND status = $2)`, loanID, PlanActive).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check repayment plans of Loan %d: %w", loanID, err)
+	}
+	return active, nil
+}
+
+// GetRepaymentPlan returns a repayment plan with its installments.
+func GetRepaymentPlan(db execer, planID int64) (RepaymentPlan, error) {
+	plan, err := getRepaymentPlan(db, `WHERE id = $1`, planID)
+	if err != nil {
+		return RepaymentPlan{}, err
+	}
+	if plan.ID == 0 {
+		return RepaymentPlan{}, fmt.Errorf("repayment plan with ID %d not found", planID)
+	}
+	return plan, nil
+}
+
+// GetRepaymentPlansByLoanID returns the repayment plans of a Loan with their installments, oldest first.
+func GetRepaymentPlansByLoanID(db execer, loanID int64) ([]RepaymentPlan, error) {
+	return queryRepaymentPlans(db, `WHERE loan_id = $1 ORDER BY start_date, id`, loanID)
+}
+
+// getRepaymentPlan returns the one plan selected by tail, or a zero plan when there is none
+func getRepaymentPlan(db execer, tail string, args ...any) (RepaymentPlan, error) {
+	plans, err := queryRepaymentPlans(db, tail, args...)
+	if err != nil || len(plans) == 0 {
+		return RepaymentPlan{}, err
+	}
+	return plans[0], nil
+}
+
+const planColumns = `id, loan_id, arrears, start_date, grace_days, max_missed, status,
+	approver, reason, ended_at, end_reason, created_at`
+
+// queryRepaymentPlans selects plans with the conditions and ordering in tail, and loads their installments
+func queryRepaymentPlans(db execer, tail string, args ...any) ([]RepaymentPlan, error) {
+	rows, err := db.Query(`SELECT `+planColumns+` FROM repayment_plans `+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repayment plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []RepaymentPlan{}
+
+	for rows.Next() {
+		var p RepaymentPlan
+		var endedAt sql.NullTime
+		err := rows.Scan(&p.ID, &p.LoanID, &p.Arrears, &p.StartDate, &p.Rules.GraceDays, &p.Rules.MaxMissed, &p.Status,
+			&p.Approver, &p.Reason, &endedAt, &p.EndReason, &p.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan repayment plan row: %w", err)
+		}
+		p.StartDate, p.CreatedAt = p.StartDate.UTC(), p.CreatedAt.UTC()
+		if endedAt.Valid {
+			p.EndedAt = endedAt.Time.UTC()
+		}
+		plans = append(plans, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating repayment plan rows: %w", err)
+	}
+	rows.Close()
+
+	for i := range plans {
+		plans[i].Installments, err = getPlanInstallments(db, plans[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return plans, nil
+}
+
+// getPlanInstallments returns the installments of a repayment plan in order
+func getPlanInstallments(db execer, planID int64) ([]PlanInstallment, error) {
+	rows, err := db.Query(`
+	SELECT id, plan_id, number, due_date, amount_due
+	FROM repayment_plan_installments
+	WHERE plan_id = $1
+	ORDER BY number
+	`, planID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query installments of repayment plan %d: %w", planID, err)
+	}
+	defer rows.Close()
+
+	var installments []PlanInstallment
+
+	for rows.Next() {
+		var inst PlanInstallment
+		if err := rows.Scan(&inst.ID, &inst.PlanID, &inst.Number, &inst.DueDate, &inst.AmountDue); err != nil {
+			return nil, fmt.Errorf("failed to scan repayment plan installment row: %w", err)
+		}
+		inst.DueDate = inst.DueDate.UTC()
+		installments = append(installments, inst)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating repayment plan installment rows: %w", err)
+	}
+
+	return installments, nil
+}
@@ -0,0 +1,177 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSplitEvenly verifies amounts are split into whole cents that add back up.
+func TestSplitEvenly(t *testing.T) {
+	amounts := splitEvenly(1000, 3)
+	require.Equal(t, []float64{333.33, 333.33}, amounts[:2])
+	require.InDelta(t, 333.34, amounts[2], 0.0001)
+
+	require.Equal(t, []float64{250}, splitEvenly(250, 1))
+}
+
+// TestEvaluatePlan verifies money received since a plan started covers the regular installment
+// before the plan installment due with it, and how far behind the plan the borrower is.
+func TestEvaluatePlan(t *testing.T) {
+	// $500 regular installments on the 1st; February and March were missed before the plan started
+	regular := []Payment{
+		{PaymentNumber: 1, AmountDue: 500, DueDate: calendarDate(2024, time.February, 1)},
+		{PaymentNumber: 2, AmountDue: 500, DueDate: calendarDate(2024, time.March, 1)},
+		{PaymentNumber: 3, AmountDue: 500, DueDate: calendarDate(2024, time.April, 1)},
+		{PaymentNumber: 4, AmountDue: 500, DueDate: calendarDate(2024, time.May, 1)},
+		{PaymentNumber: 5, AmountDue: 500, DueDate: calendarDate(2024, time.June, 1)},
+	}
+	plan := RepaymentPlan{
+		Arrears:   1000,
+		StartDate: calendarDate(2024, time.March, 15),
+		Installments: []PlanInstallment{
+			{Number: 1, DueDate: calendarDate(2024, time.April, 1), AmountDue: 500},
+			{Number: 2, DueDate: calendarDate(2024, time.May, 1), AmountDue: 500},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		received  float64
+		asOf      time.Time
+		dpd       int
+		pastDue   float64
+		missed    int
+		remaining float64
+		paid      []float64
+	}{
+		{"Before anything is due", 0, calendarDate(2024, time.March, 20), 0, 0, 0, 1000, []float64{0, 0}},
+		{"First payment made", 1000, calendarDate(2024, time.April, 10), 0, 0, 0, 500, []float64{500, 0}},
+		{"Only the regular installment paid", 500, calendarDate(2024, time.April, 10), 9, 500, 1, 1000, []float64{0, 0}},
+		{"Second payment missed", 1000, calendarDate(2024, time.May, 20), 19, 1000, 1, 500, []float64{500, 0}},
+		{"Nothing paid", 0, calendarDate(2024, time.May, 20), 49, 2000, 2, 1000, []float64{0, 0}},
+		{"Everything paid", 2000, calendarDate(2024, time.May, 20), 0, 0, 0, 0, []float64{500, 500}},
+		{"Regular installments after the plan do not count", 2000, calendarDate(2024, time.June, 20), 0, 0, 0, 0, []float64{500, 500}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := evaluatePlan(plan, regular, tt.received, tt.asOf)
+			require.Equal(t, tt.dpd, d.DaysPastDue)
+			require.InDelta(t, tt.pastDue, d.PastDueAmount, 0.001)
+			require.Equal(t, tt.missed, d.MissedPayments)
+			require.InDelta(t, tt.remaining, d.Remaining, 0.001)
+			require.Equal(t, tt.paid, []float64{d.Installments[0].AmountPaid, d.Installments[1].AmountPaid})
+		})
+	}
+}
+
+// TestPlanBreaks verifies the break rules and their defaults.
+func TestPlanBreaks(t *testing.T) {
+	require.Empty(t, PlanDelinquency{DaysPastDue: 15, MissedPayments: 1}.breaks(PlanBreakRules{}))
+	require.NotEmpty(t, PlanDelinquency{DaysPastDue: 16, MissedPayments: 1}.breaks(PlanBreakRules{}))
+	require.NotEmpty(t, PlanDelinquency{DaysPastDue: 3, MissedPayments: 2}.breaks(PlanBreakRules{}))
+	require.Empty(t, PlanDelinquency{DaysPastDue: 16, MissedPayments: 2}.breaks(PlanBreakRules{GraceDays: 30, MaxMissed: 3}))
+}
+
+// TestRepaymentPlan verifies a plan takes a Loan out of collections and puts it back when it breaks.
+func TestRepaymentPlan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan that missed February and March
+	user, err := InitializeUserWithLoan(db, "Planned User", "planned@example.com", "555-0801",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	monthly := ln.Payments[0].AmountDue
+
+	run, err := RunCollections(db, calendarDate(2024, time.March, 15), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Opened, 1)
+
+	// Act
+	plan, err := CreateRepaymentPlan(db, ln.ID, 2, calendarDate(2024, time.March, 15), PlanBreakRules{}, "supervisor", "hardship call")
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, PlanActive, plan.Status)
+	require.InDelta(t, 2*monthly, plan.Arrears, 0.01)
+	require.Len(t, plan.Installments, 2)
+	require.Equal(t, calendarDate(2024, time.April, 1), plan.Installments[0].DueDate)
+	require.Equal(t, calendarDate(2024, time.May, 1), plan.Installments[1].DueDate)
+	require.Equal(t, DefaultPlanGraceDays, plan.Rules.GraceDays)
+
+	c, err := GetCollectionCase(db, run.Opened[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, CloseRepaymentPlan, c.CloseReason)
+
+	_, err = CreateRepaymentPlan(db, ln.ID, 2, calendarDate(2024, time.March, 16), PlanBreakRules{}, "supervisor", "")
+	require.Error(t, err, "a Loan is on one plan at a time")
+
+	// Act, April's regular and plan installments are paid and the Loan stays out of collections
+	_, err = PostPayment(db, ln.ID, monthly+plan.Installments[0].AmountDue, calendarDate(2024, time.April, 1))
+	require.NoError(t, err)
+	run, err = RunCollections(db, calendarDate(2024, time.April, 20), 0)
+	require.NoError(t, err)
+	require.Empty(t, run.Opened)
+	require.Empty(t, run.Plans)
+
+	d, err := EvaluateRepaymentPlan(db, plan.ID, calendarDate(2024, time.April, 20))
+	require.NoError(t, err)
+	require.Equal(t, 0, d.DaysPastDue)
+	require.InDelta(t, plan.Installments[1].AmountDue, d.Remaining, 0.01)
+
+	cos, err := RunChargeOffs(db, calendarDate(2024, time.April, 20), 1, "collections")
+	require.NoError(t, err)
+	require.Empty(t, cos, "loans on a plan are not charged off")
+
+	// Act, May is missed and the plan breaks once past its grace days
+	run, err = RunCollections(db, calendarDate(2024, time.May, 17), 0)
+	require.NoError(t, err)
+
+	// Assert
+	require.Len(t, run.Plans, 1)
+	require.Equal(t, PlanBroken, run.Plans[0].Status)
+	require.Len(t, run.Opened, 1, "the Loan is back in standard collections")
+	require.Equal(t, ln.ID, run.Opened[0].LoanID)
+
+	plans, err := GetRepaymentPlansByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	require.Equal(t, PlanBroken, plans[0].Status)
+	require.False(t, plans[0].EndedAt.IsZero())
+}
+
+// TestCancelRepaymentPlan verifies a cancelled plan puts the Loan back in collections.
+func TestCancelRepaymentPlan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Cancelled User", "cancelled@example.com", "555-0811",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	_, err = CreateRepaymentPlan(db, ln.ID, 2, calendarDate(2024, time.January, 20), PlanBreakRules{}, "supervisor", "")
+	require.Error(t, err, "nothing is past due yet")
+	_, err = CreateRepaymentPlan(db, ln.ID, 12, calendarDate(2024, time.March, 15), PlanBreakRules{}, "supervisor", "")
+	require.Error(t, err, "only ten installments are left")
+
+	plan, err := CreateRepaymentPlan(db, ln.ID, 3, calendarDate(2024, time.March, 15), PlanBreakRules{}, "supervisor", "")
+	require.NoError(t, err)
+
+	// Act
+	cancelled, err := CancelRepaymentPlan(db, plan.ID, "borrower changed their mind")
+	require.NoError(t, err)
+
+	// Assert
+	require.Equal(t, PlanCancelled, cancelled.Status)
+	_, err = CancelRepaymentPlan(db, plan.ID, "again")
+	require.Error(t, err)
+
+	run, err := RunCollections(db, calendarDate(2024, time.March, 20), 0)
+	require.NoError(t, err)
+	require.Len(t, run.Opened, 1)
+}
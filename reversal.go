@@ -0,0 +1,236 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ACH return reason codes
+const (
+	ReturnInsufficientFunds = "R01" // insufficient funds
+	ReturnAccountClosed     = "R02" // account closed
+	ReturnNoAccount         = "R03" // no account or unable to locate account
+	ReturnInvalidAccount    = "R04" // invalid account number
+	ReturnStopPayment       = "R08" // payment stopped
+	ReturnUncollectedFunds  = "R09" // uncollected funds
+	ReturnUnauthorized      = "R10" // customer advises not authorized
+	ReturnAccountFrozen     = "R16" // account frozen
+	ReturnOther             = "R99" // anything else, e.g. a bounced check
+)
+
+// returnReasons describes every return reason code ReversePayment accepts
+var returnReasons = map[string]string{
+	ReturnInsufficientFunds: "insufficient funds",
+	ReturnAccountClosed:     "account closed",
+	ReturnNoAccount:         "no account",
+	ReturnInvalidAccount:    "invalid account number",
+	ReturnStopPayment:       "payment stopped",
+	ReturnUncollectedFunds:  "uncollected funds",
+	ReturnUnauthorized:      "not authorized",
+	ReturnAccountFrozen:     "account frozen",
+	ReturnOther:             "other",
+}
+
+// ReturnReasonDescription returns the description of a return reason code, or "" for unknown codes.
+func ReturnReasonDescription(code string) string {
+	return returnReasons[code]
+}
+
+// PaymentReversal records a posted payment that came back unpaid.
+type PaymentReversal struct {
+	ID              int64     // unique identifier for the reversal
+	PostedPaymentID int64     // which posted payment was reversed
+	LoanID          int64     // which loan the payment was for
+	ReturnDate      time.Time // when the payment came back
+	ReasonCode      string    // return reason code, e.g. "R01"
+	FeeID           int64     // NSF fee assessed for the return (0 when none)
+	CreatedAt       time.Time // when was this record created
+}
+
+// ReversePayment un-applies a posted payment that was returned.
+// The installments and fees it covered owe again what it paid, so delinquency counts from
+// their original due dates, and a paid-off Loan becomes active again. When nsfFee is
+// positive an NSF fee of that amount is assessed on the return date.
+// On a charged-off or settled Loan only recoveries can be reversed, and without a fee.
+func ReversePayment(db *sql.DB, postedPaymentID int64, returnDate time.Time, reasonCode string, nsfFee float64) (PaymentReversal, error) {
+	return ReversePaymentContext(context.Background(), db, postedPaymentID, returnDate, reasonCode, nsfFee)
+}
+
+// ReversePaymentContext is ReversePayment, audited as the actor in ctx.
+func ReversePaymentContext(ctx context.Context, db *sql.DB, postedPaymentID int64, returnDate time.Time, reasonCode string, nsfFee float64) (PaymentReversal, error) {
+	if _, ok := returnReasons[reasonCode]; !ok {
+		return PaymentReversal{}, fmt.Errorf("unknown return reason code %q", reasonCode)
+	}
+	if returnDate.IsZero() {
+		return PaymentReversal{}, fmt.Errorf("returnDate cannot be zero time")
+	}
+	if nsfFee < 0 {
+		return PaymentReversal{}, fmt.Errorf("NSF fee cannot be negative, got %.2f", nsfFee)
+	}
+
+	var rev PaymentReversal
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		pp, err := GetPostedPaymentByID(tx, postedPaymentID)
+		if err != nil {
+			return err
+		}
+
+		// lock the Loan, then read the posting again so a concurrent reversal of it is seen
+		ln, err := lockLoan(tx, pp.LoanID)
+		if err != nil {
+			return err
+		}
+		if pp, err = GetPostedPaymentByID(tx, postedPaymentID); err != nil {
+			return err
+		}
+		if pp.Reversed {
+			return fmt.Errorf("posted payment %d has already been reversed", postedPaymentID)
+		}
+
+		// the ledger of a charged-off or settled Loan is closed: only a recovery, which never
+		// touched the schedule, can still be taken back
+		if ln.closedOut() {
+			if pp.Kind != PostingRecovery {
+				return fmt.Errorf("Loan %d is %s, posted payment %d can no longer be reversed", ln.ID, ln.Status, pp.ID)
+			}
+			if nsfFee > 0 {
+				return fmt.Errorf("Loan %d is %s and cannot be charged an NSF fee", ln.ID, ln.Status)
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE posted_payments SET reversed = TRUE WHERE id = $1`, pp.ID); err != nil {
+			return fmt.Errorf("failed to reverse posted payment %d: %w", pp.ID, err)
+		}
+
+		// Step 1: give back what the payment covered
+		for _, a := range pp.Allocations {
+			if a.FeeID != 0 {
+				if _, err := tx.Exec(`UPDATE loan_fees SET amount_paid = amount_paid - $1 WHERE id = $2`, a.Amount, a.FeeID); err != nil {
+					return fmt.Errorf("failed to restore Fee %d: %w", a.FeeID, err)
+				}
+				continue
+			}
+			if err := restoreInstallment(tx, a); err != nil {
+				return err
+			}
+		}
+
+		if err := reverseLedgerEntries(tx, pp.ID, returnDate, reasonCode); err != nil {
+			return err
+		}
+
+		// Step 2: a paid-off Loan owes again
+		if len(pp.Allocations) > 0 {
+			_, err = tx.Exec(`UPDATE loans SET status = $1 WHERE id = $2 AND status = $3`, LoanStatusActive, pp.LoanID, LoanStatusPaidOff)
+			if err != nil {
+				return fmt.Errorf("failed to reactivate Loan %d: %w", pp.LoanID, err)
+			}
+		}
+
+		rev = PaymentReversal{
+			PostedPaymentID: pp.ID,
+			LoanID:          pp.LoanID,
+			ReturnDate:      returnDate.UTC(),
+			ReasonCode:      reasonCode,
+		}
+
+		// Step 3: charge for the return
+		var feeID sql.NullInt64
+		if nsfFee > 0 {
+			fee, err := assessFee(ctx, tx, pp.LoanID, FeeNSF, nsfFee, returnDate)
+			if err != nil {
+				return err
+			}
+			rev.FeeID = fee.ID
+			feeID = sql.NullInt64{Int64: fee.ID, Valid: true}
+		}
+
+		query := `
+		INSERT INTO payment_reversals (posted_payment_id, loan_id, return_date, reason_code, fee_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+		`
+
+		err = tx.QueryRow(query, rev.PostedPaymentID, rev.LoanID, rev.ReturnDate, rev.ReasonCode, feeID).Scan(&rev.ID, &rev.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record payment reversal: %w", err)
+		}
+		rev.CreatedAt = rev.CreatedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditReversePayment, AuditLoan, pp.LoanID, pp, rev, ReturnReasonDescription(reasonCode))
+	})
+	if err != nil {
+		return PaymentReversal{}, err
+	}
+
+	return rev, nil
+}
+
+// restoreInstallment takes an allocation back off its installment.
+// The paid date falls back to the latest payment still applied to it, or to none.
+func restoreInstallment(tx *sql.Tx, a PaymentAllocation) error {
+	var lastPaid sql.NullTime
+
+	err := tx.QueryRow(`
+	SELECT MAX(pp.received_date)
+	FROM payment_allocations a
+	JOIN posted_payments pp ON pp.id = a.posted_payment_id
+	WHERE a.payment_id = $1 AND NOT pp.reversed
+	`, a.PaymentID).Scan(&lastPaid)
+	if err != nil {
+		return fmt.Errorf("failed to find earlier payments for Payment %d: %w", a.PaymentID, err)
+	}
+
+	paidDate := time.Time{}
+	if lastPaid.Valid {
+		paidDate = lastPaid.Time
+	}
+
+	_, err = tx.Exec(`UPDATE payments SET amount_paid = amount_paid - $1, paid_date = $2 WHERE id = $3`, a.Amount, paidDate, a.PaymentID)
+	if err != nil {
+		return fmt.Errorf("failed to restore Payment %d: %w", a.PaymentID, err)
+	}
+
+	return nil
+}
+
+// GetPaymentReversalsByLoanID returns every returned payment of a Loan, oldest first.
+func GetPaymentReversalsByLoanID(db execer, loanID int64) ([]PaymentReversal, error) {
+	query := `
+	SELECT id, posted_payment_id, loan_id, return_date, reason_code, COALESCE(fee_id, 0), created_at
+	FROM payment_reversals
+	WHERE loan_id = $1
+	ORDER BY return_date, id
+	`
+
+	rows, err := db.Query(query, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reversals for Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var reversals []PaymentReversal
+
+	for rows.Next() {
+		var r PaymentReversal
+
+		err := rows.Scan(&r.ID, &r.PostedPaymentID, &r.LoanID, &r.ReturnDate, &r.ReasonCode, &r.FeeID, &r.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reversal row: %w", err)
+		}
+
+		r.ReturnDate = r.ReturnDate.UTC()
+		r.CreatedAt = r.CreatedAt.UTC()
+
+		reversals = append(reversals, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reversal rows: %w", err)
+	}
+
+	return reversals, nil
+}
@@ -0,0 +1,95 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReversePayment verifies a returned payment restores arrears from the original due date and charges an NSF fee.
+func TestReversePayment(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, the first $400 installment is paid on time
+	user, err := InitializeUserWithLoan(db, "Returned User", "returned@example.com", "555-1515",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	pp, err := PostPayment(db, ln.ID, 400, calendarDate(2024, time.February, 1))
+	require.NoError(t, err)
+
+	// Act, the payment comes back five days later
+	rev, err := ReversePayment(db, pp.ID, calendarDate(2024, time.February, 6), ReturnInsufficientFunds, 30)
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, ReturnInsufficientFunds, rev.ReasonCode)
+	require.NotZero(t, rev.FeeID)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0.0, full.Payments[0].AmountPaid)
+	require.True(t, full.Payments[0].PaidDate.IsZero())
+
+	d, err := EvaluateDelinquency(full, calendarDate(2024, time.February, 10))
+	require.NoError(t, err)
+	require.Equal(t, 9, d.DaysPastDue, "arrears count from the original due date")
+
+	fees, err := GetFeesByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, fees, 1)
+	require.Equal(t, FeeNSF, fees[0].Type)
+
+	_, err = ReversePayment(db, pp.ID, calendarDate(2024, time.February, 7), ReturnInsufficientFunds, 0)
+	require.Error(t, err, "a payment can only be returned once")
+
+	reversals, err := GetPaymentReversalsByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, reversals, 1)
+}
+
+// TestReversePaymentChargedOff verifies a charged-off Loan only has its recoveries reversed.
+func TestReversePaymentChargedOff(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, one installment paid, then the Loan is charged off and money is recovered
+	user, err := InitializeUserWithLoan(db, "Written Off User", "writtenoff@example.com", "555-1616",
+		1200, 0, 3, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	paid, err := PostPayment(db, ln.ID, 400, calendarDate(2024, time.February, 1))
+	require.NoError(t, err)
+	_, err = ChargeOffLoan(db, ln.ID, calendarDate(2024, time.June, 15), "collections", "never paid again")
+	require.NoError(t, err)
+	recovered, err := PostPayment(db, ln.ID, 100, calendarDate(2024, time.July, 1))
+	require.NoError(t, err)
+
+	// Act
+	_, err = ReversePayment(db, paid.ID, calendarDate(2024, time.July, 2), ReturnUnauthorized, 0)
+
+	// Assert
+	require.ErrorContains(t, err, "can no longer be reversed")
+	_, err = ReversePayment(db, recovered.ID, calendarDate(2024, time.July, 2), ReturnInsufficientFunds, 30)
+	require.ErrorContains(t, err, "cannot be charged an NSF fee")
+
+	_, err = ReversePayment(db, recovered.ID, calendarDate(2024, time.July, 2), ReturnInsufficientFunds, 0)
+	require.NoError(t, err)
+	recoveries, err := GetRecoveriesByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Empty(t, recoveries)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusChargedOff, full.Status)
+	require.Equal(t, 400.0, full.Payments[0].AmountPaid)
+}
+
+// TestReversePaymentUnknownReason verifies the reason code is validated.
+func TestReversePaymentUnknownReason(t *testing.T) {
+	_, err := ReversePayment(nil, 1, calendarDate(2024, time.February, 6), "X42", 0)
+	require.ErrorContains(t, err, "unknown return reason code")
+	require.Equal(t, "insufficient funds", ReturnReasonDescription(ReturnInsufficientFunds))
+}
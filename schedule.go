@@ -0,0 +1,192 @@
+package delinquencytracker
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ScheduleType names the structure used to build a Loan's installments.
+type ScheduleType string
+
+const (
+	ScheduleLevel        ScheduleType = "level"         // equal payments that fully amortize the Loan
+	ScheduleInterestOnly ScheduleType = "interest_only" // interest-only period followed by amortization
+	ScheduleBalloon      ScheduleType = "balloon"       // regular payments with a large final payment
+	ScheduleGraduated    ScheduleType = "graduated"     // payments that step up every period
+)
+
+// ScheduleConfig is the persisted description of how a Loan's schedule is generated.
+// Only the fields relevant to Type are used, the rest are left at zero.
+type ScheduleConfig struct {
+	Type               ScheduleType // which structure the schedule follows
+	InterestOnlyMonths int          // interest_only: how many months only interest is due
+	AmortizationMonths int          // balloon: the term the regular payment is amortized over
+	StepRate           float64      // graduated: how much the payment grows each step (0.075 for 7.5%)
+	StepMonths         int          // graduated: months between steps (defaults to 12)
+	Steps              int          // graduated: number of steps before the payment levels off
+}
+
+// ScheduleGenerator builds the installments of a Loan.
+// The returned payments only carry PaymentNumber, AmountDue and DueDate, they are not persisted.
+type ScheduleGenerator interface {
+	Generate(principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error)
+}
+
+// Generator returns the ScheduleGenerator described by the config.
+// An empty Type is treated as a level schedule.
+func (c ScheduleConfig) Generator() (ScheduleGenerator, error) {
+	switch c.Type {
+	case "", ScheduleLevel:
+		return LevelSchedule{}, nil
+	case ScheduleInterestOnly:
+		return InterestOnlySchedule{InterestOnlyMonths: c.InterestOnlyMonths}, nil
+	case ScheduleBalloon:
+		return BalloonSchedule{AmortizationMonths: c.AmortizationMonths}, nil
+	case ScheduleGraduated:
+		return GraduatedSchedule{StepRate: c.StepRate, StepMonths: c.StepMonths, Steps: c.Steps}, nil
+	default:
+		return nil, fmt.Errorf("unknown schedule type %q", c.Type)
+	}
+}
+
+// normalized fills in the defaults so that what is stored matches what is generated.
+func (c ScheduleConfig) normalized() ScheduleConfig {
+	if c.Type == "" {
+		c.Type = ScheduleLevel
+	}
+	if c.Type == ScheduleGraduated && c.StepMonths == 0 {
+		c.StepMonths = 12
+	}
+	return c
+}
+
+// LevelSchedule produces equal payments that fully amortize the Loan over its term.
+type LevelSchedule struct{}
+
+func (LevelSchedule) Generate(principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error) {
+	monthlyPayment := calculateMonthlyPayment(principal, annualRate, termMonths)
+	payments := make([]Payment, 0, termMonths)
+
+	for i := 1; i <= termMonths; i++ {
+		payments = append(payments, scheduledPayment(i, monthlyPayment, dateTaken, dayDue))
+	}
+
+	return payments, nil
+}
+
+// InterestOnlySchedule charges only interest for the first InterestOnlyMonths,
+// then amortizes the full principal over the remaining months.
+type InterestOnlySchedule struct {
+	InterestOnlyMonths int
+}
+
+func (s InterestOnlySchedule) Generate(principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error) {
+	if s.InterestOnlyMonths < 0 || s.InterestOnlyMonths >= termMonths {
+		return nil, fmt.Errorf("interest-only months must be between 0 and %d, got %d", termMonths-1, s.InterestOnlyMonths)
+	}
+
+	interestPayment := principal * annualRate / 12
+	amortizedPayment := calculateMonthlyPayment(principal, annualRate, termMonths-s.InterestOnlyMonths)
+	payments := make([]Payment, 0, termMonths)
+
+	for i := 1; i <= termMonths; i++ {
+		amount := amortizedPayment
+		if i <= s.InterestOnlyMonths {
+			amount = interestPayment
+		}
+		payments = append(payments, scheduledPayment(i, amount, dateTaken, dayDue))
+	}
+
+	return payments, nil
+}
+
+// BalloonSchedule charges the payment of a loan amortized over AmortizationMonths,
+// and the balance still outstanding at the end of the term is due with the last installment.
+type BalloonSchedule struct {
+	AmortizationMonths int
+}
+
+func (s BalloonSchedule) Generate(principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error) {
+	if s.AmortizationMonths <= termMonths {
+		return nil, fmt.Errorf("balloon amortization months must exceed the term of %d, got %d", termMonths, s.AmortizationMonths)
+	}
+
+	monthlyPayment := calculateMonthlyPayment(principal, annualRate, s.AmortizationMonths)
+	monthlyRate := annualRate / 12
+	balance := principal
+	payments := make([]Payment, 0, termMonths)
+
+	for i := 1; i <= termMonths; i++ {
+		interest := balance * monthlyRate
+
+		amount := monthlyPayment
+		if i == termMonths {
+			// the balloon: whatever is left plus this month's interest
+			amount = balance + interest
+		}
+		balance = balance + interest - amount
+
+		payments = append(payments, scheduledPayment(i, amount, dateTaken, dayDue))
+	}
+
+	return payments, nil
+}
+
+// GraduatedSchedule starts with a lower payment that grows by StepRate every StepMonths,
+// for Steps increases, and stays level afterwards. The first payment is chosen so that
+// the payments fully amortize the Loan over its term.
+type GraduatedSchedule struct {
+	StepRate   float64
+	StepMonths int
+	Steps      int
+}
+
+func (s GraduatedSchedule) Generate(principal, annualRate float64, termMonths, dayDue int, dateTaken time.Time) ([]Payment, error) {
+	stepMonths := s.StepMonths
+	if stepMonths == 0 {
+		stepMonths = 12
+	}
+	if stepMonths < 0 {
+		return nil, fmt.Errorf("graduated step months must be positive, got %d", stepMonths)
+	}
+	if s.StepRate <= 0 {
+		return nil, fmt.Errorf("graduated step rate must be positive, got %.4f", s.StepRate)
+	}
+	if s.Steps <= 0 {
+		return nil, fmt.Errorf("graduated steps must be positive, got %d", s.Steps)
+	}
+
+	// growth factor of installment i relative to the first payment
+	factor := func(i int) float64 {
+		step := (i - 1) / stepMonths
+		if step > s.Steps {
+			step = s.Steps
+		}
+		return math.Pow(1+s.StepRate, float64(step))
+	}
+
+	// the present value of the payments per unit of first payment
+	monthlyRate := annualRate / 12
+	var presentValue float64
+	for i := 1; i <= termMonths; i++ {
+		presentValue += factor(i) / math.Pow(1+monthlyRate, float64(i))
+	}
+	firstPayment := principal / presentValue
+
+	payments := make([]Payment, 0, termMonths)
+	for i := 1; i <= termMonths; i++ {
+		payments = append(payments, scheduledPayment(i, firstPayment*factor(i), dateTaken, dayDue))
+	}
+
+	return payments, nil
+}
+
+// scheduledPayment builds the unsaved installment number i of a schedule.
+func scheduledPayment(i int, amountDue float64, dateTaken time.Time, dayDue int) Payment {
+	return Payment{
+		PaymentNumber: int64(i),
+		AmountDue:     amountDue,
+		DueDate:       calculateDueDate(dateTaken, i, dayDue),
+	}
+}
@@ -0,0 +1,162 @@
+package delinquencytracker
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// formatSchedule renders installments one per line so schedules can be compared against golden files
+func formatSchedule(payments []Payment) string {
+	var b strings.Builder
+	var total float64
+
+	for _, p := range payments {
+		fmt.Fprintf(&b, "%3d  %s  %12.2f\n", p.PaymentNumber, p.DueDate.Format("2006-01-02"), p.AmountDue)
+		total += p.AmountDue
+	}
+	fmt.Fprintf(&b, "total            %12.2f\n", total)
+
+	return b.String()
+}
+
+// checkGolden compares got with testdata/<name>.golden, rewriting it when -update is set
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+	}
+
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "missing golden file, run go test -update")
+	require.Equal(t, string(want), got, "schedule differs from %s", path)
+}
+
+// TestScheduleGeneratorsGolden verifies every schedule structure against its golden file.
+func TestScheduleGeneratorsGolden(t *testing.T) {
+	dateTaken := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		config    ScheduleConfig
+		principal float64
+		rate      float64
+		term      int
+	}{
+		{"level", ScheduleConfig{Type: ScheduleLevel}, 10000, 0.06, 12},
+		{"interest_only", ScheduleConfig{Type: ScheduleInterestOnly, InterestOnlyMonths: 6}, 100000, 0.08, 24},
+		{"balloon", ScheduleConfig{Type: ScheduleBalloon, AmortizationMonths: 360}, 200000, 0.065, 60},
+		{"graduated", ScheduleConfig{Type: ScheduleGraduated, StepRate: 0.075, Steps: 3}, 50000, 0.05, 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen, err := tt.config.Generator()
+			require.NoError(t, err)
+
+			payments, err := gen.Generate(tt.principal, tt.rate, tt.term, 15, dateTaken)
+			require.NoError(t, err)
+			require.Len(t, payments, tt.term)
+
+			checkGolden(t, filepath.Join("schedules", tt.name), formatSchedule(payments))
+		})
+	}
+}
+
+// TestLevelScheduleMatchesMonthlyPayment verifies the level generator keeps the original amortization.
+func TestLevelScheduleMatchesMonthlyPayment(t *testing.T) {
+	dateTaken := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	payments, err := LevelSchedule{}.Generate(20000, 0.05, 60, 15, dateTaken)
+	require.NoError(t, err)
+
+	expected := calculateMonthlyPayment(20000, 0.05, 60)
+	for i, p := range payments {
+		require.Equal(t, expected, p.AmountDue, "Payment %d should be the level payment", i+1)
+		require.Equal(t, calculateDueDate(dateTaken, i+1, 15), p.DueDate)
+	}
+}
+
+// TestScheduleGeneratorsAmortize verifies the discounted installments of every structure repay the principal.
+func TestScheduleGeneratorsAmortize(t *testing.T) {
+	dateTaken := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	principal, rate, term := 50000.0, 0.07, 48
+
+	generators := []ScheduleGenerator{
+		LevelSchedule{},
+		InterestOnlySchedule{InterestOnlyMonths: 12},
+		BalloonSchedule{AmortizationMonths: 120},
+		GraduatedSchedule{StepRate: 0.05, StepMonths: 12, Steps: 2},
+	}
+
+	for _, gen := range generators {
+		payments, err := gen.Generate(principal, rate, term, 1, dateTaken)
+		require.NoError(t, err)
+
+		// roll the balance forward month by month, it should land on zero
+		balance := principal
+		for _, p := range payments {
+			balance = balance*(1+rate/12) - p.AmountDue
+		}
+		require.InDelta(t, 0, balance, 0.01, "%T should fully repay the Loan", gen)
+	}
+}
+
+// TestScheduleGeneratorsRejectBadParameters verifies structural validation of each generator.
+func TestScheduleGeneratorsRejectBadParameters(t *testing.T) {
+	dateTaken := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config ScheduleConfig
+	}{
+		{"Unknown type", ScheduleConfig{Type: "weekly"}},
+		{"Interest-only covers whole term", ScheduleConfig{Type: ScheduleInterestOnly, InterestOnlyMonths: 12}},
+		{"Balloon amortization shorter than term", ScheduleConfig{Type: ScheduleBalloon, AmortizationMonths: 6}},
+		{"Graduated without step rate", ScheduleConfig{Type: ScheduleGraduated, Steps: 3}},
+		{"Graduated without steps", ScheduleConfig{Type: ScheduleGraduated, StepRate: 0.05}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := generateSchedule(LoanOptions{Schedule: tt.config}, 10000, 0.05, 12, 15, dateTaken)
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestInitializeUserWithBalloonLoan verifies the schedule structure is persisted with the Loan.
+func TestInitializeUserWithBalloonLoan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	dateTaken := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	opts := LoanOptions{Schedule: ScheduleConfig{Type: ScheduleBalloon, AmortizationMonths: 360}}
+
+	// Act
+	user, err := InitializeUserWithLoanOptions(db, "Balloon User", "balloon@example.com", "555-3333",
+		200000, 0.065, 60, 15, dateTaken, false, opts)
+
+	// Assert
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	require.Equal(t, ScheduleBalloon, ln.Schedule.Type)
+	require.Len(t, ln.Payments, 60)
+	require.Greater(t, ln.Payments[59].AmountDue, ln.Payments[0].AmountDue*10, "last payment should be the balloon")
+
+	stored, err := GetLoanByLoanID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, opts.Schedule, stored.Schedule, "schedule structure should be persisted")
+}
@@ -0,0 +1,525 @@
+-- Schema for the delinquency tracker.
+-- Every statement is idempotent so the file can be re-applied to an existing
+-- database with ApplySchema (or psql -f schema.sql) after each upgrade.
+
+CREATE TABLE IF NOT EXISTS users (
+	id         BIGSERIAL PRIMARY KEY,
+	name       TEXT NOT NULL,
+	email      TEXT NOT NULL UNIQUE,
+	phone      TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS loans (
+	id            BIGSERIAL PRIMARY KEY,
+	user_id       BIGINT NOT NULL REFERENCES users(id),
+	total_amount  DOUBLE PRECISION NOT NULL,
+	interest_rate DOUBLE PRECISION NOT NULL,
+	term_months   INTEGER NOT NULL,
+	day_due       INTEGER NOT NULL,
+	status        TEXT NOT NULL,
+	date_taken    TIMESTAMPTZ NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+	id             BIGSERIAL PRIMARY KEY,
+	loan_id        BIGINT NOT NULL REFERENCES loans(id),
+	payment_number BIGINT NOT NULL,
+	amount_due     DOUBLE PRECISION NOT NULL,
+	amount_paid    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	due_date       TIMESTAMPTZ NOT NULL,
+	paid_date      TIMESTAMPTZ,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Payment schedule structures (level, interest-only, balloon, graduated)
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS schedule_type TEXT NOT NULL DEFAULT 'level';
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS interest_only_months INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS amortization_months INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS step_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS step_months INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS step_count INTEGER NOT NULL DEFAULT 0;
+
+-- Variable-rate loans: index values, adjustable rate terms and the rate history
+CREATE TABLE IF NOT EXISTS rate_index_values (
+	index_name     TEXT NOT NULL,
+	effective_date TIMESTAMPTZ NOT NULL,
+	rate           DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (index_name, effective_date)
+);
+
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS rate_index TEXT NOT NULL DEFAULT '';
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS rate_margin DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS first_reset_months INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS reset_months INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS periodic_cap DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS periodic_floor DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS lifetime_cap DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS lifetime_floor DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS loan_rate_history (
+	id             BIGSERIAL PRIMARY KEY,
+	loan_id        BIGINT NOT NULL REFERENCES loans(id),
+	from_payment   BIGINT NOT NULL,
+	effective_date TIMESTAMPTZ NOT NULL,
+	index_value    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	margin         DOUBLE PRECISION NOT NULL DEFAULT 0,
+	rate           DOUBLE PRECISION NOT NULL,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Business-day adjustment of due dates
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS business_day_convention TEXT NOT NULL DEFAULT 'unadjusted';
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS holiday_calendar TEXT NOT NULL DEFAULT '';
+
+-- Loan modifications and forbearance
+CREATE TABLE IF NOT EXISTS loan_modifications (
+	id                 BIGSERIAL PRIMARY KEY,
+	loan_id            BIGINT NOT NULL REFERENCES loans(id),
+	type               TEXT NOT NULL,
+	from_payment       BIGINT NOT NULL DEFAULT 0,
+	effective_date     TIMESTAMPTZ NOT NULL,
+	end_date           TIMESTAMPTZ,
+	approver           TEXT NOT NULL,
+	reason             TEXT NOT NULL DEFAULT '',
+	deferred_payments  INTEGER NOT NULL DEFAULT 0,
+	extension_months   INTEGER NOT NULL DEFAULT 0,
+	new_rate           DOUBLE PRECISION NOT NULL DEFAULT 0,
+	capitalized_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+	before_terms       JSONB NOT NULL,
+	after_terms        JSONB NOT NULL,
+	created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Fees, payment posting, charge-offs and recoveries
+CREATE TABLE IF NOT EXISTS loan_fees (
+	id            BIGSERIAL PRIMARY KEY,
+	loan_id       BIGINT NOT NULL REFERENCES loans(id),
+	type          TEXT NOT NULL,
+	amount        DOUBLE PRECISION NOT NULL,
+	amount_paid   DOUBLE PRECISION NOT NULL DEFAULT 0,
+	assessed_date TIMESTAMPTZ NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS charge_offs (
+	id              BIGSERIAL PRIMARY KEY,
+	loan_id         BIGINT NOT NULL UNIQUE REFERENCES loans(id),
+	charge_off_date TIMESTAMPTZ NOT NULL,
+	principal       DOUBLE PRECISION NOT NULL,
+	interest        DOUBLE PRECISION NOT NULL,
+	fees            DOUBLE PRECISION NOT NULL,
+	days_past_due   INTEGER NOT NULL,
+	approver        TEXT NOT NULL,
+	reason          TEXT NOT NULL DEFAULT '',
+	created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS posted_payments (
+	id               BIGSERIAL PRIMARY KEY,
+	loan_id          BIGINT NOT NULL REFERENCES loans(id),
+	amount           DOUBLE PRECISION NOT NULL,
+	received_date    TIMESTAMPTZ NOT NULL,
+	kind             TEXT NOT NULL,
+	charge_off_id    BIGINT REFERENCES charge_offs(id),
+	unapplied_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+	created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS payment_allocations (
+	id                BIGSERIAL PRIMARY KEY,
+	posted_payment_id BIGINT NOT NULL REFERENCES posted_payments(id),
+	payment_id        BIGINT REFERENCES payments(id),
+	fee_id            BIGINT REFERENCES loan_fees(id),
+	amount            DOUBLE PRECISION NOT NULL
+);
+
+-- Returned payments
+ALTER TABLE posted_payments ADD COLUMN IF NOT EXISTS reversed BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS payment_reversals (
+	id                BIGSERIAL PRIMARY KEY,
+	posted_payment_id BIGINT NOT NULL UNIQUE REFERENCES posted_payments(id),
+	loan_id           BIGINT NOT NULL REFERENCES loans(id),
+	return_date       TIMESTAMPTZ NOT NULL,
+	reason_code       TEXT NOT NULL,
+	fee_id            BIGINT REFERENCES loan_fees(id),
+	created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Append-only ledger
+ALTER TABLE loan_fees ADD COLUMN IF NOT EXISTS amount_waived DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- loan_id has no foreign key so the ledger outlives a deleted Loan
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id                BIGSERIAL PRIMARY KEY,
+	loan_id           BIGINT NOT NULL,
+	type              TEXT NOT NULL,
+	debit_account     TEXT NOT NULL,
+	credit_account    TEXT NOT NULL,
+	amount            DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+	payment_number    BIGINT NOT NULL DEFAULT 0,
+	fee_id            BIGINT REFERENCES loan_fees(id),
+	posted_payment_id BIGINT REFERENCES posted_payments(id),
+	effective_date    TIMESTAMPTZ NOT NULL,
+	posted_date       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	memo              TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_loan_id ON ledger_entries (loan_id, id);
+
+-- entries are corrected with new entries, never changed
+CREATE OR REPLACE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+
+-- Row history: every version of users, loans and payments as it was recorded
+CREATE TABLE IF NOT EXISTS row_history (
+	id          BIGSERIAL PRIMARY KEY,
+	table_name  TEXT NOT NULL,
+	row_id      BIGINT NOT NULL,
+	operation   TEXT NOT NULL,
+	row_data    JSONB NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS row_history_row ON row_history (table_name, row_id, recorded_at);
+
+CREATE OR REPLACE FUNCTION record_row_history() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		INSERT INTO row_history (table_name, row_id, operation, row_data) VALUES (TG_TABLE_NAME, OLD.id, TG_OP, to_jsonb(OLD));
+		RETURN OLD;
+	END IF;
+	INSERT INTO row_history (table_name, row_id, operation, row_data) VALUES (TG_TABLE_NAME, NEW.id, TG_OP, to_jsonb(NEW));
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_history ON users;
+CREATE TRIGGER users_history AFTER INSERT OR UPDATE OR DELETE ON users
+	FOR EACH ROW EXECUTE FUNCTION record_row_history();
+DROP TRIGGER IF EXISTS loans_history ON loans;
+CREATE TRIGGER loans_history AFTER INSERT OR UPDATE OR DELETE ON loans
+	FOR EACH ROW EXECUTE FUNCTION record_row_history();
+DROP TRIGGER IF EXISTS payments_history ON payments;
+CREATE TRIGGER payments_history AFTER INSERT OR UPDATE OR DELETE ON payments
+	FOR EACH ROW EXECUTE FUNCTION record_row_history();
+
+-- rows that predate the history are taken as unchanged since they were created
+INSERT INTO row_history (table_name, row_id, operation, row_data, recorded_at)
+SELECT 'users', u.id, 'INSERT', to_jsonb(u), u.created_at FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM row_history h WHERE h.table_name = 'users' AND h.row_id = u.id);
+INSERT INTO row_history (table_name, row_id, operation, row_data, recorded_at)
+SELECT 'loans', l.id, 'INSERT', to_jsonb(l), l.created_at FROM loans l
+WHERE NOT EXISTS (SELECT 1 FROM row_history h WHERE h.table_name = 'loans' AND h.row_id = l.id);
+INSERT INTO row_history (table_name, row_id, operation, row_data, recorded_at)
+SELECT 'payments', p.id, 'INSERT', to_jsonb(p), p.created_at FROM payments p
+WHERE NOT EXISTS (SELECT 1 FROM row_history h WHERE h.table_name = 'payments' AND h.row_id = p.id);
+
+-- Audit log: who changed what, when and why
+CREATE TABLE IF NOT EXISTS audit_log (
+	id          BIGSERIAL PRIMARY KEY,
+	actor       TEXT NOT NULL,
+	operation   TEXT NOT NULL,
+	entity_type TEXT NOT NULL,
+	entity_id   BIGINT NOT NULL,
+	before_data JSONB,
+	after_data  JSONB,
+	reason      TEXT NOT NULL DEFAULT '',
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+-- Soft deletion: users and loans are marked deleted instead of removed, so their
+-- payment history is kept. The foreign keys above have no ON DELETE action on purpose:
+-- a hard DELETE of a User or Loan that still has rows pointing at it fails.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS deleted_by TEXT NOT NULL DEFAULT '';
+-- loan_rate_history was created with ON DELETE CASCADE before this, replace its key with a plain one
+ALTER TABLE loan_rate_history DROP CONSTRAINT IF EXISTS loan_rate_history_loan_id_fkey;
+ALTER TABLE loan_rate_history ADD CONSTRAINT loan_rate_history_loan_id_fkey FOREIGN KEY (loan_id) REFERENCES loans(id);
+
+-- Optimistic concurrency: every change to a Loan or Payment bumps its version,
+-- and UpdateLoan and UpdatePayment only apply on top of the version the caller read
+ALTER TABLE loans ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+	NEW.version := OLD.version + 1;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS loans_version ON loans;
+CREATE TRIGGER loans_version BEFORE UPDATE ON loans
+	FOR EACH ROW EXECUTE FUNCTION bump_version();
+DROP TRIGGER IF EXISTS payments_version ON payments;
+CREATE TRIGGER payments_version BEFORE UPDATE ON payments
+	FOR EACH ROW EXECUTE FUNCTION bump_version();
+
+-- Idempotency keys: the result of each keyed origination or payment posting, returned on replay
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key          TEXT PRIMARY KEY,
+	operation    TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	result       JSONB,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- User profiles: date of birth, time zone, language, contact preferences and mailing addresses
+ALTER TABLE users ADD COLUMN IF NOT EXISTS date_of_birth DATE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS contact_channel TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS contact_opt_outs TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS user_addresses (
+	id          BIGSERIAL PRIMARY KEY,
+	user_id     BIGINT NOT NULL REFERENCES users(id),
+	line1       TEXT NOT NULL,
+	line2       TEXT NOT NULL DEFAULT '',
+	city        TEXT NOT NULL,
+	region      TEXT NOT NULL DEFAULT '',
+	postal_code TEXT NOT NULL DEFAULT '',
+	country     TEXT NOT NULL,
+	valid_from  TIMESTAMPTZ NOT NULL,
+	valid_to    TIMESTAMPTZ,
+	created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- at most one current address per User
+CREATE UNIQUE INDEX IF NOT EXISTS user_addresses_current_idx ON user_addresses (user_id) WHERE valid_to IS NULL;
+
+-- Duplicate users: a User merged into another keeps its row, soft-deleted, and
+-- points at the survivor so its old ID still resolves
+ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into BIGINT REFERENCES users(id);
+
+-- User search: trigram indexes for fuzzy names and substring matches on email and phone
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS users_name_trgm_idx ON users USING gin (lower(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_email_trgm_idx ON users USING gin (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_phone_trgm_idx ON users USING gin (phone gin_trgm_ops);
+
+-- Loan parties: co-borrowers and guarantors liable for a Loan alongside its
+-- primary borrower, who stays loans.user_id
+CREATE TABLE IF NOT EXISTS loan_parties (
+	id       BIGSERIAL PRIMARY KEY,
+	loan_id  BIGINT NOT NULL REFERENCES loans(id),
+	user_id  BIGINT NOT NULL REFERENCES users(id),
+	role     TEXT NOT NULL CHECK (role IN ('co_borrower', 'guarantor')),
+	added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (loan_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS loan_parties_user_idx ON loan_parties (user_id);
+
+-- Collections: a case is opened for each Loan that falls far enough behind, routed to a
+-- queue, worked by a collector and closed when the Loan cures
+CREATE TABLE IF NOT EXISTS collection_cases (
+	id              BIGSERIAL PRIMARY KEY,
+	loan_id         BIGINT NOT NULL REFERENCES loans(id),
+	queue           TEXT NOT NULL,
+	status          TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+	days_past_due   INTEGER NOT NULL,
+	past_due_amount DOUBLE PRECISION NOT NULL,
+	balance         DOUBLE PRECISION NOT NULL,
+	assigned_to     TEXT NOT NULL DEFAULT '',
+	assigned_at     TIMESTAMPTZ,
+	opened_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	closed_at       TIMESTAMPTZ,
+	close_reason    TEXT NOT NULL DEFAULT ''
+);
+
+-- at most one open case per Loan
+CREATE UNIQUE INDEX IF NOT EXISTS collection_cases_open_idx ON collection_cases (loan_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS collection_cases_queue_idx ON collection_cases (queue, days_past_due DESC) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS collection_contacts (
+	id           BIGSERIAL PRIMARY KEY,
+	case_id      BIGINT NOT NULL REFERENCES collection_cases(id),
+	user_id      BIGINT NOT NULL REFERENCES users(id),
+	collector    TEXT NOT NULL,
+	channel      TEXT NOT NULL,
+	outcome      TEXT NOT NULL,
+	notes        TEXT NOT NULL DEFAULT '',
+	contacted_at TIMESTAMPTZ NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS collection_contacts_case_idx ON collection_contacts (case_id, contacted_at);
+
+-- Promises to pay: what a borrower promised to pay by when, settled as kept,
+-- partially kept or broken from the payments posted by then
+CREATE TABLE IF NOT EXISTS promises_to_pay (
+	id            BIGSERIAL PRIMARY KEY,
+	loan_id       BIGINT NOT NULL REFERENCES loans(id),
+	case_id       BIGINT REFERENCES collection_cases(id),
+	user_id       BIGINT NOT NULL REFERENCES users(id),
+	collector     TEXT NOT NULL,
+	amount        DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+	made_on       TIMESTAMPTZ NOT NULL,
+	promised_date TIMESTAMPTZ NOT NULL,
+	status        TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'kept', 'partially_kept', 'broken')),
+	amount_paid   DOUBLE PRECISION NOT NULL DEFAULT 0,
+	evaluated_at  TIMESTAMPTZ,
+	created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- at most one pending promise per Loan
+CREATE UNIQUE INDEX IF NOT EXISTS promises_to_pay_pending_idx ON promises_to_pay (loan_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS promises_to_pay_due_idx ON promises_to_pay (promised_date, collector);
+
+-- Repayment plans: the arrears of a delinquent Loan spread over installments due on top
+-- of its regular payments, kept apart from the Loan's own schedule
+CREATE TABLE IF NOT EXISTS repayment_plans (
+	id         BIGSERIAL PRIMARY KEY,
+	loan_id    BIGINT NOT NULL REFERENCES loans(id),
+	arrears    DOUBLE PRECISION NOT NULL CHECK (arrears > 0),
+	start_date TIMESTAMPTZ NOT NULL,
+	grace_days INTEGER NOT NULL,
+	max_missed INTEGER NOT NULL,
+	status     TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'completed', 'broken', 'cancelled')),
+	approver   TEXT NOT NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	ended_at   TIMESTAMPTZ,
+	end_reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- at most one active plan per Loan
+CREATE UNIQUE INDEX IF NOT EXISTS repayment_plans_active_idx ON repayment_plans (loan_id) WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS repayment_plan_installments (
+	id         BIGSERIAL PRIMARY KEY,
+	plan_id    BIGINT NOT NULL REFERENCES repayment_plans(id),
+	number     INTEGER NOT NULL,
+	due_date   TIMESTAMPTZ NOT NULL,
+	amount_due DOUBLE PRECISION NOT NULL,
+	UNIQUE (plan_id, number)
+);
+
+-- Settlements: offers to close a delinquent or charged-off Loan for less than is owed.
+-- Once paid the Loan is settled and the forgiven amount is kept for reporting.
+CREATE TABLE IF NOT EXISTS settlements (
+	id                 BIGSERIAL PRIMARY KEY,
+	loan_id            BIGINT NOT NULL REFERENCES loans(id),
+	kind               TEXT NOT NULL CHECK (kind IN ('lump_sum', 'installments')),
+	amount             DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+	balance            DOUBLE PRECISION NOT NULL,
+	offered_on         TIMESTAMPTZ NOT NULL,
+	expires_on         TIMESTAMPTZ NOT NULL,
+	status             TEXT NOT NULL DEFAULT 'pending'
+		CHECK (status IN ('pending', 'approved', 'satisfied', 'rejected', 'expired', 'broken')),
+	offered_by         TEXT NOT NULL,
+	approver           TEXT NOT NULL DEFAULT '',
+	decided_at         TIMESTAMPTZ,
+	paid               DOUBLE PRECISION NOT NULL DEFAULT 0,
+	settled_on         TIMESTAMPTZ,
+	forgiven_principal DOUBLE PRECISION NOT NULL DEFAULT 0,
+	forgiven_interest  DOUBLE PRECISION NOT NULL DEFAULT 0,
+	forgiven_fees      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	end_reason         TEXT NOT NULL DEFAULT '',
+	created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- at most one pending or approved settlement per Loan
+CREATE UNIQUE INDEX IF NOT EXISTS settlements_open_idx ON settlements (loan_id) WHERE status IN ('pending', 'approved');
+CREATE INDEX IF NOT EXISTS settlements_settled_idx ON settlements (settled_on) WHERE status = 'satisfied';
+
+CREATE TABLE IF NOT EXISTS settlement_installments (
+	settlement_id BIGINT NOT NULL REFERENCES settlements(id),
+	number        INTEGER NOT NULL,
+	due_date      TIMESTAMPTZ NOT NULL,
+	amount        DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (settlement_id, number)
+);
+
+-- Strategy actions: what a collection strategy run called for on each Loan.
+-- A rule fires at most once per Loan and day, so reruns do not repeat its action.
+CREATE TABLE IF NOT EXISTS strategy_actions (
+	id         BIGSERIAL PRIMARY KEY,
+	loan_id    BIGINT NOT NULL REFERENCES loans(id),
+	strategy   TEXT NOT NULL DEFAULT '',
+	rule       TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	queue      TEXT NOT NULL DEFAULT '',
+	template   TEXT NOT NULL DEFAULT '',
+	as_of      TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	UNIQUE (loan_id, rule, as_of)
+);
+
+-- Notifications: every notice sent, or meant to be sent, to a borrower and whether it was delivered.
+-- A notice about a Loan and installment goes to a user once; failed ones are retried.
+CREATE TABLE IF NOT EXISTS notifications (
+	id             BIGSERIAL PRIMARY KEY,
+	user_id        BIGINT NOT NULL REFERENCES users(id),
+	loan_id        BIGINT NOT NULL REFERENCES loans(id),
+	kind           TEXT NOT NULL CHECK (kind IN ('upcoming_due', 'missed_payment', 'payoff')),
+	payment_number INTEGER NOT NULL DEFAULT 0,
+	channel        TEXT NOT NULL DEFAULT '',
+	recipient      TEXT NOT NULL DEFAULT '',
+	subject        TEXT NOT NULL DEFAULT '',
+	body           TEXT NOT NULL DEFAULT '',
+	status         TEXT NOT NULL CHECK (status IN ('pending', 'sent', 'failed', 'skipped')),
+	error          TEXT NOT NULL DEFAULT '',
+	attempts       INTEGER NOT NULL DEFAULT 0,
+	created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	sent_at        TIMESTAMPTZ,
+	UNIQUE (user_id, loan_id, kind, payment_number)
+);
+-- when a run last claimed the notice, a pending claim older than the run's timeout is taken over
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- In-app messages: the borrower's inbox in the app.
+CREATE TABLE IF NOT EXISTS in_app_messages (
+	id         BIGSERIAL PRIMARY KEY,
+	user_id    BIGINT NOT NULL REFERENCES users(id),
+	kind       TEXT NOT NULL DEFAULT '',
+	subject    TEXT NOT NULL DEFAULT '',
+	body       TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	read_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS in_app_messages_user_idx ON in_app_messages (user_id, created_at);
+
+-- Message templates: what notices say on each channel in each language.
+-- Drafts can be previewed; one template per notice, channel and locale is active.
+CREATE TABLE IF NOT EXISTS message_templates (
+	id           BIGSERIAL PRIMARY KEY,
+	name         TEXT NOT NULL,
+	channel      TEXT NOT NULL CHECK (channel IN ('email', 'sms', 'in_app')),
+	locale       TEXT NOT NULL,
+	subject      TEXT NOT NULL DEFAULT '',
+	body         TEXT NOT NULL,
+	html         TEXT NOT NULL DEFAULT '',
+	status       TEXT NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'active', 'retired')),
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	activated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS message_templates_active_idx ON message_templates (name, channel, locale) WHERE status = 'active';
@@ -0,0 +1,188 @@
+package delinquencytracker
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Paging limits of SearchUsers
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// results ranked below this are not matches; it is also pg_trgm's default % threshold
+const minSearchRank = 0.3
+
+// phone queries need at least this many digits, so a caller's last four find them
+const minPhoneSuffix = 4
+
+// UserSearch is a search for users from partial information
+type UserSearch struct {
+	Query  string // part of a name, an email fragment, or the last digits of a phone number
+	Limit  int    // results per page, DefaultSearchLimit when zero
+	Offset int    // results to skip, for later pages
+}
+
+// UserSearchResult is one User found by SearchUsers
+type UserSearchResult struct {
+	User    User        // the User found
+	Rank    float64     // how well they matched, from 0 to 1
+	Summary LoanSummary // their loans and how delinquent they are
+}
+
+// UserSearchPage is one page of search results, best match first
+type UserSearchPage struct {
+	Results []UserSearchResult
+	Total   int // matches across all pages
+	Limit   int
+	Offset  int
+}
+
+// LoanSummary sums up a User's loans and their delinquency on a day
+type LoanSummary struct {
+	Loans          int     // loans the User has, paid off ones included
+	ActiveLoans    int     // loans still being repaid
+	PastDueAmount  float64 // owed on past due installments across all loans
+	MaxDaysPastDue int     // days past due of the most delinquent Loan
+	Bucket         string  // aging bucket of the most delinquent Loan
+}
+
+// SearchUsers finds users by a case-insensitive prefix or substring of their name or email, a name that is
+// spelled alike, or the last digits of their phone, ranked best match first. Deleted users are left out.
+func SearchUsers(db *sql.DB, s UserSearch) (UserSearchPage, error) {
+	query := strings.ToLower(strings.TrimSpace(s.Query))
+	if query == "" {
+		return UserSearchPage{}, fmt.Errorf("search query cannot be empty")
+	}
+	if s.Limit == 0 {
+		s.Limit = DefaultSearchLimit
+	}
+	if s.Limit < 0 || s.Limit > MaxSearchLimit {
+		return UserSearchPage{}, fmt.Errorf("search limit must be between 1 and %d, got %d", MaxSearchLimit, s.Limit)
+	}
+	if s.Offset < 0 {
+		return UserSearchPage{}, fmt.Errorf("search offset cannot be negative, got %d", s.Offset)
+	}
+
+	// prefix matches rank above substring matches, which rank above names that are only spelled alike
+	sqlQuery := `
+	SELECT ` + userColumns + `, rank, COUNT(*) OVER ()
+	FROM (
+		SELECT u.*, GREATEST(
+			CASE
+				WHEN lower(name) LIKE $2::text || '%' OR email LIKE $2::text || '%' THEN 1.0
+				WHEN lower(name) LIKE '%' || $2::text || '%' OR email LIKE '%' || $2::text || '%' THEN 0.8
+				ELSE 0
+			END,
+			CASE WHEN $3::text <> '' AND phone LIKE '%' || $3::text THEN 0.9 ELSE 0 END,
+			similarity(lower(name), $1::text)
+		) AS rank
+		FROM users u
+		WHERE deleted_at IS NULL
+		  AND (lower(name) % $1::text OR lower(name) LIKE '%' || $2::text || '%' OR email LIKE '%' || $2::text || '%'
+		       OR ($3::text <> '' AND phone LIKE '%' || $3::text))
+	) matches
+	WHERE rank >= $4
+	ORDER BY rank DESC, id
+	LIMIT $5 OFFSET $6
+	`
+
+	rows, err := db.Query(sqlQuery, query, likeEscape(query), phoneSuffix(query), minSearchRank, s.Limit, s.Offset)
+	if err != nil {
+		return UserSearchPage{}, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	page := UserSearchPage{Results: []UserSearchResult{}, Limit: s.Limit, Offset: s.Offset}
+
+	for rows.Next() {
+		var r UserSearchResult
+		usr, err := scanUser(scannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &r.Rank, &page.Total)...)
+		}))
+		if err != nil {
+			return UserSearchPage{}, fmt.Errorf("failed to scan User row: %w", err)
+		}
+		r.User = usr
+		page.Results = append(page.Results, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return UserSearchPage{}, fmt.Errorf("error iterating User rows: %w", err)
+	}
+	rows.Close()
+
+	today := time.Now().UTC()
+	for i := range page.Results {
+		page.Results[i].Summary, err = GetLoanSummary(db, page.Results[i].User.ID, today)
+		if err != nil {
+			return UserSearchPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// scannerFunc lets a function stand in for a row, to scan extra columns after the ones a scan helper reads
+type scannerFunc func(dest ...any) error
+
+func (f scannerFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// likeEscape escapes the LIKE wildcards in s so it matches literally
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// phoneSuffix returns the digits of a query made only of digits and phone punctuation,
+// or "" when the query is not a phone number fragment
+func phoneSuffix(query string) string {
+	var digits strings.Builder
+	for _, r := range query {
+		switch {
+		case unicode.IsDigit(r):
+			digits.WriteRune(r)
+		case strings.ContainsRune("+ -.()", r):
+		default:
+			return ""
+		}
+	}
+	if digits.Len() < minPhoneSuffix {
+		return ""
+	}
+	return digits.String()
+}
+
+// GetLoanSummary sums up a User's loans and their delinquency as of a day.
+func GetLoanSummary(db *sql.DB, userID int64, asOf time.Time) (LoanSummary, error) {
+	loans, err := GetLoansByUserID(db, userID)
+	if err != nil {
+		return LoanSummary{}, err
+	}
+
+	summary := LoanSummary{Loans: len(loans), Bucket: BucketCurrent}
+
+	for _, ln := range loans {
+		if ln.Status != LoanStatusActive && ln.Status != LoanStatusDefaulted {
+			continue
+		}
+		summary.ActiveLoans++
+
+		d, err := GetLoanDelinquency(db, ln.ID, asOf)
+		if err != nil {
+			return LoanSummary{}, err
+		}
+		summary.PastDueAmount += d.PastDueAmount
+		if d.DaysPastDue > summary.MaxDaysPastDue {
+			summary.MaxDaysPastDue = d.DaysPastDue
+			summary.Bucket = d.Bucket
+		}
+	}
+
+	return summary, nil
+}
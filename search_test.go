@@ -0,0 +1,98 @@
+package delinquencytracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPhoneSuffix verifies only digit-only queries are read as phone fragments.
+func TestPhoneSuffix(t *testing.T) {
+	require.Equal(t, "0101", phoneSuffix("0101"))
+	require.Equal(t, "5550101", phoneSuffix("555-0101"))
+	require.Equal(t, "", phoneSuffix("101"), "too few digits")
+	require.Equal(t, "", phoneSuffix("jane 0101"))
+	require.Equal(t, `50\%\_off`, likeEscape("50%_off"))
+}
+
+// TestSearchUsers verifies prefix, fuzzy, email and phone-suffix matches are ranked and paged.
+func TestSearchUsers(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	jonathan, err := InitializeUserWithLoan(db, "Jonathan Miller", "jmiller@example.com", "555-0101",
+		1200, 5, 12, 1, time.Now().UTC().AddDate(0, -3, 0), false)
+	require.NoError(t, err)
+	jonathon, err := CreateUser(db, "Jonathon Millar", "jon@example.org", "555-0202")
+	require.NoError(t, err)
+	_, err = CreateUser(db, "Alice Smith", "alice@example.com", "555-0303")
+	require.NoError(t, err)
+
+	// Act & Assert: a name prefix ranks above a name that is only spelled alike
+	page, err := SearchUsers(db, UserSearch{Query: "jonathan mil"})
+	require.NoError(t, err)
+	require.Equal(t, 2, page.Total)
+	require.Equal(t, jonathan.ID, page.Results[0].User.ID)
+	require.Equal(t, jonathon.ID, page.Results[1].User.ID)
+	require.Greater(t, page.Results[0].Rank, page.Results[1].Rank)
+
+	summary := page.Results[0].Summary
+	require.Equal(t, 1, summary.ActiveLoans)
+	require.Greater(t, summary.PastDueAmount, 0.0)
+	require.NotEqual(t, BucketCurrent, summary.Bucket)
+
+	// email fragment
+	page, err = SearchUsers(db, UserSearch{Query: "EXAMPLE.ORG"})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, jonathon.ID, page.Results[0].User.ID)
+
+	// last four digits of the phone
+	page, err = SearchUsers(db, UserSearch{Query: "0303"})
+	require.NoError(t, err)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, "Alice Smith", page.Results[0].User.Name)
+
+	// pages
+	page, err = SearchUsers(db, UserSearch{Query: "example", Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, 3, page.Total)
+	require.Len(t, page.Results, 1)
+
+	_, err = SearchUsers(db, UserSearch{Query: "x", Limit: MaxSearchLimit + 1})
+	require.Error(t, err)
+}
+
+// TestSearchUsersHandler verifies the HTTP API returns a page of results and rejects bad paging.
+func TestSearchUsersHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange
+	usr, err := CreateUser(db, "Handler User", "handler@example.com", "555-0404")
+	require.NoError(t, err)
+	handler := NewHandler(db)
+
+	// Act
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/search?q=handler&limit=5", nil))
+
+	// Assert
+	require.Equal(t, http.StatusOK, rec.Code)
+	var page UserSearchPage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Equal(t, 5, page.Limit)
+	require.Len(t, page.Results, 1)
+	require.Equal(t, usr.ID, page.Results[0].User.ID)
+
+	for _, target := range []string{"/users/search", "/users/search?q=a&limit=x", "/users/search?q=a&offset=-1"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
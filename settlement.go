@@ -0,0 +1,547 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Settlement kinds
+const (
+	SettlementLumpSum      = "lump_sum"     // one payment
+	SettlementInstallments = "installments" // monthly payments
+)
+
+// Settlement statuses
+const (
+	SettlementPending   = "pending"   // offered, waiting for approval
+	SettlementApproved  = "approved"  // approved, waiting for the borrower's payments
+	SettlementSatisfied = "satisfied" // paid as agreed, the Loan is settled
+	SettlementRejected  = "rejected"  // turned down at approval
+	SettlementExpired   = "expired"   // not approved before it expired
+	SettlementBroken    = "broken"    // approved, but the borrower did not pay as agreed
+)
+
+// DefaultSettlementGraceDays is how late a settlement installment may be paid before the settlement breaks
+const DefaultSettlementGraceDays = 10
+
+// SettlementOffer is what a collector offers a borrower: to close their Loan for less than they owe.
+type SettlementOffer struct {
+	LoanID       int64     // the Loan to settle
+	Amount       float64   // what the borrower pays to settle, less than they owe
+	Installments int       // 1 for a lump sum, more for monthly installments
+	FirstDueDate time.Time // when the lump sum or first installment is due (default ExpiresOn)
+	OfferedOn    time.Time // the day of the offer (default today); payments from then on count
+	ExpiresOn    time.Time // the offer lapses unless it is approved by this day
+}
+
+// Settlement is a settlement offer and what became of it
+type Settlement struct {
+	ID           int64                   // unique identifier for the settlement
+	LoanID       int64                   // the Loan being settled
+	Kind         string                  // "lump_sum" or "installments"
+	Amount       float64                 // what the borrower agreed to pay
+	Balance      float64                 // what they owed when it was offered
+	OfferedOn    time.Time               // the day of the offer
+	ExpiresOn    time.Time               // the day it lapses unless approved
+	Installments []SettlementInstallment // when the agreed amount is due
+	Status       string                  // "pending", "approved", "satisfied", "rejected", "expired" or "broken"
+	OfferedBy    string                  // who made the offer
+	Approver     string                  // who approved or rejected it
+	DecidedAt    time.Time               // when it was approved or rejected
+	Paid         float64                 // paid towards it when it was last evaluated
+	SettledOn    time.Time               // the day the Loan was settled (zero until satisfied)
+	Forgiven     ForgivenAmount          // what was forgiven when the Loan was settled
+	EndReason    string                  // why it was rejected, expired or broke
+	CreatedAt    time.Time               // when was this record created
+}
+
+// SettlementInstallment is one payment due under a settlement
+type SettlementInstallment struct {
+	Number  int       // 1 for the first
+	DueDate time.Time // when it is due
+	Amount  float64   // how much is due
+}
+
+// ForgivenAmount is the debt cancelled when a Loan is settled for less than it owed
+type ForgivenAmount struct {
+	Principal float64
+	Interest  float64
+	Fees      float64
+}
+
+// Total returns everything forgiven.
+func (f ForgivenAmount) Total() float64 {
+	return f.Principal + f.Interest + f.Fees
+}
+
+// settlementOwed is what is owed on a Loan as of a day, split as it would be forgiven.
+// A charged-off Loan owes what was written off less what was recovered since, taken off interest,
+// then fees, then principal. Any other Loan owes what is outstanding on its schedule and fees.
+func settlementOwed(db execer, ln Loan, day time.Time) (ForgivenAmount, error) {
+	if ln.Status == LoanStatusChargedOff {
+		co, err := GetChargeOffByLoanID(db, ln.ID)
+		if err != nil {
+			return ForgivenAmount{}, err
+		}
+		recoveries, err := GetRecoveriesByLoanID(db, ln.ID)
+		if err != nil {
+			return ForgivenAmount{}, err
+		}
+
+		owed := ForgivenAmount{Principal: co.Principal, Interest: co.Interest, Fees: co.Fees}
+		for _, r := range recoveries {
+			if startOfDay(r.ReceivedDate).After(startOfDay(day)) {
+				continue
+			}
+			left := r.Amount
+			for _, part := range []*float64{&owed.Interest, &owed.Fees, &owed.Principal} {
+				taken := min(left, *part)
+				*part -= taken
+				left -= taken
+			}
+		}
+		return owed, nil
+	}
+
+	history, err := GetRateHistory(db, ln.ID)
+	if err != nil {
+		return ForgivenAmount{}, err
+	}
+	if len(history) == 0 {
+		history = []RateChange{{LoanID: ln.ID, FromPayment: 1, Rate: ln.InterestRate}}
+	}
+	fees, err := GetFeesByLoanID(db, ln.ID)
+	if err != nil {
+		return ForgivenAmount{}, err
+	}
+
+	owed := ForgivenAmount{Fees: outstandingFees(fees)}
+	owed.Principal, owed.Interest = splitOutstanding(ln, history, day)
+	return owed, nil
+}
+
+// OfferSettlement offers to settle a delinquent or charged-off Loan for less than is owed.
+// The offer must be approved by someone other than whoever made it before it expires,
+// and the Loan has at most one pending or approved settlement at a time.
+func OfferSettlement(db *sql.DB, offer SettlementOffer) (Settlement, error) {
+	return OfferSettlementContext(context.Background(), db, offer)
+}
+
+// OfferSettlementContext is OfferSettlement, made and audited as the actor in ctx.
+func OfferSettlementContext(ctx context.Context, db *sql.DB, offer SettlementOffer) (Settlement, error) {
+	if offer.Amount <= 0 {
+		return Settlement{}, fmt.Errorf("settlement amount must be positive, got %.2f", offer.Amount)
+	}
+	if offer.ExpiresOn.IsZero() {
+		return Settlement{}, fmt.Errorf("a settlement offer needs an expiry")
+	}
+	if offer.Installments < 1 {
+		offer.Installments = 1
+	}
+	if offer.OfferedOn.IsZero() {
+		offer.OfferedOn = time.Now()
+	}
+	if offer.FirstDueDate.IsZero() {
+		offer.FirstDueDate = offer.ExpiresOn
+	}
+	offer.OfferedOn, offer.ExpiresOn, offer.FirstDueDate = startOfDay(offer.OfferedOn), startOfDay(offer.ExpiresOn), startOfDay(offer.FirstDueDate)
+	if offer.ExpiresOn.Before(offer.OfferedOn) || offer.FirstDueDate.Before(offer.OfferedOn) {
+		return Settlement{}, fmt.Errorf("a settlement cannot expire or fall due before it is offered on %s", offer.OfferedOn.Format("2006-01-02"))
+	}
+
+	ln, err := LoanFromLedger(db, offer.LoanID)
+	if err != nil {
+		return Settlement{}, err
+	}
+	switch ln.Status {
+	case LoanStatusChargedOff:
+	case LoanStatusActive, LoanStatusDefaulted:
+		d, err := EvaluateDelinquency(ln, offer.OfferedOn)
+		if err != nil {
+			return Settlement{}, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", ln.ID, err)
+		}
+		if !d.IsDelinquent() {
+			return Settlement{}, fmt.Errorf("Loan %d is current and cannot be settled", ln.ID)
+		}
+	default:
+		return Settlement{}, fmt.Errorf("Loan %d is %s and cannot be settled", ln.ID, ln.Status)
+	}
+
+	s := Settlement{
+		LoanID:    ln.ID,
+		Kind:      SettlementLumpSum,
+		Amount:    offer.Amount,
+		OfferedOn: offer.OfferedOn,
+		ExpiresOn: offer.ExpiresOn,
+		Status:    SettlementPending,
+		OfferedBy: ActorFromContext(ctx),
+	}
+	if offer.Installments > 1 {
+		s.Kind = SettlementInstallments
+	}
+	for i, amount := range splitEvenly(offer.Amount, offer.Installments) {
+		s.Installments = append(s.Installments, SettlementInstallment{Number: i + 1, DueDate: offer.FirstDueDate.AddDate(0, i, 0), Amount: amount})
+	}
+
+	err = inTx(db, func(tx *sql.Tx) error {
+		owed, err := settlementOwed(tx, ln, offer.OfferedOn)
+		if err != nil {
+			return err
+		}
+		s.Balance = owed.Total()
+		if s.Amount >= s.Balance-paymentTolerance {
+			return fmt.Errorf("settlement amount %.2f is not less than the %.2f owed on Loan %d", s.Amount, s.Balance, ln.ID)
+		}
+
+		var open int64
+		err = tx.QueryRow(`SELECT id FROM settlements WHERE loan_id = $1 AND status IN ($2, $3)`, ln.ID, SettlementPending, SettlementApproved).Scan(&open)
+		if err == nil {
+			return fmt.Errorf("Loan %d already has open settlement %d", ln.ID, open)
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to check settlements of Loan %d: %w", ln.ID, err)
+		}
+
+		err = tx.QueryRow(`
+		INSERT INTO settlements (loan_id, kind, amount, balance, offered_on, expires_on, offered_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`, s.LoanID, s.Kind, s.Amount, s.Balance, s.OfferedOn, s.ExpiresOn, s.OfferedBy).Scan(&s.ID, &s.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to offer settlement on Loan %d: %w", ln.ID, err)
+		}
+		s.CreatedAt = s.CreatedAt.UTC()
+
+		for _, inst := range s.Installments {
+			_, err := tx.Exec(`INSERT INTO settlement_installments (settlement_id, number, due_date, amount) VALUES ($1, $2, $3, $4)`,
+				s.ID, inst.Number, inst.DueDate, inst.Amount)
+			if err != nil {
+				return fmt.Errorf("failed to add installment %d to settlement %d: %w", inst.Number, s.ID, err)
+			}
+		}
+
+		return recordAudit(ctx, tx, AuditCreate, AuditSettlement, s.ID, nil, s, "")
+	})
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	return s, nil
+}
+
+// ApproveSettlement approves a pending settlement offer on a day no later than its expiry, as approver.
+// The approver cannot be whoever made the offer.
+func ApproveSettlement(db *sql.DB, settlementID int64, approver string, approvedOn time.Time) (Settlement, error) {
+	return ApproveSettlementContext(WithActor(context.Background(), approver), db, settlementID, approvedOn)
+}
+
+// ApproveSettlementContext is ApproveSettlement with the actor in ctx as the approver.
+func ApproveSettlementContext(ctx context.Context, db *sql.DB, settlementID int64, approvedOn time.Time) (Settlement, error) {
+	if approvedOn.IsZero() {
+		return Settlement{}, fmt.Errorf("approvedOn cannot be zero time")
+	}
+	return decideSettlement(ctx, db, settlementID, SettlementApproved, "", approvedOn)
+}
+
+// RejectSettlement turns down a pending settlement offer, as approver.
+func RejectSettlement(db *sql.DB, settlementID int64, approver, reason string) (Settlement, error) {
+	return RejectSettlementContext(WithActor(context.Background(), approver), db, settlementID, reason)
+}
+
+// RejectSettlementContext is RejectSettlement with the actor in ctx as the approver.
+func RejectSettlementContext(ctx context.Context, db *sql.DB, settlementID int64, reason string) (Settlement, error) {
+	return decideSettlement(ctx, db, settlementID, SettlementRejected, reason, time.Now())
+}
+
+// decideSettlement approves or rejects a pending settlement on a day as the actor in ctx
+func decideSettlement(ctx context.Context, db *sql.DB, settlementID int64, status, reason string, day time.Time) (Settlement, error) {
+	approver := ActorFromContext(ctx)
+	if approver == SystemActor {
+		return Settlement{}, fmt.Errorf("a settlement decision needs an approver, set the actor")
+	}
+
+	var after Settlement
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		before, err := getSettlement(tx, `WHERE id = $1 FOR UPDATE`, settlementID)
+		if err != nil {
+			return err
+		}
+		if before.Status != SettlementPending {
+			return fmt.Errorf("settlement %d is %s", settlementID, before.Status)
+		}
+		if approver == before.OfferedBy {
+			return fmt.Errorf("settlement %d was offered by %s and needs someone else's approval", settlementID, approver)
+		}
+		if status == SettlementApproved && startOfDay(day).After(before.ExpiresOn) {
+			return fmt.Errorf("settlement %d expired on %s", settlementID, before.ExpiresOn.Format("2006-01-02"))
+		}
+
+		after = before
+		after.Status, after.Approver, after.EndReason = status, approver, reason
+		err = tx.QueryRow(`UPDATE settlements SET status = $1, approver = $2, end_reason = $3, decided_at = NOW() WHERE id = $4 RETURNING decided_at`,
+			status, approver, reason, settlementID).Scan(&after.DecidedAt)
+		if err != nil {
+			return fmt.Errorf("failed to decide settlement %d: %w", settlementID, err)
+		}
+		after.DecidedAt = after.DecidedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditUpdate, AuditSettlement, settlementID, before, after, reason)
+	})
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	return after, nil
+}
+
+// EvaluateSettlements checks open settlements as of a day. A pending offer past its expiry expires.
+// An approved settlement paid in full is satisfied: its Loan moves to settled, the installments still
+// to come are cancelled and what remains owed is forgiven. One with an installment unpaid more than
+// DefaultSettlementGraceDays after it fell due is broken. It returns the settlements that changed.
+func EvaluateSettlements(db *sql.DB, asOf time.Time) ([]Settlement, error) {
+	return EvaluateSettlementsContext(context.Background(), db, asOf)
+}
+
+// EvaluateSettlementsContext is EvaluateSettlements, audited as the actor in ctx.
+func EvaluateSettlementsContext(ctx context.Context, db *sql.DB, asOf time.Time) ([]Settlement, error) {
+	day := startOfDay(asOf)
+	var changed []Settlement
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		open, err := querySettlements(tx, `WHERE status IN ($1, $2) AND offered_on <= $3 ORDER BY id FOR UPDATE`,
+			SettlementPending, SettlementApproved, day)
+		if err != nil {
+			return err
+		}
+
+		for _, before := range open {
+			after := before
+
+			switch before.Status {
+			case SettlementPending:
+				if !day.After(before.ExpiresOn) {
+					continue
+				}
+				after.Status, after.EndReason = SettlementExpired, "not approved by "+before.ExpiresOn.Format("2006-01-02")
+
+			case SettlementApproved:
+				// hold off payments and reversals until the settlement is evaluated against them
+				ln, err := lockLoan(tx, before.LoanID, IncludeDeleted)
+				if err != nil {
+					return err
+				}
+
+				err = tx.QueryRow(`
+				SELECT COALESCE(SUM(amount), 0)
+				FROM posted_payments
+				WHERE loan_id = $1 AND NOT reversed AND received_date >= $2 AND received_date < $3
+				`, before.LoanID, before.OfferedOn, day.AddDate(0, 0, 1)).Scan(&after.Paid)
+				if err != nil {
+					return fmt.Errorf("failed to sum payments towards settlement %d: %w", before.ID, err)
+				}
+
+				if after.Paid >= before.Amount-paymentTolerance {
+					if after, err = settleLoan(ctx, tx, ln, after, day); err != nil {
+						return err
+					}
+					break
+				}
+				if why := after.missedInstallment(day); why != "" {
+					after.Status, after.EndReason = SettlementBroken, why
+					break
+				}
+				if after.Paid == before.Paid {
+					continue
+				}
+			}
+
+			_, err := tx.Exec(`UPDATE settlements SET status = $1, paid = $2, end_reason = $3 WHERE id = $4`,
+				after.Status, after.Paid, after.EndReason, after.ID)
+			if err != nil {
+				return fmt.Errorf("failed to update settlement %d: %w", after.ID, err)
+			}
+			if err := recordAudit(ctx, tx, AuditUpdate, AuditSettlement, after.ID, before, after, after.EndReason); err != nil {
+				return err
+			}
+			if after.Status != before.Status {
+				changed = append(changed, after)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// missedInstallment reports the installment left unpaid past the grace days as of a day, or "" when there is none
+func (s Settlement) missedInstallment(day time.Time) string {
+	var due float64
+	for _, inst := range s.Installments {
+		due += inst.Amount
+		if day.After(inst.DueDate.AddDate(0, 0, DefaultSettlementGraceDays)) && s.Paid < due-paymentTolerance {
+			return fmt.Sprintf("installment %d due on %s was not paid", inst.Number, inst.DueDate.Format("2006-01-02"))
+		}
+	}
+	return ""
+}
+
+// settleLoan closes the Loan of a satisfied settlement and forgives what it still owes.
+// The Loan must have been locked with lockLoan in tx so what it owes cannot change underneath.
+func settleLoan(ctx context.Context, tx *sql.Tx, ln Loan, s Settlement, day time.Time) (Settlement, error) {
+	var err error
+	s.Forgiven, err = settlementOwed(tx, ln, day)
+	if err != nil {
+		return Settlement{}, err
+	}
+	s.Status, s.SettledOn = SettlementSatisfied, day
+
+	// a charged-off Loan was written off already
+	if ln.Status != LoanStatusChargedOff {
+		memo := fmt.Sprintf("settlement %d", s.ID)
+		if err := closeOutLedger(tx, ln.ID, day, memo, EntrySettlement, AccountSettlementForgiven, memo); err != nil {
+			return Settlement{}, err
+		}
+	}
+
+	_, err = tx.Exec(`
+	UPDATE settlements SET settled_on = $1, forgiven_principal = $2, forgiven_interest = $3, forgiven_fees = $4
+	WHERE id = $5
+	`, s.SettledOn, s.Forgiven.Principal, s.Forgiven.Interest, s.Forgiven.Fees, s.ID)
+	if err != nil {
+		return Settlement{}, fmt.Errorf("failed to record forgiveness of settlement %d: %w", s.ID, err)
+	}
+
+	if _, err := tx.Exec(`UPDATE loans SET status = $1 WHERE id = $2`, LoanStatusSettled, ln.ID); err != nil {
+		return Settlement{}, fmt.Errorf("failed to settle Loan %d: %w", ln.ID, err)
+	}
+	after := ln
+	after.Status = LoanStatusSettled
+	reason := fmt.Sprintf("settled for %.2f, %.2f forgiven", s.Amount, s.Forgiven.Total())
+	if err := recordAudit(ctx, tx, AuditSettle, AuditLoan, ln.ID, ln, after, reason); err != nil {
+		return Settlement{}, err
+	}
+
+	return s, nil
+}
+
+// closedOutOn is the day the ledger of a settled Loan was closed out: its charge-off if it was
+// charged off before it was settled, otherwise the day it was settled
+func closedOutOn(db execer, loanID int64) (time.Time, error) {
+	var day time.Time
+	err := db.QueryRow(`
+	SELECT COALESCE(
+		(SELECT charge_off_date FROM charge_offs WHERE loan_id = $1),
+		(SELECT settled_on FROM settlements WHERE loan_id = $1 AND status = $2))
+	`, loanID, SettlementSatisfied).Scan(&day)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the day Loan %d was closed out: %w", loanID, err)
+	}
+	return startOfDay(day), nil
+}
+
+// GetSettlement returns a settlement with its installments.
+func GetSettlement(db execer, settlementID int64) (Settlement, error) {
+	return getSettlement(db, `WHERE id = $1`, settlementID)
+}
+
+// GetSettlementsByLoanID returns the settlements offered on a Loan, oldest first.
+func GetSettlementsByLoanID(db execer, loanID int64) ([]Settlement, error) {
+	return querySettlements(db, `WHERE loan_id = $1 ORDER BY offered_on, id`, loanID)
+}
+
+// GetSettledBetween returns the settlements satisfied between two days, for reporting forgiven debt.
+func GetSettledBetween(db execer, from, to time.Time) ([]Settlement, error) {
+	return querySettlements(db, `WHERE status = $1 AND settled_on >= $2 AND settled_on < $3 ORDER BY settled_on, id`,
+		SettlementSatisfied, startOfDay(from), startOfDay(to).AddDate(0, 0, 1))
+}
+
+// getSettlement returns the one settlement selected by tail
+func getSettlement(db execer, tail string, settlementID int64) (Settlement, error) {
+	settlements, err := querySettlements(db, tail, settlementID)
+	if err != nil {
+		return Settlement{}, err
+	}
+	if len(settlements) == 0 {
+		return Settlement{}, fmt.Errorf("settlement with ID %d not found", settlementID)
+	}
+	return settlements[0], nil
+}
+
+const settlementColumns = `id, loan_id, kind, amount, balance, offered_on, expires_on, status, offered_by,
+	approver, decided_at, paid, settled_on, forgiven_principal, forgiven_interest, forgiven_fees, end_reason, created_at`
+
+// querySettlements selects settlements with the conditions and ordering in tail, and loads their installments
+func querySettlements(db execer, tail string, args ...any) ([]Settlement, error) {
+	rows, err := db.Query(`SELECT `+settlementColumns+` FROM settlements `+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query settlements: %w", err)
+	}
+	defer rows.Close()
+
+	settlements := []Settlement{}
+
+	for rows.Next() {
+		var s Settlement
+		var decidedAt, settledOn sql.NullTime
+		err := rows.Scan(&s.ID, &s.LoanID, &s.Kind, &s.Amount, &s.Balance, &s.OfferedOn, &s.ExpiresOn, &s.Status, &s.OfferedBy,
+			&s.Approver, &decidedAt, &s.Paid, &settledOn, &s.Forgiven.Principal, &s.Forgiven.Interest, &s.Forgiven.Fees, &s.EndReason, &s.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan settlement row: %w", err)
+		}
+		s.OfferedOn, s.ExpiresOn, s.CreatedAt = s.OfferedOn.UTC(), s.ExpiresOn.UTC(), s.CreatedAt.UTC()
+		if decidedAt.Valid {
+			s.DecidedAt = decidedAt.Time.UTC()
+		}
+		if settledOn.Valid {
+			s.SettledOn = settledOn.Time.UTC()
+		}
+		settlements = append(settlements, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating settlement rows: %w", err)
+	}
+	rows.Close()
+
+	for i := range settlements {
+		settlements[i].Installments, err = getSettlementInstallments(db, settlements[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return settlements, nil
+}
+
+// getSettlementInstallments returns the installments of a settlement in order
+func getSettlementInstallments(db execer, settlementID int64) ([]SettlementInstallment, error) {
+	rows, err := db.Query(`SELECT number, due_date, amount FROM settlement_installments WHERE settlement_id = $1 ORDER BY number`, settlementID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query installments of settlement %d: %w", settlementID, err)
+	}
+	defer rows.Close()
+
+	var installments []SettlementInstallment
+
+	for rows.Next() {
+		var inst SettlementInstallment
+		if err := rows.Scan(&inst.Number, &inst.DueDate, &inst.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan settlement installment row: %w", err)
+		}
+		inst.DueDate = inst.DueDate.UTC()
+		installments = append(installments, inst)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating settlement installment rows: %w", err)
+	}
+
+	return installments, nil
+}
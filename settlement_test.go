@@ -0,0 +1,209 @@
+package delinquencytracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSettleChargedOffLoan verifies a lump sum recovered on a charged-off Loan settles it and
+// forgives the rest of what was written off.
+func TestSettleChargedOffLoan(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan that was never paid and was charged off
+	user, err := InitializeUserWithLoan(db, "Settling User", "settling@example.com", "555-1515",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	co, err := ChargeOffLoan(db, ln.ID, calendarDate(2024, time.June, 15), "collections", "never paid")
+	require.NoError(t, err)
+
+	ctx := WithActor(context.Background(), "alice")
+
+	// Act
+	s, err := OfferSettlementContext(ctx, db, SettlementOffer{
+		LoanID:    ln.ID,
+		Amount:    5000,
+		OfferedOn: calendarDate(2024, time.July, 1),
+		ExpiresOn: calendarDate(2024, time.July, 15),
+	})
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, SettlementLumpSum, s.Kind)
+	require.Equal(t, SettlementPending, s.Status)
+	require.Equal(t, "alice", s.OfferedBy)
+	require.InDelta(t, co.Total(), s.Balance, 0.01)
+	require.Len(t, s.Installments, 1)
+	require.Equal(t, calendarDate(2024, time.July, 15), s.Installments[0].DueDate)
+
+	_, err = ApproveSettlementContext(ctx, db, s.ID, calendarDate(2024, time.July, 2))
+	require.Error(t, err, "whoever offered a settlement cannot approve it")
+	_, err = ApproveSettlementContext(context.Background(), db, s.ID, calendarDate(2024, time.July, 2))
+	require.Error(t, err, "a decision without an actor has no approver")
+	s, err = ApproveSettlementContext(WithActor(context.Background(), "bob"), db, s.ID, calendarDate(2024, time.July, 2))
+	require.NoError(t, err)
+	require.Equal(t, SettlementApproved, s.Status)
+	require.Equal(t, "bob", s.Approver)
+
+	_, err = PostPayment(db, ln.ID, 5000, calendarDate(2024, time.July, 10))
+	require.NoError(t, err)
+
+	changed, err := EvaluateSettlements(db, calendarDate(2024, time.July, 10))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	require.Equal(t, SettlementSatisfied, changed[0].Status)
+
+	s, err = GetSettlement(db, s.ID)
+	require.NoError(t, err)
+	require.Equal(t, SettlementSatisfied, s.Status)
+	require.InDelta(t, 5000, s.Paid, 0.001)
+	require.Equal(t, calendarDate(2024, time.July, 10), s.SettledOn)
+	require.InDelta(t, co.Total()-5000, s.Forgiven.Total(), 0.01)
+	require.InDelta(t, co.Principal, s.Forgiven.Principal, 0.01, "the recovery went to interest first")
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusSettled, full.Status)
+
+	settled, err := GetSettledBetween(db, calendarDate(2024, time.July, 1), calendarDate(2024, time.July, 31))
+	require.NoError(t, err)
+	require.Len(t, settled, 1)
+
+	_, err = PostPayment(db, ln.ID, 100, calendarDate(2024, time.July, 20))
+	require.Error(t, err, "a settled Loan takes no more payments")
+}
+
+// TestSettlementInstallments verifies an installment settlement on a delinquent Loan closes its ledger out once paid.
+func TestSettlementInstallments(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, three installments behind
+	user, err := InitializeUserWithLoan(db, "Installment User", "installment@example.com", "555-1616",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	s, err := OfferSettlement(db, SettlementOffer{
+		LoanID:       ln.ID,
+		Amount:       6000,
+		Installments: 2,
+		FirstDueDate: calendarDate(2024, time.April, 15),
+		OfferedOn:    calendarDate(2024, time.April, 1),
+		ExpiresOn:    calendarDate(2024, time.April, 10),
+	})
+	require.NoError(t, err)
+	require.Equal(t, SettlementInstallments, s.Kind)
+	require.Len(t, s.Installments, 2)
+	require.Equal(t, calendarDate(2024, time.May, 15), s.Installments[1].DueDate)
+	require.InDelta(t, 3000, s.Installments[1].Amount, 0.001)
+
+	_, err = ApproveSettlement(db, s.ID, "manager", calendarDate(2024, time.April, 5))
+	require.NoError(t, err)
+
+	// Act, the first installment leaves the settlement approved
+	_, err = PostPayment(db, ln.ID, 3000, calendarDate(2024, time.April, 14))
+	require.NoError(t, err)
+	changed, err := EvaluateSettlements(db, calendarDate(2024, time.April, 20))
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	s, err = GetSettlement(db, s.ID)
+	require.NoError(t, err)
+	require.Equal(t, SettlementApproved, s.Status)
+	require.InDelta(t, 3000, s.Paid, 0.001)
+
+	// the second satisfies it
+	_, err = PostPayment(db, ln.ID, 3000, calendarDate(2024, time.May, 10))
+	require.NoError(t, err)
+	changed, err = EvaluateSettlements(db, calendarDate(2024, time.May, 10))
+	require.NoError(t, err)
+
+	// Assert
+	require.Len(t, changed, 1)
+	s = changed[0]
+	require.Equal(t, SettlementSatisfied, s.Status)
+	require.Greater(t, s.Forgiven.Principal, 0.0)
+
+	b, err := GetLoanBalances(db, ln.ID, calendarDate(2024, time.December, 31))
+	require.NoError(t, err)
+	require.InDelta(t, 0, b.Total(), 0.01)
+	require.InDelta(t, s.Forgiven.Total(), b.Accounts[AccountSettlementForgiven], 0.01)
+
+	discrepancies, err := ReconcileLedger(db, ln.ID)
+	require.NoError(t, err)
+	require.Empty(t, discrepancies)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusSettled, full.Status)
+}
+
+// TestSettlementLifecycle verifies offers are refused, expire, are rejected and break.
+func TestSettlementLifecycle(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Offered User", "offered@example.com", "555-1717",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	offer := func(amount float64, on, expires time.Time) (Settlement, error) {
+		return OfferSettlement(db, SettlementOffer{LoanID: ln.ID, Amount: amount, OfferedOn: on, ExpiresOn: expires})
+	}
+
+	// a current Loan cannot be settled
+	_, err = offer(1000, calendarDate(2024, time.January, 15), calendarDate(2024, time.January, 31))
+	require.Error(t, err)
+
+	// nor for what is owed
+	_, err = offer(20000, calendarDate(2024, time.March, 1), calendarDate(2024, time.March, 10))
+	require.Error(t, err)
+
+	s, err := offer(5000, calendarDate(2024, time.March, 1), calendarDate(2024, time.March, 10))
+	require.NoError(t, err)
+	_, err = offer(4000, calendarDate(2024, time.March, 2), calendarDate(2024, time.March, 10))
+	require.Error(t, err, "a Loan has at most one open settlement")
+
+	// not approved in time, it expires
+	_, err = ApproveSettlement(db, s.ID, "manager", calendarDate(2024, time.March, 11))
+	require.Error(t, err)
+	changed, err := EvaluateSettlements(db, calendarDate(2024, time.March, 11))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	require.Equal(t, SettlementExpired, changed[0].Status)
+
+	// a rejected offer cannot be approved afterwards
+	s, err = offer(5000, calendarDate(2024, time.March, 12), calendarDate(2024, time.March, 20))
+	require.NoError(t, err)
+	s, err = RejectSettlement(db, s.ID, "manager", "too low")
+	require.NoError(t, err)
+	require.Equal(t, SettlementRejected, s.Status)
+	_, err = ApproveSettlement(db, s.ID, "manager", calendarDate(2024, time.March, 13))
+	require.Error(t, err)
+
+	// an approved settlement left unpaid past the grace days breaks
+	s, err = offer(5000, calendarDate(2024, time.March, 14), calendarDate(2024, time.March, 20))
+	require.NoError(t, err)
+	_, err = ApproveSettlement(db, s.ID, "manager", calendarDate(2024, time.March, 15))
+	require.NoError(t, err)
+
+	changed, err = EvaluateSettlements(db, calendarDate(2024, time.March, 20).AddDate(0, 0, DefaultSettlementGraceDays))
+	require.NoError(t, err)
+	require.Empty(t, changed, "still within the grace days")
+
+	changed, err = EvaluateSettlements(db, calendarDate(2024, time.March, 21).AddDate(0, 0, DefaultSettlementGraceDays))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	require.Equal(t, SettlementBroken, changed[0].Status)
+
+	full, err := GetFullLoanByID(db, ln.ID)
+	require.NoError(t, err)
+	require.Equal(t, LoanStatusActive, full.Status)
+}
@@ -0,0 +1,519 @@
+package delinquencytracker
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strategy actions
+const (
+	ActionEmail           = "send_email"           // email the borrower
+	ActionSMS             = "send_sms"             // text the borrower
+	ActionCall            = "call"                 // have a collector call
+	ActionLetter          = "send_letter"          // mail a letter
+	ActionAssignQueue     = "assign_queue"         // move the open collection case to Queue
+	ActionChargeOff       = "recommend_charge_off" // put the Loan up for charge-off
+	ActionOfferPlan       = "offer_plan"           // offer a repayment plan
+	ActionOfferSettlement = "offer_settlement"     // offer a settlement
+)
+
+var strategyActions = map[string]bool{
+	ActionEmail: true, ActionSMS: true, ActionCall: true, ActionLetter: true, ActionAssignQueue: true,
+	ActionChargeOff: true, ActionOfferPlan: true, ActionOfferSettlement: true,
+}
+
+// Strategy decides what collections does about each Loan. Rules are tried in order
+// and every rule whose conditions all hold adds its action, until one marked Stop fires.
+// While a Loan has a pending promise to pay or an active repayment plan only rules marked
+// IgnoreArrangements fire, so a borrower keeping to an arrangement is not chased.
+type Strategy struct {
+	Name  string         `json:"name" yaml:"name"`
+	Rules []StrategyRule `json:"rules" yaml:"rules"`
+}
+
+// StrategyRule is one rule of a Strategy. Each condition compares a fact of the Loan
+// with a value, e.g. "dpd >= 30", "balance > 1000" or "language == es".
+type StrategyRule struct {
+	Name     string   `json:"name" yaml:"name"`                             // unique within the strategy
+	When     []string `json:"when" yaml:"when"`                             // conditions that must all hold; none always fires
+	Action   string   `json:"action" yaml:"action"`                         // what to do, e.g. "send_sms"
+	Queue    string   `json:"queue,omitempty" yaml:"queue,omitempty"`       // the queue for assign_queue
+	Template string   `json:"template,omitempty" yaml:"template,omitempty"` // the message to send, for contact actions
+	Stop     bool     `json:"stop,omitempty" yaml:"stop,omitempty"`         // no later rule fires once this one has
+
+	IgnoreArrangements bool `json:"ignore_arrangements,omitempty" yaml:"ignore_arrangements,omitempty"` // fires even while the Loan is promised or on a plan
+}
+
+// StrategyAction is an action a Strategy calls for on a Loan
+type StrategyAction struct {
+	Rule     string // the rule that fired
+	Action   string // what to do
+	Queue    string // the queue for assign_queue
+	Template string // the message to send, for contact actions
+}
+
+// LoanFacts is what a Strategy knows about a Loan on a day
+type LoanFacts struct {
+	LoanID          int64
+	AsOf            time.Time
+	Status          string  // status
+	DaysPastDue     int     // dpd
+	PastDueAmount   float64 // past_due
+	MissedPayments  int     // missed_payments
+	Bucket          string  // bucket
+	Balance         float64 // balance, everything owed
+	Fees            float64 // fees, unpaid
+	InForbearance   bool    // in_forbearance
+	Queue           string  // queue of the open collection case ("" when there is none)
+	Assigned        bool    // assigned, whether a collector works the case
+	ContactAttempts int     // contact_attempts on the open case
+	Promised        bool    // promised, a pending promise to pay
+	BrokenPromises  int     // broken_promises, ever
+	OnPlan          bool    // on_plan, an active repayment plan
+	BrokenPlans     int     // broken_plans, ever
+	Language        string  // language of the primary borrower
+	Channel         string  // channel the primary borrower prefers
+}
+
+// strategyFields are the facts a condition can name
+var strategyFields = map[string]func(f LoanFacts) any{
+	"status":           func(f LoanFacts) any { return f.Status },
+	"dpd":              func(f LoanFacts) any { return float64(f.DaysPastDue) },
+	"past_due":         func(f LoanFacts) any { return f.PastDueAmount },
+	"missed_payments":  func(f LoanFacts) any { return float64(f.MissedPayments) },
+	"bucket":           func(f LoanFacts) any { return f.Bucket },
+	"balance":          func(f LoanFacts) any { return f.Balance },
+	"fees":             func(f LoanFacts) any { return f.Fees },
+	"in_forbearance":   func(f LoanFacts) any { return f.InForbearance },
+	"queue":            func(f LoanFacts) any { return f.Queue },
+	"assigned":         func(f LoanFacts) any { return f.Assigned },
+	"contact_attempts": func(f LoanFacts) any { return float64(f.ContactAttempts) },
+	"promised":         func(f LoanFacts) any { return f.Promised },
+	"broken_promises":  func(f LoanFacts) any { return float64(f.BrokenPromises) },
+	"on_plan":          func(f LoanFacts) any { return f.OnPlan },
+	"broken_plans":     func(f LoanFacts) any { return float64(f.BrokenPlans) },
+	"language":         func(f LoanFacts) any { return f.Language },
+	"channel":          func(f LoanFacts) any { return f.Channel },
+}
+
+// conditionPattern splits a condition into field, operator and value
+var conditionPattern = regexp.MustCompile(`^\s*([a-z_]+)\s*(==|!=|>=|<=|>|<)\s*(.*?)\s*$`)
+
+// condition is a parsed rule condition
+type condition struct {
+	field string
+	op    string
+	value any // float64, string or bool, the same type as the field
+}
+
+// parseCondition parses a condition such as "dpd >= 30" against the fields it may name
+func parseCondition(s string) (condition, error) {
+	m := conditionPattern.FindStringSubmatch(s)
+	if m == nil || m[3] == "" {
+		return condition{}, fmt.Errorf("condition %q is not of the form \"field op value\"", s)
+	}
+	c := condition{field: m[1], op: m[2]}
+
+	get, ok := strategyFields[c.field]
+	if !ok {
+		return condition{}, fmt.Errorf("condition %q names unknown field %q", s, c.field)
+	}
+
+	raw := m[3]
+	switch get(LoanFacts{}).(type) {
+	case float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return condition{}, fmt.Errorf("condition %q compares %s with %q, which is not a number", s, c.field, raw)
+		}
+		c.value = n
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return condition{}, fmt.Errorf("condition %q compares %s with %q, which is not true or false", s, c.field, raw)
+		}
+		c.value = b
+	default:
+		if len(raw) >= 2 && (raw[0] == '\'' || raw[0] == '"') && raw[len(raw)-1] == raw[0] {
+			raw = raw[1 : len(raw)-1]
+		}
+		c.value = raw
+	}
+
+	if _, isNumber := c.value.(float64); !isNumber && c.op != "==" && c.op != "!=" {
+		return condition{}, fmt.Errorf("condition %q can only compare %s with == or !=", s, c.field)
+	}
+	return c, nil
+}
+
+// holds reports whether the condition holds for a Loan
+func (c condition) holds(f LoanFacts) bool {
+	got := strategyFields[c.field](f)
+
+	if n, ok := got.(float64); ok {
+		want := c.value.(float64)
+		switch c.op {
+		case "==":
+			return n == want
+		case "!=":
+			return n != want
+		case ">=":
+			return n >= want
+		case "<=":
+			return n <= want
+		case ">":
+			return n > want
+		default:
+			return n < want
+		}
+	}
+
+	if c.op == "!=" {
+		return got != c.value
+	}
+	return got == c.value
+}
+
+// Validate checks that every rule is named once, has a known action with what it needs and
+// conditions over known fields.
+func (s Strategy) Validate() error {
+	if len(s.Rules) == 0 {
+		return fmt.Errorf("strategy %q has no rules", s.Name)
+	}
+
+	names := make(map[string]bool)
+	for i, r := range s.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule %q is named twice", r.Name)
+		}
+		names[r.Name] = true
+
+		if !strategyActions[r.Action] {
+			return fmt.Errorf("rule %q has unknown action %q", r.Name, r.Action)
+		}
+		if r.Action == ActionAssignQueue && r.Queue == "" {
+			return fmt.Errorf("rule %q assigns a queue but names none", r.Name)
+		}
+		if r.Action != ActionAssignQueue && r.Queue != "" {
+			return fmt.Errorf("rule %q names a queue but does not assign one", r.Name)
+		}
+
+		for _, w := range r.When {
+			if _, err := parseCondition(w); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseStrategy reads a Strategy in "yaml" or "json" and validates it. Unknown keys are refused.
+func ParseStrategy(data []byte, format string) (Strategy, error) {
+	var s Strategy
+
+	switch format {
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&s); err != nil {
+			return Strategy{}, fmt.Errorf("failed to parse strategy: %w", err)
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			return Strategy{}, fmt.Errorf("failed to parse strategy: %w", err)
+		}
+	default:
+		return Strategy{}, fmt.Errorf("unknown strategy format %q, want yaml or json", format)
+	}
+
+	if err := s.Validate(); err != nil {
+		return Strategy{}, err
+	}
+	return s, nil
+}
+
+// LoadStrategy reads a Strategy from a .yaml, .yml or .json file.
+func LoadStrategy(path string) (Strategy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Strategy{}, fmt.Errorf("failed to read strategy: %w", err)
+	}
+	return ParseStrategy(data, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+}
+
+// Evaluate returns the actions the Strategy calls for on a Loan, in rule order.
+func (s Strategy) Evaluate(f LoanFacts) ([]StrategyAction, error) {
+	var actions []StrategyAction
+	arranged := f.Promised || f.OnPlan
+
+	for _, r := range s.Rules {
+		if arranged && !r.IgnoreArrangements {
+			continue
+		}
+
+		fires := true
+		for _, w := range r.When {
+			c, err := parseCondition(w)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+			if !c.holds(f) {
+				fires = false
+				break
+			}
+		}
+		if !fires {
+			continue
+		}
+
+		actions = append(actions, StrategyAction{Rule: r.Name, Action: r.Action, Queue: r.Queue, Template: r.Template})
+		if r.Stop {
+			break
+		}
+	}
+
+	return actions, nil
+}
+
+// StrategyResult is what a Strategy called for on one Loan
+type StrategyResult struct {
+	Facts   LoanFacts
+	Actions []StrategyAction
+}
+
+// GetLoanFacts gathers what a Strategy knows about a Loan on a day.
+func GetLoanFacts(db *sql.DB, loanID int64, asOf time.Time) (LoanFacts, error) {
+	ln, err := LoanFromLedger(db, loanID)
+	if err != nil {
+		return LoanFacts{}, err
+	}
+
+	var f LoanFacts
+	err = inTx(db, func(tx *sql.Tx) error {
+		f, _, err = loanFacts(tx, ln, asOf)
+		return err
+	})
+	if err != nil {
+		return LoanFacts{}, err
+	}
+
+	return f, nil
+}
+
+// loanFacts gathers the facts of a Loan loaded with its payments, returning its open case locked
+func loanFacts(tx *sql.Tx, ln Loan, asOf time.Time) (LoanFacts, CollectionCase, error) {
+	asOf = startOfDay(asOf)
+
+	d, err := EvaluateDelinquency(ln, asOf)
+	if err != nil {
+		return LoanFacts{}, CollectionCase{}, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", ln.ID, err)
+	}
+	balances, err := GetLoanBalances(tx, ln.ID, asOf)
+	if err != nil {
+		return LoanFacts{}, CollectionCase{}, err
+	}
+	user, err := GetUserByID(tx, ln.UserID, IncludeDeleted)
+	if err != nil {
+		return LoanFacts{}, CollectionCase{}, err
+	}
+	open, err := getOpenCase(tx, ln.ID)
+	if err != nil {
+		return LoanFacts{}, CollectionCase{}, err
+	}
+
+	f := LoanFacts{
+		LoanID:         ln.ID,
+		AsOf:           asOf,
+		Status:         ln.Status,
+		DaysPastDue:    d.DaysPastDue,
+		PastDueAmount:  d.PastDueAmount,
+		MissedPayments: d.MissedPayments,
+		Bucket:         d.Bucket,
+		Balance:        balances.Total(),
+		Fees:           balances.Fees,
+		InForbearance:  d.InForbearance,
+		Queue:          open.Queue,
+		Assigned:       open.AssignedTo != "",
+		Language:       user.Profile.Language,
+		Channel:        user.Profile.Contact.Channel,
+	}
+
+	if f.Promised, err = hasActivePromise(tx, ln.ID, asOf); err != nil {
+		return LoanFacts{}, CollectionCase{}, err
+	}
+	if f.OnPlan, err = hasActivePlan(tx, ln.ID); err != nil {
+		return LoanFacts{}, CollectionCase{}, err
+	}
+
+	err = tx.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM collection_contacts WHERE case_id = $1),
+		(SELECT COUNT(*) FROM promises_to_pay WHERE loan_id = $2 AND status = $3),
+		(SELECT COUNT(*) FROM repayment_plans WHERE loan_id = $2 AND status = $4)
+	`, open.ID, ln.ID, PromiseBroken, PlanBroken).Scan(&f.ContactAttempts, &f.BrokenPromises, &f.BrokenPlans)
+	if err != nil {
+		return LoanFacts{}, CollectionCase{}, fmt.Errorf("failed to count collections history of Loan %d: %w", ln.ID, err)
+	}
+
+	return f, open, nil
+}
+
+// RunStrategy evaluates a Strategy against every active or defaulted Loan as of a day and
+// returns the loans it called for actions on. With dryRun nothing is changed. Otherwise the
+// actions are logged, once per Loan, rule and day, for the contact and review work they call
+// for, and assign_queue moves the Loan's open case. RunCollections routes cases by
+// CollectionQueues, so run the Strategy after it.
+func RunStrategy(db *sql.DB, s Strategy, asOf time.Time, dryRun bool) ([]StrategyResult, error) {
+	return RunStrategyContext(context.Background(), db, s, asOf, dryRun)
+}
+
+// RunStrategyContext is RunStrategy, audited as the actor in ctx.
+func RunStrategyContext(ctx context.Context, db *sql.DB, s Strategy, asOf time.Time, dryRun bool) ([]StrategyResult, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	day := startOfDay(asOf)
+
+	rows, err := db.Query(`SELECT id FROM loans WHERE status IN ($1, $2) AND deleted_at IS NULL ORDER BY id`, LoanStatusActive, LoanStatusDefaulted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loans: %w", err)
+	}
+
+	var loanIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan Loan row: %w", err)
+		}
+		loanIDs = append(loanIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating Loan rows: %w", err)
+	}
+
+	var results []StrategyResult
+	for _, id := range loanIDs {
+		ln, err := LoanFromLedger(db, id)
+		if err != nil {
+			return results, err
+		}
+
+		var result StrategyResult
+		err = inTx(db, func(tx *sql.Tx) error {
+			f, open, err := loanFacts(tx, ln, day)
+			if err != nil {
+				return err
+			}
+			actions, err := s.Evaluate(f)
+			if err != nil {
+				return err
+			}
+			result = StrategyResult{Facts: f, Actions: actions}
+
+			if dryRun {
+				return nil
+			}
+			return applyStrategyActions(ctx, tx, s.Name, open, f, actions)
+		})
+		if err != nil {
+			return results, err
+		}
+
+		if len(result.Actions) > 0 {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// applyStrategyActions logs the actions called for on a Loan and moves its open case to any queue assigned
+func applyStrategyActions(ctx context.Context, tx *sql.Tx, strategy string, open CollectionCase, f LoanFacts, actions []StrategyAction) error {
+	for _, a := range actions {
+		_, err := tx.Exec(`
+		INSERT INTO strategy_actions (loan_id, strategy, rule, action, queue, template, as_of)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (loan_id, rule, as_of) DO NOTHING
+		`, f.LoanID, strategy, a.Rule, a.Action, a.Queue, a.Template, f.AsOf)
+		if err != nil {
+			return fmt.Errorf("failed to log action %s on Loan %d: %w", a.Action, f.LoanID, err)
+		}
+
+		if a.Action != ActionAssignQueue || open.ID == 0 || open.Queue == a.Queue {
+			continue
+		}
+
+		after := open
+		after.Queue, after.AssignedTo, after.AssignedAt = a.Queue, "", time.Time{}
+		_, err = tx.Exec(`UPDATE collection_cases SET queue = $1, assigned_to = '', assigned_at = NULL WHERE id = $2`, a.Queue, open.ID)
+		if err != nil {
+			return fmt.Errorf("failed to move collection case %d: %w", open.ID, err)
+		}
+		reason := fmt.Sprintf("rule %q moved it from %s to %s", a.Rule, open.Queue, a.Queue)
+		if err := recordAudit(ctx, tx, AuditUpdate, AuditCollectionCase, open.ID, open, after, reason); err != nil {
+			return err
+		}
+		open = after
+	}
+
+	return nil
+}
+
+// LoggedStrategyAction is an action a Strategy run called for, as logged
+type LoggedStrategyAction struct {
+	ID             int64     // unique identifier for the logged action
+	LoanID         int64     // the Loan it was called for on
+	Strategy       string    // the strategy that called for it
+	StrategyAction           // the rule, action, queue and template
+	AsOf           time.Time // the day of the run
+	CreatedAt      time.Time // when was this record created
+}
+
+// GetStrategyActions returns the actions logged for a Loan, oldest first.
+func GetStrategyActions(db execer, loanID int64) ([]LoggedStrategyAction, error) {
+	rows, err := db.Query(`
+	SELECT id, loan_id, strategy, rule, action, queue, template, as_of, created_at
+	FROM strategy_actions
+	WHERE loan_id = $1
+	ORDER BY id
+	`, loanID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query strategy actions of Loan %d: %w", loanID, err)
+	}
+	defer rows.Close()
+
+	var actions []LoggedStrategyAction
+	for rows.Next() {
+		var a LoggedStrategyAction
+		err := rows.Scan(&a.ID, &a.LoanID, &a.Strategy, &a.Rule, &a.Action, &a.Queue, &a.Template, &a.AsOf, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan strategy action row: %w", err)
+		}
+		a.AsOf, a.CreatedAt = a.AsOf.UTC(), a.CreatedAt.UTC()
+		actions = append(actions, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating strategy action rows: %w", err)
+	}
+
+	return actions, nil
+}
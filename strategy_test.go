@@ -0,0 +1,188 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testStrategyYAML = `
+name: standard
+rules:
+  - name: early reminder
+    when: ["dpd >= 5", "balance > 1000"]
+    action: send_sms
+    template: reminder
+  - name: queue b
+    when: ["dpd >= 30"]
+    action: assign_queue
+    queue: B
+  - name: charge off
+    when: ["dpd >= 90"]
+    action: recommend_charge_off
+    stop: true
+  - name: spanish letter
+    when: ["dpd >= 30", "language == es"]
+    action: send_letter
+`
+
+// TestParseStrategy verifies strategies parse from YAML and JSON and bad ones are refused.
+func TestParseStrategy(t *testing.T) {
+	s, err := ParseStrategy([]byte(testStrategyYAML), "yaml")
+	require.NoError(t, err)
+	require.Equal(t, "standard", s.Name)
+	require.Len(t, s.Rules, 4)
+	require.Equal(t, []string{"dpd >= 5", "balance > 1000"}, s.Rules[0].When)
+	require.True(t, s.Rules[2].Stop)
+
+	s, err = ParseStrategy([]byte(`{"name": "json", "rules": [{"name": "call", "when": ["dpd>=10"], "action": "call"}]}`), "json")
+	require.NoError(t, err)
+	require.Equal(t, ActionCall, s.Rules[0].Action)
+
+	bad := map[string]string{
+		"unknown key":       `{"rules": [{"name": "a", "action": "call", "priority": 1}]}`,
+		"no rules":          `{"name": "empty"}`,
+		"unnamed rule":      `{"rules": [{"action": "call"}]}`,
+		"duplicate name":    `{"rules": [{"name": "a", "action": "call"}, {"name": "a", "action": "send_sms"}]}`,
+		"unknown action":    `{"rules": [{"name": "a", "action": "shout"}]}`,
+		"queue missing":     `{"rules": [{"name": "a", "action": "assign_queue"}]}`,
+		"stray queue":       `{"rules": [{"name": "a", "action": "call", "queue": "B"}]}`,
+		"unknown field":     `{"rules": [{"name": "a", "when": ["mood == sad"], "action": "call"}]}`,
+		"not a number":      `{"rules": [{"name": "a", "when": ["dpd >= lots"], "action": "call"}]}`,
+		"ordered string":    `{"rules": [{"name": "a", "when": ["language > en"], "action": "call"}]}`,
+		"not a bool":        `{"rules": [{"name": "a", "when": ["promised == maybe"], "action": "call"}]}`,
+		"malformed":         `{"rules": [{"name": "a", "when": ["dpd"], "action": "call"}]}`,
+		"missing the value": `{"rules": [{"name": "a", "when": ["dpd >="], "action": "call"}]}`,
+	}
+	for name, data := range bad {
+		_, err := ParseStrategy([]byte(data), "json")
+		require.Error(t, err, name)
+	}
+
+	_, err = ParseStrategy([]byte(testStrategyYAML), "toml")
+	require.Error(t, err)
+}
+
+// TestStrategyEvaluate verifies rules fire in order when all their conditions hold, until one stops the rest.
+func TestStrategyEvaluate(t *testing.T) {
+	s, err := ParseStrategy([]byte(testStrategyYAML), "yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		facts LoanFacts
+		want  []string
+	}{
+		{"current", LoanFacts{Balance: 5000}, nil},
+		{"small balance", LoanFacts{DaysPastDue: 10, Balance: 500}, nil},
+		{"early", LoanFacts{DaysPastDue: 10, Balance: 5000}, []string{"early reminder"}},
+		{"mid", LoanFacts{DaysPastDue: 45, Balance: 5000}, []string{"early reminder", "queue b"}},
+		{"mid in spanish", LoanFacts{DaysPastDue: 45, Balance: 5000, Language: "es"}, []string{"early reminder", "queue b", "spanish letter"}},
+		{"stops at charge-off", LoanFacts{DaysPastDue: 120, Balance: 5000, Language: "es"}, []string{"early reminder", "queue b", "charge off"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := s.Evaluate(tt.facts)
+			require.NoError(t, err)
+
+			var rules []string
+			for _, a := range actions {
+				rules = append(rules, a.Rule)
+			}
+			require.Equal(t, tt.want, rules)
+		})
+	}
+
+	actions, err := s.Evaluate(LoanFacts{DaysPastDue: 45, Balance: 5000})
+	require.NoError(t, err)
+	require.Equal(t, StrategyAction{Rule: "queue b", Action: ActionAssignQueue, Queue: "B"}, actions[1])
+	require.Equal(t, "reminder", actions[0].Template)
+
+	s = Strategy{Rules: []StrategyRule{
+		{Name: "unassigned", When: []string{"assigned == false", "queue != ''"}, Action: ActionCall},
+	}}
+	actions, err = s.Evaluate(LoanFacts{Queue: "early"})
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	actions, err = s.Evaluate(LoanFacts{})
+	require.NoError(t, err)
+	require.Empty(t, actions)
+
+	// a Loan keeping to a promise or plan is left alone unless the rule opts in
+	s = Strategy{Rules: []StrategyRule{
+		{Name: "chase", When: []string{"dpd > 0"}, Action: ActionCall},
+		{Name: "confirm plan", When: []string{"on_plan == true"}, Action: ActionSMS, IgnoreArrangements: true},
+	}}
+	actions, err = s.Evaluate(LoanFacts{DaysPastDue: 20, Promised: true})
+	require.NoError(t, err)
+	require.Empty(t, actions)
+	actions, err = s.Evaluate(LoanFacts{DaysPastDue: 20, OnPlan: true})
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	require.Equal(t, "confirm plan", actions[0].Rule)
+}
+
+// TestRunStrategy verifies a dry run changes nothing and a real run logs actions once and moves cases.
+func TestRunStrategy(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	// Arrange, a Loan 43 days past due with a case in the mid queue, and one that is current
+	user, err := InitializeUserWithLoan(db, "Strategy User", "strategy@example.com", "555-1818",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+	other, err := InitializeUserWithLoan(db, "Current User", "current@example.com", "555-1919",
+		12000, 0.12, 12, 1, calendarDate(2024, time.March, 1), false)
+	require.NoError(t, err)
+
+	asOf := calendarDate(2024, time.March, 15)
+	run, err := RunCollections(db, asOf, 0)
+	require.NoError(t, err)
+	require.Len(t, run.Opened, 1)
+	require.Equal(t, "mid", run.Opened[0].Queue)
+
+	s, err := ParseStrategy([]byte(testStrategyYAML), "yaml")
+	require.NoError(t, err)
+
+	// Act, a dry run
+	results, err := RunStrategy(db, s, asOf, true)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, results, 1, "nothing fires on the current Loan %d", other.Loans[0].ID)
+	require.Equal(t, ln.ID, results[0].Facts.LoanID)
+	require.Equal(t, 43, results[0].Facts.DaysPastDue)
+	require.Equal(t, "mid", results[0].Facts.Queue)
+	require.Len(t, results[0].Actions, 2)
+
+	logged, err := GetStrategyActions(db, ln.ID)
+	require.NoError(t, err)
+	require.Empty(t, logged)
+	c, err := GetCollectionCase(db, run.Opened[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "mid", c.Queue)
+
+	// a real run, twice
+	for range 2 {
+		_, err = RunStrategy(db, s, asOf, false)
+		require.NoError(t, err)
+	}
+
+	logged, err = GetStrategyActions(db, ln.ID)
+	require.NoError(t, err)
+	require.Len(t, logged, 2)
+	require.Equal(t, "standard", logged[0].Strategy)
+	require.Equal(t, ActionSMS, logged[0].Action)
+	require.Equal(t, ActionAssignQueue, logged[1].Action)
+
+	c, err = GetCollectionCase(db, run.Opened[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "B", c.Queue)
+
+	f, err := GetLoanFacts(db, ln.ID, asOf)
+	require.NoError(t, err)
+	require.Equal(t, "B", f.Queue)
+}
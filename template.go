@@ -0,0 +1,643 @@
+package delinquencytracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// DefaultLocale is used when no template matches the borrower's language
+const DefaultLocale = "en"
+
+// Template statuses
+const (
+	TemplateDraft   = "draft"   // being written, not used for notices
+	TemplateActive  = "active"  // used for notices
+	TemplateRetired = "retired" // replaced by a newer active template
+)
+
+// MessageTemplate is the text of one notice on one channel in one language.
+// Subject and Body are text/template templates; HTML, for email only, is an html/template
+// template sent alongside Body. They are executed against NoticeData.
+type MessageTemplate struct {
+	ID          int64     // unique identifier for the template (0 for built-in templates)
+	Name        string    // the notice it writes, e.g. "missed_payment"
+	Channel     string    // "email", "sms" or "in_app"
+	Locale      string    // language tag, e.g. "en" or "es-MX"
+	Subject     string    // subject line (unused by SMS)
+	Body        string    // plain text body
+	HTML        string    // HTML body, email only ("" to send plain text only)
+	Status      string    // "draft", "active" or "retired"
+	CreatedAt   time.Time // when was this record created
+	ActivatedAt time.Time // when it was activated (zero while a draft)
+}
+
+// NoticeData is what a template can refer to, e.g. {{.BorrowerName}} or {{money .AmountDue}}.
+// Templates also have the functions money and date, which format amounts and days for the locale.
+type NoticeData struct {
+	BorrowerName  string    // the borrower's full name
+	LoanID        int64     // the Loan the notice is about
+	PaymentNumber int64     // the installment the notice is about (0 when it is about the Loan)
+	AmountDue     float64   // still owed on that installment
+	DueDate       time.Time // when that installment is due
+	DaysPastDue   int       // how far behind the Loan is
+	PastDueAmount float64   // everything past due on the Loan
+	PayoffAmount  float64   // everything owed to pay the Loan off
+	AsOf          time.Time // the day of the notice
+}
+
+// localeFormat is how a language writes amounts and days
+type localeFormat struct {
+	thousands, decimal string
+	date               func(t time.Time) string
+}
+
+var localeFormats = map[string]localeFormat{
+	"en": {thousands: ",", decimal: ".", date: func(t time.Time) string { return t.Format("January 2, 2006") }},
+	"es": {thousands: ".", decimal: ",", date: func(t time.Time) string {
+		return fmt.Sprintf("%d de %s de %d", t.Day(), spanishMonths[t.Month()-1], t.Year())
+	}},
+}
+
+var spanishMonths = [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio",
+	"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"}
+
+// formatFor returns how a locale writes amounts and days, by its language and else as English
+func formatFor(locale string) localeFormat {
+	lang, _, _ := strings.Cut(locale, "-")
+	if f, ok := localeFormats[lang]; ok {
+		return f
+	}
+	return localeFormats[DefaultLocale]
+}
+
+// money writes an amount with two decimals and grouped thousands, e.g. "$1,234.56"
+func (f localeFormat) money(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	whole, cents, _ := strings.Cut(fmt.Sprintf("%.2f", amount), ".")
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(f.thousands)
+		}
+		grouped.WriteRune(digit)
+	}
+	return sign + "$" + grouped.String() + f.decimal + cents
+}
+
+// templateFuncs are the functions templates in a locale can call
+func templateFuncs(locale string) map[string]any {
+	f := formatFor(locale)
+	return map[string]any{"money": f.money, "date": f.date}
+}
+
+// templateKey identifies the templates that can stand in for each other
+type templateKey struct {
+	name, channel, locale string
+}
+
+// TemplateRegistry holds the template in use for each notice, channel and locale
+type TemplateRegistry struct {
+	templates map[templateKey]MessageTemplate
+}
+
+// NewTemplateRegistry returns a registry of the built-in English and Spanish templates.
+func NewTemplateRegistry() *TemplateRegistry {
+	r := &TemplateRegistry{templates: make(map[templateKey]MessageTemplate)}
+	for _, t := range builtinTemplates {
+		t.Status = TemplateActive
+		if err := r.Register(t); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register validates a template and puts it in use, replacing any for the same notice, channel and locale.
+func (r *TemplateRegistry) Register(t MessageTemplate) error {
+	if err := ValidateTemplate(t); err != nil {
+		return err
+	}
+	r.templates[templateKey{t.Name, t.Channel, t.Locale}] = t
+	return nil
+}
+
+// Lookup returns the template for a notice on a channel in the best match for a locale:
+// the locale itself, then its language, then DefaultLocale.
+func (r *TemplateRegistry) Lookup(name, channel, locale string) (MessageTemplate, bool) {
+	lang, _, _ := strings.Cut(locale, "-")
+	for _, l := range []string{locale, lang, DefaultLocale} {
+		if t, ok := r.templates[templateKey{name, channel, l}]; ok {
+			return t, true
+		}
+	}
+	return MessageTemplate{}, false
+}
+
+// Render writes a notice from the registry's template in the best match for a locale.
+func (r *TemplateRegistry) Render(name, channel, locale string, data NoticeData) (Message, error) {
+	t, ok := r.Lookup(name, channel, locale)
+	if !ok {
+		return Message{}, fmt.Errorf("no %s template for %s notices", channel, name)
+	}
+	return RenderTemplate(t, data)
+}
+
+// LoadTemplates returns the built-in templates overridden by the active templates in db.
+func LoadTemplates(db execer) (*TemplateRegistry, error) {
+	r := NewTemplateRegistry()
+
+	active, err := queryTemplates(db, `WHERE status = $1 ORDER BY id`, TemplateActive)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range active {
+		if err := r.Register(t); err != nil {
+			return nil, fmt.Errorf("active template %d: %w", t.ID, err)
+		}
+	}
+
+	return r, nil
+}
+
+// parseTemplates parses the parts of a template
+func parseTemplates(t MessageTemplate) (subject, body *template.Template, html *htmltemplate.Template, err error) {
+	funcs := templateFuncs(t.Locale)
+
+	if subject, err = template.New("subject").Funcs(funcs).Option("missingkey=error").Parse(t.Subject); err != nil {
+		return nil, nil, nil, fmt.Errorf("subject: %w", err)
+	}
+	if body, err = template.New("body").Funcs(funcs).Option("missingkey=error").Parse(t.Body); err != nil {
+		return nil, nil, nil, fmt.Errorf("body: %w", err)
+	}
+	if t.HTML != "" {
+		if html, err = htmltemplate.New("html").Funcs(funcs).Option("missingkey=error").Parse(t.HTML); err != nil {
+			return nil, nil, nil, fmt.Errorf("html: %w", err)
+		}
+	}
+	return subject, body, html, nil
+}
+
+// ValidateTemplate checks that a template is for a known notice and channel, parses, and
+// refers only to fields NoticeData has, in branches that would not run as well as those that would.
+func ValidateTemplate(t MessageTemplate) error {
+	switch t.Name {
+	case NoticeUpcomingDue, NoticeMissedPayment, NoticePayoff:
+	default:
+		return fmt.Errorf("unknown notice %q", t.Name)
+	}
+	switch t.Channel {
+	case ChannelEmail, ChannelSMS, ChannelInApp:
+	default:
+		return fmt.Errorf("unknown template channel %q", t.Channel)
+	}
+	if t.Locale == "" {
+		return fmt.Errorf("template %s/%s needs a locale", t.Name, t.Channel)
+	}
+	if err := validateLanguage(t.Locale); err != nil {
+		return err
+	}
+	if strings.TrimSpace(t.Body) == "" {
+		return fmt.Errorf("template %s/%s/%s has no body", t.Name, t.Channel, t.Locale)
+	}
+	if t.HTML != "" && t.Channel != ChannelEmail {
+		return fmt.Errorf("only email templates have an HTML body")
+	}
+
+	subject, body, html, err := parseTemplates(t)
+	if err != nil {
+		return fmt.Errorf("template %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+	}
+
+	dataType := reflect.TypeOf(NoticeData{})
+	trees := []*parse.Tree{subject.Tree, body.Tree}
+	if html != nil {
+		trees = append(trees, html.Tree)
+	}
+	for _, tree := range trees {
+		if err := checkTemplateFields(tree.Root, dataType, dataType); err != nil {
+			return fmt.Errorf("template %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+		}
+	}
+	return nil
+}
+
+// checkTemplateFields checks the fields a template node refers to exist, with dot of type dot
+// (nil where it cannot be known) and $ of type root
+func checkTemplateFields(node parse.Node, dot, root reflect.Type) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateFields(child, dot, root); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplateFields(n.Pipe, dot, root)
+	case *parse.TemplateNode:
+		return checkTemplateFields(n.Pipe, dot, root)
+	case *parse.IfNode:
+		return checkBranchFields(&n.BranchNode, dot, dot, root)
+	case *parse.WithNode:
+		return checkBranchFields(&n.BranchNode, dot, pipeType(n.Pipe, dot, root), root)
+	case *parse.RangeNode:
+		var elem reflect.Type
+		if t := pipeType(n.Pipe, dot, root); t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		return checkBranchFields(&n.BranchNode, dot, elem, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if err := checkTemplateFields(arg, dot, root); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.FieldNode:
+		_, err := fieldType(dot, n.Ident)
+		return err
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			_, err := fieldType(root, n.Ident[1:])
+			return err
+		}
+	case *parse.ChainNode:
+		return checkTemplateFields(n.Node, dot, root)
+	}
+	return nil
+}
+
+// checkBranchFields checks an if, with or range: its pipeline with dot, its body with inner and its else with dot
+func checkBranchFields(n *parse.BranchNode, dot, inner, root reflect.Type) error {
+	if err := checkTemplateFields(n.Pipe, dot, root); err != nil {
+		return err
+	}
+	if err := checkTemplateFields(n.List, inner, root); err != nil {
+		return err
+	}
+	return checkTemplateFields(n.ElseList, dot, root)
+}
+
+// pipeType is the type of a pipeline that is just a field, or nil
+func pipeType(pipe *parse.PipeNode, dot, root reflect.Type) reflect.Type {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		t, _ := fieldType(dot, arg.Ident)
+		return t
+	case *parse.DotNode:
+		return dot
+	}
+	return nil
+}
+
+// fieldType follows a chain of field and method names from t, or fails on the first that does not exist
+func fieldType(t reflect.Type, idents []string) (reflect.Type, error) {
+	for _, ident := range idents {
+		if t == nil {
+			return nil, nil
+		}
+		if m, ok := t.MethodByName(ident); ok {
+			if m.Type.NumOut() == 0 {
+				return nil, fmt.Errorf("%s.%s returns nothing", t.Name(), ident)
+			}
+			t = m.Type.Out(0)
+			continue
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s has no field %s", t, ident)
+		}
+		f, ok := t.FieldByName(ident)
+		if !ok || !f.IsExported() {
+			return nil, fmt.Errorf("unknown field %s in %s", ident, t.Name())
+		}
+		t = f.Type
+	}
+	return t, nil
+}
+
+// RenderTemplate executes a template against the data of a notice.
+func RenderTemplate(t MessageTemplate, data NoticeData) (Message, error) {
+	subject, body, html, err := parseTemplates(t)
+	if err != nil {
+		return Message{}, fmt.Errorf("template %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+	}
+
+	msg := Message{Kind: t.Name}
+	var b strings.Builder
+	if err := subject.Execute(&b, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render subject of %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+	}
+	msg.Subject = strings.TrimSpace(b.String())
+
+	b.Reset()
+	if err := body.Execute(&b, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render body of %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+	}
+	msg.Body = strings.TrimSpace(b.String())
+
+	if html != nil {
+		b.Reset()
+		if err := html.Execute(&b, data); err != nil {
+			return Message{}, fmt.Errorf("failed to render HTML of %s/%s/%s: %w", t.Name, t.Channel, t.Locale, err)
+		}
+		msg.HTML = b.String()
+	}
+
+	return msg, nil
+}
+
+// noticeData is what a template knows about a notice on a Loan's installment as of a day
+func noticeData(db execer, user User, ln Loan, p Payment, asOf time.Time) (NoticeData, error) {
+	asOf = startOfDay(asOf)
+
+	d, err := EvaluateDelinquency(ln, asOf)
+	if err != nil {
+		return NoticeData{}, fmt.Errorf("failed to evaluate delinquency for Loan %d: %w", ln.ID, err)
+	}
+	balances, err := GetLoanBalances(db, ln.ID, asOf)
+	if err != nil {
+		return NoticeData{}, err
+	}
+
+	return NoticeData{
+		BorrowerName:  user.Name,
+		LoanID:        ln.ID,
+		PaymentNumber: p.PaymentNumber,
+		AmountDue:     max(p.AmountDue-paidAsOf(p, asOf), 0),
+		DueDate:       p.DueDate,
+		DaysPastDue:   d.DaysPastDue,
+		PastDueAmount: d.PastDueAmount,
+		PayoffAmount:  balances.Total(),
+		AsOf:          asOf,
+	}, nil
+}
+
+// CreateTemplate saves a draft template. It is checked as it would be on activation, so a
+// draft can be previewed, but it is not used for notices until it is activated.
+func CreateTemplate(db *sql.DB, t MessageTemplate) (MessageTemplate, error) {
+	return CreateTemplateContext(context.Background(), db, t)
+}
+
+// CreateTemplateContext is CreateTemplate, audited as the actor in ctx.
+func CreateTemplateContext(ctx context.Context, db *sql.DB, t MessageTemplate) (MessageTemplate, error) {
+	if err := ValidateTemplate(t); err != nil {
+		return MessageTemplate{}, err
+	}
+	t.Status, t.ActivatedAt = TemplateDraft, time.Time{}
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+		INSERT INTO message_templates (name, channel, locale, subject, body, html, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+		`, t.Name, t.Channel, t.Locale, t.Subject, t.Body, t.HTML, t.Status).Scan(&t.ID, &t.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create template: %w", err)
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditCreate, AuditMessageTemplate, t.ID, nil, t, "")
+	})
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+
+	return t, nil
+}
+
+// ActivateTemplate validates a draft template and puts it in use, retiring the template it replaces.
+func ActivateTemplate(db *sql.DB, templateID int64) (MessageTemplate, error) {
+	return ActivateTemplateContext(context.Background(), db, templateID)
+}
+
+// ActivateTemplateContext is ActivateTemplate, audited as the actor in ctx.
+func ActivateTemplateContext(ctx context.Context, db *sql.DB, templateID int64) (MessageTemplate, error) {
+	var after MessageTemplate
+
+	err := inTx(db, func(tx *sql.Tx) error {
+		before, err := getTemplate(tx, `WHERE id = $1 FOR UPDATE`, templateID)
+		if err != nil {
+			return err
+		}
+		if before.Status != TemplateDraft {
+			return fmt.Errorf("template %d is %s, only drafts can be activated", templateID, before.Status)
+		}
+		if err := ValidateTemplate(before); err != nil {
+			return err
+		}
+
+		replaced, err := queryTemplates(tx, `WHERE name = $1 AND channel = $2 AND locale = $3 AND status = $4 FOR UPDATE`,
+			before.Name, before.Channel, before.Locale, TemplateActive)
+		if err != nil {
+			return err
+		}
+		for _, old := range replaced {
+			if _, err := tx.Exec(`UPDATE message_templates SET status = $1 WHERE id = $2`, TemplateRetired, old.ID); err != nil {
+				return fmt.Errorf("failed to retire template %d: %w", old.ID, err)
+			}
+			retired := old
+			retired.Status = TemplateRetired
+			reason := fmt.Sprintf("replaced by template %d", templateID)
+			if err := recordAudit(ctx, tx, AuditUpdate, AuditMessageTemplate, old.ID, old, retired, reason); err != nil {
+				return err
+			}
+		}
+
+		after = before
+		after.Status = TemplateActive
+		err = tx.QueryRow(`UPDATE message_templates SET status = $1, activated_at = NOW() WHERE id = $2 RETURNING activated_at`,
+			TemplateActive, templateID).Scan(&after.ActivatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to activate template %d: %w", templateID, err)
+		}
+		after.ActivatedAt = after.ActivatedAt.UTC()
+
+		return recordAudit(ctx, tx, AuditUpdate, AuditMessageTemplate, templateID, before, after, "activated")
+	})
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+
+	return after, nil
+}
+
+// PreviewTemplate renders a template, draft or not, against a Loan as of a day: about the
+// oldest installment still unpaid, or the last one when everything is paid.
+func PreviewTemplate(db *sql.DB, templateID, loanID int64, asOf time.Time) (Message, error) {
+	t, err := GetTemplate(db, templateID)
+	if err != nil {
+		return Message{}, err
+	}
+	ln, err := LoanFromLedger(db, loanID)
+	if err != nil {
+		return Message{}, err
+	}
+	user, err := GetUserByID(db, ln.UserID, IncludeDeleted)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var p Payment
+	for _, inst := range ln.Payments {
+		p = inst
+		if inst.AmountDue-paidAsOf(inst, startOfDay(asOf)) > paymentTolerance {
+			break
+		}
+	}
+
+	data, err := noticeData(db, user, ln, p, asOf)
+	if err != nil {
+		return Message{}, err
+	}
+	msg, err := RenderTemplate(t, data)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.UserID = user.ID
+	return msg, nil
+}
+
+const templateColumns = `id, name, channel, locale, subject, body, html, status, created_at, activated_at`
+
+// GetTemplate returns a stored template.
+func GetTemplate(db execer, templateID int64) (MessageTemplate, error) {
+	return getTemplate(db, `WHERE id = $1`, templateID)
+}
+
+// GetTemplates returns the stored templates for a notice, or for every notice when name is "",
+// by notice, channel and locale and then newest first.
+func GetTemplates(db execer, name string) ([]MessageTemplate, error) {
+	if name == "" {
+		return queryTemplates(db, `ORDER BY name, channel, locale, id DESC`)
+	}
+	return queryTemplates(db, `WHERE name = $1 ORDER BY name, channel, locale, id DESC`, name)
+}
+
+func getTemplate(db execer, tail string, templateID int64) (MessageTemplate, error) {
+	templates, err := queryTemplates(db, tail, templateID)
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+	if len(templates) == 0 {
+		return MessageTemplate{}, fmt.Errorf("template with ID %d not found", templateID)
+	}
+	return templates[0], nil
+}
+
+func queryTemplates(db execer, tail string, args ...any) ([]MessageTemplate, error) {
+	rows, err := db.Query(`SELECT `+templateColumns+` FROM message_templates `+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []MessageTemplate
+	for rows.Next() {
+		var t MessageTemplate
+		var activatedAt sql.NullTime
+		err := rows.Scan(&t.ID, &t.Name, &t.Channel, &t.Locale, &t.Subject, &t.Body, &t.HTML, &t.Status, &t.CreatedAt, &activatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template row: %w", err)
+		}
+		t.CreatedAt = t.CreatedAt.UTC()
+		if activatedAt.Valid {
+			t.ActivatedAt = activatedAt.Time.UTC()
+		}
+		templates = append(templates, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating template rows: %w", err)
+	}
+
+	return templates, nil
+}
+
+// builtinTemplates are the notices sent when no stored template replaces them
+var builtinTemplates = []MessageTemplate{
+	{Name: NoticeUpcomingDue, Channel: ChannelEmail, Locale: "en",
+		Subject: `Payment due on {{date .DueDate}}`,
+		Body:    "Hi {{.BorrowerName}},\n\nyour payment of {{money .AmountDue}} on loan {{.LoanID}} is due on {{date .DueDate}}.",
+		HTML:    `<p>Hi {{.BorrowerName}},</p><p>your payment of <b>{{money .AmountDue}}</b> on loan {{.LoanID}} is due on {{date .DueDate}}.</p>`},
+	{Name: NoticeUpcomingDue, Channel: ChannelSMS, Locale: "en",
+		Body: `Your payment of {{money .AmountDue}} on loan {{.LoanID}} is due on {{date .DueDate}}.`},
+	{Name: NoticeUpcomingDue, Channel: ChannelInApp, Locale: "en",
+		Subject: `Payment due on {{date .DueDate}}`,
+		Body:    `Your payment of {{money .AmountDue}} on loan {{.LoanID}} is due on {{date .DueDate}}.`},
+
+	{Name: NoticeMissedPayment, Channel: ChannelEmail, Locale: "en",
+		Subject: `Your payment is past due`,
+		Body: "Hi {{.BorrowerName}},\n\nwe have not received your payment of {{money .AmountDue}} on loan {{.LoanID}} that was due on {{date .DueDate}}. " +
+			"Your loan is {{.DaysPastDue}} days past due with {{money .PastDueAmount}} past due in total.",
+		HTML: `<p>Hi {{.BorrowerName}},</p><p>we have not received your payment of <b>{{money .AmountDue}}</b> on loan {{.LoanID}} that was due on {{date .DueDate}}. ` +
+			`Your loan is {{.DaysPastDue}} days past due with {{money .PastDueAmount}} past due in total.</p>`},
+	{Name: NoticeMissedPayment, Channel: ChannelSMS, Locale: "en",
+		Body: `Your loan {{.LoanID}} is {{.DaysPastDue}} days past due. Please pay {{money .PastDueAmount}} as soon as you can.`},
+	{Name: NoticeMissedPayment, Channel: ChannelInApp, Locale: "en",
+		Subject: `Your payment is past due`,
+		Body:    `Your loan {{.LoanID}} is {{.DaysPastDue}} days past due with {{money .PastDueAmount}} past due in total.`},
+
+	{Name: NoticePayoff, Channel: ChannelEmail, Locale: "en",
+		Subject: `Your loan is paid off`,
+		Body:    "Hi {{.BorrowerName}},\n\nyour loan {{.LoanID}} is paid in full. Thank you.",
+		HTML:    `<p>Hi {{.BorrowerName}},</p><p>your loan {{.LoanID}} is paid in full. Thank you.</p>`},
+	{Name: NoticePayoff, Channel: ChannelSMS, Locale: "en",
+		Body: `Your loan {{.LoanID}} is paid in full. Thank you.`},
+	{Name: NoticePayoff, Channel: ChannelInApp, Locale: "en",
+		Subject: `Your loan is paid off`,
+		Body:    `Your loan {{.LoanID}} is paid in full. Thank you.`},
+
+	{Name: NoticeUpcomingDue, Channel: ChannelEmail, Locale: "es",
+		Subject: `Pago con vencimiento el {{date .DueDate}}`,
+		Body:    "Hola {{.BorrowerName}}:\n\nsu pago de {{money .AmountDue}} del préstamo {{.LoanID}} vence el {{date .DueDate}}.",
+		HTML:    `<p>Hola {{.BorrowerName}}:</p><p>su pago de <b>{{money .AmountDue}}</b> del préstamo {{.LoanID}} vence el {{date .DueDate}}.</p>`},
+	{Name: NoticeUpcomingDue, Channel: ChannelSMS, Locale: "es",
+		Body: `Su pago de {{money .AmountDue}} del préstamo {{.LoanID}} vence el {{date .DueDate}}.`},
+	{Name: NoticeUpcomingDue, Channel: ChannelInApp, Locale: "es",
+		Subject: `Pago con vencimiento el {{date .DueDate}}`,
+		Body:    `Su pago de {{money .AmountDue}} del préstamo {{.LoanID}} vence el {{date .DueDate}}.`},
+
+	{Name: NoticeMissedPayment, Channel: ChannelEmail, Locale: "es",
+		Subject: `Su pago está vencido`,
+		Body: "Hola {{.BorrowerName}}:\n\nno hemos recibido su pago de {{money .AmountDue}} del préstamo {{.LoanID}} que vencía el {{date .DueDate}}. " +
+			"Su préstamo tiene {{.DaysPastDue}} días de atraso y {{money .PastDueAmount}} vencidos en total.",
+		HTML: `<p>Hola {{.BorrowerName}}:</p><p>no hemos recibido su pago de <b>{{money .AmountDue}}</b> del préstamo {{.LoanID}} que vencía el {{date .DueDate}}. ` +
+			`Su préstamo tiene {{.DaysPastDue}} días de atraso y {{money .PastDueAmount}} vencidos en total.</p>`},
+	{Name: NoticeMissedPayment, Channel: ChannelSMS, Locale: "es",
+		Body: `Su préstamo {{.LoanID}} tiene {{.DaysPastDue}} días de atraso. Por favor pague {{money .PastDueAmount}} lo antes posible.`},
+	{Name: NoticeMissedPayment, Channel: ChannelInApp, Locale: "es",
+		Subject: `Su pago está vencido`,
+		Body:    `Su préstamo {{.LoanID}} tiene {{.DaysPastDue}} días de atraso y {{money .PastDueAmount}} vencidos en total.`},
+
+	{Name: NoticePayoff, Channel: ChannelEmail, Locale: "es",
+		Subject: `Su préstamo está pagado`,
+		Body:    "Hola {{.BorrowerName}}:\n\nsu préstamo {{.LoanID}} está pagado en su totalidad. Gracias.",
+		HTML:    `<p>Hola {{.BorrowerName}}:</p><p>su préstamo {{.LoanID}} está pagado en su totalidad. Gracias.</p>`},
+	{Name: NoticePayoff, Channel: ChannelSMS, Locale: "es",
+		Body: `Su préstamo {{.LoanID}} está pagado en su totalidad. Gracias.`},
+	{Name: NoticePayoff, Channel: ChannelInApp, Locale: "es",
+		Subject: `Su préstamo está pagado`,
+		Body:    `Su préstamo {{.LoanID}} está pagado en su totalidad. Gracias.`},
+}
@@ -0,0 +1,172 @@
+package delinquencytracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocaleFormat verifies amounts and days are written the way each language writes them.
+func TestLocaleFormat(t *testing.T) {
+	tests := []struct {
+		locale string
+		amount float64
+		money  string
+		date   string
+	}{
+		{"en", 1234567.891, "$1,234,567.89", "February 1, 2024"},
+		{"en-US", 12.5, "$12.50", "February 1, 2024"},
+		{"es", 1066.19, "$1.066,19", "1 de febrero de 2024"},
+		{"es-MX", 999, "$999,00", "1 de febrero de 2024"},
+		{"fr", -1500, "-$1,500.00", "February 1, 2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			f := formatFor(tt.locale)
+			require.Equal(t, tt.money, f.money(tt.amount))
+			require.Equal(t, tt.date, f.date(calendarDate(2024, time.February, 1)))
+		})
+	}
+}
+
+// TestValidateTemplate verifies templates referring to fields NoticeData lacks are refused, even in branches that would not run.
+func TestValidateTemplate(t *testing.T) {
+	for _, b := range builtinTemplates {
+		require.NoError(t, ValidateTemplate(b), "%s/%s/%s", b.Name, b.Channel, b.Locale)
+	}
+
+	valid := MessageTemplate{Name: NoticeMissedPayment, Channel: ChannelEmail, Locale: "en", Subject: "Past due", Body: "{{.BorrowerName}}"}
+	tests := map[string]func(t *MessageTemplate){
+		"unknown notice":        func(t *MessageTemplate) { t.Name = "birthday" },
+		"unknown channel":       func(t *MessageTemplate) { t.Channel = "fax" },
+		"no locale":             func(t *MessageTemplate) { t.Locale = "" },
+		"bad locale":            func(t *MessageTemplate) { t.Locale = "English" },
+		"no body":               func(t *MessageTemplate) { t.Body = " " },
+		"html on sms":           func(t *MessageTemplate) { t.Channel, t.HTML = ChannelSMS, "<p>hi</p>" },
+		"syntax":                func(t *MessageTemplate) { t.Body = "{{.BorrowerName" },
+		"unknown function":      func(t *MessageTemplate) { t.Body = "{{shout .BorrowerName}}" },
+		"unknown field":         func(t *MessageTemplate) { t.Body = "{{.FirstName}}" },
+		"unknown field in else": func(t *MessageTemplate) { t.Body = "{{if .DaysPastDue}}late{{else}}{{.Balance}}{{end}}" },
+		"unknown field in with": func(t *MessageTemplate) { t.Body = "{{with .DueDate}}{{.Weekday}} {{.Nonsense}}{{end}}" },
+		"unknown field in $":    func(t *MessageTemplate) { t.Body = "{{with .DueDate}}{{$.Balance}}{{end}}" },
+		"unknown field in html": func(t *MessageTemplate) { t.HTML = "<p>{{.Balance}}</p>" },
+		"unknown subject field": func(t *MessageTemplate) { t.Subject = "{{.Balance}}" },
+		"unknown method":        func(t *MessageTemplate) { t.Body = "{{.DueDate.Fortnight}}" },
+	}
+
+	require.NoError(t, ValidateTemplate(valid))
+	for name, mutate := range tests {
+		tmpl := valid
+		mutate(&tmpl)
+		require.Error(t, ValidateTemplate(tmpl), name)
+	}
+
+	ok := valid
+	ok.Body = `{{if gt .DaysPastDue 30}}{{money .PastDueAmount}}{{else}}{{.DueDate.Format "Jan 2"}}{{end}} {{with .DueDate}}{{.Year}}{{$.LoanID}}{{end}}`
+	require.NoError(t, ValidateTemplate(ok))
+}
+
+// TestTemplateRegistry verifies lookups fall back from locale to language to English and render safely.
+func TestTemplateRegistry(t *testing.T) {
+	r := NewTemplateRegistry()
+
+	tmpl, ok := r.Lookup(NoticeMissedPayment, ChannelSMS, "es-MX")
+	require.True(t, ok)
+	require.Equal(t, "es", tmpl.Locale)
+	tmpl, ok = r.Lookup(NoticeMissedPayment, ChannelSMS, "fr")
+	require.True(t, ok)
+	require.Equal(t, "en", tmpl.Locale)
+	tmpl, ok = r.Lookup(NoticeMissedPayment, ChannelSMS, "")
+	require.True(t, ok)
+	require.Equal(t, "en", tmpl.Locale)
+
+	require.NoError(t, r.Register(MessageTemplate{Name: NoticeMissedPayment, Channel: ChannelSMS, Locale: "es-MX", Body: "Hola {{.BorrowerName}}"}))
+	tmpl, _ = r.Lookup(NoticeMissedPayment, ChannelSMS, "es-MX")
+	require.Equal(t, "es-MX", tmpl.Locale)
+	require.Error(t, r.Register(MessageTemplate{Name: NoticeMissedPayment, Channel: ChannelSMS, Locale: "es", Body: "{{.Nope}}"}))
+
+	data := NoticeData{
+		BorrowerName:  "Ana <Ruiz>",
+		LoanID:        7,
+		AmountDue:     1066.19,
+		DueDate:       calendarDate(2024, time.February, 1),
+		DaysPastDue:   9,
+		PastDueAmount: 1066.19,
+	}
+	msg, err := r.Render(NoticeMissedPayment, ChannelEmail, "es", data)
+	require.NoError(t, err)
+	require.Equal(t, NoticeMissedPayment, msg.Kind)
+	require.Equal(t, "Su pago está vencido", msg.Subject)
+	require.Contains(t, msg.Body, "Hola Ana <Ruiz>:")
+	require.Contains(t, msg.Body, "$1.066,19 del préstamo 7 que vencía el 1 de febrero de 2024")
+	require.Contains(t, msg.HTML, "Hola Ana &lt;Ruiz&gt;:", "HTML escapes what it is given")
+
+	_, err = r.Render("birthday", ChannelEmail, "en", data)
+	require.Error(t, err)
+}
+
+// TestMessageTemplates verifies drafts are previewed against a real Loan and activating one retires the one it replaces.
+func TestMessageTemplates(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	user, err := InitializeUserWithLoan(db, "Template User", "template@example.com", "555-2323",
+		12000, 0.12, 12, 1, calendarDate(2024, time.January, 1), false)
+	require.NoError(t, err)
+	ln := user.Loans[0]
+
+	draft := MessageTemplate{
+		Name:    NoticeMissedPayment,
+		Channel: ChannelSMS,
+		Locale:  "en",
+		Body:    "{{.BorrowerName}}: {{.DaysPastDue}} days late on payment {{.PaymentNumber}}, {{money .PayoffAmount}} pays it off",
+	}
+
+	_, err = CreateTemplate(db, MessageTemplate{Name: NoticeMissedPayment, Channel: ChannelSMS, Locale: "en", Body: "{{.Balance}}"})
+	require.Error(t, err, "an invalid template cannot even be a draft")
+
+	// Act
+	first, err := CreateTemplate(db, draft)
+	require.NoError(t, err)
+	require.Equal(t, TemplateDraft, first.Status)
+
+	msg, err := PreviewTemplate(db, first.ID, ln.ID, calendarDate(2024, time.February, 10))
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, user.ID, msg.UserID)
+	require.Contains(t, msg.Body, "Template User: 9 days late on payment 1, $")
+
+	r, err := LoadTemplates(db)
+	require.NoError(t, err)
+	tmpl, _ := r.Lookup(NoticeMissedPayment, ChannelSMS, "en")
+	require.Zero(t, tmpl.ID, "a draft is not used")
+
+	first, err = ActivateTemplate(db, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, TemplateActive, first.Status)
+	require.False(t, first.ActivatedAt.IsZero())
+	_, err = ActivateTemplate(db, first.ID)
+	require.Error(t, err)
+
+	r, err = LoadTemplates(db)
+	require.NoError(t, err)
+	tmpl, _ = r.Lookup(NoticeMissedPayment, ChannelSMS, "en-GB")
+	require.Equal(t, first.ID, tmpl.ID)
+
+	second, err := CreateTemplate(db, draft)
+	require.NoError(t, err)
+	_, err = ActivateTemplate(db, second.ID)
+	require.NoError(t, err)
+
+	first, err = GetTemplate(db, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, TemplateRetired, first.Status)
+
+	templates, err := GetTemplates(db, NoticeMissedPayment)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	require.Equal(t, second.ID, templates[0].ID)
+}
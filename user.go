@@ -3,11 +3,17 @@ package delinquencytracker
 import "time"
 
 type User struct {
-	ID        int64     // unique identifier for the user
-	Name      string    // full name of the user
-	Email     string    // email address
-	Phone     string    // phone number
-	CreatedAt time.Time // when the user was created
+	ID         int64     // unique identifier for the user
+	Name       string    // full name of the user
+	Email      string    // email address
+	Phone      string    // phone number
+	CreatedAt  time.Time // when the user was created
+	DeletedAt  time.Time // when the user was soft-deleted (zero while they are not)
+	DeletedBy  string    // who deleted the user
+	MergedInto int64     // the user this one was merged into as a duplicate (zero if it was not)
+
+	Profile UserProfile // date of birth, time zone, language and contact preferences
+	Address Address     // current mailing address (filled by GetFullUserByID)
 
 	Loans []Loan // all loans associated with this user
 }